@@ -10,6 +10,7 @@ func buildInWrite(buf []byte, num int64) {
 	binary.BigEndian.PutUint64(buf, uint64(num))
 }
 
+//perf:nobce
 func buildInWrite2(b []byte, v int64) {
 	_ = b[7] // early bounds check to guarantee safety of writes below
 	b[0] = byte(v >> 56)
@@ -0,0 +1,206 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/buptbill220/go_performance/lib/mq"
+	"github.com/buptbill220/go_performance/lib/mq/nsqmq"
+	"github.com/buptbill220/go_performance/lib/nsqbench"
+	"github.com/buptbill220/go_performance/lib/nsqfake"
+	"github.com/buptbill220/go_performance/lib/nsqpub"
+	"github.com/buptbill220/go_performance/lib/nsqsub"
+	"github.com/nsqio/go-nsq"
+)
+
+// 同一个进程里跑生产者和消费者, 测nsq端到端的吞吐, 延迟, 重复和丢失
+// 不给 -nsqd 时起一个进程内的 lib/nsqfake, 测的是客户端和协议本身的开销
+//
+//	go run ./apps/nsq_bench -count=100000 -concurrency=8 -max_in_flight=200
+//	go run ./apps/nsq_bench -nsqd=127.0.0.1:4150 -rate=5000 -size=1024 -pub_batch=50
+//	go run ./apps/nsq_bench -memory    // lib/mq 的内存broker, 只有消费框架的开销
+//
+// 跨机器测用 nsq_p -bench 和 nsq_c -bench
+// 连真的nsqd时上次没消费完的消息会被跳过, 算在 stale 里
+
+type options struct {
+	nsqd         []string
+	topic        string
+	channel      string
+	count        int
+	rate         int
+	size         int
+	pubBatch     int
+	concurrency  int
+	maxInFlight  int
+	handlerSleep time.Duration
+	timeout      time.Duration
+	memory       bool
+}
+
+type result struct {
+	Sent     uint64
+	SendTime time.Duration
+	Stale    uint64 // messages of an earlier run
+	Report   nsqbench.Report
+}
+
+// Missing is what was sent but never received, including losses after the highest received sequence.
+func (r *result) Missing() uint64 {
+	return r.Sent - r.Report.Unique
+}
+
+func main() {
+	var (
+		o    options
+		nsqd = flag.String("nsqd", "", "comma separated nsqd TCP addresses, empty starts an in-process fake")
+	)
+	flag.StringVar(&o.topic, "topic", "nsq_bench", "topic")
+	flag.StringVar(&o.channel, "channel", "nsq_bench", "channel")
+	flag.IntVar(&o.count, "count", 10000, "messages to send")
+	flag.IntVar(&o.rate, "rate", 0, "messages per second, 0 sends as fast as possible")
+	flag.IntVar(&o.size, "size", 128, "message body size")
+	flag.IntVar(&o.pubBatch, "pub_batch", 1, "publish with MPUB in batches of this size")
+	flag.IntVar(&o.concurrency, "concurrency", 4, "consumer handler goroutines")
+	flag.IntVar(&o.maxInFlight, "max_in_flight", 0, "consumer MaxInFlight, 0 is -concurrency")
+	flag.DurationVar(&o.handlerSleep, "handler_sleep", 0, "time spent handling each message")
+	flag.DurationVar(&o.timeout, "timeout", 10*time.Second, "wait this long for the rest after the last send")
+	flag.BoolVar(&o.memory, "memory", false, "use the lib/mq in-memory broker, the cost of the consumer framework without a network")
+	flag.Parse()
+	if *nsqd != "" {
+		o.nsqd = strings.Split(*nsqd, ",")
+	}
+
+	r, err := run(o, os.Stderr)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "nsq_bench: %v\n", err)
+		os.Exit(1)
+	}
+	printResult(os.Stdout, o, r)
+}
+
+func run(o options, logOut io.Writer) (*result, error) {
+	if o.count <= 0 {
+		return nil, fmt.Errorf("-count must be positive")
+	}
+	if o.pubBatch < 1 {
+		o.pubBatch = 1
+	}
+	enc := nsqbench.NewEncoder(o.size)
+	tr := nsqbench.NewTracker()
+	var stale uint64
+	handle := func(m mq.Message) error {
+		if h, err := nsqbench.Decode(m.Body()); err != nil || h.Producer != enc.ID() {
+			atomic.AddUint64(&stale, 1)
+			return nil
+		}
+		tr.Record(m.Body(), time.Now())
+		if o.handlerSleep > 0 {
+			time.Sleep(o.handlerSleep)
+		}
+		return nil
+	}
+
+	var (
+		p mq.Producer
+		s mq.Subscriber
+	)
+	if o.memory {
+		b := mq.NewMemory()
+		b.ConsumerOptions = mq.MemoryOptions{Concurrency: o.concurrency}
+		p, s = b, b
+	} else {
+		addrs := o.nsqd
+		if len(addrs) == 0 {
+			n, err := nsqfake.NewNSQD("127.0.0.1:0")
+			if err != nil {
+				return nil, err
+			}
+			defer n.Close()
+			addrs = []string{n.Addr()}
+		}
+		logger := log.New(logOut, "", log.LstdFlags)
+		np, err := nsqmq.NewProducer(nsqpub.Options{Addrs: addrs, Logger: logger})
+		if err != nil {
+			return nil, err
+		}
+		p = np
+		s = nsqmq.NewSubscriber(nsqsub.Options{
+			NSQD:        addrs,
+			Concurrency: o.concurrency,
+			MaxInFlight: o.maxInFlight,
+			Logger:      logger,
+			LogLevel:    nsq.LogLevelWarning,
+		})
+	}
+	defer p.Stop()
+	c, err := s.Subscribe(o.topic, o.channel, handle)
+	if err != nil {
+		return nil, err
+	}
+	if err := c.Start(); err != nil {
+		c.Stop()
+		return nil, err
+	}
+	defer c.Stop()
+
+	start := time.Now()
+	for sent := 0; sent < o.count; {
+		n := o.pubBatch
+		if n > o.count-sent {
+			n = o.count - sent
+		}
+		if o.rate > 0 {
+			// 按发送进度算该发的时间, 不用每条一个ticker
+			if d := time.Duration(sent) * time.Second / time.Duration(o.rate); time.Since(start) < d {
+				time.Sleep(d - time.Since(start))
+			}
+		}
+		var err error
+		if n == 1 {
+			err = p.Publish(o.topic, enc.Next())
+		} else {
+			bodies := make([][]byte, n)
+			for i := range bodies {
+				bodies[i] = enc.Next()
+			}
+			err = p.MultiPublish(o.topic, bodies)
+		}
+		if err != nil {
+			return nil, err
+		}
+		sent += n
+	}
+	r := &result{Sent: enc.Sent(), SendTime: time.Since(start)}
+
+	deadline := time.Now().Add(o.timeout)
+	for tr.Unique() < r.Sent && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+	if err := c.Stop(); err != nil {
+		return nil, err
+	}
+	r.Stale = atomic.LoadUint64(&stale)
+	r.Report = tr.Report()
+	return r, nil
+}
+
+func printResult(w io.Writer, o options, r *result) {
+	target := "in-process fake"
+	switch {
+	case o.memory:
+		target = "mq.Memory"
+	case len(o.nsqd) > 0:
+		target = strings.Join(o.nsqd, ",")
+	}
+	fmt.Fprintf(w, "%s: size=%d pub_batch=%d concurrency=%d max_in_flight=%d\n", target, o.size, o.pubBatch, o.concurrency, o.maxInFlight)
+	fmt.Fprintf(w, "sent=%d in %v (%.0f/s) missing=%d stale=%d\n", r.Sent, r.SendTime.Round(time.Millisecond),
+		float64(r.Sent)/r.SendTime.Seconds(), r.Missing(), r.Stale)
+	fmt.Fprintln(w, r.Report)
+}
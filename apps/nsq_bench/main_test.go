@@ -0,0 +1,108 @@
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"testing"
+	"time"
+
+	"github.com/buptbill220/go_performance/lib/nsqbench"
+	"github.com/buptbill220/go_performance/lib/nsqfake"
+	"github.com/stretchr/testify/assert"
+)
+
+func testOptions() options {
+	return options{topic: "nsq_bench", channel: "nsq_bench", count: 2000, size: 64, pubBatch: 1,
+		concurrency: 4, timeout: 5 * time.Second}
+}
+
+func TestRunFake(t *testing.T) {
+	o := testOptions()
+	r, err := run(o, ioutil.Discard)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, uint64(2000), r.Sent)
+	assert.Equal(t, uint64(2000), r.Report.Unique)
+	assert.Equal(t, uint64(0), r.Missing())
+	assert.Equal(t, uint64(0), r.Report.Duplicates)
+	assert.Equal(t, uint64(2000), r.Report.Latency.Count)
+	assert.True(t, r.Report.Throughput > 0)
+
+	var out bytes.Buffer
+	printResult(&out, o, r)
+	assert.Contains(t, out.String(), "in-process fake")
+	assert.Contains(t, out.String(), "missing=0")
+}
+
+func TestRunBatchAndRate(t *testing.T) {
+	o := testOptions()
+	o.count = 500
+	o.pubBatch = 50
+	o.rate = 5000
+	o.maxInFlight = 100
+	start := time.Now()
+	r, err := run(o, ioutil.Discard)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, uint64(0), r.Missing())
+	// 500条按5000/s发, 最后一批在90ms时发出
+	assert.True(t, time.Since(start) >= 90*time.Millisecond)
+}
+
+func TestRunStale(t *testing.T) {
+	n, err := nsqfake.NewNSQD("127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer n.Close()
+	// 上一次压测剩下的和不认识的消息
+	n.Publish("nsq_bench", nsqbench.NewEncoder(0).Next(), []byte("x"))
+	o := testOptions()
+	o.nsqd = []string{n.Addr()}
+	o.count = 100
+	r, err := run(o, ioutil.Discard)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, uint64(100), r.Report.Unique)
+	assert.Equal(t, uint64(2), r.Stale)
+	assert.Equal(t, 1, r.Report.Producers)
+}
+
+func TestRunMissing(t *testing.T) {
+	n, err := nsqfake.NewNSQD("127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer n.Close()
+	o := testOptions()
+	o.nsqd = []string{n.Addr()}
+	o.count = 10
+	o.timeout = 100 * time.Millisecond
+	// 消费者比超时还慢, 一部分消息等不到
+	o.concurrency = 1
+	o.handlerSleep = 30 * time.Millisecond
+	r, err := run(o, ioutil.Discard)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.True(t, r.Missing() > 0)
+	assert.Equal(t, r.Sent, r.Report.Unique+r.Missing())
+}
+
+func TestRunMemory(t *testing.T) {
+	o := testOptions()
+	o.memory = true
+	o.pubBatch = 10
+	r, err := run(o, ioutil.Discard)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, uint64(2000), r.Report.Unique)
+	assert.Equal(t, uint64(0), r.Missing())
+	var out bytes.Buffer
+	printResult(&out, o, r)
+	assert.Contains(t, out.String(), "mq.Memory")
+}
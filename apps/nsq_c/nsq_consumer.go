@@ -1,41 +1,224 @@
 package main
 
 import (
-	"github.com/nsqio/go-nsq"
+	"errors"
+	"flag"
 	"fmt"
+	"io"
+	"math/rand"
+	"os"
 	"os/signal"
+	"strings"
+	"sync"
 	"syscall"
-	"os"
 	"time"
+
+	"github.com/buptbill220/go_performance/lib/mq"
+	"github.com/buptbill220/go_performance/lib/mq/nsqmq"
+	"github.com/buptbill220/go_performance/lib/nsqbench"
+	"github.com/buptbill220/go_performance/lib/nsqpub"
+	"github.com/buptbill220/go_performance/lib/nsqsub"
+	"github.com/nsqio/go-nsq"
 )
 
+// 从nsqlookupd发现nsqd消费一个topic, 每条消息 sleep 一会儿模拟处理, 打印 C-<body>
+// 收到 SIGTERM/SIGINT 后先把在途的消息处理完再退出, 最多等 -drain_timeout
+//
+//	go run ./apps/nsq_c -lookupd=127.0.0.1:4161 -sleep=2s
+//	go run ./apps/nsq_c -nsqd=127.0.0.1:4150 -max_in_flight=100 -concurrency=25 -sleep=0
+//	go run ./apps/nsq_c -fail_rate=0.3 -max_attempts=3 -dead_letter_nsqd=127.0.0.1:4150    // 重试和死信
+//	go run ./apps/nsq_c -batch=50 -batch_timeout=200ms -concurrency=2    // 攒批, 每批 sleep 一次
+//	go run ./apps/nsq_c -bench -sleep=0 -concurrency=8 -stats=1s    // 统计 nsq_p -bench 的吞吐, 延迟, 重复和丢失
 
 const (
-	topice_name = "fucker"
+	topice_name  = "fucker"
 	channel_name = "mdzz"
 )
 
-func main() {
+type options struct {
+	lookupd     []string
+	nsqd        []string
+	topic       string
+	channel     string
+	maxInFlight int
+	concurrency int
+	sleep       time.Duration
+	failRate    float64
 
-	config := nsq.NewConfig()
+	batch        int
+	batchTimeout time.Duration
+	bench        bool
 
-	fmt.Printf("%#v\n", config.MaxInFlight)
-	config.MaxInFlight = 10
-	c, _ := nsq.NewConsumer(topice_name, channel_name, config)
+	drainTimeout    time.Duration
+	maxAttempts     int
+	backoff         time.Duration
+	deadLetterNSQD  []string
+	deadLetterTopic string
+	stats           time.Duration
+	config          *nsq.Config   // nil is nsq.NewConfig()
+	subscriber      mq.Subscriber // nil subscribes to nsq with the options above, tests pass a mq.Memory; dead letters need nsq
+}
 
-	c.AddConcurrentHandlers(nsq.HandlerFunc(func(message *nsq.Message) error {
-		time.Sleep(2 * time.Second)
-		fmt.Printf("C-%s\n", string(message.Body))
-		return nil
-	}), 25)
+func main() {
+	var (
+		o          options
+		lookupd    = flag.String("lookupd", "127.0.0.1:4161", "comma separated nsqlookupd HTTP addresses")
+		nsqd       = flag.String("nsqd", "", "comma separated nsqd TCP addresses to connect to directly")
+		deadLetter = flag.String("dead_letter_nsqd", "", "comma separated nsqd TCP addresses for dead letters, empty drops them")
+	)
+	flag.StringVar(&o.topic, "topic", topice_name, "topic to consume")
+	flag.StringVar(&o.channel, "channel", channel_name, "channel to consume")
+	flag.IntVar(&o.concurrency, "concurrency", 25, "concurrent handlers")
+	flag.IntVar(&o.maxInFlight, "max_in_flight", 0, "messages in flight over all nsqd, 0 is -concurrency")
+	flag.DurationVar(&o.sleep, "sleep", 2*time.Second, "time spent handling each message")
+	flag.Float64Var(&o.failRate, "fail_rate", 0, "fraction of messages the handler fails")
+	flag.IntVar(&o.batch, "batch", 0, "handle messages in batches of up to this size, 0 handles them one by one")
+	flag.DurationVar(&o.batchTimeout, "batch_timeout", 100*time.Millisecond, "handle a partial batch after this delay")
+	flag.BoolVar(&o.bench, "bench", false, "report throughput and latency of nsq_p -bench messages instead of printing them")
+	flag.DurationVar(&o.drainTimeout, "drain_timeout", 30*time.Second, "wait this long for messages in flight on shutdown")
+	flag.IntVar(&o.maxAttempts, "max_attempts", 5, "deliveries before a message is dead-lettered")
+	flag.DurationVar(&o.backoff, "backoff", time.Second, "requeue delay after the first failure, doubled on every further one")
+	flag.StringVar(&o.deadLetterTopic, "dead_letter_topic", "", "dead letter topic, empty is <topic>_dead")
+	flag.DurationVar(&o.stats, "stats", 10*time.Second, "print handler stats at this interval, 0 is off")
+	flag.Parse()
+	o.lookupd = splitAddrs(*lookupd)
+	o.nsqd = splitAddrs(*nsqd)
+	o.deadLetterNSQD = splitAddrs(*deadLetter)
 
-	err := c.ConnectToNSQLookupd("127.0.0.1:4161")
-	if err != nil {
-		panic(err)
-	}
 	termChan := make(chan os.Signal, 1)
 	signal.Notify(termChan, syscall.SIGTERM, syscall.SIGINT)
-	<-termChan
+	stop := make(chan struct{})
+	go func() {
+		<-termChan
+		close(stop)
+	}()
+	if err := run(o, stop, os.Stdout, os.Stderr); err != nil {
+		fmt.Fprintf(os.Stderr, "nsq_c: %v\n", err)
+		os.Exit(1)
+	}
 }
 
+func splitAddrs(s string) []string {
+	if s == "" {
+		return nil
+	}
+	return strings.Split(s, ",")
+}
 
+var errInjected = errors.New("injected failure")
+
+// run consumes until stop is closed and the messages in flight are drained.
+func run(o options, stop <-chan struct{}, out, errOut io.Writer) error {
+	if o.maxAttempts < 1 || o.maxAttempts > 65535 {
+		return fmt.Errorf("-max_attempts must be in 1-65535")
+	}
+	so := nsqsub.Options{
+		Topic:           o.topic,
+		Channel:         o.channel,
+		Lookupd:         o.lookupd,
+		NSQD:            o.nsqd,
+		Config:          o.config,
+		Concurrency:     o.concurrency,
+		MaxInFlight:     o.maxInFlight,
+		BatchSize:       o.batch,
+		BatchTimeout:    o.batchTimeout,
+		DrainTimeout:    o.drainTimeout,
+		MaxAttempts:     uint16(o.maxAttempts),
+		BackoffBase:     o.backoff,
+		DeadLetterTopic: o.deadLetterTopic,
+	}
+	if o.subscriber != nil && len(o.deadLetterNSQD) > 0 {
+		return fmt.Errorf("-dead_letter_nsqd needs nsq")
+	}
+	if len(o.deadLetterNSQD) > 0 {
+		p, err := nsqmq.NewProducer(nsqpub.Options{Addrs: o.deadLetterNSQD})
+		if err != nil {
+			return err
+		}
+		defer p.Stop()
+		so.DeadLetter = p
+	}
+
+	var mu sync.Mutex
+	var tr *nsqbench.Tracker
+	if o.bench {
+		tr = nsqbench.NewTracker()
+	}
+	handle := func(m mq.Message) error {
+		mu.Lock()
+		defer mu.Unlock()
+		if o.failRate > 0 && rand.Float64() < o.failRate {
+			return errInjected
+		}
+		if tr != nil {
+			tr.Record(m.Body(), time.Now())
+			return nil
+		}
+		fmt.Fprintf(out, "C-%s\n", string(m.Body()))
+		return nil
+	}
+	sub := o.subscriber
+	if sub == nil {
+		sub = nsqmq.NewSubscriber(so)
+	}
+	var (
+		c   mq.Consumer
+		err error
+	)
+	if o.batch > 0 {
+		c, err = sub.SubscribeBatch(o.topic, o.channel, func(ms []mq.Message) []error {
+			time.Sleep(o.sleep)
+			errs := make([]error, len(ms))
+			for i, m := range ms {
+				errs[i] = handle(m)
+			}
+			return errs
+		})
+	} else {
+		c, err = sub.Subscribe(o.topic, o.channel, func(m mq.Message) error {
+			time.Sleep(o.sleep)
+			return handle(m)
+		})
+	}
+	if err != nil {
+		return err
+	}
+	if err := c.Start(); err != nil {
+		c.Stop()
+		return err
+	}
+
+	var statsC <-chan time.Time
+	if o.stats > 0 {
+		t := time.NewTicker(o.stats)
+		defer t.Stop()
+		statsC = t.C
+	}
+	for {
+		select {
+		case <-statsC:
+			printStats(errOut, c)
+			if tr != nil {
+				fmt.Fprintln(out, tr.Report())
+			}
+			continue
+		case <-stop:
+		}
+		break
+	}
+	err = c.Stop()
+	printStats(errOut, c)
+	if tr != nil {
+		fmt.Fprintln(out, tr.Report())
+	}
+	return err
+}
+
+func printStats(w io.Writer, c mq.Consumer) {
+	switch c := c.(type) {
+	case *nsqsub.Consumer:
+		fmt.Fprintln(w, c.Stats())
+	case *mq.MemoryConsumer:
+		fmt.Fprintln(w, c.Stats())
+	}
+}
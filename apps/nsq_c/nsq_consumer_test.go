@@ -0,0 +1,251 @@
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/buptbill220/go_performance/lib/mq"
+	"github.com/buptbill220/go_performance/lib/nsqbench"
+	"github.com/buptbill220/go_performance/lib/nsqfake"
+	"github.com/nsqio/go-nsq"
+	"github.com/stretchr/testify/assert"
+)
+
+type syncBuffer struct {
+	mu sync.Mutex
+	b  bytes.Buffer
+}
+
+func (s *syncBuffer) Write(p []byte) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.b.Write(p)
+}
+
+func (s *syncBuffer) lines() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return strings.Fields(s.b.String())
+}
+
+func startNSQD(t *testing.T) *nsqfake.NSQD {
+	n, err := nsqfake.NewNSQD("127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(n.Close)
+	return n
+}
+
+func testOptions() options {
+	cfg := nsq.NewConfig()
+	cfg.LookupdPollInterval = 50 * time.Millisecond
+	return options{topic: topice_name, channel: channel_name, concurrency: 4, config: cfg,
+		drainTimeout: time.Second, maxAttempts: 5, backoff: time.Millisecond}
+}
+
+// runUntil runs the consumer until the channel finished want messages on every nsqd.
+func runUntil(t *testing.T, o options, want int, nsqds ...*nsqfake.NSQD) *syncBuffer {
+	out := &syncBuffer{}
+	stop := make(chan struct{})
+	done := make(chan error, 1)
+	go func() { done <- run(o, stop, out, ioutil.Discard) }()
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		finished := 0
+		for _, n := range nsqds {
+			finished += n.Channel(topice_name, channel_name).Finished
+		}
+		if finished >= want {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("finished %d of %d messages", finished, want)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	close(stop)
+	assert.NoError(t, <-done)
+	return out
+}
+
+func publish(n *nsqfake.NSQD, from, to int) {
+	for i := from; i < to; i++ {
+		n.Publish(topice_name, []byte(strconv.Itoa(i)+"-p"))
+	}
+}
+
+func TestRunNSQD(t *testing.T) {
+	n := startNSQD(t)
+	publish(n, 0, 20)
+	o := testOptions()
+	o.nsqd = []string{n.Addr()}
+	out := runUntil(t, o, 20, n)
+	lines := out.lines()
+	assert.Equal(t, 20, len(lines))
+	assert.Contains(t, lines, "C-19-p")
+	assert.Equal(t, 0, n.Channel(topice_name, channel_name).Depth)
+}
+
+func TestRunLookupd(t *testing.T) {
+	n1, n2 := startNSQD(t), startNSQD(t)
+	publish(n1, 0, 5)
+	publish(n2, 5, 10)
+	l, err := nsqfake.NewLookupd("127.0.0.1:0", n1, n2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+
+	o := testOptions()
+	o.lookupd = []string{l.Addr()}
+	// 先连上的拿走全部 MaxInFlight 做RDY, 后连上的要等 go-nsq 5s 后重试;
+	// 每个连接的RDY最多2500(nsqfake不改 max_rdy_count), 5000 两个连接都能马上拿到
+	o.maxInFlight = 5000
+	out := runUntil(t, o, 10, n1, n2)
+	assert.Equal(t, 10, len(out.lines()))
+	assert.Contains(t, out.lines(), "C-0-p")
+	assert.Contains(t, out.lines(), "C-9-p")
+}
+
+func TestRunSlowHandler(t *testing.T) {
+	n := startNSQD(t)
+	publish(n, 0, 8)
+	o := testOptions()
+	o.nsqd = []string{n.Addr()}
+	o.sleep = 50 * time.Millisecond
+	start := time.Now()
+	runUntil(t, o, 8, n)
+	// 4个handler并发处理, 8条消息大约两轮
+	assert.True(t, time.Since(start) < 8*50*time.Millisecond)
+}
+
+func TestRunNoAddr(t *testing.T) {
+	assert.Error(t, run(testOptions(), nil, ioutil.Discard, ioutil.Discard))
+}
+
+func TestRunConcurrency(t *testing.T) {
+	o := testOptions()
+	o.nsqd = []string{"127.0.0.1:4150"}
+	o.maxInFlight = 2
+	err := run(o, nil, ioutil.Discard, ioutil.Discard)
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(), "MaxInFlight")
+	}
+}
+
+func TestRunDeadLetter(t *testing.T) {
+	n := startNSQD(t)
+	publish(n, 0, 10)
+	o := testOptions()
+	o.nsqd = []string{n.Addr()}
+	o.deadLetterNSQD = []string{n.Addr()}
+	o.failRate = 1
+	o.maxAttempts = 2
+	out := runUntil(t, o, 10, n)
+	assert.Empty(t, out.lines())
+	assert.Equal(t, 10, len(n.Messages(topice_name+"_dead")))
+	assert.Equal(t, 10, n.Channel(topice_name, channel_name).Requeued)
+}
+
+func TestRunDrain(t *testing.T) {
+	n := startNSQD(t)
+	publish(n, 0, 4)
+	o := testOptions()
+	o.nsqd = []string{n.Addr()}
+	o.sleep = 100 * time.Millisecond
+	out := &syncBuffer{}
+	stop := make(chan struct{})
+	done := make(chan error, 1)
+	go func() { done <- run(o, stop, out, ioutil.Discard) }()
+	for n.Channel(topice_name, channel_name).InFlight < 4 {
+		time.Sleep(5 * time.Millisecond)
+	}
+	// 停的时候4条都在处理中, 处理完才退出
+	close(stop)
+	assert.NoError(t, <-done)
+	assert.Equal(t, 4, len(out.lines()))
+	assert.Equal(t, 4, n.Channel(topice_name, channel_name).Finished)
+}
+
+func TestRunBatch(t *testing.T) {
+	n := startNSQD(t)
+	publish(n, 0, 20)
+	o := testOptions()
+	o.nsqd = []string{n.Addr()}
+	o.concurrency = 1
+	o.batch = 10
+	o.batchTimeout = time.Second
+	o.sleep = 100 * time.Millisecond
+	start := time.Now()
+	out := runUntil(t, o, 20, n)
+	assert.Equal(t, 20, len(out.lines()))
+	// 两批各 sleep 一次, 一条一条处理要2s
+	assert.True(t, time.Since(start) < time.Second)
+}
+
+func TestRunBench(t *testing.T) {
+	n := startNSQD(t)
+	enc := nsqbench.NewEncoder(0)
+	for i := 0; i < 10; i++ {
+		b := enc.Next()
+		n.Publish(topice_name, b)
+		if i == 3 {
+			n.Publish(topice_name, b) // 重复
+		}
+	}
+	o := testOptions()
+	o.nsqd = []string{n.Addr()}
+	o.bench = true
+	out := runUntil(t, o, 11, n)
+	s := strings.Join(out.lines(), " ")
+	assert.Contains(t, s, "received=11 unique=10 dup=1 lost=0")
+	assert.NotContains(t, s, "C-")
+}
+
+func TestRunMemory(t *testing.T) {
+	b := mq.NewMemory()
+	for i := 0; i < 10; i++ {
+		b.Publish(topice_name, []byte(strconv.Itoa(i)+"-p"))
+	}
+	b.ConsumerOptions = mq.MemoryOptions{Concurrency: 4, BatchSize: 5, BatchTimeout: time.Second}
+	o := testOptions()
+	o.subscriber = b
+	runMemory := func(o options, want int) *syncBuffer {
+		out := &syncBuffer{}
+		stop := make(chan struct{})
+		done := make(chan error, 1)
+		go func() { done <- run(o, stop, out, ioutil.Discard) }()
+		deadline := time.Now().Add(5 * time.Second)
+		for len(out.lines()) < want && time.Now().Before(deadline) {
+			time.Sleep(5 * time.Millisecond)
+		}
+		close(stop)
+		assert.NoError(t, <-done)
+		return out
+	}
+	out := runMemory(o, 10)
+	assert.Equal(t, 10, len(out.lines()))
+	assert.Contains(t, out.lines(), "C-9-p")
+	assert.Equal(t, int64(0), b.Depth(topice_name, channel_name))
+
+	for i := 10; i < 20; i++ {
+		b.Publish(topice_name, []byte(strconv.Itoa(i)+"-p"))
+	}
+	o.batch = 10
+	o.sleep = 100 * time.Millisecond
+	start := time.Now()
+	out = runMemory(o, 10)
+	assert.Equal(t, 10, len(out.lines()))
+	assert.Contains(t, out.lines(), "C-19-p")
+	// 两批并发各 sleep 一次, 一条一条要1s
+	assert.True(t, time.Since(start) < 500*time.Millisecond)
+
+	o.deadLetterNSQD = []string{"127.0.0.1:4150"}
+	assert.Error(t, run(o, nil, ioutil.Discard, ioutil.Discard))
+}
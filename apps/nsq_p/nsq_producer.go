@@ -1,44 +1,189 @@
 package main
 
 import (
-	"github.com/nsqio/go-nsq"
-	"time"
-	"strconv"
+	"flag"
 	"fmt"
+	"io"
 	"os"
 	"os/signal"
+	"strconv"
+	"strings"
 	"syscall"
+	"time"
+
+	"github.com/buptbill220/go_performance/lib/mq"
+	"github.com/buptbill220/go_performance/lib/mq/nsqmq"
+	"github.com/buptbill220/go_performance/lib/nsqbench"
+	"github.com/buptbill220/go_performance/lib/nsqpub"
 )
 
+// 往一组nsqd发消息, 节点挂了自动切换, 每隔 -stats 打一次每个节点的发送数, 错误数和延迟
+//
+//	go run ./apps/nsq_p -nsqd=127.0.0.1:4150,127.0.0.1:4250 -strategy=latency -rate=100
+//	go run ./apps/nsq_p -batch=50 -batch_delay=20ms -rate=5000    // MultiPublish 攒批
+//	go run ./apps/nsq_p -defer=10s -count=10                      // DeferredPublish
+//	go run ./apps/nsq_p -bench -size=512 -rate=10000              // 消息带序号和发送时间, 配合 nsq_c -bench
+
 const (
 	topice_name = "fucker"
+	// 再大ticker间隔不到1µs, 超过1e9间隔是0, NewTicker会panic
+	maxRate = 1000000
 )
 
+type options struct {
+	addrs      []string
+	strategy   nsqpub.Strategy
+	topic      string
+	rate       int
+	count      int
+	batch      int
+	batchDelay time.Duration
+	deferBy    time.Duration
+	attempts   int
+	stats      time.Duration
+	bench      bool
+	size       int
+	producer   mq.Producer // nil publishes to addrs with nsqpub, it is not stopped by run
+}
+
 func main() {
+	var (
+		o        options
+		addrs    = flag.String("nsqd", "127.0.0.1:4150", "comma separated nsqd TCP addresses")
+		strategy = flag.String("strategy", "rr", "node selection: rr or latency")
+	)
+	flag.StringVar(&o.topic, "topic", topice_name, "topic to publish to")
+	flag.IntVar(&o.rate, "rate", 2, "messages per second")
+	flag.IntVar(&o.count, "count", 0, "stop after this many messages, 0 runs until interrupted")
+	flag.IntVar(&o.batch, "batch", 1, "publish with MPUB in batches of this size")
+	flag.DurationVar(&o.batchDelay, "batch_delay", 100*time.Millisecond, "flush a partial batch after this delay")
+	flag.DurationVar(&o.deferBy, "defer", 0, "publish with DPUB delayed by this duration")
+	flag.IntVar(&o.attempts, "attempts", 0, "publish attempts over all nodes, 0 is 2 per node")
+	flag.DurationVar(&o.stats, "stats", 10*time.Second, "print node stats at this interval, 0 is off")
+	flag.BoolVar(&o.bench, "bench", false, "send lib/nsqbench bodies with a sequence number and send time")
+	flag.IntVar(&o.size, "size", 128, "body size with -bench")
+	flag.Parse()
 
-	config := nsq.NewConfig()
+	var err error
+	if o.strategy, err = nsqpub.ParseStrategy(*strategy); err != nil {
+		fatal(err)
+	}
+	o.addrs = strings.Split(*addrs, ",")
 
-	writer1, _ :=nsq.NewProducer("127.0.0.1:23333", config)
-	writer2, _ := nsq.NewProducer("127.0.0.1:4150", config)
-	t1 := time.NewTicker(time.Millisecond * 500)
-	t2 := time.NewTicker(time.Second * 2)
-	go SendMsg(writer1, t1)
-	go SendMsg(writer2, t2)
 	termChan := make(chan os.Signal, 1)
 	signal.Notify(termChan, syscall.SIGTERM, syscall.SIGINT)
-	<-termChan
+	stop := make(chan struct{})
+	go func() {
+		<-termChan
+		close(stop)
+	}()
+	if err := run(o, stop, os.Stdout, os.Stderr); err != nil {
+		fatal(err)
+	}
 }
 
-func SendMsg(p *nsq.Producer, ticket *time.Ticker) {
-	times := 0
-	for {
-		<-ticket.C
-		msg := strconv.Itoa(times) + "-p"
-		fmt.Printf("MSG: %s\n", msg)
-		err := p.Publish(topice_name, []byte(msg))
+// run publishes until o.count messages were sent or stop is closed.
+func run(o options, stop <-chan struct{}, out, errOut io.Writer) error {
+	if o.rate <= 0 || o.rate > maxRate {
+		return fmt.Errorf("-rate %d not in [1, %d]", o.rate, maxRate)
+	}
+	if o.batch > 1 && o.deferBy > 0 {
+		return fmt.Errorf("-batch and -defer can not be used together")
+	}
+	p := o.producer
+	if p == nil {
+		np, err := nsqmq.NewProducer(nsqpub.Options{
+			Addrs:       o.addrs,
+			Strategy:    o.strategy,
+			MaxAttempts: o.attempts,
+		})
 		if err != nil {
-			fmt.Printf("%#v\n", err)
+			return err
+		}
+		defer np.Stop()
+		p = np
+	}
+
+	send := func(body []byte) error {
+		if o.deferBy > 0 {
+			return p.DeferredPublish(o.topic, o.deferBy, body)
+		}
+		return p.Publish(o.topic, body)
+	}
+	var b *mq.Batcher
+	if o.batch > 1 {
+		b = mq.NewBatcher(p, o.topic, o.batch, o.batchDelay, func(err error, bodies [][]byte) {
+			fmt.Fprintf(errOut, "dropped %d messages: %v\n", len(bodies), err)
+		})
+		send = b.Add
+	}
+
+	ticker := time.NewTicker(time.Second / time.Duration(o.rate))
+	defer ticker.Stop()
+	var statsC <-chan time.Time
+	if o.stats > 0 {
+		t := time.NewTicker(o.stats)
+		defer t.Stop()
+		statsC = t.C
+	}
+
+	sent, failed := 0, 0
+	next := func() []byte {
+		return []byte(strconv.Itoa(sent+failed) + "-p")
+	}
+	undo := func() {}
+	if o.bench {
+		enc := nsqbench.NewEncoder(o.size)
+		next = enc.Next
+		// 发失败的序号下一条再用, nsq_c 那边不算丢失; -batch 时整批丢掉的在上面的回调里报
+		if b == nil {
+			undo = enc.Undo
 		}
-		times += 1
 	}
+
+loop:
+	for o.count == 0 || sent+failed < o.count {
+		select {
+		case <-stop:
+			break loop
+		case <-statsC:
+			printStats(out, p, sent, failed)
+		case <-ticker.C:
+			msg := next()
+			if err := send(msg); err != nil {
+				fmt.Fprintf(errOut, "MSG %d: %v\n", sent+failed, err)
+				failed++
+				undo()
+				continue
+			}
+			sent++
+		}
+	}
+	if b != nil {
+		if err := b.Stop(); err != nil {
+			fmt.Fprintf(errOut, "flush: %v\n", err)
+		}
+	}
+	printStats(out, p, sent, failed)
+	return nil
+}
+
+func printStats(w io.Writer, p mq.Producer, sent, failed int) {
+	fmt.Fprintf(w, "sent=%d failed=%d\n", sent, failed)
+	np, ok := p.(*nsqpub.Publisher)
+	if !ok {
+		return
+	}
+	for _, st := range np.Stats() {
+		down := ""
+		if st.Down {
+			down = " down"
+		}
+		fmt.Fprintf(w, "  %-21s published=%d errors=%d latency=%v%s\n", st.Addr, st.Published, st.Errors, st.Latency, down)
+	}
+}
+
+func fatal(err error) {
+	fmt.Fprintf(os.Stderr, "nsq_p: %v\n", err)
+	os.Exit(2)
 }
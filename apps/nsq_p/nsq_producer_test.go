@@ -0,0 +1,164 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"io/ioutil"
+	"testing"
+	"time"
+
+	"github.com/buptbill220/go_performance/lib/mq"
+	"github.com/buptbill220/go_performance/lib/nsqbench"
+	"github.com/buptbill220/go_performance/lib/nsqfake"
+	"github.com/buptbill220/go_performance/lib/nsqpub"
+	"github.com/stretchr/testify/assert"
+)
+
+func startNSQD(t *testing.T) *nsqfake.NSQD {
+	n, err := nsqfake.NewNSQD("127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(n.Close)
+	return n
+}
+
+func testOptions(addrs ...string) options {
+	return options{addrs: addrs, topic: topice_name, rate: 1000, count: 20, batch: 1}
+}
+
+func TestRun(t *testing.T) {
+	n1, n2 := startNSQD(t), startNSQD(t)
+	var out bytes.Buffer
+	assert.NoError(t, run(testOptions(n1.Addr(), n2.Addr()), nil, &out, ioutil.Discard))
+	assert.Equal(t, 10, len(n1.Messages(topice_name)))
+	assert.Equal(t, 10, len(n2.Messages(topice_name)))
+	assert.Equal(t, "0-p", string(n1.Messages(topice_name)[0].Body))
+	assert.Contains(t, out.String(), "sent=20 failed=0")
+}
+
+func TestRunFailover(t *testing.T) {
+	n1, n2 := startNSQD(t), startNSQD(t)
+	n1.Close()
+	o := testOptions(n1.Addr(), n2.Addr())
+	o.strategy = nsqpub.LeastLatency
+	var out bytes.Buffer
+	assert.NoError(t, run(o, nil, &out, ioutil.Discard))
+	assert.Equal(t, 20, len(n2.Messages(topice_name)))
+	assert.Contains(t, out.String(), "sent=20 failed=0")
+	assert.Contains(t, out.String(), " down")
+}
+
+func TestRunBatch(t *testing.T) {
+	n := startNSQD(t)
+	o := testOptions(n.Addr())
+	o.batch = 8
+	o.batchDelay = time.Hour
+	assert.NoError(t, run(o, nil, ioutil.Discard, ioutil.Discard))
+	assert.Equal(t, 20, len(n.Messages(topice_name)))
+	mpub := 0
+	for _, c := range n.Commands() {
+		if c == "MPUB "+topice_name {
+			mpub++
+		}
+	}
+	assert.Equal(t, 3, mpub)
+}
+
+func TestRunDefer(t *testing.T) {
+	n := startNSQD(t)
+	o := testOptions(n.Addr())
+	o.count = 3
+	o.deferBy = time.Minute
+	assert.NoError(t, run(o, nil, ioutil.Discard, ioutil.Discard))
+	ms := n.Messages(topice_name)
+	if assert.Equal(t, 3, len(ms)) {
+		assert.Equal(t, time.Minute, ms[2].Defer)
+	}
+
+	o.batch = 2
+	assert.Error(t, run(o, nil, ioutil.Discard, ioutil.Discard))
+
+	o.batch, o.deferBy = 1, 0
+	o.rate = 2e9
+	assert.EqualError(t, run(o, nil, ioutil.Discard, ioutil.Discard), "-rate 2000000000 not in [1, 1000000]")
+}
+
+func TestRunStop(t *testing.T) {
+	n := startNSQD(t)
+	o := testOptions(n.Addr())
+	o.count = 0
+	stop := make(chan struct{})
+	time.AfterFunc(50*time.Millisecond, func() { close(stop) })
+	assert.NoError(t, run(o, stop, ioutil.Discard, ioutil.Discard))
+	assert.True(t, len(n.Messages(topice_name)) > 0)
+}
+
+func TestRunBench(t *testing.T) {
+	n := startNSQD(t)
+	o := testOptions(n.Addr())
+	o.count = 5
+	o.bench = true
+	o.size = 100
+	assert.NoError(t, run(o, nil, ioutil.Discard, ioutil.Discard))
+	ms := n.Messages(topice_name)
+	if assert.Equal(t, 5, len(ms)) {
+		assert.Equal(t, 100, len(ms[4].Body))
+		h, err := nsqbench.Decode(ms[4].Body)
+		assert.NoError(t, err)
+		assert.Equal(t, uint64(4), h.Seq)
+	}
+}
+
+// flaky fails every other Publish.
+type flaky struct {
+	mq.Producer
+	n int
+}
+
+func (f *flaky) Publish(topic string, body []byte) error {
+	f.n++
+	if f.n%2 == 0 {
+		return errors.New("publish failed")
+	}
+	return f.Producer.Publish(topic, body)
+}
+
+func TestRunBenchFailed(t *testing.T) {
+	b := mq.NewMemory()
+	o := testOptions()
+	o.producer = &flaky{Producer: b}
+	o.count = 10
+	o.bench = true
+	var out bytes.Buffer
+	assert.NoError(t, run(o, nil, &out, ioutil.Discard))
+	assert.Equal(t, "sent=5 failed=5\n", out.String())
+
+	// 失败的序号被后面的消息重用, 没有空洞
+	tr := nsqbench.NewTracker()
+	c := b.NewConsumer(topice_name, "ch", mq.MemoryOptions{}, func(m mq.Message) error {
+		_, err := tr.Record(m.Body(), time.Now())
+		return err
+	})
+	assert.NoError(t, c.Start())
+	deadline := time.Now().Add(5 * time.Second)
+	for tr.Unique() < 5 && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+	c.Stop()
+	r := tr.Report()
+	assert.Equal(t, uint64(5), r.Unique)
+	assert.Equal(t, uint64(0), r.Lost)
+}
+
+func TestRunMemory(t *testing.T) {
+	b := mq.NewMemory()
+	o := testOptions()
+	o.producer = b
+	o.batch = 8
+	o.batchDelay = time.Hour
+	var out bytes.Buffer
+	assert.NoError(t, run(o, nil, &out, ioutil.Discard))
+	assert.Equal(t, "sent=20 failed=0\n", out.String())
+	assert.Equal(t, int64(20), b.Depth(topice_name, ""))
+}
@@ -0,0 +1,216 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/buptbill220/go_performance/lib/schedprobe"
+)
+
+// schedtrace 里的时间是进程启动后的毫秒数, 用init时的时间近似进程启动时间
+var processStart = time.Now()
+
+type SchedTraceSample struct {
+	Time time.Time
+	schedprobe.SchedTrace
+}
+
+var schedTraces struct {
+	sync.Mutex
+	samples []SchedTraceSample
+}
+
+func collectSchedTrace(line string) {
+	t, ok := schedprobe.ParseSchedTrace(line)
+	if !ok {
+		return
+	}
+	schedTraces.Lock()
+	schedTraces.samples = append(schedTraces.samples, SchedTraceSample{Time: processStart.Add(t.Uptime), SchedTrace: t})
+	schedTraces.Unlock()
+}
+
+func schedTracesBetween(start, end time.Time) []SchedTraceSample {
+	schedTraces.Lock()
+	defer schedTraces.Unlock()
+	var out []SchedTraceSample
+	for _, s := range schedTraces.samples {
+		if !s.Time.Before(start) && !s.Time.After(end) {
+			out = append(out, s)
+		}
+	}
+	return out
+}
+
+type JobSample struct {
+	At    time.Time // tick time of the job
+	Delay time.Duration
+}
+
+type Outlier struct {
+	At       time.Duration // since the scenario started
+	Delay    time.Duration
+	GC       bool
+	Runnable int // deepest run queue seen during the delay, -1 if there is no sample
+}
+
+// Analysis correlates the slowest jobs with GC cycles and run-queue depth.
+type Analysis struct {
+	Threshold time.Duration
+	Outliers  []*Outlier // slowest first
+	InGC      int
+	// GCFraction is the share of the run covered by metrics windows with a GC cycle,
+	// InGC/len(Outliers) well above it means the outliers line up with GC
+	GCFraction       float64
+	RunnableSource   string
+	RunnableOutliers float64 // mean of Outlier.Runnable
+	RunnableAll      float64 // mean over all samples of the run
+	SchedP99         time.Duration
+	GCCycles         uint64
+}
+
+// minOutlier keeps runs without pressure from reporting sub-millisecond noise as outliers.
+const minOutlier = time.Millisecond
+
+type runnableSample struct {
+	t time.Time
+	n int
+}
+
+func Analyze(start time.Time, jobs []JobSample, samples []schedprobe.MetricsSample, traces []SchedTraceSample) *Analysis {
+	a := &Analysis{}
+	if len(samples) >= 2 {
+		first, last := samples[0], samples[len(samples)-1]
+		a.GCCycles = last.GCCycles - first.GCCycles
+		a.SchedP99 = schedprobe.LatencyQuantile(first.SchedLatencies, last.SchedLatencies, 0.99)
+		var gcTime time.Duration
+		for i := 1; i < len(samples); i++ {
+			if samples[i].GCCycles > samples[i-1].GCCycles {
+				gcTime += samples[i].Time.Sub(samples[i-1].Time)
+			}
+		}
+		if span := last.Time.Sub(first.Time); span > 0 {
+			a.GCFraction = float64(gcTime) / float64(span)
+		}
+	}
+
+	// schedtrace 是sysmon打的, CPU打满也不会漏; runtime/metrics 的采样goroutine自己也要排队
+	var runnable []runnableSample
+	if len(traces) > 0 {
+		a.RunnableSource = "schedtrace"
+		for _, t := range traces {
+			runnable = append(runnable, runnableSample{t.Time, t.Runnable()})
+		}
+	} else if len(samples) > 0 {
+		a.RunnableSource = "runtime/metrics"
+		for _, s := range samples {
+			runnable = append(runnable, runnableSample{s.Time, int(s.Runnable)})
+		}
+	}
+	if len(runnable) > 0 {
+		sum := 0
+		for _, r := range runnable {
+			sum += r.n
+		}
+		a.RunnableAll = float64(sum) / float64(len(runnable))
+	}
+
+	if len(jobs) == 0 {
+		return a
+	}
+	delays := make([]time.Duration, len(jobs))
+	for i, j := range jobs {
+		delays[i] = j.Delay
+	}
+	sort.Slice(delays, func(i, j int) bool { return delays[i] < delays[j] })
+	a.Threshold = delays[(len(delays)*99)/100]
+	if a.Threshold < minOutlier {
+		a.Threshold = minOutlier
+	}
+
+	runnableSum, runnableN := 0, 0
+	for _, j := range jobs {
+		if j.Delay < a.Threshold {
+			continue
+		}
+		end := j.At.Add(j.Delay)
+		o := &Outlier{At: j.At.Sub(start), Delay: j.Delay, Runnable: -1}
+		for i := 1; i < len(samples); i++ {
+			if samples[i].GCCycles > samples[i-1].GCCycles && samples[i-1].Time.Before(end) && samples[i].Time.After(j.At) {
+				o.GC = true
+				break
+			}
+		}
+		o.Runnable = maxRunnable(runnable, j.At, end)
+		if o.GC {
+			a.InGC++
+		}
+		if o.Runnable >= 0 {
+			runnableSum += o.Runnable
+			runnableN++
+		}
+		a.Outliers = append(a.Outliers, o)
+	}
+	if runnableN > 0 {
+		a.RunnableOutliers = float64(runnableSum) / float64(runnableN)
+	}
+	sort.SliceStable(a.Outliers, func(i, j int) bool { return a.Outliers[i].Delay > a.Outliers[j].Delay })
+	return a
+}
+
+// maxRunnable is the deepest run queue in [from, to], or in the first sample after it when the window is too short.
+func maxRunnable(samples []runnableSample, from, to time.Time) int {
+	max := -1
+	for _, s := range samples {
+		if s.t.Before(from) {
+			continue
+		}
+		if s.t.After(to) {
+			if max < 0 {
+				max = s.n
+			}
+			break
+		}
+		if s.n > max {
+			max = s.n
+		}
+	}
+	return max
+}
+
+const topOutliers = 10
+
+func (a *Analysis) Print(w io.Writer, name string) {
+	fmt.Fprintf(w, "\n%s: %d outliers >= %v\n", name, len(a.Outliers), a.Threshold.Round(10*time.Microsecond))
+	if len(a.Outliers) > 0 {
+		fmt.Fprintf(w, "  GC: %d/%d outliers overlap a GC cycle, GC windows cover %.0f%% of the run (%d cycles)\n",
+			a.InGC, len(a.Outliers), a.GCFraction*100, a.GCCycles)
+	} else {
+		fmt.Fprintf(w, "  GC: %d cycles, GC windows cover %.0f%% of the run\n", a.GCCycles, a.GCFraction*100)
+	}
+	if a.RunnableSource != "" {
+		fmt.Fprintf(w, "  run queue (%s): mean %.1f during outliers, %.1f over the run\n", a.RunnableSource, a.RunnableOutliers, a.RunnableAll)
+	}
+	fmt.Fprintf(w, "  sched latency p99 (runtime/metrics): %v\n", a.SchedP99)
+	if len(a.Outliers) == 0 {
+		return
+	}
+	fmt.Fprintf(w, "  %10s %10s %4s %9s\n", "at", "delay", "gc", "runnable")
+	for i, o := range a.Outliers {
+		if i == topOutliers {
+			break
+		}
+		gc := "no"
+		if o.GC {
+			gc = "yes"
+		}
+		runnable := "-"
+		if o.Runnable >= 0 {
+			runnable = fmt.Sprint(o.Runnable)
+		}
+		fmt.Fprintf(w, "  %10v %10v %4s %9s\n", o.At.Round(time.Millisecond), o.Delay.Round(10*time.Microsecond), gc, runnable)
+	}
+}
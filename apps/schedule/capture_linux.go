@@ -0,0 +1,66 @@
+//go:build linux
+
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"runtime/debug"
+	"strings"
+	"syscall"
+)
+
+// enableSchedTrace re-executes the program with GODEBUG=schedtrace=ms, the runtime only reads it at startup.
+func enableSchedTrace(ms int) error {
+	godebug := os.Getenv("GODEBUG")
+	if strings.Contains(godebug, "schedtrace=") {
+		return nil
+	}
+	if godebug != "" {
+		godebug += ","
+	}
+	godebug += fmt.Sprintf("schedtrace=%d", ms)
+	exe, err := os.Executable()
+	if err != nil {
+		return err
+	}
+	env := []string{"GODEBUG=" + godebug}
+	for _, kv := range os.Environ() {
+		if !strings.HasPrefix(kv, "GODEBUG=") {
+			env = append(env, kv)
+		}
+	}
+	return syscall.Exec(exe, os.Args, env)
+}
+
+// captureStderr points fd 2 at a pipe, schedtrace lines go to fn and the rest to the real stderr.
+// os.Stderr and crash output keep going to the real stderr directly.
+func captureStderr(fn func(line string)) error {
+	r, w, err := os.Pipe()
+	if err != nil {
+		return err
+	}
+	orig, err := syscall.Dup(2)
+	if err != nil {
+		return err
+	}
+	if err := syscall.Dup3(int(w.Fd()), 2, 0); err != nil {
+		return err
+	}
+	stderr := os.NewFile(uintptr(orig), "/dev/stderr")
+	os.Stderr = stderr
+	debug.SetCrashOutput(stderr, debug.CrashOptions{})
+	go func() {
+		sc := bufio.NewScanner(r)
+		for sc.Scan() {
+			line := sc.Text()
+			if strings.HasPrefix(line, "SCHED ") {
+				fn(line)
+			} else {
+				fmt.Fprintln(stderr, line)
+			}
+		}
+	}()
+	return nil
+}
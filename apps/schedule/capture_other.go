@@ -0,0 +1,15 @@
+//go:build !linux
+
+package main
+
+import "errors"
+
+var errCapture = errors.New("schedtrace capture is only supported on linux")
+
+func enableSchedTrace(ms int) error {
+	return errCapture
+}
+
+func captureStderr(fn func(line string)) error {
+	return errCapture
+}
@@ -1,83 +1,131 @@
 package main
 
 import (
-	"math/rand"
-	"time"
+	"flag"
+	"fmt"
 	"os"
 	"os/signal"
+	"path/filepath"
+	"strings"
 	"syscall"
-	"fmt"
+	"time"
+
+	"github.com/buptbill220/go_performance/lib/schedprobe"
 )
 
 // 用于模拟在CPU负载高的Case下, chan的调度会受到影响
+// 烧CPU的goroutine数, 忙闲比, GOMAXPROCS, chan缓冲, 生产QPS, 消费者数都可以配, 每个场景跑固定时长后出汇总
+//
+//	go run ./apps/schedule -burners=1000 -duty=0.5 -qps=250 -duration=10s
+//	go run ./apps/schedule -config=apps/schedule/scenarios.yaml
+//	go run ./apps/schedule -watch    // 不停地跑, 每秒打一次 schedprobe 的唤醒延迟
+//	go run ./apps/schedule -duty=0.9 -pool    // 同样的负载经过 lib/workpool, 对比p99
+//
+// 延迟有毛刺时看是GC还是run queue太长:
+//
+//	go run ./apps/schedule -duty=0.9 -metrics=20ms -schedtrace=100ms -trace=schedule.trace
+//
+// -schedtrace 会带上 GODEBUG=schedtrace 重新exec自己; 汇总后面会按场景列出最慢的1%的job
+// 和GC周期, run queue 长度的对应关系; trace 文件用 go tool trace 看, 多个场景时文件名带场景名
 
+func main() {
+	def := DefaultScenario()
+	var (
+		config = flag.String("config", "", "yaml scenario file, the other scenario flags are ignored")
+		watch  = flag.Bool("watch", false, "run until interrupted and print scheduler latency every second")
 
-const (
-	loop_size = 1000000 // 100w
-	goroutine_size = 1000
-)
-func  calculateSomething() {
-	sum := 0
-	for i := 0; i < loop_size; i++ {
-		sum += rand.Int()
-	}
-}
+		tracePath  = flag.String("trace", "", "write a runtime/trace of each scenario")
+		metrics    = flag.Duration("metrics", 0, "sample runtime/metrics at this interval, 0 is off")
+		schedtrace = flag.Duration("schedtrace", 0, "capture GODEBUG=schedtrace at this interval (linux), 0 is off")
 
-type job struct {
-	addTime int
-}
+		s = def
+	)
+	flag.StringVar(&s.Name, "name", def.Name, "scenario name")
+	flag.DurationVar(&s.Duration, "duration", def.Duration, "how long to run")
+	flag.IntVar(&s.Procs, "procs", def.Procs, "GOMAXPROCS, 0 keeps the default")
+	flag.IntVar(&s.Burners, "burners", def.Burners, "CPU burning goroutines")
+	flag.Float64Var(&s.Duty, "duty", def.Duty, "fraction of burn_period each burner is busy")
+	flag.DurationVar(&s.BurnPeriod, "burn_period", def.BurnPeriod, "busy + idle period of a burner")
+	flag.IntVar(&s.QPS, "qps", def.QPS, "jobs emitted per second")
+	flag.IntVar(&s.Buffer, "buffer", def.Buffer, "job chan buffer size")
+	flag.IntVar(&s.Consumers, "consumers", def.Consumers, "consumer goroutines")
+	flag.BoolVar(&s.Pool, "pool", def.Pool, "run jobs and burners through a workpool with priority lanes")
+	flag.IntVar(&s.PoolLowWorkers, "pool_low_workers", def.PoolLowWorkers, "concurrent burns in pool mode, 0 is GOMAXPROCS-1")
+	flag.IntVar(&s.PoolQueue, "pool_queue", def.PoolQueue, "queued burns in pool mode before burners wait, 0 is 4*pool_low_workers")
+	flag.Parse()
+
+	if *schedtrace > 0 {
+		if err := startSchedTrace(*schedtrace); err != nil {
+			fmt.Fprintf(os.Stderr, "schedule: schedtrace: %v\n", err)
+			os.Exit(1)
+		}
+	}
 
-var jobChan = make(chan *job)
+	scenarios := []Scenario{s}
+	if *config != "" {
+		var err error
+		if scenarios, err = LoadScenarios(*config); err != nil {
+			fmt.Fprintf(os.Stderr, "schedule: %v\n", err)
+			os.Exit(1)
+		}
+	} else if err := s.Validate(); err != nil {
+		fmt.Fprintf(os.Stderr, "schedule: %v\n", err)
+		os.Exit(2)
+	}
 
-func emitJob() {
-	j := &job{addTime: time.Now().Nanosecond()}
-	jobChan<-j
-}
+	if *watch {
+		s := scenarios[0]
+		s.Duration = 365 * 24 * time.Hour
+		go Run(s, Capture{})
+		Watch()
+		return
+	}
 
-func InitCPUCost() {
-	for i := 0; i < goroutine_size; i++ {
-		go func() {
-			interval := int(rand.Int31n(100)) + 1
-			t := time.NewTicker(time.Duration(interval) * time.Millisecond)
-			for {
-				<-t.C
-				calculateSomething()
+	var results []*Result
+	for _, s := range scenarios {
+		c := Capture{Metrics: *metrics, SchedTrace: *schedtrace > 0}
+		if *tracePath != "" {
+			c.Trace = *tracePath
+			if len(scenarios) > 1 {
+				ext := filepath.Ext(c.Trace)
+				c.Trace = strings.TrimSuffix(c.Trace, ext) + "-" + s.Name + ext
 			}
-		}()
+		}
+		fmt.Fprintf(os.Stderr, "running %s for %v\n", s.Name, s.Duration)
+		r, err := Run(s, c)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "schedule: %s: %v\n", s.Name, err)
+			os.Exit(1)
+		}
+		results = append(results, r)
 	}
+	PrintReport(os.Stdout, results)
 }
 
-
-// 模拟单机QPS, 4ms一个, 1s 250qps
-func InitQPSEmiter() {
-	go func() {
-		t := time.NewTicker(time.Millisecond * time.Duration(4))
-		for {
-			<-t.C
-			emitJob()
-		}
-	}()
+func startSchedTrace(interval time.Duration) error {
+	ms := int(interval / time.Millisecond)
+	if ms <= 0 {
+		ms = 1
+	}
+	if err := enableSchedTrace(ms); err != nil {
+		return err
+	}
+	return captureStderr(collectSchedTrace)
 }
 
-func ConsumerJob() {
+// Watch prints the scheduler latency every second until SIGTERM or SIGINT.
+func Watch() {
+	p := schedprobe.Start(schedprobe.Options{})
+	termChan := make(chan os.Signal, 1)
+	signal.Notify(termChan, syscall.SIGTERM, syscall.SIGINT)
+	t := time.NewTicker(time.Second)
 	for {
 		select {
-		case j := <-jobChan:
-			delta := (time.Now().Nanosecond() - j.addTime) / 1000 / 1000
-			if delta > 0 {
-				fmt.Printf("delta-ms: %d\n", delta)
-			}
+		case <-termChan:
+			return
+		case <-t.C:
+			st := p.StatsAndReset()
+			fmt.Printf("wakeup: %v\ntimer:  %v stalled=%v\n", st.Wakeup, st.Timer, st.Stalled)
 		}
 	}
 }
-
-func main() {
-	InitCPUCost()
-
-	go ConsumerJob()
-
-	InitQPSEmiter()
-	termChan := make(chan os.Signal, 1)
-	signal.Notify(termChan, syscall.SIGTERM, syscall.SIGINT)
-	<-termChan
-}
\ No newline at end of file
@@ -0,0 +1,383 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math/rand"
+	"os"
+	"runtime"
+	"runtime/trace"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/buptbill220/go_performance/lib/schedprobe"
+	"github.com/buptbill220/go_performance/lib/workpool"
+	"gopkg.in/yaml.v2"
+)
+
+// Scenario is one setting of the experiment, yaml keys are the same as the flags.
+type Scenario struct {
+	Name     string        `yaml:"name"`
+	Duration time.Duration `yaml:"duration"`
+	// GOMAXPROCS, 0 keeps the current value
+	Procs int `yaml:"procs"`
+
+	// 烧CPU的goroutine数, 每个在 BurnPeriod 里忙 Duty 比例的时间, 其余时间sleep
+	Burners    int           `yaml:"burners"`
+	Duty       float64       `yaml:"duty"`
+	BurnPeriod time.Duration `yaml:"burn_period"`
+
+	QPS       int `yaml:"qps"`
+	Buffer    int `yaml:"buffer"`
+	Consumers int `yaml:"consumers"`
+
+	// Pool 时job走 workpool 的High lane(Consumers个worker, Buffer是队列长度),
+	// 烧CPU的工作作为Low任务提交, 并发是 PoolLowWorkers(默认GOMAXPROCS-1), 队列 PoolQueue 满了burner等着有空位,
+	// 负载和不走pool时一样, 只是限了并发
+	Pool           bool `yaml:"pool"`
+	PoolLowWorkers int  `yaml:"pool_low_workers"`
+	PoolQueue      int  `yaml:"pool_queue"`
+}
+
+func DefaultScenario() Scenario {
+	// 和最早写死的一样: 1000个goroutine, 4ms一个job(250qps), 无缓冲chan, 一个消费者
+	return Scenario{
+		Name:       "default",
+		Duration:   10 * time.Second,
+		Burners:    1000,
+		Duty:       0.5,
+		BurnPeriod: 100 * time.Millisecond,
+		QPS:        250,
+		Consumers:  1,
+	}
+}
+
+// scenarioFile is
+//
+//	defaults: {duration: 10s, qps: 250}
+//	scenarios:
+//	  - {name: idle, burners: 0}
+//	  - {name: busy, burners: 1000, duty: 0.8}
+//
+// 每个scenario在 defaults 的基础上覆盖, 所以 burners: 0 这种零值也能写
+type scenarioFile struct {
+	Defaults  yaml.MapSlice   `yaml:"defaults"`
+	Scenarios []yaml.MapSlice `yaml:"scenarios"`
+}
+
+func LoadScenarios(path string) ([]Scenario, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return ParseScenarios(data)
+}
+
+func ParseScenarios(data []byte) ([]Scenario, error) {
+	var f scenarioFile
+	if err := yaml.UnmarshalStrict(data, &f); err != nil {
+		return nil, err
+	}
+	if len(f.Scenarios) == 0 {
+		return nil, fmt.Errorf("no scenarios")
+	}
+	defaults := DefaultScenario()
+	if err := overlay(&defaults, f.Defaults); err != nil {
+		return nil, fmt.Errorf("defaults: %v", err)
+	}
+	scenarios := make([]Scenario, len(f.Scenarios))
+	for i, m := range f.Scenarios {
+		s := defaults
+		s.Name = fmt.Sprintf("scenario-%d", i+1)
+		if err := overlay(&s, m); err != nil {
+			return nil, fmt.Errorf("%s: %v", s.Name, err)
+		}
+		if err := s.Validate(); err != nil {
+			return nil, fmt.Errorf("%s: %v", s.Name, err)
+		}
+		scenarios[i] = s
+	}
+	return scenarios, nil
+}
+
+// overlay sets the fields present in m.
+func overlay(s *Scenario, m yaml.MapSlice) error {
+	if len(m) == 0 {
+		return nil
+	}
+	data, err := yaml.Marshal(m)
+	if err != nil {
+		return err
+	}
+	return yaml.UnmarshalStrict(data, s)
+}
+
+// maxQPS 以上ticker间隔不到1µs, 超过1e9时间隔算出来是0, NewTicker会panic
+const maxQPS = 1000000
+
+func (s *Scenario) Validate() error {
+	switch {
+	case s.Duration <= 0:
+		return fmt.Errorf("duration must be positive")
+	case s.Duty < 0 || s.Duty > 1:
+		return fmt.Errorf("duty %v not in [0, 1]", s.Duty)
+	case s.Burners > 0 && s.BurnPeriod <= 0:
+		return fmt.Errorf("burn_period must be positive")
+	case s.QPS <= 0 || s.QPS > maxQPS:
+		return fmt.Errorf("qps %d not in [1, %d]", s.QPS, maxQPS)
+	case s.Consumers <= 0:
+		return fmt.Errorf("consumers must be positive")
+	case s.Buffer < 0 || s.Procs < 0 || s.PoolLowWorkers < 0 || s.PoolQueue < 0:
+		return fmt.Errorf("buffer, procs and pool sizes can not be negative")
+	}
+	return nil
+}
+
+type Result struct {
+	Scenario Scenario
+	Procs    int // GOMAXPROCS actually used
+	// Expected is QPS*Duration, the ticker drops ticks when the producer is not scheduled in time
+	Expected int64
+	Emitted  int64
+	Consumed int64
+	Dropped  int64 // rejected by the pool's High lane
+	// Burns is the busy periods the burners got done, in pool mode they wait for the Low lane instead of being
+	// dropped, so the CPU stays as busy as without the pool and fewer burns finish
+	Burns int64
+	// Delay is from the tick that emits a job to a consumer receiving it, so it includes the producer's own wake-up delay.
+	// SendBlock is how long the producer waited on the chan.
+	Delay     schedprobe.Summary
+	SendBlock schedprobe.Summary
+	Sched     schedprobe.Stats
+	Pool      *workpool.Stats
+
+	Start      time.Time
+	Jobs       []JobSample
+	Metrics    []schedprobe.MetricsSample
+	SchedTrace []SchedTraceSample
+	Analysis   *Analysis // nil unless some capture was on
+}
+
+// Capture is what to record besides the latencies, the zero value records nothing.
+type Capture struct {
+	Trace      string        // runtime/trace output file
+	Metrics    time.Duration // runtime/metrics sampling interval
+	SchedTrace bool          // keep the GODEBUG=schedtrace lines collected during the run
+}
+
+func (c Capture) enabled() bool {
+	return c.Trace != "" || c.Metrics > 0 || c.SchedTrace
+}
+
+// maxJobSamples bounds the memory of the per-job delays kept for the analysis.
+const maxJobSamples = 1 << 20
+
+type job struct {
+	addTime time.Time
+}
+
+// Run runs a scenario for its duration, GOMAXPROCS is restored afterwards.
+func Run(s Scenario, c Capture) (*Result, error) {
+	old := runtime.GOMAXPROCS(0)
+	if s.Procs > 0 {
+		runtime.GOMAXPROCS(s.Procs)
+	}
+	defer runtime.GOMAXPROCS(old)
+
+	res := &Result{Scenario: s, Procs: runtime.GOMAXPROCS(0), Expected: int64(s.Duration.Seconds() * float64(s.QPS))}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	var wg sync.WaitGroup
+
+	if c.Trace != "" {
+		f, err := os.Create(c.Trace)
+		if err != nil {
+			return nil, err
+		}
+		defer f.Close()
+		if err := trace.Start(f); err != nil {
+			return nil, err
+		}
+		defer trace.Stop()
+	}
+	var recorder *schedprobe.MetricsRecorder
+	if c.Metrics > 0 {
+		recorder = schedprobe.RecordMetrics(c.Metrics)
+	}
+	var pool *workpool.Pool
+	if s.Pool {
+		pool = workpool.New(workpool.Options{
+			HighWorkers: s.Consumers,
+			HighQueue:   s.Buffer,
+			LowWorkers:  s.PoolLowWorkers,
+			LowQueue:    s.PoolQueue,
+		})
+	}
+	res.Start = time.Now()
+	probe := schedprobe.Start(schedprobe.Options{})
+	for i := 0; i < s.Burners; i++ {
+		wg.Add(1)
+		go func(seed int64) {
+			defer wg.Done()
+			burn(ctx, s.Duty, s.BurnPeriod, rand.New(rand.NewSource(seed)), pool, &res.Burns)
+		}(int64(i))
+	}
+
+	var (
+		mu        sync.Mutex
+		delay     schedprobe.Histogram
+		sendBlock schedprobe.Histogram
+		consumers sync.WaitGroup
+	)
+	consume := func(j *job) {
+		d := time.Since(j.addTime)
+		atomic.AddInt64(&res.Consumed, 1)
+		mu.Lock()
+		delay.Record(d)
+		if c.enabled() && len(res.Jobs) < maxJobSamples {
+			res.Jobs = append(res.Jobs, JobSample{At: j.addTime, Delay: d})
+		}
+		mu.Unlock()
+	}
+	jobs := make(chan *job, s.Buffer)
+	if pool == nil {
+		for i := 0; i < s.Consumers; i++ {
+			consumers.Add(1)
+			go func() {
+				defer consumers.Done()
+				for j := range jobs {
+					consume(j)
+				}
+			}()
+		}
+	}
+
+	t := time.NewTicker(time.Second / time.Duration(s.QPS))
+	timeout := time.After(s.Duration)
+emit:
+	for {
+		select {
+		case <-timeout:
+			break emit
+		case tick := <-t.C:
+			// tick 是计划的时间, 带单调时钟
+			j := &job{addTime: tick}
+			res.Emitted++
+			if pool != nil {
+				if pool.Submit(workpool.High, func() { consume(j) }) != nil {
+					res.Dropped++
+				}
+				continue
+			}
+			start := time.Now()
+			jobs <- j
+			sendBlock.Record(time.Since(start))
+		}
+	}
+	t.Stop()
+	end := time.Now()
+	res.Sched = probe.Stats()
+	probe.Stop()
+	if recorder != nil {
+		res.Metrics = recorder.Stop()
+	}
+	if c.SchedTrace {
+		res.SchedTrace = schedTracesBetween(res.Start, end)
+	}
+	cancel()
+	close(jobs)
+	consumers.Wait()
+	wg.Wait()
+	if pool != nil {
+		pool.Close()
+		st := pool.Stats()
+		res.Pool = &st
+	}
+
+	mu.Lock()
+	res.Delay = schedprobe.Summarize(&delay)
+	mu.Unlock()
+	res.SendBlock = schedprobe.Summarize(&sendBlock)
+	if c.enabled() {
+		sort.Slice(res.Jobs, func(i, j int) bool { return res.Jobs[i].At.Before(res.Jobs[j].At) })
+		res.Analysis = Analyze(res.Start, res.Jobs, res.Metrics, res.SchedTrace)
+	}
+	return res, nil
+}
+
+// burn 每个周期忙 duty*period, 开始时随机错开, 免得所有goroutine同时醒
+// pool 不为nil时忙的部分作为Low任务交给pool, 用 SubmitWait 等Low lane的空位, 不丢; done 原子地计数
+func burn(ctx context.Context, duty float64, period time.Duration, r *rand.Rand, pool *workpool.Pool, done *int64) {
+	busy := time.Duration(float64(period) * duty)
+	idle := period - busy
+	time.Sleep(time.Duration(r.Int63n(int64(period) + 1)))
+	checkpoint := func() {}
+	if pool != nil {
+		checkpoint = pool.Checkpoint
+	}
+	work := func() {
+		sum := 0
+		deadline := time.Now().Add(busy)
+		for time.Now().Before(deadline) {
+			for i := 0; i < 1000; i++ {
+				sum += r.Int()
+			}
+			checkpoint()
+		}
+	}
+	for {
+		if ctx.Err() != nil {
+			return
+		}
+		if pool == nil {
+			work()
+		} else {
+			finished := make(chan struct{})
+			if pool.SubmitWait(ctx, workpool.Low, func() { work(); close(finished) }) != nil {
+				return
+			}
+			<-finished
+		}
+		atomic.AddInt64(done, 1)
+		if idle > 0 {
+			time.Sleep(idle)
+		}
+	}
+}
+
+func ms(d time.Duration) string {
+	return fmt.Sprintf("%.2f", float64(d)/float64(time.Millisecond))
+}
+
+// PrintReport writes one row per scenario, latencies in ms.
+func PrintReport(w io.Writer, results []*Result) {
+	fmt.Fprintf(w, "%-16s %5s %7s %5s %6s %6s %4s %8s %8s %8s %8s %8s %8s %8s %8s %8s\n",
+		"scenario", "procs", "burners", "duty", "qps", "buffer", "cons", "jobs",
+		"p50", "p99", "max", "burns", "send-p99", "wake-p50", "wake-p99", "wake-max")
+	for _, r := range results {
+		s := r.Scenario
+		fmt.Fprintf(w, "%-16s %5d %7d %5.2f %6d %6d %4d %8s %8s %8s %8s %8d %8s %8s %8s %8s\n",
+			s.Name, r.Procs, s.Burners, s.Duty, s.QPS, s.Buffer, s.Consumers,
+			fmt.Sprintf("%d/%d", r.Emitted, r.Expected),
+			ms(r.Delay.P50), ms(r.Delay.P99), ms(r.Delay.Max),
+			r.Burns, ms(r.SendBlock.P99),
+			ms(r.Sched.Wakeup.P50), ms(r.Sched.Wakeup.P99), ms(r.Sched.Wakeup.Max))
+	}
+	fmt.Fprintln(w, "jobs: emitted/expected, latencies in ms; p50/p99/max: tick -> consumer, burns: busy periods done,")
+	fmt.Fprintln(w, "send: producer blocked on chan, wake: schedprobe; with pool the burns wait for pool_low_workers, the CPU is as busy")
+	for _, r := range results {
+		if p := r.Pool; p != nil {
+			fmt.Fprintf(w, "%s: pool high %d workers, %d dropped; low %d workers, %d burns run\n",
+				r.Scenario.Name, p.High.Workers, r.Dropped, p.Low.Workers, p.Low.Completed)
+		}
+	}
+	for _, r := range results {
+		if r.Analysis != nil {
+			r.Analysis.Print(w, r.Scenario.Name)
+		}
+	}
+}
@@ -0,0 +1,151 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/buptbill220/go_performance/lib/schedprobe"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseScenarios(t *testing.T) {
+	ss, err := ParseScenarios([]byte(`
+defaults:
+  duration: 2s
+  qps: 100
+scenarios:
+  - name: idle
+    burners: 0
+  - duty: 0.9
+    buffer: 16
+`))
+	assert.Nil(t, err)
+	assert.Equal(t, 2, len(ss))
+	assert.Equal(t, "idle", ss[0].Name)
+	assert.Equal(t, 0, ss[0].Burners)
+	assert.Equal(t, 2*time.Second, ss[0].Duration)
+	assert.Equal(t, 100, ss[0].QPS)
+	assert.Equal(t, "scenario-2", ss[1].Name)
+	assert.Equal(t, 1000, ss[1].Burners)
+	assert.Equal(t, 0.9, ss[1].Duty)
+	assert.Equal(t, 16, ss[1].Buffer)
+	assert.Equal(t, 100*time.Millisecond, ss[1].BurnPeriod)
+
+	_, err = ParseScenarios([]byte("scenarios:\n  - {duty: 2}\n"))
+	assert.EqualError(t, err, "scenario-1: duty 2 not in [0, 1]")
+	_, err = ParseScenarios([]byte("scenarios:\n  - {qps: 2000000000}\n"))
+	assert.EqualError(t, err, "scenario-1: qps 2000000000 not in [1, 1000000]")
+	_, err = ParseScenarios([]byte("scenarios:\n  - {qsp: 2}\n"))
+	assert.NotNil(t, err, "unknown keys are errors")
+	_, err = ParseScenarios([]byte("defaults: {qps: 1}\n"))
+	assert.EqualError(t, err, "no scenarios")
+}
+
+func TestScenariosFile(t *testing.T) {
+	ss, err := LoadScenarios("scenarios.yaml")
+	assert.Nil(t, err)
+	assert.Equal(t, "idle", ss[0].Name)
+	assert.Equal(t, 0, ss[0].Burners)
+}
+
+func TestRun(t *testing.T) {
+	s := DefaultScenario()
+	s.Duration = 200 * time.Millisecond
+	s.Burners = 1
+	s.Duty = 0.2
+	s.BurnPeriod = 10 * time.Millisecond
+	s.QPS = 500
+	s.Buffer = 4
+	s.Consumers = 2
+	r, err := Run(s, Capture{})
+	assert.Nil(t, err)
+	assert.True(t, r.Emitted > 10, "%d", r.Emitted)
+	assert.Equal(t, r.Emitted, r.Consumed)
+	assert.Equal(t, uint64(r.Consumed), r.Delay.Count)
+	assert.True(t, r.Sched.Wakeup.Count > 0)
+	assert.Nil(t, r.Analysis)
+	assert.Equal(t, 0, len(r.Jobs))
+	assert.True(t, r.Burns > 0)
+
+	var buf bytes.Buffer
+	PrintReport(&buf, []*Result{r})
+	assert.Contains(t, buf.String(), "default ")
+}
+
+func TestAnalyze(t *testing.T) {
+	start := time.Now()
+	at := func(ms int) time.Time { return start.Add(time.Duration(ms) * time.Millisecond) }
+	var jobs []JobSample
+	for i := 0; i < 200; i++ {
+		jobs = append(jobs, JobSample{At: at(i * 5), Delay: 100 * time.Microsecond})
+	}
+	// 两个毛刺, 一个在GC里, 一个在run queue很长的时候
+	jobs[40].Delay = 30 * time.Millisecond  // 200ms
+	jobs[150].Delay = 20 * time.Millisecond // 750ms
+	var samples []schedprobe.MetricsSample
+	for i := 0; i <= 10; i++ {
+		s := schedprobe.MetricsSample{Time: at(i * 100), GCCycles: 1}
+		if i >= 3 {
+			s.GCCycles = 2 // GC 在 200ms~300ms 之间
+		}
+		samples = append(samples, s)
+	}
+	var traces []SchedTraceSample
+	for i := 0; i < 20; i++ {
+		st := schedprobe.SchedTrace{RunQueue: 2, LocalRunQueues: []int{1}}
+		if i == 15 {
+			st.RunQueue = 97 // 750ms
+		}
+		traces = append(traces, SchedTraceSample{Time: at(i*50 + 1), SchedTrace: st})
+	}
+
+	a := Analyze(start, jobs, samples, traces)
+	assert.Equal(t, 2, len(a.Outliers))
+	assert.Equal(t, 20*time.Millisecond, a.Threshold)
+	assert.Equal(t, 30*time.Millisecond, a.Outliers[0].Delay)
+	assert.Equal(t, 200*time.Millisecond, a.Outliers[0].At)
+	assert.True(t, a.Outliers[0].GC)
+	assert.False(t, a.Outliers[1].GC)
+	assert.Equal(t, 98, a.Outliers[1].Runnable)
+	assert.Equal(t, 1, a.InGC)
+	assert.Equal(t, uint64(1), a.GCCycles)
+	assert.InDelta(t, 0.1, a.GCFraction, 1e-9)
+	assert.Equal(t, "schedtrace", a.RunnableSource)
+	assert.InDelta(t, 3+95.0/20, a.RunnableAll, 1e-9)
+
+	var buf bytes.Buffer
+	a.Print(&buf, "test")
+	assert.Contains(t, buf.String(), "1/2 outliers overlap a GC cycle, GC windows cover 10% of the run")
+
+	// 没有压力的时候不报亚毫秒的抖动
+	a = Analyze(start, jobs[:10], samples, nil)
+	assert.Equal(t, 0, len(a.Outliers))
+	assert.Equal(t, "runtime/metrics", a.RunnableSource)
+}
+
+func TestRunPool(t *testing.T) {
+	s := DefaultScenario()
+	s.Duration = 200 * time.Millisecond
+	s.Burners = 8
+	s.Duty = 0.9
+	s.BurnPeriod = 10 * time.Millisecond
+	s.QPS = 500
+	s.Pool = true
+	s.PoolLowWorkers = 1
+	s.PoolQueue = 2
+	r, err := Run(s, Capture{})
+	assert.Nil(t, err)
+	assert.NotNil(t, r.Pool)
+	assert.Equal(t, r.Emitted, r.Consumed+r.Dropped)
+	// 8 burners, 1 worker, 队列2: burner 等空位, 只有停的时候还在等的算 Rejected
+	assert.True(t, r.Pool.Low.Rejected <= int64(s.Burners), "%d", r.Pool.Low.Rejected)
+	assert.True(t, r.Burns > 0)
+	assert.Equal(t, r.Pool.Low.Completed, r.Burns)
+	assert.Equal(t, 1, r.Pool.Low.Workers)
+
+	var buf bytes.Buffer
+	PrintReport(&buf, []*Result{r})
+	assert.Contains(t, buf.String(), fmt.Sprintf("default: pool high 1 workers, %d dropped; low 1 workers, %d burns run", r.Dropped, r.Burns))
+}
@@ -0,0 +1,26 @@
+// Code generated by jsongen -type=extraInfo; DO NOT EDIT.
+
+package build_in
+
+import "github.com/buptbill220/go_performance/lib/jsonenc"
+
+// AppendJSON appends the JSON encoding of p to dst, same as encoding/json.
+func (p *extraInfo) AppendJSON(dst []byte) []byte {
+	if p == nil {
+		return append(dst, "null"...)
+	}
+	dst = append(dst, '{')
+	dst = append(dst, "\"v_show\":"...)
+	dst = jsonenc.AppendInt(dst, int64(p.VivoShow))
+	dst = append(dst, ",\"v_click\":"...)
+	dst = jsonenc.AppendInt(dst, int64(p.VClick))
+	dst = append(dst, ",\"v_convt\":"...)
+	dst = jsonenc.AppendInt(dst, int64(p.VConvt))
+	dst = append(dst, ",\"n_v_show\":"...)
+	dst = jsonenc.AppendInt(dst, int64(p.NVShow))
+	dst = append(dst, ",\"nv_click\":"...)
+	dst = jsonenc.AppendInt(dst, int64(p.NVClick))
+	dst = append(dst, ",\"n_v_convt\":"...)
+	dst = jsonenc.AppendInt(dst, int64(p.NVConvert))
+	return append(dst, '}')
+}
@@ -6,6 +6,19 @@ import (
 	//"fmt"
 )
 
+// go test -v -bench=Json explicit_type_map_vs_strcut_test.go explicit_type_map_vs_strcut_json_test.go -benchmem
+
+//go:generate go run ../cmd/jsongen -type=extraInfo explicit_type_map_vs_strcut_test.go
+
+type extraInfo struct {
+	VivoShow  int64 `json:"v_show"`
+	VClick    int64 `json:"v_click"`
+	VConvt    int64 `json:"v_convt"`
+	NVShow    int64 `json:"n_v_show"`
+	NVClick   int64 `json:"nv_click"`
+	NVConvert int64 `json:"n_v_convt"`
+}
+
 func mapToJson() {
 	m := map[string]int64{
 		"v_show":    1,
@@ -35,6 +48,23 @@ func structToJson() {
 	//fmt.Printf("%#v, err: %v\n", string(dat), err)
 }
 
+var extraBuf = make([]byte, 0, 128)
+
+func structAppendJson() {
+	extra := extraInfo{
+		1, 2, 3, 4, 5, 6,
+	}
+	extraBuf = extra.AppendJSON(extraBuf[:0])
+}
+
+func TestStructAppendJson(t *testing.T) {
+	extra := &extraInfo{1, 2, 3, 4, 5, 6}
+	expected, _ := json.Marshal(extra)
+	if string(expected) != string(extra.AppendJSON(nil)) {
+		t.Fatalf("expected %s, got %s", expected, extra.AppendJSON(nil))
+	}
+}
+
 /*
 func TestStructToJson(t *testing.T) {
 	structToJson()
@@ -52,3 +82,9 @@ func BenchmarkStruct2Json(b *testing.B) {
 		structToJson()
 	}
 }
+
+func BenchmarkStructAppendJson(b *testing.B) {
+	for i := 0; i < b.N; i++ {
+		structAppendJson()
+	}
+}
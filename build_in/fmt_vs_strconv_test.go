@@ -5,6 +5,8 @@ import (
 	"strconv"
 	"strings"
 	"testing"
+
+	"github.com/buptbill220/go_performance/lib/keybuilder"
 )
 
 func fmtInt() string {
@@ -27,6 +29,12 @@ func strconvAdd() string {
 	return "after_rank:" + strconv.FormatInt(100, 10)
 }
 
+var joinBuilder = keybuilder.New(':', 32)
+
+func keyBuilderJoin() []byte {
+	return joinBuilder.Reset().Str("after_rank").Int(100).Key()
+}
+
 func TestFmtJoin(t *testing.T) {
 	fmtJoin()
 }
@@ -47,6 +55,12 @@ func BenchmarkStrconvAdd(b *testing.B) {
 	}
 }
 
+func BenchmarkKeyBuilderJoin(b *testing.B) {
+	for i := 0; i < b.N; i++ {
+		keyBuilderJoin()
+	}
+}
+
 func BenchmarkStrconvJoin(b *testing.B) {
 	for i := 0; i < b.N; i++ {
 		strconvJoin()
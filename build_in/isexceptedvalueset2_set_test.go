@@ -0,0 +1,89 @@
+// Code generated by setgen -func=isExceptedValueSet2 -type=string; DO NOT EDIT.
+
+package build_in
+
+//bench:group switch_vs_map
+
+import "testing"
+
+var isExceptedValueSet2Seeds = [4]int32{
+	1, 4, 0, 0,
+}
+
+var isExceptedValueSet2Keys = [4]string{
+	"efg",
+	"abc",
+	"hij",
+	"klm",
+}
+
+func isExceptedValueSet2Hash(s string, seed uint32) uint32 {
+	h := uint32(2166136261) ^ seed
+	for i := 0; i < len(s); i++ {
+		h ^= uint32(s[i])
+		h *= 16777619
+	}
+	return h
+}
+
+// isExceptedValueSet2 reports whether s is one of the 4 constants, minimal perfect hash and one string compare.
+func isExceptedValueSet2(s string) bool {
+	d := isExceptedValueSet2Seeds[uint64(isExceptedValueSet2Hash(s, 0))*4>>32]
+	var i uint64
+	if d < 0 {
+		i = uint64(-d - 1)
+	} else {
+		i = uint64(isExceptedValueSet2Hash(s, uint32(d))) * 4 >> 32
+	}
+	return isExceptedValueSet2Keys[i] == s
+}
+
+var isExceptedValueSet2Map = map[string]bool{
+	"abc": true,
+	"efg": true,
+	"hij": true,
+	"klm": true,
+}
+
+var isExceptedValueSet2Input = []string{
+	"abc",
+	"efg",
+	"hij",
+	"klm",
+	"",
+	"abc_",
+	"abb",
+	"efg_",
+}
+
+var isExceptedValueSet2Hits int
+
+func TestIsExceptedValueSet2(t *testing.T) {
+	for _, v := range isExceptedValueSet2Input {
+		if isExceptedValueSet2(v) != isExceptedValueSet2Map[v] {
+			t.Fatalf("isExceptedValueSet2(%v) = %v", v, isExceptedValueSet2(v))
+		}
+	}
+}
+
+func BenchmarkIsExceptedValueSet2(b *testing.B) {
+	in := isExceptedValueSet2Input
+	n := 0
+	for i := 0; i < b.N; i++ {
+		if isExceptedValueSet2(in[i%len(in)]) {
+			n++
+		}
+	}
+	isExceptedValueSet2Hits = n
+}
+
+func BenchmarkIsExceptedValueSet2Map(b *testing.B) {
+	in := isExceptedValueSet2Input
+	n := 0
+	for i := 0; i < b.N; i++ {
+		if isExceptedValueSet2Map[in[i%len(in)]] {
+			n++
+		}
+	}
+	isExceptedValueSet2Hits = n
+}
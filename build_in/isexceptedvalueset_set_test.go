@@ -0,0 +1,85 @@
+// Code generated by setgen -func=isExceptedValueSet -type=int; DO NOT EDIT.
+
+package build_in
+
+//bench:group switch_vs_map
+
+import "testing"
+
+// isExceptedValueSet reports whether v is one of the 16 constants, bitmap over [1, 16].
+func isExceptedValueSet(v int) bool {
+	u := uint64(v) - 0x1
+	return u < 64 && 0xffff>>u&1 != 0
+}
+
+var isExceptedValueSetMap = map[int]bool{
+	1:  true,
+	2:  true,
+	3:  true,
+	4:  true,
+	5:  true,
+	6:  true,
+	7:  true,
+	8:  true,
+	9:  true,
+	10: true,
+	11: true,
+	12: true,
+	13: true,
+	14: true,
+	15: true,
+	16: true,
+}
+
+var isExceptedValueSetInput = []int{
+	1,
+	2,
+	3,
+	4,
+	5,
+	6,
+	7,
+	8,
+	9,
+	10,
+	11,
+	12,
+	13,
+	14,
+	15,
+	16,
+	0,
+	17,
+}
+
+var isExceptedValueSetHits int
+
+func TestIsExceptedValueSet(t *testing.T) {
+	for _, v := range isExceptedValueSetInput {
+		if isExceptedValueSet(v) != isExceptedValueSetMap[v] {
+			t.Fatalf("isExceptedValueSet(%v) = %v", v, isExceptedValueSet(v))
+		}
+	}
+}
+
+func BenchmarkIsExceptedValueSet(b *testing.B) {
+	in := isExceptedValueSetInput
+	n := 0
+	for i := 0; i < b.N; i++ {
+		if isExceptedValueSet(in[i%len(in)]) {
+			n++
+		}
+	}
+	isExceptedValueSetHits = n
+}
+
+func BenchmarkIsExceptedValueSetMap(b *testing.B) {
+	in := isExceptedValueSetInput
+	n := 0
+	for i := 0; i < b.N; i++ {
+		if isExceptedValueSetMap[in[i%len(in)]] {
+			n++
+		}
+	}
+	isExceptedValueSetHits = n
+}
@@ -0,0 +1,84 @@
+// Code generated by setgen -func=isSparseValueSet -type=int; DO NOT EDIT.
+
+package build_in
+
+//bench:group switch_vs_map
+
+import "testing"
+
+var isSparseValueSetTable = [6]int{
+	1, 10, 100, 1000, 10000, 100000,
+}
+
+// isSparseValueSet reports whether v is one of the 6 constants, binary search over a sorted table.
+func isSparseValueSet(v int) bool {
+	if v < 1 || v > 100000 {
+		return false
+	}
+	lo, hi := 0, len(isSparseValueSetTable)
+	for lo < hi {
+		m := int(uint(lo+hi) >> 1)
+		if isSparseValueSetTable[m] < v {
+			lo = m + 1
+		} else {
+			hi = m
+		}
+	}
+	return lo < len(isSparseValueSetTable) && isSparseValueSetTable[lo] == v
+}
+
+var isSparseValueSetMap = map[int]bool{
+	1:      true,
+	10:     true,
+	100:    true,
+	1000:   true,
+	10000:  true,
+	100000: true,
+}
+
+var isSparseValueSetInput = []int{
+	1,
+	10,
+	100,
+	1000,
+	10000,
+	100000,
+	0,
+	100001,
+	2,
+	11,
+	101,
+	1001,
+}
+
+var isSparseValueSetHits int
+
+func TestIsSparseValueSet(t *testing.T) {
+	for _, v := range isSparseValueSetInput {
+		if isSparseValueSet(v) != isSparseValueSetMap[v] {
+			t.Fatalf("isSparseValueSet(%v) = %v", v, isSparseValueSet(v))
+		}
+	}
+}
+
+func BenchmarkIsSparseValueSet(b *testing.B) {
+	in := isSparseValueSetInput
+	n := 0
+	for i := 0; i < b.N; i++ {
+		if isSparseValueSet(in[i%len(in)]) {
+			n++
+		}
+	}
+	isSparseValueSetHits = n
+}
+
+func BenchmarkIsSparseValueSetMap(b *testing.B) {
+	in := isSparseValueSetInput
+	n := 0
+	for i := 0; i < b.N; i++ {
+		if isSparseValueSetMap[in[i%len(in)]] {
+			n++
+		}
+	}
+	isSparseValueSetHits = n
+}
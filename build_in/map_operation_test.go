@@ -1,6 +1,10 @@
 package build_in
 
-import "testing"
+import (
+	"testing"
+
+	"github.com/buptbill220/go_performance/lib/sweep"
+)
 
 var size = 50000
 
@@ -55,3 +59,25 @@ func BenchmarkAssignMapWithCap2(b *testing.B) {
 		assignMapWithCap2()
 	}
 }
+
+// size 不写死, 看预分配在多大时开始明显有收益, 多个goroutine同时建map时分配器的影响
+func BenchmarkAssignMapSweep(b *testing.B) {
+	sweep.New().
+		Ints("size", 100, 1000, 10000, 50000).
+		Values("cap", "none", "size").
+		Goroutines(1, 4).
+		Run(b, func(b *testing.B, p sweep.Point) {
+			n := p.Int("size")
+			hint := 0
+			if p.String("cap") == "size" {
+				hint = n
+			}
+			b.ReportAllocs()
+			p.RunParallel(b, func() {
+				m := make(map[int]int, hint)
+				for i := 0; i < n; i++ {
+					m[i] = i
+				}
+			})
+		})
+}
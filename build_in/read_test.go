@@ -4,11 +4,14 @@ import (
     "encoding/hex"
     "math/rand"
     "testing"
+
+    "github.com/buptbill220/go_performance/lib/perfevent"
 )
 
 // ref: xiaoguoqiao, 特别鸣谢
 // 鉴于此, thrift在golang中对list<Obj> a; []*Obj 这种代码简直匪夷所思
 // go test -v -bench=. read_test.go -benchmem
+// go test -bench=Read ./build_in -perfevent 可以看到 L1d-misses/op, LLC-misses/op
 
 type A struct {
     Num1 int
@@ -45,25 +48,31 @@ func init() {
 
 func BenchmarkRead1(b *testing.B) {
     var sum = 0
+    c := perfevent.Start(b)
     for i := 0; i < b.N; i++ {
         for _, m := range m1 {
             sum += m.Num3
         }
     }
+    c.Stop()
 }
 func BenchmarkRead2(b *testing.B) {
     var sum = 0
+    c := perfevent.Start(b)
     for i := 0; i < b.N; i++ {
         for _, m := range m2 {
             sum += m.Num3
         }
     }
+    c.Stop()
 }
 func BenchmarkRead3(b *testing.B) {
 	var sum = 0
+	c := perfevent.Start(b)
 	for i := 0; i < b.N; i++ {
 		for idx := range m1 {
 			sum += m1[idx].Num3
 		}
 	}
+	c.Stop()
 }
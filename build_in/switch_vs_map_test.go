@@ -1,6 +1,15 @@
 package build_in
 
-import "testing"
+import (
+	"strconv"
+	"testing"
+
+	"github.com/buptbill220/go_performance/lib/sweep"
+)
+
+//go:generate go run ../cmd/setgen -func=isExceptedValueSet -type=int 1 2 3 4 5 6 7 8 9 10 11 12 13 14 15 16
+//go:generate go run ../cmd/setgen -func=isExceptedValueSet2 -type=string abc efg hij klm
+//go:generate go run ../cmd/setgen -func=isSparseValueSet -type=int 1 10 100 1000 10000 100000
 
 var s1 = []int{1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15, 16}
 var s2 = []string{"abc", "efg", "hij", "klm"}
@@ -62,7 +71,7 @@ var m = map[int]bool{
 	16: true,
 }
 
-var m2 = map[string]bool{
+var mStr = map[string]bool{
 	"abc": true,
 	"efg": true,
 	"hij": true,
@@ -74,7 +83,7 @@ func IsExceptedValueMap(val int) bool {
 }
 
 func IsExceptedValueMap2(val string) bool {
-	return m2[val]
+	return mStr[val]
 }
 
 func IsExceptedValueSlice(val int) bool {
@@ -132,3 +141,64 @@ func BenchmarkMap2(b *testing.B) {
 		IsExceptedValueMap2("abc")
 	}
 }
+
+// 集合多大时map开始比线性查找快
+// go test -bench SliceVsMapSweep ./build_in -sweep.csv=slice_vs_map.csv
+func BenchmarkSliceVsMapSweep(b *testing.B) {
+	sweep.New().
+		Ints("size", 2, 4, 8, 16, 32, 64, 128, 256).
+		Values("type", "int", "string").
+		Values("impl", "slice", "map").
+		Run(b, func(b *testing.B, p sweep.Point) {
+			n := p.Int("size")
+			ints := make([]int, n)
+			strs := make([]string, n)
+			mInt := make(map[int]bool, n)
+			mStr := make(map[string]bool, n)
+			for i := 0; i < n; i++ {
+				ints[i] = i * 7
+				strs[i] = "key_" + strconv.Itoa(i*7)
+				mInt[ints[i]] = true
+				mStr[strs[i]] = true
+			}
+			found := 0
+			b.ResetTimer()
+			switch p.String("type") + "/" + p.String("impl") {
+			case "int/slice":
+				for i := 0; i < b.N; i++ {
+					v := ints[i%n]
+					for _, item := range ints {
+						if item == v {
+							found++
+							break
+						}
+					}
+				}
+			case "int/map":
+				for i := 0; i < b.N; i++ {
+					if mInt[ints[i%n]] {
+						found++
+					}
+				}
+			case "string/slice":
+				for i := 0; i < b.N; i++ {
+					v := strs[i%n]
+					for _, item := range strs {
+						if item == v {
+							found++
+							break
+						}
+					}
+				}
+			case "string/map":
+				for i := 0; i < b.N; i++ {
+					if mStr[strs[i%n]] {
+						found++
+					}
+				}
+			}
+			if found != b.N {
+				b.Fatalf("found %d of %d", found, b.N)
+			}
+		})
+}
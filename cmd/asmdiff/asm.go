@@ -0,0 +1,258 @@
+package main
+
+import (
+	"bufio"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"io"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/buptbill220/go_performance/lib/gcdiag"
+)
+
+type inst struct {
+	line int
+	text string
+}
+
+type function struct {
+	symbol string
+	file   string
+	line   int // line of the func keyword
+	end    int // last line of the body, 0 if unknown
+	size   int
+	insts  []inst
+	bounds int
+
+	nilChecks int
+	escapes   []string
+	inline    string // compiler's decision about inlining this function
+	inlined   []string
+}
+
+var (
+	headerRe = regexp.MustCompile(`^(\S+) STEXT .*size=(\d+)`)
+	instRe   = regexp.MustCompile(`^\t0x[0-9a-f]+ \d{5} \((.+):(\d+)\)\t(.*)$`)
+	diagRe   = regexp.MustCompile(`^(.+\.go):(\d+):\d+: (.*)$`)
+	boundsRe = regexp.MustCompile(`^CALL\truntime\.(go)?panic(Bounds|Index|Slice)`)
+	jumpRe   = regexp.MustCompile(`^(J[A-Z]+|LOOP)\t\d+$`)
+)
+
+// pseudo instructions carry metadata and emit no code
+var pseudo = map[string]bool{"TEXT": true, "FUNCDATA": true, "PCDATA": true, "PCALIGN": true}
+
+// parseAsm reads -S output and returns the functions by symbol.
+func parseAsm(r io.Reader) (map[string]*function, error) {
+	funcs := make(map[string]*function)
+	var cur *function
+	sc := bufio.NewScanner(r)
+	sc.Buffer(make([]byte, 64<<10), 1<<20)
+	for sc.Scan() {
+		line := sc.Text()
+		if m := headerRe.FindStringSubmatch(line); m != nil {
+			cur = &function{symbol: m[1]}
+			cur.size, _ = strconv.Atoi(m[2])
+			funcs[cur.symbol] = cur
+			continue
+		}
+		if !strings.HasPrefix(line, "\t") {
+			cur = nil
+			continue
+		}
+		m := instRe.FindStringSubmatch(line)
+		if cur == nil || m == nil {
+			continue
+		}
+		n, _ := strconv.Atoi(m[2])
+		op := m[3]
+		if i := strings.IndexByte(op, '\t'); i >= 0 {
+			op = op[:i]
+		}
+		if op == "TEXT" {
+			cur.file, cur.line = m[1], n
+		}
+		if pseudo[op] {
+			continue
+		}
+		cur.insts = append(cur.insts, inst{line: n, text: m[3]})
+		if boundsRe.MatchString(m[3]) {
+			cur.bounds++
+		}
+	}
+	return funcs, sc.Err()
+}
+
+// setRange finds where f's body ends by parsing its source file.
+func (f *function) setRange(fset *token.FileSet, files map[string]*ast.File) {
+	if f.file == "" || f.file == "<autogenerated>" {
+		return
+	}
+	af, ok := files[f.file]
+	if !ok {
+		af, _ = parser.ParseFile(fset, f.file, nil, 0)
+		files[f.file] = af
+	}
+	if af == nil {
+		return
+	}
+	for _, d := range af.Decls {
+		fd, ok := d.(*ast.FuncDecl)
+		if ok && fset.Position(fd.Pos()).Line == f.line {
+			f.end = fset.Position(fd.End()).Line
+			return
+		}
+	}
+}
+
+func (f *function) contains(file string, line int) bool {
+	return f.end > 0 && file == f.file && f.line <= line && line <= f.end
+}
+
+// addDiagnostics attributes -m and -d=nil messages to the functions whose source contains them.
+// Relative paths in the messages are resolved against dir, see gcdiag.Resolve.
+func addDiagnostics(r io.Reader, dir string, funcs []*function) error {
+	var files []string
+	for _, f := range funcs {
+		if f.file != "" {
+			files = append(files, f.file)
+		}
+	}
+	resolved := make(map[string]string)
+	sc := bufio.NewScanner(r)
+	sc.Buffer(make([]byte, 64<<10), 1<<20)
+	for sc.Scan() {
+		m := diagRe.FindStringSubmatch(sc.Text())
+		if m == nil {
+			continue
+		}
+		file, ok := resolved[m[1]]
+		if !ok {
+			file = gcdiag.Resolve(files, dir, m[1])
+			resolved[m[1]] = file
+		}
+		line, _ := strconv.Atoi(m[2])
+		msg := m[3]
+		for _, f := range funcs {
+			if !f.contains(file, line) {
+				continue
+			}
+			switch {
+			case msg == "generated nil check":
+				f.nilChecks++
+			case strings.HasPrefix(msg, "inlining call to "):
+				f.inlined = append(f.inlined, strings.TrimPrefix(msg, "inlining call to "))
+			case line == f.line && (strings.HasPrefix(msg, "can inline ") || strings.HasPrefix(msg, "cannot inline ")):
+				f.inline = msg
+				if i := strings.Index(msg, " as: "); i >= 0 {
+					f.inline = msg[:i]
+				}
+			case strings.HasSuffix(msg, "escapes to heap") || strings.HasPrefix(msg, "moved to heap: "):
+				f.escapes = append(f.escapes, m[2]+": "+msg)
+			}
+		}
+	}
+	return sc.Err()
+}
+
+// shortName strips the package path from a symbol: "a/b.(*T).M" -> "(*T).M".
+func shortName(symbol string) string {
+	s := symbol
+	if i := strings.LastIndexByte(s, '/'); i >= 0 {
+		s = s[i+1:]
+	}
+	if i := strings.IndexByte(s, '.'); i >= 0 {
+		s = s[i+1:]
+	}
+	return s
+}
+
+func findFunc(funcs map[string]*function, name string) *function {
+	if f, ok := funcs[name]; ok {
+		return f
+	}
+	for sym, f := range funcs {
+		if shortName(sym) == name {
+			return f
+		}
+	}
+	return nil
+}
+
+// normalize drops jump targets, they are offsets and differ whenever anything before them does.
+func normalize(text string) string {
+	if jumpRe.MatchString(text) {
+		return text[:strings.IndexByte(text, '\t')]
+	}
+	return text
+}
+
+type op byte
+
+const (
+	same   op = ' '
+	change op = '|'
+	left   op = '<'
+	right  op = '>'
+)
+
+type pair struct {
+	op   op
+	a, b int // index into each side, -1 if absent
+}
+
+// diff aligns two instruction lists by their longest common subsequence, like diff -y.
+func diff(a, b []inst) []pair {
+	n, m := len(a), len(b)
+	lcs := make([][]int, n+1)
+	for i := range lcs {
+		lcs[i] = make([]int, m+1)
+	}
+	for i := n - 1; i >= 0; i-- {
+		for j := m - 1; j >= 0; j-- {
+			if normalize(a[i].text) == normalize(b[j].text) {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else if lcs[i+1][j] >= lcs[i][j+1] {
+				lcs[i][j] = lcs[i+1][j]
+			} else {
+				lcs[i][j] = lcs[i][j+1]
+			}
+		}
+	}
+
+	var out []pair
+	var dels, ins []int
+	flush := func() {
+		k := 0
+		for ; k < len(dels) && k < len(ins); k++ {
+			out = append(out, pair{change, dels[k], ins[k]})
+		}
+		for _, i := range dels[k:] {
+			out = append(out, pair{left, i, -1})
+		}
+		for _, j := range ins[k:] {
+			out = append(out, pair{right, -1, j})
+		}
+		dels, ins = dels[:0], ins[:0]
+	}
+	i, j := 0, 0
+	for i < n || j < m {
+		switch {
+		case i < n && j < m && normalize(a[i].text) == normalize(b[j].text):
+			flush()
+			out = append(out, pair{same, i, j})
+			i++
+			j++
+		case j == m || (i < n && lcs[i+1][j] >= lcs[i][j+1]):
+			dels = append(dels, i)
+			i++
+		default:
+			ins = append(ins, j)
+			j++
+		}
+	}
+	flush()
+	return out
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const source = `package main
+
+func IsSomething(num int) bool {
+	switch num {
+	case 1, 3, 5, 7, 9:
+		return true
+	}
+	return false
+}
+
+func Get(b []int, i int) int {
+	return b[i]
+}
+`
+
+// 真实的 -S -m=2 -d=nil 输出裁剪而来, 文件路径在测试里替换
+const output = `# command-line-arguments
+FILE:3:6: can inline IsSomething with cost 13 as: func(int) bool { switch statement }
+FILE:11:6: can inline Get with cost 6 as: func([]int, int) int { return b[i] }
+FILE:11:10: b does not escape
+FILE:12:10: generated nil check
+main.IsSomething STEXT nosplit size=26 args=0x8 locals=0x0 funcid=0x0 align=0x0
+	0x0000 00000 (ABS:3)	TEXT	main.IsSomething(SB), NOSPLIT|NOFRAME|ABIInternal, $0-8
+	0x0000 00000 (ABS:3)	FUNCDATA	$0, gclocals·g5+hNtRBP6YXNjfog7aZjQ==(SB)
+	0x0000 00000 (ABS:4)	LEAQ	-1(AX), CX
+	0x0004 00004 (ABS:4)	CMPQ	CX, $8
+	0x0008 00008 (ABS:4)	JHI	23
+	0x000a 00010 (ABS:4)	LEAQ	main..stmp_0(SB), CX
+	0x0011 00017 (ABS:4)	MOVBLZX	-1(AX)(CX*1), AX
+	0x0016 00022 (ABS:4)	RET
+	0x0017 00023 (ABS:8)	XORL	AX, AX
+	0x0019 00025 (ABS:8)	RET
+	0x0000 48 8d 48 ff 48 83 f9 08 77 0d 48 8d 0d 00 00 00  H.H.H...w.H.....
+	rel 13+4 t=R_PCREL main..stmp_0+0
+main.Get STEXT size=32 args=0x20 locals=0x18 funcid=0x0 align=0x0
+	0x0000 00000 (ABS:11)	TEXT	main.Get(SB), ABIInternal, $24-32
+	0x0000 00000 (ABS:11)	PCDATA	$3, $1
+	0x0000 00000 (ABS:12)	CMPQ	DI, BX
+	0x0003 00003 (ABS:12)	JCC	12
+	0x0005 00005 (ABS:12)	MOVQ	(AX)(DI*8), AX
+	0x0009 00009 (ABS:12)	RET
+	0x000c 00012 (ABS:12)	MOVQ	DI, AX
+	0x000f 00015 (ABS:12)	MOVQ	BX, CX
+	0x0012 00018 (ABS:12)	PCDATA	$1, $1
+	0x0012 00018 (ABS:12)	CALL	runtime.panicBounds(SB)
+	0x0017 00023 (ABS:12)	XCHGL	AX, AX
+`
+
+func TestLoad(t *testing.T) {
+	dir := t.TempDir()
+	file := filepath.Join(dir, "main.go")
+	assert.Nil(t, os.WriteFile(file, []byte(source), 0644))
+	out := strings.Replace(strings.Replace(output, "ABS", file, -1), "FILE", "main.go", -1)
+
+	funcs, err := load([]byte(out), dir, "IsSomething", "main.Get")
+	assert.Nil(t, err)
+	a, b := funcs[0], funcs[1]
+	assert.Equal(t, 26, a.size)
+	assert.Equal(t, 8, len(a.insts))
+	assert.Equal(t, 0, a.bounds)
+	assert.Equal(t, 9, a.end)
+	assert.Equal(t, "can inline IsSomething with cost 13", a.inline)
+	assert.Equal(t, 0, a.nilChecks)
+
+	assert.Equal(t, 8, len(b.insts))
+	assert.Equal(t, 1, b.bounds)
+	assert.Equal(t, 1, b.nilChecks)
+	assert.Equal(t, "can inline Get with cost 6", b.inline)
+
+	_, err = load([]byte(out), dir, "IsNothing")
+	assert.NotNil(t, err)
+
+	var buf bytes.Buffer
+	report(&buf, a, b, 40)
+	assert.Contains(t, buf.String(), "bounds checks    0")
+	assert.Contains(t, buf.String(), "CALL runtime.panicBounds(SB)")
+}
+
+func TestLoadCachedPaths(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "pkg")
+	assert.Nil(t, os.Mkdir(dir, 0755))
+	file := filepath.Join(dir, "main.go")
+	assert.Nil(t, os.WriteFile(file, []byte(source), 0644))
+	// 缓存回放的输出, 路径相对的是第一次编译时的目录
+	out := strings.Replace(strings.Replace(output, "ABS", file, -1), "FILE", "pkg/main.go", -1)
+
+	funcs, err := load([]byte(out), dir, "IsSomething", "Get")
+	assert.Nil(t, err)
+	assert.Equal(t, "can inline IsSomething with cost 13", funcs[0].inline)
+	assert.Equal(t, 1, funcs[1].nilChecks)
+}
+
+func TestShortName(t *testing.T) {
+	assert.Equal(t, "IsSomething", shortName("main.IsSomething"))
+	assert.Equal(t, "(*Builder).Int", shortName("github.com/buptbill220/go_performance/lib/keybuilder.(*Builder).Int"))
+}
+
+func TestDiff(t *testing.T) {
+	a := []inst{{1, "CMPQ\tAX, $1"}, {1, "JEQ\t10"}, {2, "MOVL\t$1, AX"}, {3, "RET"}}
+	b := []inst{{1, "CMPQ\tAX, $1"}, {1, "JEQ\t14"}, {1, "CMPQ\tAX, $3"}, {1, "JEQ\t14"}, {3, "RET"}}
+	var ops []byte
+	for _, p := range diff(a, b) {
+		ops = append(ops, byte(p.op))
+	}
+	assert.Equal(t, "  |> ", string(ops))
+}
@@ -0,0 +1,143 @@
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"go/ast"
+	"go/token"
+	"io"
+	"os"
+	"os/exec"
+	"strings"
+)
+
+/*
+	代替手动 go tool compile -S 看汇编, 对比两个函数编译后的结果
+
+	go run ./cmd/asmdiff -pkg=./ams/switch_ams.go IsSomething IsSomething2
+	go run ./cmd/asmdiff -pkg=./build_in -test IsExceptedValueSwitch isExceptedValueSet
+
+	用 -gcflags="-S -m=2 -d=nil" 编译一次, 输出:
+		指令数/代码大小/边界检查(runtime.panicBounds等调用)/nil检查/逃逸, 是否能内联以及内联了哪些调用
+		并排的汇编diff, 跳转目标偏移不参与比较; ' ' 相同, '|' 不同, '<' '>' 只有一边有
+*/
+
+var (
+	pkg     = flag.String("pkg", ".", "package or .go file to compile")
+	test    = flag.Bool("test", false, "compile the test binary, for functions in _test.go files")
+	gcflags = flag.String("gcflags", "", "extra compiler flags, e.g. -N or -B")
+	width   = flag.Int("width", 50, "column width of the side by side diff")
+)
+
+func main() {
+	flag.Parse()
+	if flag.NArg() != 2 {
+		fmt.Fprintf(os.Stderr, "usage: asmdiff [-pkg=dir|file.go] [-test] [-gcflags=flags] FuncA FuncB\n")
+		os.Exit(2)
+	}
+	out, err := compile(*pkg, *test, *gcflags)
+	if err != nil {
+		fatal(err)
+	}
+	wd, _ := os.Getwd()
+	funcs, err := load(out, wd, flag.Arg(0), flag.Arg(1))
+	if err != nil {
+		fatal(err)
+	}
+	report(os.Stdout, funcs[0], funcs[1], *width)
+}
+
+func fatal(err error) {
+	fmt.Fprintf(os.Stderr, "asmdiff: %v\n", err)
+	os.Exit(1)
+}
+
+// compile builds pkg and returns the compiler output, for a cached build the go command replays it.
+func compile(pkg string, test bool, extra string) ([]byte, error) {
+	flags := strings.TrimSpace(extra + " -S -m=2 -d=nil")
+	args := []string{"build", "-o", os.DevNull, "-gcflags=" + flags, pkg}
+	if test {
+		args = []string{"test", "-c", "-o", os.DevNull, "-gcflags=" + flags, pkg}
+	}
+	cmd := exec.Command("go", args...)
+	var buf bytes.Buffer
+	cmd.Stdout = &buf
+	cmd.Stderr = &buf
+	if err := cmd.Run(); err != nil {
+		return nil, fmt.Errorf("go %s: %v\n%s", strings.Join(args, " "), err, buf.Bytes())
+	}
+	return buf.Bytes(), nil
+}
+
+// load extracts the named functions from the compiler output, relative paths are resolved against dir.
+func load(out []byte, dir string, names ...string) ([]*function, error) {
+	all, err := parseAsm(bytes.NewReader(out))
+	if err != nil {
+		return nil, err
+	}
+	fset := token.NewFileSet()
+	files := make(map[string]*ast.File)
+	var funcs []*function
+	for _, name := range names {
+		f := findFunc(all, name)
+		if f == nil {
+			return nil, fmt.Errorf("function %s not found in compiler output", name)
+		}
+		f.setRange(fset, files)
+		funcs = append(funcs, f)
+	}
+	if err := addDiagnostics(bytes.NewReader(out), dir, funcs); err != nil {
+		return nil, err
+	}
+	return funcs, nil
+}
+
+func report(w io.Writer, a, b *function, width int) {
+	row := func(name string, va, vb interface{}) {
+		fmt.Fprintf(w, "%-16s %-*v %v\n", name, width, va, vb)
+	}
+	// 包路径太长, 只留最后一段
+	trim := func(s string) string {
+		for _, f := range []*function{a, b} {
+			if i := strings.LastIndexByte(f.symbol, '/'); i >= 0 {
+				s = strings.Replace(s, f.symbol[:i+1], "", -1)
+			}
+		}
+		return s
+	}
+	row("", trim(a.symbol), trim(b.symbol))
+	row("size (bytes)", a.size, b.size)
+	row("instructions", len(a.insts), len(b.insts))
+	row("bounds checks", a.bounds, b.bounds)
+	row("nil checks", a.nilChecks, b.nilChecks)
+	row("heap escapes", len(a.escapes), len(b.escapes))
+	row("inlined calls", len(a.inlined), len(b.inlined))
+	fmt.Fprintln(w)
+	for _, f := range []*function{a, b} {
+		if f.inline != "" {
+			fmt.Fprintf(w, "%s: %s\n", trim(f.symbol), f.inline)
+		}
+		for _, c := range f.inlined {
+			fmt.Fprintf(w, "\tinlining call to %s\n", c)
+		}
+		for _, e := range f.escapes {
+			fmt.Fprintf(w, "\tline %s\n", e)
+		}
+	}
+	fmt.Fprintln(w)
+
+	cell := func(f *function, i int) string {
+		if i < 0 {
+			return ""
+		}
+		s := fmt.Sprintf("%-4d %s", f.insts[i].line, strings.Replace(trim(f.insts[i].text), "\t", " ", -1))
+		if len(s) > width {
+			s = s[:width]
+		}
+		return s
+	}
+	for _, p := range diff(a.insts, b.insts) {
+		fmt.Fprintf(w, "%-*s %c %s\n", width, cell(a, p.a), p.op, cell(b, p.b))
+	}
+}
@@ -0,0 +1,286 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/buptbill220/go_performance/lib/benchparse"
+)
+
+/*
+	跑所有实验包的benchmark, 按实验(一个 _test.go 文件就是一个实验, 比如 switch_vs_map_test.go)分组,
+	生成离线的 report.md + report.html, 带 ns/op, B/op, allocs/op, 相对最慢的加速比和SVG柱状图
+
+	go run ./cmd/benchreport -count=5 -o=benchreport
+	go run ./cmd/benchreport ./build_in ./lib/...
+
+	文件里写 //bench:group switch_vs_map 可以并到别的实验里, 比如 cmd/setgen 生成的文件
+	编译不过的包在报告里标出来, 不影响其他包
+*/
+
+var defaultPackages = []string{"./build_in", "./runtime", "./op", "./lib/...", "./simple_impl"}
+
+var (
+	outDir    = flag.String("o", "benchreport", "output directory")
+	bench     = flag.String("bench", ".", "benchmarks to run")
+	count     = flag.Int("count", 3, "runs of each benchmark, the report shows the median")
+	benchtime = flag.String("benchtime", "", "go test -benchtime")
+)
+
+type listPackage struct {
+	ImportPath   string
+	Dir          string
+	TestGoFiles  []string
+	XTestGoFiles []string
+}
+
+func main() {
+	flag.Parse()
+	patterns := flag.Args()
+	if len(patterns) == 0 {
+		patterns = defaultPackages
+	}
+	pkgs, err := list(patterns)
+	if err != nil {
+		fatal(err)
+	}
+
+	rep := &Report{
+		Time:      time.Now(),
+		GoVersion: goVersion(),
+		Commit:    git("rev-parse", "--short", "HEAD"),
+	}
+	for _, p := range pkgs {
+		files, err := benchFiles(p)
+		if err != nil {
+			fatal(err)
+		}
+		if len(files) == 0 {
+			continue
+		}
+		fmt.Fprintf(os.Stderr, "running %s\n", p.ImportPath)
+		out, runErr := runBench(p.ImportPath)
+		results, h, _ := benchparse.Parse(bytes.NewReader(out))
+		if h.CPU != "" {
+			rep.CPU, rep.GOOS, rep.GOARCH = h.CPU, h.GOOS, h.GOARCH
+		}
+		if runErr != nil && len(results) == 0 {
+			rep.Failed = append(rep.Failed, Failure{Pkg: p.ImportPath, Output: firstLines(string(out), 10)})
+			continue
+		}
+		rep.Experiments = append(rep.Experiments, group(p, files, results)...)
+	}
+	if rep.CPU == "" {
+		rep.CPU = runtime.GOARCH
+	}
+	if err := write(*outDir, rep); err != nil {
+		fatal(err)
+	}
+	fmt.Fprintf(os.Stderr, "wrote %s/report.md and %s/report.html\n", *outDir, *outDir)
+}
+
+func fatal(err error) {
+	fmt.Fprintf(os.Stderr, "benchreport: %v\n", err)
+	os.Exit(1)
+}
+
+func list(patterns []string) ([]*listPackage, error) {
+	out, err := exec.Command("go", append([]string{"list", "-e", "-json"}, patterns...)...).Output()
+	if err != nil {
+		return nil, fmt.Errorf("go list: %v", err)
+	}
+	var pkgs []*listPackage
+	dec := json.NewDecoder(bytes.NewReader(out))
+	for dec.More() {
+		p := new(listPackage)
+		if err := dec.Decode(p); err != nil {
+			return nil, err
+		}
+		pkgs = append(pkgs, p)
+	}
+	return pkgs, nil
+}
+
+const groupDirective = "//bench:group "
+
+// benchFile is a test file with its benchmarks in source order.
+type benchFile struct {
+	name    string
+	group   string // experiment name, the file name without _test.go unless set by //bench:group
+	benches []string
+}
+
+func (f *benchFile) own() bool {
+	return f.name == f.group+"_test.go"
+}
+
+func benchFiles(p *listPackage) ([]*benchFile, error) {
+	fset := token.NewFileSet()
+	var files []*benchFile
+	for _, name := range append(append([]string(nil), p.TestGoFiles...), p.XTestGoFiles...) {
+		af, err := parser.ParseFile(fset, filepath.Join(p.Dir, name), nil, parser.ParseComments)
+		if err != nil {
+			return nil, err
+		}
+		bf := &benchFile{name: name, group: strings.TrimSuffix(name, "_test.go")}
+		for _, cg := range af.Comments {
+			for _, c := range cg.List {
+				if strings.HasPrefix(c.Text, groupDirective) {
+					bf.group = strings.TrimSpace(strings.TrimPrefix(c.Text, groupDirective))
+				}
+			}
+		}
+		for _, d := range af.Decls {
+			if fd, ok := d.(*ast.FuncDecl); ok && fd.Recv == nil && strings.HasPrefix(fd.Name.Name, "Benchmark") {
+				bf.benches = append(bf.benches, fd.Name.Name)
+			}
+		}
+		if len(bf.benches) > 0 {
+			files = append(files, bf)
+		}
+	}
+	return files, nil
+}
+
+func runBench(pkg string) ([]byte, error) {
+	args := []string{"test", "-run=^$", "-bench=" + *bench, "-benchmem", fmt.Sprintf("-count=%d", *count)}
+	if *benchtime != "" {
+		args = append(args, "-benchtime="+*benchtime)
+	}
+	args = append(args, pkg)
+	var buf bytes.Buffer
+	cmd := exec.Command("go", args...)
+	cmd.Stdout = &buf
+	cmd.Stderr = &buf
+	err := cmd.Run()
+	return buf.Bytes(), err
+}
+
+// group puts the results of a package into one experiment per test file or //bench:group.
+func group(p *listPackage, files []*benchFile, results []*benchparse.Result) []*Experiment {
+	type loc struct {
+		exp   *Experiment
+		order int
+	}
+	where := make(map[string]loc)
+	byGroup := make(map[string]*Experiment)
+	var exps []*Experiment
+	// 生成的文件按名字排在前面, 实验自己的文件放第一个
+	files = append([]*benchFile(nil), files...)
+	sort.SliceStable(files, func(i, j int) bool { return files[i].own() && !files[j].own() })
+	order := 0
+	for _, f := range files {
+		e := byGroup[f.group]
+		if e == nil {
+			e = &Experiment{Pkg: p.ImportPath, Group: f.group, Title: title(p.ImportPath, f.group)}
+			byGroup[f.group] = e
+			exps = append(exps, e)
+		}
+		e.Files = append(e.Files, f.name)
+		for _, b := range f.benches {
+			where[b] = loc{e, order}
+			order++
+		}
+	}
+
+	benches := make(map[string]*Bench)
+	for _, r := range results {
+		top := r.Name
+		if i := strings.IndexByte(top, '/'); i >= 0 {
+			top = top[:i]
+		}
+		l, ok := where[top]
+		if !ok {
+			continue
+		}
+		b := benches[r.Name]
+		if b == nil {
+			b = &Bench{Name: r.Name, order: l.order, samples: make(map[string][]float64)}
+			benches[r.Name] = b
+			l.exp.Benches = append(l.exp.Benches, b)
+		}
+		for unit, v := range r.Metrics {
+			b.samples[unit] = append(b.samples[unit], v)
+		}
+	}
+
+	var out []*Experiment
+	for _, e := range exps {
+		if len(e.Benches) == 0 {
+			continue
+		}
+		// 子benchmark保持输出的顺序
+		sort.SliceStable(e.Benches, func(i, j int) bool { return e.Benches[i].order < e.Benches[j].order })
+		for _, b := range e.Benches {
+			b.finish()
+		}
+		out = append(out, e)
+	}
+	return out
+}
+
+// title turns build_in and switch_vs_map into "build_in: switch vs map".
+func title(pkg, group string) string {
+	name := strings.Replace(group, "_vs_", " vs ", -1)
+	name = strings.Replace(name, "_", " ", -1)
+	return filepath.Base(pkg) + ": " + name
+}
+
+func firstLines(s string, n int) string {
+	lines := strings.SplitN(s, "\n", n+1)
+	if len(lines) > n {
+		lines = lines[:n]
+	}
+	return strings.Join(lines, "\n")
+}
+
+func git(args ...string) string {
+	out, err := exec.Command("git", args...).Output()
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(string(out))
+}
+
+func goVersion() string {
+	out, err := exec.Command("go", "env", "GOVERSION").Output()
+	if err != nil {
+		return runtime.Version()
+	}
+	return strings.TrimSpace(string(out))
+}
+
+func write(dir string, rep *Report) error {
+	if err := os.MkdirAll(filepath.Join(dir, "svg"), 0755); err != nil {
+		return err
+	}
+	for _, e := range rep.Experiments {
+		for _, c := range e.Charts() {
+			if err := ioutil.WriteFile(filepath.Join(dir, "svg", c.File), []byte(c.SVG), 0644); err != nil {
+				return err
+			}
+		}
+	}
+	var md, html bytes.Buffer
+	RenderMarkdown(&md, rep)
+	if err := RenderHTML(&html, rep); err != nil {
+		return err
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, "report.md"), md.Bytes(), 0644); err != nil {
+		return err
+	}
+	return ioutil.WriteFile(filepath.Join(dir, "report.html"), html.Bytes(), 0644)
+}
@@ -0,0 +1,240 @@
+package main
+
+import (
+	"fmt"
+	"html"
+	"html/template"
+	"io"
+	"math"
+	"path"
+	"sort"
+	"strings"
+	"time"
+)
+
+type Report struct {
+	Time        time.Time
+	GoVersion   string
+	Commit      string
+	GOOS        string
+	GOARCH      string
+	CPU         string
+	Experiments []*Experiment
+	Failed      []Failure
+}
+
+type Failure struct {
+	Pkg    string
+	Output string
+}
+
+type Experiment struct {
+	Pkg     string
+	Group   string
+	Files   []string
+	Title   string
+	Benches []*Bench
+}
+
+type Bench struct {
+	Name    string
+	Metrics map[string]float64 // median of the samples
+	Runs    int
+
+	order   int
+	samples map[string][]float64
+}
+
+func (b *Bench) finish() {
+	b.Metrics = make(map[string]float64)
+	for unit, xs := range b.samples {
+		sort.Float64s(xs)
+		n := len(xs)
+		if n%2 == 1 {
+			b.Metrics[unit] = xs[n/2]
+		} else {
+			b.Metrics[unit] = (xs[n/2-1] + xs[n/2]) / 2
+		}
+		if n > b.Runs {
+			b.Runs = n
+		}
+	}
+}
+
+// Speedup is how many times faster than the slowest benchmark of the experiment b is.
+func (e *Experiment) Speedup(b *Bench) float64 {
+	var slowest float64
+	for _, x := range e.Benches {
+		slowest = math.Max(slowest, x.Metrics["ns/op"])
+	}
+	ns := b.Metrics["ns/op"]
+	if ns == 0 {
+		return 0
+	}
+	return slowest / ns
+}
+
+// ID is usable as a file name and an html anchor.
+func (e *Experiment) ID() string {
+	return strings.Map(func(r rune) rune {
+		if r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '_' {
+			return r
+		}
+		return '-'
+	}, path.Base(e.Pkg)+"-"+e.Group)
+}
+
+type Chart struct {
+	File string
+	SVG  string
+}
+
+// Charts returns a ns/op chart, and a B/op chart when something allocates.
+func (e *Experiment) Charts() []Chart {
+	charts := []Chart{{File: e.ID() + "-ns.svg", SVG: e.chart("ns/op")}}
+	for _, b := range e.Benches {
+		if b.Metrics["B/op"] > 0 {
+			charts = append(charts, Chart{File: e.ID() + "-bytes.svg", SVG: e.chart("B/op")})
+			break
+		}
+	}
+	return charts
+}
+
+const (
+	chartWidth  = 760
+	labelWidth  = 300
+	valueWidth  = 110
+	barHeight   = 20
+	barGap      = 6
+	chartMargin = 10
+)
+
+// chart draws a horizontal bar per benchmark, scaled to the largest value.
+func (e *Experiment) chart(unit string) string {
+	var max float64
+	for _, b := range e.Benches {
+		max = math.Max(max, b.Metrics[unit])
+	}
+	height := 2*chartMargin + 20 + len(e.Benches)*(barHeight+barGap)
+	barSpace := float64(chartWidth - labelWidth - valueWidth - 2*chartMargin)
+
+	var sb strings.Builder
+	fmt.Fprintf(&sb, `<svg xmlns="http://www.w3.org/2000/svg" width="%d" height="%d" font-family="monospace" font-size="12">`+"\n", chartWidth, height)
+	fmt.Fprintf(&sb, `<text x="%d" y="%d" font-weight="bold">%s (%s)</text>`+"\n", chartMargin, chartMargin+12, html.EscapeString(e.Title), unit)
+	for i, b := range e.Benches {
+		y := chartMargin + 20 + i*(barHeight+barGap)
+		v := b.Metrics[unit]
+		w := 0.0
+		if max > 0 {
+			w = v / max * barSpace
+		}
+		label := b.Name
+		if len(label) > 40 {
+			label = label[:37] + "..."
+		}
+		fmt.Fprintf(&sb, `<text x="%d" y="%d">%s</text>`+"\n", chartMargin, y+barHeight-6, html.EscapeString(label))
+		fmt.Fprintf(&sb, `<rect x="%d" y="%d" width="%.1f" height="%d" fill="#4e79a7"/>`+"\n", labelWidth, y, w, barHeight)
+		fmt.Fprintf(&sb, `<text x="%.1f" y="%d">%s</text>`+"\n", float64(labelWidth)+w+4, y+barHeight-6, formatValue(v))
+	}
+	sb.WriteString("</svg>\n")
+	return sb.String()
+}
+
+func formatValue(v float64) string {
+	if v == math.Trunc(v) && v < 1e15 {
+		return fmt.Sprintf("%.0f", v)
+	}
+	return fmt.Sprintf("%.4g", v)
+}
+
+func formatSpeedup(s float64) string {
+	if s == 0 {
+		return "-"
+	}
+	return fmt.Sprintf("%.2fx", s)
+}
+
+func metric(b *Bench, unit string) string {
+	v, ok := b.Metrics[unit]
+	if !ok {
+		return "-"
+	}
+	return formatValue(v)
+}
+
+func RenderMarkdown(w io.Writer, rep *Report) {
+	fmt.Fprintf(w, "# Benchmark report\n\n")
+	fmt.Fprintf(w, "%s, commit %s, %s %s/%s, %s\n\n", rep.Time.Format("2006-01-02 15:04"), rep.Commit, rep.GoVersion, rep.GOOS, rep.GOARCH, rep.CPU)
+	for _, e := range rep.Experiments {
+		fmt.Fprintf(w, "## %s\n\n", e.Title)
+		fmt.Fprintf(w, "`%s` %s\n\n", e.Pkg, strings.Join(e.Files, ", "))
+		fmt.Fprintf(w, "| benchmark | ns/op | B/op | allocs/op | speedup |\n")
+		fmt.Fprintf(w, "|---|---:|---:|---:|---:|\n")
+		for _, b := range e.Benches {
+			fmt.Fprintf(w, "| %s | %s | %s | %s | %s |\n", strings.Replace(b.Name, "|", `\|`, -1),
+				metric(b, "ns/op"), metric(b, "B/op"), metric(b, "allocs/op"), formatSpeedup(e.Speedup(b)))
+		}
+		fmt.Fprintln(w)
+		for _, c := range e.Charts() {
+			fmt.Fprintf(w, "![%s](svg/%s)\n\n", e.Title, c.File)
+		}
+	}
+	if len(rep.Failed) > 0 {
+		fmt.Fprintf(w, "## Failed packages\n\n")
+		for _, f := range rep.Failed {
+			fmt.Fprintf(w, "### %s\n\n```\n%s\n```\n\n", f.Pkg, f.Output)
+		}
+	}
+}
+
+var htmlTmpl = template.Must(template.New("report").Funcs(template.FuncMap{
+	"metric":  metric,
+	"speedup": func(e *Experiment, b *Bench) string { return formatSpeedup(e.Speedup(b)) },
+	"charts": func(e *Experiment) []template.HTML {
+		var out []template.HTML
+		for _, c := range e.Charts() {
+			out = append(out, template.HTML(c.SVG))
+		}
+		return out
+	},
+}).Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>Benchmark report</title>
+<style>
+body { font-family: sans-serif; margin: 2em; }
+table { border-collapse: collapse; margin-bottom: 1em; }
+th, td { border: 1px solid #ccc; padding: 4px 8px; }
+td.num { text-align: right; font-family: monospace; }
+pre { background: #f6f6f6; padding: 1em; }
+</style>
+</head>
+<body>
+<h1>Benchmark report</h1>
+<p>{{.Time.Format "2006-01-02 15:04"}}, commit {{.Commit}}, {{.GoVersion}} {{.GOOS}}/{{.GOARCH}}, {{.CPU}}</p>
+<ul>
+{{range .Experiments}}<li><a href="#{{.ID}}">{{.Title}}</a></li>
+{{end}}</ul>
+{{range $e := .Experiments}}
+<h2 id="{{$e.ID}}">{{$e.Title}}</h2>
+<p><code>{{$e.Pkg}}</code> {{range $e.Files}}{{.}} {{end}}</p>
+<table>
+<tr><th>benchmark</th><th>ns/op</th><th>B/op</th><th>allocs/op</th><th>speedup</th></tr>
+{{range $e.Benches}}<tr><td>{{.Name}}</td><td class="num">{{metric . "ns/op"}}</td><td class="num">{{metric . "B/op"}}</td><td class="num">{{metric . "allocs/op"}}</td><td class="num">{{speedup $e .}}</td></tr>
+{{end}}</table>
+{{range charts $e}}{{.}}{{end}}
+{{end}}
+{{if .Failed}}<h2>Failed packages</h2>
+{{range .Failed}}<h3>{{.Pkg}}</h3>
+<pre>{{.Output}}</pre>
+{{end}}{{end}}
+</body>
+</html>
+`))
+
+// RenderHTML writes a single self-contained page, the charts are inline SVG.
+func RenderHTML(w io.Writer, rep *Report) error {
+	return htmlTmpl.Execute(w, rep)
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/buptbill220/go_performance/lib/benchparse"
+	"github.com/stretchr/testify/assert"
+)
+
+func result(name string, ns, bytes float64) *benchparse.Result {
+	return &benchparse.Result{Name: name, Metrics: map[string]float64{"ns/op": ns, "B/op": bytes, "allocs/op": 0}}
+}
+
+func TestGroup(t *testing.T) {
+	p := &listPackage{ImportPath: "github.com/buptbill220/go_performance/build_in"}
+	files := []*benchFile{
+		{name: "isset_set_test.go", group: "switch_vs_map", benches: []string{"BenchmarkIsSet"}},
+		{name: "switch_vs_map_test.go", group: "switch_vs_map", benches: []string{"BenchmarkSwitch", "BenchmarkMap"}},
+		{name: "read_test.go", group: "read", benches: []string{"BenchmarkRead"}},
+		{name: "empty_test.go", group: "empty", benches: []string{"BenchmarkSkipped"}},
+	}
+	results := []*benchparse.Result{
+		result("BenchmarkMap", 20, 0),
+		result("BenchmarkSwitch", 5, 0),
+		result("BenchmarkMap", 22, 0),
+		result("BenchmarkRead/size=1", 100, 16),
+		result("BenchmarkRead/size=8", 300, 128),
+		result("BenchmarkOther", 1, 0),
+		result("BenchmarkIsSet", 2, 0),
+	}
+	exps := group(p, files, results)
+	assert.Equal(t, 2, len(exps))
+
+	e := exps[0]
+	assert.Equal(t, "build_in: switch vs map", e.Title)
+	assert.Equal(t, "build_in-switch_vs_map", e.ID())
+	assert.Equal(t, []string{"switch_vs_map_test.go", "isset_set_test.go"}, e.Files)
+	assert.Equal(t, 3, len(e.Benches))
+	assert.Equal(t, "BenchmarkSwitch", e.Benches[0].Name)
+	assert.Equal(t, 21.0, e.Benches[1].Metrics["ns/op"])
+	assert.Equal(t, 2, e.Benches[1].Runs)
+	assert.Equal(t, 4.2, e.Speedup(e.Benches[0]))
+	assert.Equal(t, "BenchmarkIsSet", e.Benches[2].Name)
+	assert.Equal(t, 1, len(e.Charts()), "nothing allocates")
+
+	e = exps[1]
+	assert.Equal(t, []string{"BenchmarkRead/size=1", "BenchmarkRead/size=8"}, []string{e.Benches[0].Name, e.Benches[1].Name})
+	assert.Equal(t, 2, len(e.Charts()))
+}
+
+func TestRender(t *testing.T) {
+	p := &listPackage{ImportPath: "github.com/buptbill220/go_performance/lib"}
+	files := []*benchFile{{name: "parse_uri_test.go", group: "parse_uri", benches: []string{"BenchmarkParseUri", "BenchmarkQueryIter"}}}
+	rep := &Report{
+		Experiments: group(p, files, []*benchparse.Result{
+			result("BenchmarkParseUri", 3000, 4096),
+			result("BenchmarkQueryIter", 300, 0),
+		}),
+		Failed: []Failure{{Pkg: "broken", Output: "x <redeclared>"}},
+	}
+
+	var md bytes.Buffer
+	RenderMarkdown(&md, rep)
+	assert.Contains(t, md.String(), "## lib: parse uri")
+	assert.Contains(t, md.String(), "| BenchmarkQueryIter | 300 | 0 | 0 | 10.00x |")
+	assert.Contains(t, md.String(), "![lib: parse uri](svg/lib-parse_uri-bytes.svg)")
+
+	var html bytes.Buffer
+	assert.Nil(t, RenderHTML(&html, rep))
+	assert.Contains(t, html.String(), `<rect x="300"`)
+	assert.Contains(t, html.String(), "x &lt;redeclared&gt;")
+	assert.Equal(t, 2, strings.Count(html.String(), "<svg "))
+}
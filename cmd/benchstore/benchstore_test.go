@@ -0,0 +1,136 @@
+package main
+
+import (
+	"math"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/buptbill220/go_performance/lib/benchparse"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMannWhitney(t *testing.T) {
+	// scipy.stats.mannwhitneyu(x, y, method="exact")
+	p := mannWhitney([]float64{1, 2, 3, 4, 5}, []float64{6, 7, 8, 9, 10})
+	assert.InDelta(t, 2.0/252, p, 1e-9)
+	p = mannWhitney([]float64{1, 3, 5, 7}, []float64{2, 4, 6, 8})
+	assert.InDelta(t, 48.0/70, p, 1e-9)
+
+	same := []float64{10, 10, 10, 10}
+	assert.Equal(t, 1.0, mannWhitney(same, same))
+	assert.Equal(t, 1.0, mannWhitney(nil, same))
+
+	// 有重复值, 走正态近似
+	p = mannWhitney([]float64{1, 1, 2, 2, 3, 3, 4, 4}, []float64{5, 5, 6, 6, 7, 7, 8, 8})
+	assert.True(t, p < 0.01, "p=%v", p)
+
+	var total float64
+	for _, c := range uDist(10, 10) {
+		total += c
+	}
+	assert.Equal(t, 184756.0, total) // C(20, 10)
+}
+
+func run(ns ...float64) *Run {
+	r := &Run{}
+	for _, v := range ns {
+		r.Results = append(r.Results, &benchparse.Result{Pkg: "p", Name: "BenchmarkX", Metrics: map[string]float64{"ns/op": v, "MB/s": 1000 / v}})
+	}
+	return r
+}
+
+func TestCompare(t *testing.T) {
+	old := run(100, 101, 99, 100, 102, 98, 100, 101)
+	slow := run(110, 111, 109, 110, 112, 108, 110, 111)
+	rows := Compare(old, slow, 0.05, 0.05)
+	assert.Equal(t, 2, len(rows))
+	assert.Equal(t, "ns/op", rows[0].Unit)
+	assert.InDelta(t, 0.10, rows[0].Delta, 1e-9)
+	assert.True(t, rows[0].Regression)
+	assert.True(t, rows[1].Regression, "lower MB/s is a regression")
+
+	rows = Compare(slow, old, 0.05, 0.05)
+	assert.False(t, rows[0].Regression)
+	assert.False(t, rows[1].Regression)
+
+	rows = Compare(old, slow, 0.05, 0.20)
+	assert.False(t, rows[0].Regression, "below threshold")
+
+	noisy := run(100, 130, 80, 95)
+	rows = Compare(old, noisy, 0.05, 0.05)
+	assert.False(t, rows[0].Regression, "not significant")
+
+	var sb strings.Builder
+	PrintRows(&sb, Compare(old, slow, 0.05, 0.05), 0.05)
+	assert.Contains(t, sb.String(), "+10.00%")
+	assert.Contains(t, sb.String(), "REGRESSION")
+}
+
+func TestCompareProcs(t *testing.T) {
+	// -cpu=1,4 跑出来的两组不能混在一起比
+	old, new := run(100, 101, 99, 100), run(100, 101, 99, 100)
+	for _, v := range []float64{50, 51, 49, 50} {
+		old.Results = append(old.Results, &benchparse.Result{Pkg: "p", Name: "BenchmarkX", Procs: 4, Metrics: map[string]float64{"ns/op": v}})
+		new.Results = append(new.Results, &benchparse.Result{Pkg: "p", Name: "BenchmarkX", Procs: 4, Metrics: map[string]float64{"ns/op": v * 2}})
+	}
+	var keys []string
+	for _, r := range Compare(old, new, 0.05, 0.05) {
+		if r.Unit == "ns/op" {
+			keys = append(keys, r.Key)
+			assert.Equal(t, r.Key == "p.BenchmarkX-4", r.Regression, r.Key)
+		}
+	}
+	assert.Equal(t, []string{"p.BenchmarkX", "p.BenchmarkX-4"}, keys)
+}
+
+func TestStore(t *testing.T) {
+	s := NewStore(t.TempDir())
+	_, err := s.Resolve("latest")
+	assert.NotNil(t, err)
+
+	t0 := time.Date(2026, 10, 19, 10, 0, 0, 0, time.UTC)
+	for i, label := range []string{"before", "after", ""} {
+		r := run(100)
+		r.Time = t0.Add(time.Duration(i) * time.Minute)
+		r.Commit = "abc123"
+		r.Label = label
+		assert.Nil(t, s.Save(r))
+	}
+	ids, _ := s.IDs()
+	assert.Equal(t, []string{"20261019-100000-abc123", "20261019-100100-abc123", "20261019-100200-abc123"}, ids)
+
+	r, err := s.Resolve("latest")
+	assert.Nil(t, err)
+	assert.Equal(t, ids[2], r.ID)
+	r, _ = s.Resolve("latest~2")
+	assert.Equal(t, "before", r.Label)
+	r, _ = s.Resolve("after")
+	assert.Equal(t, ids[1], r.ID)
+	r, _ = s.Resolve("20261019-1002")
+	assert.Equal(t, ids[2], r.ID)
+	assert.Equal(t, 100.0, r.Results[0].Metrics["ns/op"])
+
+	_, err = s.Resolve("20261019")
+	assert.NotNil(t, err, "ambiguous")
+	_, err = s.Resolve("latest~3")
+	assert.NotNil(t, err)
+	_, err = s.Resolve("nothing")
+	assert.NotNil(t, err)
+	assert.False(t, math.IsNaN(r.Results[0].Metrics["MB/s"]))
+
+	// 同一秒的两次run不覆盖
+	r = run(200)
+	r.Time = t0.Add(2 * time.Minute)
+	r.Commit = "abc123"
+	assert.Nil(t, s.Save(r))
+	assert.Equal(t, "20261019-100200-abc123-01", r.ID)
+	ids, _ = s.IDs()
+	assert.Equal(t, 4, len(ids))
+	r, _ = s.Resolve("latest")
+	assert.Equal(t, 200.0, r.Results[0].Metrics["ns/op"])
+	r, _ = s.Resolve("latest~1")
+	assert.Equal(t, 100.0, r.Results[0].Metrics["ns/op"])
+	assert.True(t, os.IsExist(s.Save(r)), "explicit id exists")
+}
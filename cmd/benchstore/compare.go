@@ -0,0 +1,120 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"strings"
+)
+
+type Row struct {
+	Key        string
+	Unit       string
+	Old, New   []float64
+	OldMed     float64
+	NewMed     float64
+	Delta      float64 // (new-old)/old
+	P          float64
+	Regression bool
+}
+
+// higherIsBetter is true for throughput units like MB/s or ops/s, the others (ns/op, B/op, allocs/op
+// and most custom metrics such as p99-us) are costs.
+func higherIsBetter(unit string) bool {
+	return strings.HasSuffix(unit, "/s")
+}
+
+func samples(r *Run) map[string]map[string][]float64 {
+	m := make(map[string]map[string][]float64)
+	for _, res := range r.Results {
+		units := m[res.Key()]
+		if units == nil {
+			units = make(map[string][]float64)
+			m[res.Key()] = units
+		}
+		for unit, v := range res.Metrics {
+			units[unit] = append(units[unit], v)
+		}
+	}
+	return m
+}
+
+// Compare pairs the benchmarks present in both runs. A row is a regression when the change is
+// significant at alpha and worse than threshold, e.g. 0.05 for 5%.
+func Compare(old, new *Run, alpha, threshold float64) []*Row {
+	olds, news := samples(old), samples(new)
+	var rows []*Row
+	for key, units := range olds {
+		for unit, ov := range units {
+			nv, ok := news[key][unit]
+			if !ok {
+				continue
+			}
+			r := &Row{Key: key, Unit: unit, Old: ov, New: nv, OldMed: median(ov), NewMed: median(nv)}
+			if r.OldMed != 0 {
+				r.Delta = (r.NewMed - r.OldMed) / math.Abs(r.OldMed)
+			}
+			r.P = mannWhitney(ov, nv)
+			worse := r.Delta
+			if higherIsBetter(unit) {
+				worse = -worse
+			}
+			r.Regression = r.P < alpha && worse > threshold
+			rows = append(rows, r)
+		}
+	}
+	sort.Slice(rows, func(i, j int) bool {
+		if rows[i].Unit != rows[j].Unit {
+			return unitOrder(rows[i].Unit) < unitOrder(rows[j].Unit)
+		}
+		return rows[i].Key < rows[j].Key
+	})
+	return rows
+}
+
+func unitOrder(unit string) string {
+	switch unit {
+	case "ns/op":
+		return "0"
+	case "B/op":
+		return "1"
+	case "allocs/op":
+		return "2"
+	}
+	return "3" + unit
+}
+
+// PrintRows prints the rows like benchstat, one table per unit, "~" marks changes that are not significant.
+func PrintRows(w io.Writer, rows []*Row, alpha float64) {
+	width := len("name")
+	for _, r := range rows {
+		if len(r.Key) > width {
+			width = len(r.Key)
+		}
+	}
+	unit := ""
+	for _, r := range rows {
+		if r.Unit != unit {
+			if unit != "" {
+				fmt.Fprintln(w)
+			}
+			unit = r.Unit
+			fmt.Fprintf(w, "%-*s  %20s  %20s  %s\n", width, "name", "old "+unit, "new "+unit, "delta")
+		}
+		delta := "~"
+		if r.P < alpha {
+			delta = fmt.Sprintf("%+.2f%%", r.Delta*100)
+		}
+		mark := ""
+		if r.Regression {
+			mark = "  REGRESSION"
+		}
+		fmt.Fprintf(w, "%-*s  %20s  %20s  %s (p=%.3f n=%d+%d)%s\n", width, r.Key,
+			formatSample(r.OldMed, r.Old), formatSample(r.NewMed, r.New), delta, r.P, len(r.Old), len(r.New), mark)
+	}
+}
+
+func formatSample(med float64, xs []float64) string {
+	return fmt.Sprintf("%.4g ± %2.0f%%", med, spread(xs, med)*100)
+}
@@ -0,0 +1,225 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"runtime"
+	"strings"
+	"time"
+
+	"github.com/buptbill220/go_performance/lib/benchparse"
+)
+
+/*
+	保存benchmark结果并和历史对比, 代替README里手贴的数字
+
+	benchstore run -count=10 -label=before ./lib/...        跑 go test -bench -benchmem, 结果存到 .benchstore/
+	benchstore run -input=bench.txt                          解析已有的 go test -bench 输出
+	benchstore list
+	benchstore compare -threshold=5 before latest            Mann-Whitney U 检验, 显著且变差超过5%时退出码为1
+
+	run 可以用 id, id前缀, label, latest, latest~1 指定
+*/
+
+const usage = `usage:
+	benchstore run [-store=dir] [-bench=regexp] [-count=n] [-benchtime=d] [-label=name] [-input=file] [packages]
+	benchstore list [-store=dir]
+	benchstore compare [-store=dir] [-alpha=0.05] [-threshold=5] [old [new]]
+`
+
+func main() {
+	if len(os.Args) < 2 {
+		fmt.Fprint(os.Stderr, usage)
+		os.Exit(2)
+	}
+	var err error
+	switch os.Args[1] {
+	case "run":
+		err = runCmd(os.Args[2:])
+	case "list":
+		err = listCmd(os.Args[2:])
+	case "compare":
+		err = compareCmd(os.Args[2:])
+	default:
+		fmt.Fprint(os.Stderr, usage)
+		os.Exit(2)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "benchstore: %v\n", err)
+		os.Exit(1)
+	}
+}
+
+func runCmd(args []string) error {
+	fs := flag.NewFlagSet("run", flag.ExitOnError)
+	dir := fs.String("store", ".benchstore", "store directory")
+	bench := fs.String("bench", ".", "benchmarks to run")
+	count := fs.Int("count", 10, "runs of each benchmark, the comparison needs several")
+	benchtime := fs.String("benchtime", "", "go test -benchtime")
+	label := fs.String("label", "", "name for the run, usable in compare")
+	input := fs.String("input", "", "parse this go test -bench output instead of running")
+	fs.Parse(args)
+	pkgs := fs.Args()
+	if len(pkgs) == 0 {
+		pkgs = []string{"./..."}
+	}
+
+	var out io.Reader
+	if *input != "" {
+		data, err := ioutil.ReadFile(*input)
+		if err != nil {
+			return err
+		}
+		out = bytes.NewReader(data)
+	} else {
+		goArgs := []string{"test", "-run=^$", "-bench=" + *bench, "-benchmem", fmt.Sprintf("-count=%d", *count)}
+		if *benchtime != "" {
+			goArgs = append(goArgs, "-benchtime="+*benchtime)
+		}
+		goArgs = append(goArgs, pkgs...)
+		cmd := exec.Command("go", goArgs...)
+		var buf bytes.Buffer
+		cmd.Stdout = io.MultiWriter(os.Stdout, &buf)
+		cmd.Stderr = os.Stderr
+		if err := cmd.Run(); err != nil {
+			return fmt.Errorf("go %s: %v", strings.Join(goArgs, " "), err)
+		}
+		out = &buf
+	}
+
+	results, h, err := benchparse.Parse(out)
+	if err != nil {
+		return err
+	}
+	if len(results) == 0 {
+		return fmt.Errorf("no benchmark results")
+	}
+	r := &Run{
+		Time:      time.Now(),
+		Label:     *label,
+		Commit:    git("rev-parse", "--short", "HEAD"),
+		Dirty:     git("status", "--porcelain") != "",
+		GoVersion: goVersion(),
+		GOOS:      h.GOOS,
+		GOARCH:    h.GOARCH,
+		CPU:       h.CPU,
+		NumCPU:    runtime.NumCPU(),
+		Packages:  pkgs,
+		Results:   results,
+	}
+	if r.CPU == "" {
+		r.CPU = cpuModel()
+	}
+	if err := NewStore(*dir).Save(r); err != nil {
+		return err
+	}
+	fmt.Printf("saved run %s: %d results\n", r.ID, len(results))
+	return nil
+}
+
+func listCmd(args []string) error {
+	fs := flag.NewFlagSet("list", flag.ExitOnError)
+	dir := fs.String("store", ".benchstore", "store directory")
+	fs.Parse(args)
+	s := NewStore(*dir)
+	ids, err := s.IDs()
+	if err != nil {
+		return err
+	}
+	for _, id := range ids {
+		r, err := s.Load(id)
+		if err != nil {
+			return err
+		}
+		dirty := ""
+		if r.Dirty {
+			dirty = "+dirty"
+		}
+		fmt.Printf("%-28s %-12s %s%s\t%s\t%s\t%d results\n", r.ID, r.Label, r.Commit, dirty, r.GoVersion, r.CPU, len(r.Results))
+	}
+	return nil
+}
+
+func compareCmd(args []string) error {
+	fs := flag.NewFlagSet("compare", flag.ExitOnError)
+	dir := fs.String("store", ".benchstore", "store directory")
+	alpha := fs.Float64("alpha", 0.05, "significance level")
+	threshold := fs.Float64("threshold", 5, "regression threshold in percent")
+	fs.Parse(args)
+	oldRef, newRef := "latest~1", "latest"
+	switch fs.NArg() {
+	case 0:
+	case 1:
+		oldRef = fs.Arg(0)
+	case 2:
+		oldRef, newRef = fs.Arg(0), fs.Arg(1)
+	default:
+		return fmt.Errorf("compare takes at most two runs")
+	}
+
+	s := NewStore(*dir)
+	old, err := s.Resolve(oldRef)
+	if err != nil {
+		return err
+	}
+	new, err := s.Resolve(newRef)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("old: %s %s %s %s\n", old.ID, old.Commit, old.GoVersion, old.CPU)
+	fmt.Printf("new: %s %s %s %s\n\n", new.ID, new.Commit, new.GoVersion, new.CPU)
+	if old.CPU != new.CPU || old.GoVersion != new.GoVersion {
+		fmt.Printf("warning: runs differ in cpu or go version\n\n")
+	}
+
+	rows := Compare(old, new, *alpha, *threshold/100)
+	PrintRows(os.Stdout, rows, *alpha)
+	n := 0
+	for _, r := range rows {
+		if r.Regression {
+			n++
+		}
+	}
+	if n > 0 {
+		return fmt.Errorf("%d regressions beyond %.1f%%", n, *threshold)
+	}
+	return nil
+}
+
+func git(args ...string) string {
+	out, err := exec.Command("git", args...).Output()
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(string(out))
+}
+
+// goVersion is the version of the go command that ran the benchmarks, not of this binary.
+func goVersion() string {
+	out, err := exec.Command("go", "env", "GOVERSION").Output()
+	if err != nil {
+		return runtime.Version()
+	}
+	return strings.TrimSpace(string(out))
+}
+
+func cpuModel() string {
+	f, err := os.Open("/proc/cpuinfo")
+	if err != nil {
+		return runtime.GOARCH
+	}
+	defer f.Close()
+	sc := bufio.NewScanner(f)
+	for sc.Scan() {
+		if k, v, ok := strings.Cut(sc.Text(), ":"); ok && strings.TrimSpace(k) == "model name" {
+			return strings.TrimSpace(v)
+		}
+	}
+	return runtime.GOARCH
+}
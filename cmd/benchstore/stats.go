@@ -0,0 +1,131 @@
+package main
+
+import (
+	"math"
+	"sort"
+)
+
+func median(xs []float64) float64 {
+	s := append([]float64(nil), xs...)
+	sort.Float64s(s)
+	n := len(s)
+	if n == 0 {
+		return 0
+	}
+	if n%2 == 1 {
+		return s[n/2]
+	}
+	return (s[n/2-1] + s[n/2]) / 2
+}
+
+// spread is the largest distance from the median, relative to it.
+func spread(xs []float64, med float64) float64 {
+	if med == 0 {
+		return 0
+	}
+	var d float64
+	for _, x := range xs {
+		d = math.Max(d, math.Abs(x-med))
+	}
+	return d / math.Abs(med)
+}
+
+// maxExact bounds the sample sizes of the exact Mann-Whitney distribution.
+const maxExact = 30
+
+// mannWhitney returns the two-sided p-value of the Mann-Whitney U test, the test benchstat uses:
+// it makes no assumption about the distribution, benchmark timings are rarely normal.
+// The distribution of U is exact for small samples without ties, normal otherwise.
+func mannWhitney(x, y []float64) float64 {
+	n1, n2 := len(x), len(y)
+	if n1 == 0 || n2 == 0 {
+		return 1
+	}
+	type obs struct {
+		v    float64
+		left bool
+	}
+	all := make([]obs, 0, n1+n2)
+	for _, v := range x {
+		all = append(all, obs{v, true})
+	}
+	for _, v := range y {
+		all = append(all, obs{v, false})
+	}
+	sort.Slice(all, func(i, j int) bool { return all[i].v < all[j].v })
+
+	// 相同的值取平均秩
+	var r1, tie float64
+	ties := false
+	for i := 0; i < len(all); {
+		j := i
+		for j < len(all) && all[j].v == all[i].v {
+			j++
+		}
+		rank := float64(i+j+1) / 2
+		for k := i; k < j; k++ {
+			if all[k].left {
+				r1 += rank
+			}
+		}
+		if t := float64(j - i); t > 1 {
+			ties = true
+			tie += t*t*t - t
+		}
+		i = j
+	}
+	u := r1 - float64(n1*(n1+1))/2
+
+	if !ties && n1 <= maxExact && n2 <= maxExact {
+		dist := uDist(n1, n2)
+		var total, le, ge float64
+		for k, c := range dist {
+			total += c
+			if float64(k) <= u {
+				le += c
+			}
+			if float64(k) >= u {
+				ge += c
+			}
+		}
+		return math.Min(1, 2*math.Min(le, ge)/total)
+	}
+
+	n := float64(n1 + n2)
+	mu := float64(n1*n2) / 2
+	sigma := math.Sqrt(float64(n1*n2) / 12 * ((n + 1) - tie/(n*(n-1))))
+	if sigma == 0 {
+		return 1
+	}
+	z := (math.Abs(u-mu) - 0.5) / sigma
+	if z < 0 {
+		return 1
+	}
+	return math.Erfc(z / math.Sqrt2)
+}
+
+// uDist counts the orderings giving each U for samples of n1 and n2 distinct values,
+// f(m, n)[u] = f(m-1, n)[u-n] + f(m, n-1)[u].
+func uDist(n1, n2 int) []float64 {
+	f := make([][][]float64, n1+1)
+	for m := 0; m <= n1; m++ {
+		f[m] = make([][]float64, n2+1)
+		for n := 0; n <= n2; n++ {
+			d := make([]float64, m*n+1)
+			if m == 0 || n == 0 {
+				d[0] = 1
+			} else {
+				for u := range d {
+					if u >= n && u-n < len(f[m-1][n]) {
+						d[u] += f[m-1][n][u-n]
+					}
+					if u < len(f[m][n-1]) {
+						d[u] += f[m][n-1][u]
+					}
+				}
+			}
+			f[m][n] = d
+		}
+	}
+	return f[n1][n2]
+}
@@ -0,0 +1,151 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/buptbill220/go_performance/lib/benchparse"
+)
+
+type Run struct {
+	ID        string               `json:"id"`
+	Time      time.Time            `json:"time"`
+	Label     string               `json:"label,omitempty"`
+	Commit    string               `json:"commit"`
+	Dirty     bool                 `json:"dirty,omitempty"` // uncommitted changes when the run was made
+	GoVersion string               `json:"go_version"`
+	GOOS      string               `json:"goos"`
+	GOARCH    string               `json:"goarch"`
+	CPU       string               `json:"cpu"`
+	NumCPU    int                  `json:"num_cpu"`
+	Packages  []string             `json:"packages"`
+	Results   []*benchparse.Result `json:"results"`
+}
+
+// Store keeps one JSON file per run in a directory, file names sort by time.
+type Store struct {
+	dir string
+}
+
+func NewStore(dir string) *Store {
+	return &Store{dir: dir}
+}
+
+// Save writes r as a new file and never overwrites a stored run. Without an ID it gets
+// time-commit, runs in the same second get -01, -02... appended so they still sort by time.
+func (s *Store) Save(r *Run) error {
+	if err := os.MkdirAll(s.dir, 0755); err != nil {
+		return err
+	}
+	if r.ID != "" {
+		return s.create(r)
+	}
+	base := r.Time.Format("20060102-150405")
+	if r.Commit != "" {
+		base += "-" + r.Commit
+	}
+	r.ID = base
+	for n := 1; ; n++ {
+		err := s.create(r)
+		if !os.IsExist(err) {
+			return err
+		}
+		r.ID = fmt.Sprintf("%s-%02d", base, n)
+	}
+}
+
+func (s *Store) create(r *Run) error {
+	data, err := json.MarshalIndent(r, "", "  ")
+	if err != nil {
+		return err
+	}
+	f, err := os.OpenFile(filepath.Join(s.dir, r.ID+".json"), os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+	if err != nil {
+		return err
+	}
+	if _, err = f.Write(data); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// IDs returns the stored run ids, oldest first.
+func (s *Store) IDs() ([]string, error) {
+	files, err := filepath.Glob(filepath.Join(s.dir, "*.json"))
+	if err != nil {
+		return nil, err
+	}
+	var ids []string
+	for _, f := range files {
+		ids = append(ids, strings.TrimSuffix(filepath.Base(f), ".json"))
+	}
+	sort.Strings(ids)
+	return ids, nil
+}
+
+func (s *Store) Load(id string) (*Run, error) {
+	data, err := ioutil.ReadFile(filepath.Join(s.dir, id+".json"))
+	if err != nil {
+		return nil, err
+	}
+	r := new(Run)
+	return r, json.Unmarshal(data, r)
+}
+
+// Resolve finds a run by id, unique id prefix, label, "latest" or "latest~N" (N runs before the latest).
+func (s *Store) Resolve(ref string) (*Run, error) {
+	ids, err := s.IDs()
+	if err != nil {
+		return nil, err
+	}
+	if len(ids) == 0 {
+		return nil, fmt.Errorf("no runs in %s", s.dir)
+	}
+	if strings.HasPrefix(ref, "latest") {
+		n := 0
+		if rest := strings.TrimPrefix(ref, "latest"); rest != "" {
+			if !strings.HasPrefix(rest, "~") {
+				return nil, fmt.Errorf("bad run reference %q", ref)
+			}
+			if n, err = strconv.Atoi(rest[1:]); err != nil || n < 0 {
+				return nil, fmt.Errorf("bad run reference %q", ref)
+			}
+		}
+		if n >= len(ids) {
+			return nil, fmt.Errorf("%s: only %d runs", ref, len(ids))
+		}
+		return s.Load(ids[len(ids)-1-n])
+	}
+
+	var match []string
+	for _, id := range ids {
+		if id == ref {
+			return s.Load(id)
+		}
+		if strings.HasPrefix(id, ref) {
+			match = append(match, id)
+		}
+	}
+	switch len(match) {
+	case 1:
+		return s.Load(match[0])
+	case 0:
+		// 最后按label找, 同名取最新的
+		for i := len(ids) - 1; i >= 0; i-- {
+			r, err := s.Load(ids[i])
+			if err == nil && r.Label == ref {
+				return r, nil
+			}
+		}
+		return nil, fmt.Errorf("run %q not found", ref)
+	}
+	return nil, fmt.Errorf("run %q is ambiguous: %s", ref, strings.Join(match, ", "))
+}
@@ -0,0 +1,475 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"go/ast"
+	"go/format"
+	"go/parser"
+	"go/token"
+	"io/ioutil"
+	"os"
+	"reflect"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+/*
+	为struct生成 AppendJSON(dst []byte) []byte 方法, 输出和 encoding/json 完全一致, 不走反射也不分配
+	唯一的例外是浮点字段的 NaN/Inf: json.Marshal 返回 *json.UnsupportedValueError, AppendJSON 没有error, 写成 null;
+	浮点字段可能是 NaN/Inf 的, 调用前自己检查
+
+	//go:generate go run github.com/buptbill220/go_performance/cmd/jsongen -type=Data json_format_test.go
+
+	输入 xxx.go 生成 xxx_json.go, 输入 xxx_test.go 生成 xxx_json_test.go
+	支持: string/bool/整数/浮点/[]byte(包括 []MyByte), 以及它们的slice/指针, 同一批-type里的struct; 支持 omitempty 和 ,string
+	不支持: map, interface, 匿名嵌入字段, 同一文件里有 MarshalJSON/MarshalText 方法的类型(直接报错, 不会悄悄按字段输出)
+*/
+
+const runtimePkg = "github.com/buptbill220/go_performance/lib/jsonenc"
+
+var (
+	typeNames = flag.String("type", "", "comma-separated list of struct type names")
+	output    = flag.String("output", "", "output file name, default <file>_json.go")
+)
+
+func main() {
+	flag.Parse()
+	if *typeNames == "" || flag.NArg() != 1 {
+		fmt.Fprintf(os.Stderr, "usage: jsongen -type=T1,T2 [-output=file] file.go\n")
+		os.Exit(2)
+	}
+	input := flag.Arg(0)
+	src, err := ioutil.ReadFile(input)
+	if err != nil {
+		fatal(err)
+	}
+	code, err := generate(input, src, strings.Split(*typeNames, ","))
+	if err != nil {
+		fatal(err)
+	}
+	out := *output
+	if out == "" {
+		out = outputName(input)
+	}
+	if err := ioutil.WriteFile(out, code, 0644); err != nil {
+		fatal(err)
+	}
+}
+
+func fatal(err error) {
+	fmt.Fprintf(os.Stderr, "jsongen: %v\n", err)
+	os.Exit(1)
+}
+
+func outputName(input string) string {
+	if strings.HasSuffix(input, "_test.go") {
+		return strings.TrimSuffix(input, "_test.go") + "_json_test.go"
+	}
+	return strings.TrimSuffix(input, ".go") + "_json.go"
+}
+
+type kind int
+
+const (
+	kString kind = iota
+	kBool
+	kInt
+	kUint
+	kFloat32
+	kFloat64
+	kBytes
+	kStruct
+	kSlice
+	kPtr
+)
+
+type typeInfo struct {
+	kind kind
+	elem *typeInfo
+	// named 只对kBytes有用, 元素是命名的byte类型时不能直接转成[]byte
+	named bool
+}
+
+type field struct {
+	goName    string
+	jsonName  string
+	typ       *typeInfo
+	omitEmpty bool
+	quoted    bool
+}
+
+type generator struct {
+	specs     map[string]ast.Expr
+	methods   map[string][]string // receiver type name -> method names
+	generated map[string]bool
+	buf       bytes.Buffer
+	depth     int
+}
+
+func generate(filename string, src []byte, names []string) ([]byte, error) {
+	fset := token.NewFileSet()
+	file, err := parser.ParseFile(fset, filename, src, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	g := &generator{
+		specs:     make(map[string]ast.Expr),
+		methods:   make(map[string][]string),
+		generated: make(map[string]bool),
+	}
+	for _, decl := range file.Decls {
+		if fd, ok := decl.(*ast.FuncDecl); ok && fd.Recv != nil && len(fd.Recv.List) == 1 {
+			recv := fd.Recv.List[0].Type
+			if star, ok := recv.(*ast.StarExpr); ok {
+				recv = star.X
+			}
+			if ident, ok := recv.(*ast.Ident); ok {
+				g.methods[ident.Name] = append(g.methods[ident.Name], fd.Name.Name)
+			}
+			continue
+		}
+		gd, ok := decl.(*ast.GenDecl)
+		if !ok || gd.Tok != token.TYPE {
+			continue
+		}
+		for _, spec := range gd.Specs {
+			ts := spec.(*ast.TypeSpec)
+			g.specs[ts.Name.Name] = ts.Type
+		}
+	}
+	for _, name := range names {
+		g.generated[name] = true
+	}
+
+	fmt.Fprintf(&g.buf, "// Code generated by jsongen -type=%s; DO NOT EDIT.\n\n", strings.Join(names, ","))
+	fmt.Fprintf(&g.buf, "package %s\n\n", file.Name.Name)
+	fmt.Fprintf(&g.buf, "import %q\n", runtimePkg)
+	for _, name := range names {
+		st, ok := g.specs[name].(*ast.StructType)
+		if !ok {
+			return nil, fmt.Errorf("%s: struct type %s not found", filename, name)
+		}
+		if err := g.checkMarshaler(name); err != nil {
+			return nil, fmt.Errorf("%s: %v", filename, err)
+		}
+		fields, err := g.fields(name, st)
+		if err != nil {
+			return nil, err
+		}
+		g.genMethod(name, fields)
+	}
+	return format.Source(g.buf.Bytes())
+}
+
+func (g *generator) fields(typeName string, st *ast.StructType) ([]field, error) {
+	var fields []field
+	seen := make(map[string]bool)
+	for _, f := range st.Fields.List {
+		if len(f.Names) == 0 {
+			return nil, fmt.Errorf("%s: embedded fields are not supported", typeName)
+		}
+		var tag string
+		if f.Tag != nil {
+			raw, _ := strconv.Unquote(f.Tag.Value)
+			tag = reflect.StructTag(raw).Get("json")
+		}
+		if tag == "-" {
+			continue
+		}
+		tagName, opts := tag, ""
+		if idx := strings.IndexByte(tag, ','); idx >= 0 {
+			tagName, opts = tag[:idx], tag[idx+1:]
+		}
+		for _, ident := range f.Names {
+			if !ident.IsExported() {
+				continue
+			}
+			t, err := g.resolve(f.Type)
+			if err != nil {
+				return nil, fmt.Errorf("%s.%s: %v", typeName, ident.Name, err)
+			}
+			fd := field{
+				goName:    ident.Name,
+				jsonName:  ident.Name,
+				typ:       t,
+				omitEmpty: hasOption(opts, "omitempty"),
+			}
+			if isValidTag(tagName) {
+				fd.jsonName = tagName
+			}
+			if hasOption(opts, "string") {
+				st := t
+				if st.kind == kPtr {
+					st = st.elem
+				}
+				switch st.kind {
+				case kString, kBool, kInt, kUint, kFloat32, kFloat64:
+					fd.quoted = true
+				}
+			}
+			if seen[fd.jsonName] {
+				return nil, fmt.Errorf("%s: duplicate json name %q", typeName, fd.jsonName)
+			}
+			seen[fd.jsonName] = true
+			fields = append(fields, fd)
+		}
+	}
+	return fields, nil
+}
+
+// checkMarshaler rejects types whose JSON encoding/json takes from a method,
+// only methods declared in the input file are seen.
+func (g *generator) checkMarshaler(name string) error {
+	for _, m := range g.methods[name] {
+		switch m {
+		case "MarshalJSON":
+			return fmt.Errorf("type %s implements json.Marshaler", name)
+		case "MarshalText":
+			return fmt.Errorf("type %s implements encoding.TextMarshaler", name)
+		}
+	}
+	return nil
+}
+
+func (g *generator) resolve(expr ast.Expr) (*typeInfo, error) {
+	switch t := expr.(type) {
+	case *ast.Ident:
+		if err := g.checkMarshaler(t.Name); err != nil {
+			return nil, err
+		}
+		switch t.Name {
+		case "string":
+			return &typeInfo{kind: kString}, nil
+		case "bool":
+			return &typeInfo{kind: kBool}, nil
+		case "int", "int8", "int16", "int32", "int64", "rune":
+			return &typeInfo{kind: kInt}, nil
+		case "uint", "uint8", "uint16", "uint32", "uint64", "uintptr", "byte":
+			return &typeInfo{kind: kUint}, nil
+		case "float32":
+			return &typeInfo{kind: kFloat32}, nil
+		case "float64":
+			return &typeInfo{kind: kFloat64}, nil
+		}
+		if g.generated[t.Name] {
+			return &typeInfo{kind: kStruct}, nil
+		}
+		spec, ok := g.specs[t.Name]
+		if !ok {
+			return nil, fmt.Errorf("unknown type %s", t.Name)
+		}
+		if _, ok := spec.(*ast.StructType); ok {
+			return nil, fmt.Errorf("struct type %s has no generated AppendJSON, add it to -type", t.Name)
+		}
+		return g.resolve(spec)
+	case *ast.ArrayType:
+		if t.Len != nil {
+			return nil, fmt.Errorf("arrays are not supported")
+		}
+		elem, err := g.resolve(t.Elt)
+		if err != nil {
+			return nil, err
+		}
+		if elem.kind == kUint && g.isByte(t.Elt) {
+			ident, ok := t.Elt.(*ast.Ident)
+			named := !ok || (ident.Name != "byte" && ident.Name != "uint8")
+			return &typeInfo{kind: kBytes, named: named}, nil
+		}
+		return &typeInfo{kind: kSlice, elem: elem}, nil
+	case *ast.StarExpr:
+		elem, err := g.resolve(t.X)
+		if err != nil {
+			return nil, err
+		}
+		return &typeInfo{kind: kPtr, elem: elem}, nil
+	case *ast.ParenExpr:
+		return g.resolve(t.X)
+	}
+	return nil, fmt.Errorf("unsupported type %T", expr)
+}
+
+// isByte follows named types declared in the file, encoding/json writes []MyByte as base64 too.
+func (g *generator) isByte(expr ast.Expr) bool {
+	switch t := expr.(type) {
+	case *ast.Ident:
+		if t.Name == "byte" || t.Name == "uint8" {
+			return true
+		}
+		if spec, ok := g.specs[t.Name]; ok {
+			return g.isByte(spec)
+		}
+	case *ast.ParenExpr:
+		return g.isByte(t.X)
+	}
+	return false
+}
+
+func (g *generator) printf(format string, args ...interface{}) {
+	fmt.Fprintf(&g.buf, format, args...)
+}
+
+func (g *generator) genMethod(name string, fields []field) {
+	g.printf("\n// AppendJSON appends the JSON encoding of p to dst, same as encoding/json.\n")
+	g.printf("func (p *%s) AppendJSON(dst []byte) []byte {\n", name)
+	g.printf("if p == nil {\nreturn append(dst, \"null\"...)\n}\n")
+	g.printf("dst = append(dst, '{')\n")
+	if len(fields) == 0 {
+		g.printf("return append(dst, '}')\n}\n")
+		return
+	}
+
+	// 前面有一定会输出的字段时, 逗号可以直接写死; 前面全是omitempty字段时按长度判断
+	first := len(fields)
+	for i, f := range fields {
+		if !f.omitEmpty {
+			first = i
+			break
+		}
+	}
+	if (first > 0 && first < len(fields)) || (first == len(fields) && first > 1) {
+		g.printf("start := len(dst)\n")
+	}
+	wrote := false
+	for i, f := range fields {
+		expr := "p." + f.goName
+		if f.omitEmpty {
+			g.printf("if %s {\n", notEmpty(expr, f.typ))
+		}
+		key, _ := json.Marshal(f.jsonName)
+		switch {
+		case wrote:
+			g.printf("dst = append(dst, %q...)\n", ","+string(key)+":")
+		case i > 0:
+			g.printf("if len(dst) > start {\ndst = append(dst, ',')\n}\n")
+			g.printf("dst = append(dst, %q...)\n", string(key)+":")
+		default:
+			g.printf("dst = append(dst, %q...)\n", string(key)+":")
+		}
+		g.value(expr, f.typ, f.quoted, f.omitEmpty)
+		if f.omitEmpty {
+			g.printf("}\n")
+		} else {
+			wrote = true
+		}
+	}
+	g.printf("return append(dst, '}')\n}\n")
+}
+
+func notEmpty(expr string, t *typeInfo) string {
+	switch t.kind {
+	case kString:
+		return expr + ` != ""`
+	case kBool:
+		return expr
+	case kInt, kUint, kFloat32, kFloat64:
+		return expr + " != 0"
+	case kBytes, kSlice:
+		return "len(" + expr + ") != 0"
+	case kPtr:
+		return expr + " != nil"
+	}
+	return "true"
+}
+
+// value 输出expr的值, nonNil为true时expr已经判断过非空(omitempty), 不用再判nil
+func (g *generator) value(expr string, t *typeInfo, quoted, nonNil bool) {
+	if quoted && t.kind != kPtr && t.kind != kString {
+		g.printf("dst = append(dst, '\"')\n")
+		defer g.printf("dst = append(dst, '\"')\n")
+	}
+	switch t.kind {
+	case kString:
+		if quoted {
+			g.printf("dst = jsonenc.AppendString(dst, string(jsonenc.AppendString(nil, string(%s))))\n", expr)
+		} else {
+			g.printf("dst = jsonenc.AppendString(dst, string(%s))\n", expr)
+		}
+	case kBool:
+		g.printf("dst = jsonenc.AppendBool(dst, bool(%s))\n", expr)
+	case kInt:
+		g.printf("dst = jsonenc.AppendInt(dst, int64(%s))\n", expr)
+	case kUint:
+		g.printf("dst = jsonenc.AppendUint(dst, uint64(%s))\n", expr)
+	case kFloat32:
+		g.printf("dst = jsonenc.AppendFloat(dst, float64(%s), 32)\n", expr)
+	case kFloat64:
+		g.printf("dst = jsonenc.AppendFloat(dst, float64(%s), 64)\n", expr)
+	case kBytes:
+		if t.named {
+			// []MyByte 只能经过string转, 多一次拷贝
+			g.printf("dst = jsonenc.AppendBytes(dst, []byte(string(%s)))\n", expr)
+		} else {
+			g.printf("dst = jsonenc.AppendBytes(dst, []byte(%s))\n", expr)
+		}
+	case kStruct:
+		g.printf("dst = %s.AppendJSON(dst)\n", expr)
+	case kPtr:
+		if t.elem.kind == kStruct {
+			// AppendJSON 自己处理了nil
+			g.printf("dst = %s.AppendJSON(dst)\n", expr)
+			return
+		}
+		if !nonNil {
+			g.printf("if %s == nil {\ndst = append(dst, \"null\"...)\n} else {\n", expr)
+		}
+		if t.elem.kind == kSlice || t.elem.kind == kPtr {
+			g.value("(*"+expr+")", t.elem, quoted, false)
+		} else {
+			g.value("*"+expr, t.elem, quoted, false)
+		}
+		if !nonNil {
+			g.printf("}\n")
+		}
+	case kSlice:
+		idx := "i" + strconv.Itoa(g.depth)
+		g.depth++
+		if !nonNil {
+			g.printf("if %s == nil {\ndst = append(dst, \"null\"...)\n} else {\n", expr)
+		}
+		g.printf("dst = append(dst, '[')\n")
+		g.printf("for %s := range %s {\n", idx, expr)
+		g.printf("if %s > 0 {\ndst = append(dst, ',')\n}\n", idx)
+		g.value(expr+"["+idx+"]", t.elem, false, false)
+		g.printf("}\n")
+		g.printf("dst = append(dst, ']')\n")
+		if !nonNil {
+			g.printf("}\n")
+		}
+		g.depth--
+	}
+}
+
+func hasOption(opts, name string) bool {
+	for opts != "" {
+		var opt string
+		if idx := strings.IndexByte(opts, ','); idx >= 0 {
+			opt, opts = opts[:idx], opts[idx+1:]
+		} else {
+			opt, opts = opts, ""
+		}
+		if opt == name {
+			return true
+		}
+	}
+	return false
+}
+
+// isValidTag 和 encoding/json 的规则一致, 不合法的tag名会退回到字段名
+func isValidTag(s string) bool {
+	if s == "" {
+		return false
+	}
+	for _, c := range s {
+		switch {
+		case strings.ContainsRune("!#$%&()*+-./:;<=>?@[]^_{|}~ ", c):
+		case !unicode.IsLetter(c) && !unicode.IsDigit(c):
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const src = `package lib
+
+type ID int64
+
+type MyByte byte
+
+type Blob struct {
+	B []MyByte
+}
+
+type Stamp int64
+
+func (s Stamp) MarshalText() ([]byte, error) { return nil, nil }
+
+type Event struct {
+	At Stamp
+}
+
+type Data struct {
+	ReqId   string  ` + "`json:\"req_id\"`" + `
+	ItemIds []ID    ` + "`json:\"item_ids,omitempty\"`" + `
+	Extra   *Extra
+	private int
+}
+
+type Extra struct {
+	A, B float32
+}
+
+type Bad struct {
+	M map[string]int
+}
+
+type Embed struct {
+	Extra
+}
+`
+
+func TestGenerate(t *testing.T) {
+	code, err := generate("data_test.go", []byte(src), []string{"Data", "Extra"})
+	assert.Nil(t, err)
+	out := string(code)
+	assert.True(t, strings.HasPrefix(out, "// Code generated by jsongen -type=Data,Extra; DO NOT EDIT."))
+	assert.Contains(t, out, "func (p *Data) AppendJSON(dst []byte) []byte {")
+	assert.Contains(t, out, "func (p *Extra) AppendJSON(dst []byte) []byte {")
+	assert.Contains(t, out, "jsonenc.AppendInt(dst, int64(p.ItemIds[i0]))")
+	assert.Contains(t, out, "dst = p.Extra.AppendJSON(dst)")
+	assert.Contains(t, out, "dst = jsonenc.AppendFloat(dst, float64(p.A), 32)")
+	assert.Contains(t, out, `",\"B\":"`)
+	assert.NotContains(t, out, "private")
+
+	// encoding/json 对元素是byte的命名类型也用base64
+	code, err = generate("data_test.go", []byte(src), []string{"Blob"})
+	assert.Nil(t, err)
+	assert.Contains(t, string(code), "jsonenc.AppendBytes(dst, []byte(string(p.B)))")
+}
+
+func TestGenerateError(t *testing.T) {
+	_, err := generate("data.go", []byte(src), []string{"Data"})
+	assert.NotNil(t, err, "Extra is not generated")
+
+	_, err = generate("data.go", []byte(src), []string{"Bad"})
+	assert.NotNil(t, err)
+
+	_, err = generate("data.go", []byte(src), []string{"Embed", "Extra"})
+	assert.NotNil(t, err)
+
+	_, err = generate("data.go", []byte(src), []string{"ID"})
+	assert.NotNil(t, err)
+
+	_, err = generate("data.go", []byte(src), []string{"Event"})
+	assert.NotNil(t, err, "Stamp has MarshalText")
+
+	_, err = generate("data.go", []byte(src), []string{"Stamp"})
+	assert.NotNil(t, err)
+}
+
+func TestOutputName(t *testing.T) {
+	assert.Equal(t, "json_format_json_test.go", outputName("json_format_test.go"))
+	assert.Equal(t, "model_json.go", outputName("model.go"))
+}
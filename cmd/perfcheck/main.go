@@ -0,0 +1,187 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+/*
+	用 -gcflags="-d=ssa/check_bce/debug=1 -m" 编译, 把边界检查和逃逸对应回源码里的函数
+
+	go run ./cmd/perfcheck ./lib/keybuilder ./lib/query
+	go run ./cmd/perfcheck ./ams/write_byte.go
+	go run ./cmd/perfcheck -test -json ./build_in
+
+	函数注释里加标记, 出现对应的检查时退出码为1, 可以放进CI:
+		//perf:nobce     不允许边界检查, `_ = b[7]` 这种提前检查的那一行除外
+		//perf:noescape  不允许 escapes to heap / moved to heap
+*/
+
+var (
+	testFiles = flag.Bool("test", false, "also check _test.go files, compiles the test binary")
+	jsonOut   = flag.Bool("json", false, "print the report as JSON")
+	all       = flag.Bool("all", false, "list functions without findings too")
+	gcflags   = flag.String("gcflags", "", "extra compiler flags")
+)
+
+type listPackage struct {
+	ImportPath   string
+	Dir          string
+	GoFiles      []string
+	CgoFiles     []string
+	TestGoFiles  []string
+	XTestGoFiles []string
+}
+
+type Report struct {
+	Functions  []*Func  `json:"functions"`
+	Violations []string `json:"violations"`
+}
+
+func main() {
+	flag.Parse()
+	patterns := flag.Args()
+	if len(patterns) == 0 {
+		patterns = []string{"."}
+	}
+	pkgs, err := list(patterns)
+	if err != nil {
+		fatal(err)
+	}
+	wd, _ := os.Getwd()
+	rep := &Report{Violations: []string{}}
+	for _, p := range pkgs {
+		funcs, err := check(p, *testFiles, *gcflags)
+		if err != nil {
+			fatal(err)
+		}
+		for _, f := range funcs {
+			rep.Violations = append(rep.Violations, f.Violations()...)
+			if *all || f.NoBCE || f.NoEscape || len(f.Bounds) > 0 || len(f.Escapes) > 0 {
+				rep.Functions = append(rep.Functions, f)
+			}
+		}
+	}
+	if *jsonOut {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		enc.Encode(rep)
+	} else {
+		printText(os.Stdout, rep, wd)
+	}
+	if len(rep.Violations) > 0 {
+		os.Exit(1)
+	}
+}
+
+func fatal(err error) {
+	fmt.Fprintf(os.Stderr, "perfcheck: %v\n", err)
+	os.Exit(1)
+}
+
+func list(patterns []string) ([]*listPackage, error) {
+	out, err := exec.Command("go", append([]string{"list", "-json"}, patterns...)...).Output()
+	if err != nil {
+		if ee, ok := err.(*exec.ExitError); ok {
+			return nil, fmt.Errorf("go list: %v\n%s", err, ee.Stderr)
+		}
+		return nil, err
+	}
+	var pkgs []*listPackage
+	dec := json.NewDecoder(bytes.NewReader(out))
+	for dec.More() {
+		p := new(listPackage)
+		if err := dec.Decode(p); err != nil {
+			return nil, err
+		}
+		pkgs = append(pkgs, p)
+	}
+	return pkgs, nil
+}
+
+// check compiles one package in its directory and returns its functions with their findings.
+func check(p *listPackage, test bool, extra string) ([]*Func, error) {
+	names := append(p.GoFiles, p.CgoFiles...)
+	if test {
+		names = append(append(names, p.TestGoFiles...), p.XTestGoFiles...)
+	}
+	if len(names) == 0 {
+		return nil, nil
+	}
+	var files []string
+	for _, n := range names {
+		files = append(files, filepath.Join(p.Dir, n))
+	}
+	funcs, err := parseFuncs(p.ImportPath, files)
+	if err != nil {
+		return nil, err
+	}
+
+	flags := strings.TrimSpace(extra + " -d=ssa/check_bce/debug=1 -m")
+	args := []string{"build", "-o", os.DevNull, "-gcflags=" + flags}
+	if test {
+		args = []string{"test", "-c", "-o", os.DevNull, "-gcflags=" + flags}
+	}
+	if p.ImportPath == "command-line-arguments" {
+		// 直接给的 .go 文件, 比如 ams/write_byte.go
+		args = append(args, files...)
+	} else {
+		args = append(args, p.ImportPath)
+	}
+	cmd := exec.Command("go", args...)
+	cmd.Dir = p.Dir
+	var buf bytes.Buffer
+	cmd.Stdout = &buf
+	cmd.Stderr = &buf
+	if err := cmd.Run(); err != nil {
+		return nil, fmt.Errorf("go %s: %v\n%s", strings.Join(args, " "), err, buf.Bytes())
+	}
+	if err := addFindings(&buf, p.Dir, funcs); err != nil {
+		return nil, err
+	}
+	for _, f := range funcs {
+		f.Bounds = dedup(f.Bounds)
+		f.Escapes = dedup(f.Escapes)
+	}
+	return funcs, nil
+}
+
+func printText(w io.Writer, rep *Report, wd string) {
+	for _, f := range rep.Functions {
+		file := f.File
+		if rel, err := filepath.Rel(wd, file); err == nil && !strings.HasPrefix(rel, "..") {
+			file = rel
+		}
+		var marks string
+		if f.NoBCE {
+			marks += " " + noBCE
+		}
+		if f.NoEscape {
+			marks += " " + noEscape
+		}
+		fmt.Fprintf(w, "%s:%d %s bce=%d escapes=%d%s\n", file, f.Line, f.Name, len(f.Bounds), len(f.Escapes), marks)
+		for _, b := range f.Bounds {
+			hint := ""
+			if b.Hint {
+				hint = " (hint)"
+			}
+			fmt.Fprintf(w, "\t%d:%d %s%s\n", b.Line, b.Col, b.Message, hint)
+		}
+		for _, e := range f.Escapes {
+			fmt.Fprintf(w, "\t%d:%d %s\n", e.Line, e.Col, e.Message)
+		}
+	}
+	if len(rep.Violations) > 0 {
+		fmt.Fprintf(w, "\nFAIL\n")
+		for _, v := range rep.Violations {
+			fmt.Fprintf(w, "%s\n", v)
+		}
+	}
+}
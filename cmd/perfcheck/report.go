@@ -0,0 +1,217 @@
+package main
+
+import (
+	"bufio"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"io"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/buptbill220/go_performance/lib/gcdiag"
+)
+
+const (
+	noBCE    = "//perf:nobce"
+	noEscape = "//perf:noescape"
+)
+
+type Finding struct {
+	Line    int    `json:"line"`
+	Col     int    `json:"col"`
+	Message string `json:"message"`
+	Hint    bool   `json:"hint,omitempty"` // bounds check of a `_ = b[n]` hint, allowed by //perf:nobce
+}
+
+type Func struct {
+	Package  string    `json:"package"`
+	Name     string    `json:"name"`
+	File     string    `json:"file"`
+	Line     int       `json:"line"`
+	NoBCE    bool      `json:"nobce,omitempty"`
+	NoEscape bool      `json:"noescape,omitempty"`
+	Bounds   []Finding `json:"bounds,omitempty"`
+	Escapes  []Finding `json:"escapes,omitempty"`
+
+	end   int
+	hints map[int]bool
+}
+
+// Violations lists why f breaks its annotations.
+func (f *Func) Violations() []string {
+	var out []string
+	if f.NoBCE {
+		for _, b := range f.Bounds {
+			if !b.Hint {
+				out = append(out, f.pos(b)+": bounds check in //perf:nobce function "+f.Name)
+			}
+		}
+	}
+	if f.NoEscape {
+		for _, e := range f.Escapes {
+			out = append(out, f.pos(e)+": "+e.Message+" in //perf:noescape function "+f.Name)
+		}
+	}
+	return out
+}
+
+func (f *Func) pos(x Finding) string {
+	return f.File + ":" + strconv.Itoa(x.Line) + ":" + strconv.Itoa(x.Col)
+}
+
+// funcName is the name in compiler messages: F, T.M or (*T).M.
+func funcName(fd *ast.FuncDecl) string {
+	if fd.Recv == nil || len(fd.Recv.List) == 0 {
+		return fd.Name.Name
+	}
+	t := fd.Recv.List[0].Type
+	star := false
+	if se, ok := t.(*ast.StarExpr); ok {
+		star, t = true, se.X
+	}
+	switch x := t.(type) {
+	case *ast.IndexExpr:
+		t = x.X
+	case *ast.IndexListExpr:
+		t = x.X
+	}
+	name := "?"
+	if id, ok := t.(*ast.Ident); ok {
+		name = id.Name
+	}
+	if star {
+		return "(*" + name + ")." + fd.Name.Name
+	}
+	return name + "." + fd.Name.Name
+}
+
+// parseFuncs collects the functions of a package's files, file names are absolute.
+func parseFuncs(pkg string, files []string) ([]*Func, error) {
+	fset := token.NewFileSet()
+	var funcs []*Func
+	for _, file := range files {
+		af, err := parser.ParseFile(fset, file, nil, parser.ParseComments)
+		if err != nil {
+			return nil, err
+		}
+		for _, d := range af.Decls {
+			fd, ok := d.(*ast.FuncDecl)
+			if !ok || fd.Body == nil {
+				continue
+			}
+			f := &Func{
+				Package: pkg,
+				Name:    funcName(fd),
+				File:    file,
+				Line:    fset.Position(fd.Pos()).Line,
+				end:     fset.Position(fd.End()).Line,
+				hints:   make(map[int]bool),
+			}
+			if fd.Doc != nil {
+				for _, c := range fd.Doc.List {
+					switch strings.TrimSpace(c.Text) {
+					case noBCE:
+						f.NoBCE = true
+					case noEscape:
+						f.NoEscape = true
+					}
+				}
+			}
+			ast.Inspect(fd.Body, func(n ast.Node) bool {
+				if isHint(n) {
+					f.hints[fset.Position(n.Pos()).Line] = true
+				}
+				return true
+			})
+			funcs = append(funcs, f)
+		}
+	}
+	return funcs, nil
+}
+
+// isHint matches `_ = b[n]` and `_ = b[i:j]`, written only to hoist the bounds check.
+func isHint(n ast.Node) bool {
+	as, ok := n.(*ast.AssignStmt)
+	if !ok || len(as.Lhs) != 1 || len(as.Rhs) != 1 {
+		return false
+	}
+	if id, ok := as.Lhs[0].(*ast.Ident); !ok || id.Name != "_" {
+		return false
+	}
+	switch as.Rhs[0].(type) {
+	case *ast.IndexExpr, *ast.SliceExpr:
+		return true
+	}
+	return false
+}
+
+var diagRe = regexp.MustCompile(`^(.+\.go):(\d+):(\d+): (.*)$`)
+
+// addFindings attributes the compiler messages to the functions containing them, closures count
+// for their enclosing function. Relative paths are resolved against dir, see gcdiag.Resolve.
+func addFindings(r io.Reader, dir string, funcs []*Func) error {
+	byFile := make(map[string][]*Func)
+	var files []string
+	for _, f := range funcs {
+		if _, ok := byFile[f.File]; !ok {
+			files = append(files, f.File)
+		}
+		byFile[f.File] = append(byFile[f.File], f)
+	}
+	resolved := make(map[string]string)
+	sc := bufio.NewScanner(r)
+	sc.Buffer(make([]byte, 64<<10), 1<<20)
+	for sc.Scan() {
+		m := diagRe.FindStringSubmatch(sc.Text())
+		if m == nil {
+			continue
+		}
+		msg := m[4]
+		bce := strings.HasPrefix(msg, "Found Is") && strings.HasSuffix(msg, "InBounds")
+		esc := strings.HasSuffix(msg, "escapes to heap") || strings.HasPrefix(msg, "moved to heap: ")
+		if !bce && !esc {
+			continue
+		}
+		file, ok := resolved[m[1]]
+		if !ok {
+			file = gcdiag.Resolve(files, dir, m[1])
+			resolved[m[1]] = file
+		}
+		line, _ := strconv.Atoi(m[2])
+		col, _ := strconv.Atoi(m[3])
+		for _, f := range byFile[file] {
+			if line < f.Line || line > f.end {
+				continue
+			}
+			x := Finding{Line: line, Col: col, Message: strings.TrimPrefix(msg, "Found ")}
+			if bce {
+				x.Hint = f.hints[line]
+				f.Bounds = append(f.Bounds, x)
+			} else {
+				f.Escapes = append(f.Escapes, x)
+			}
+			break
+		}
+	}
+	return sc.Err()
+}
+
+// dedup drops repeated findings, -m prints some messages once per inlined copy.
+func dedup(xs []Finding) []Finding {
+	sort.Slice(xs, func(i, j int) bool {
+		if xs[i].Line != xs[j].Line {
+			return xs[i].Line < xs[j].Line
+		}
+		return xs[i].Col < xs[j].Col
+	})
+	var out []Finding
+	for _, x := range xs {
+		if len(out) == 0 || x != out[len(out)-1] {
+			out = append(out, x)
+		}
+	}
+	return out
+}
@@ -0,0 +1,130 @@
+package main
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const source = `package p
+
+//perf:nobce
+func put(b []byte, v uint16) {
+	_ = b[1]
+	b[0] = byte(v >> 8)
+	b[1] = byte(v)
+}
+
+// get has no annotation.
+func get(b []byte, i int) byte {
+	return b[i]
+}
+
+type T struct{ buf []byte }
+
+// Key returns the key.
+//
+//perf:noescape
+//perf:nobce
+func (t *T) Key() string {
+	f := func() string { return string(t.buf) }
+	return f()
+}
+`
+
+// -d=ssa/check_bce/debug=1 -m 的输出格式
+const output = `# p
+p.go:5:7: Found IsInBounds
+p.go:12:10: Found IsInBounds
+p.go:12:10: Found IsInBounds
+p.go:11:6: can inline get
+p.go:11:10: b does not escape
+p.go:22:38: string(t.buf) escapes to heap
+p.go:25:1: Found IsSliceInBounds
+other.go:5:7: Found IsInBounds
+`
+
+func TestReport(t *testing.T) {
+	dir := t.TempDir()
+	file := filepath.Join(dir, "p.go")
+	assert.Nil(t, os.WriteFile(file, []byte(source), 0644))
+
+	funcs, err := parseFuncs("p", []string{file})
+	assert.Nil(t, err)
+	assert.Equal(t, 3, len(funcs))
+	assert.Nil(t, addFindings(strings.NewReader(output), dir, funcs))
+	for _, f := range funcs {
+		f.Bounds = dedup(f.Bounds)
+		f.Escapes = dedup(f.Escapes)
+	}
+
+	put, get, key := funcs[0], funcs[1], funcs[2]
+	assert.Equal(t, "put", put.Name)
+	assert.True(t, put.NoBCE)
+	assert.Equal(t, []Finding{{Line: 5, Col: 7, Message: "IsInBounds", Hint: true}}, put.Bounds)
+	assert.Equal(t, 0, len(put.Violations()))
+
+	assert.False(t, get.NoBCE)
+	assert.Equal(t, 1, len(get.Bounds))
+	assert.Equal(t, 0, len(get.Violations()))
+
+	assert.Equal(t, "(*T).Key", key.Name)
+	assert.True(t, key.NoBCE)
+	assert.True(t, key.NoEscape)
+	assert.Equal(t, 1, len(key.Escapes))
+	assert.Equal(t, 0, len(key.Bounds), "line 25 is past the end of Key")
+	v := key.Violations()
+	assert.Equal(t, 1, len(v))
+	assert.True(t, strings.HasSuffix(v[0], "p.go:22:38: string(t.buf) escapes to heap in //perf:noescape function (*T).Key"))
+}
+
+func TestReportRelative(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "p")
+	assert.Nil(t, os.Mkdir(dir, 0755))
+	file := filepath.Join(dir, "p.go")
+	assert.Nil(t, os.WriteFile(file, []byte(source), 0644))
+	funcs, err := parseFuncs("p", []string{file})
+	assert.Nil(t, err)
+
+	// 缓存的编译结果回放时, 路径相对的是第一次编译时的目录
+	out := strings.Replace(output, "p.go:", "../p/p.go:", -1)
+	assert.Nil(t, addFindings(strings.NewReader(out), filepath.Join(dir, "sub"), funcs))
+	assert.Equal(t, 1, len(funcs[0].Bounds))
+	assert.Equal(t, 1, len(funcs[2].Escapes))
+}
+
+func TestCheckCachedBuild(t *testing.T) {
+	if testing.Short() {
+		t.Skip("runs go build")
+	}
+	root := t.TempDir()
+	dir := filepath.Join(root, "p")
+	assert.Nil(t, os.Mkdir(dir, 0755))
+	assert.Nil(t, os.WriteFile(filepath.Join(root, "go.mod"), []byte("module m\n"), 0644))
+	// 内容每次不同, 保证下面第一次编译不会命中之前的缓存
+	src := source + "\n// " + time.Now().Format(time.RFC3339Nano) + "\n"
+	assert.Nil(t, os.WriteFile(filepath.Join(dir, "p.go"), []byte(src), 0644))
+
+	// 先在上一级目录编译一次, 之后 check 拿到的是缓存回放的 p/p.go
+	warm := exec.Command("go", "build", "-o", os.DevNull, "-gcflags=-d=ssa/check_bce/debug=1 -m", "./p")
+	warm.Dir = root
+	out, err := warm.CombinedOutput()
+	if !assert.Nil(t, err, "%s", out) {
+		return
+	}
+	assert.Contains(t, string(out), "p/p.go:")
+
+	funcs, err := check(&listPackage{ImportPath: "m/p", Dir: dir, GoFiles: []string{"p.go"}}, false, "")
+	if !assert.Nil(t, err) {
+		return
+	}
+	assert.Equal(t, 3, len(funcs))
+	assert.Equal(t, 1, len(funcs[0].Bounds))
+	assert.Equal(t, 1, len(funcs[1].Bounds))
+	assert.Equal(t, 1, len(funcs[2].Escapes))
+}
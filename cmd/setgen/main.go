@@ -0,0 +1,555 @@
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"go/format"
+	"io/ioutil"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+/*
+	为一组常量生成集合判断函数, 替代 build_in/switch_vs_map_test.go 里的 switch/map/slice 写法
+
+	//go:generate go run ../cmd/setgen -func=isExceptedValueSet -type=int 1 2 3 4 5 6 7 8 9 10 11 12 13 14 15 16
+	//go:generate go run ../cmd/setgen -func=isExceptedValueSet2 -type=string abc efg hij klm
+
+	-method=auto 时:
+		整数且稠密(每64个值的跨度里至少有一个成员, 跨度<4096): bitmap, 一次减法一次移位
+		整数且稀疏: 有序数组二分查找
+		字符串: 最小完美哈希(hash and displace), 一次或两次hash加一次字符串比较
+	同时生成和map对比的测试和benchmark; 输出是 xxx.go 时写到 xxx_test.go, 本身是 _test.go 时写在同一个文件里
+	go generate 时测试文件带上 //bench:group <$GOFILE去掉_test.go>, cmd/benchreport 会把它和源文件放在同一个实验里
+*/
+
+var (
+	funcName = flag.String("func", "", "name of the generated function")
+	typeName = flag.String("type", "int", "element type: string or an integer type")
+	method   = flag.String("method", "auto", "auto, bitmap, search or mph")
+	pkgName  = flag.String("pkg", "", "package name, default $GOPACKAGE")
+	output   = flag.String("output", "", "output file name, default <func>_set.go, <func>_set_test.go when run from a test file")
+)
+
+const maxBitmapSpan = 4096
+
+func main() {
+	flag.Parse()
+	if *funcName == "" || flag.NArg() == 0 {
+		fmt.Fprintf(os.Stderr, "usage: setgen -func=name [-type=int] [-method=auto] [-output=file] values...\n")
+		os.Exit(2)
+	}
+	pkg := *pkgName
+	if pkg == "" {
+		pkg = os.Getenv("GOPACKAGE")
+	}
+	if pkg == "" {
+		fatal(fmt.Errorf("-pkg is required outside go generate"))
+	}
+	out := *output
+	if out == "" {
+		out = outputName(*funcName, os.Getenv("GOFILE"))
+	}
+	s, err := newSet(*typeName, flag.Args())
+	if err != nil {
+		fatal(err)
+	}
+	group := strings.TrimSuffix(strings.TrimSuffix(os.Getenv("GOFILE"), ".go"), "_test")
+	code, test, err := generate(pkg, *funcName, *method, group, s, strings.HasSuffix(out, "_test.go"))
+	if err != nil {
+		fatal(err)
+	}
+	if err := ioutil.WriteFile(out, code, 0644); err != nil {
+		fatal(err)
+	}
+	if test != nil {
+		if err := ioutil.WriteFile(strings.TrimSuffix(out, ".go")+"_test.go", test, 0644); err != nil {
+			fatal(err)
+		}
+	}
+}
+
+func fatal(err error) {
+	fmt.Fprintf(os.Stderr, "setgen: %v\n", err)
+	os.Exit(1)
+}
+
+func outputName(fn, gofile string) string {
+	name := strings.ToLower(fn) + "_set"
+	if strings.HasSuffix(gofile, "_test.go") {
+		return name + "_test.go"
+	}
+	return name + ".go"
+}
+
+var intBits = map[string]int{
+	"int": 64, "int8": 8, "int16": 16, "int32": 32, "int64": 64,
+	"uint": 64, "uint8": 8, "uint16": 16, "uint32": 32, "uint64": 64, "uintptr": 64, "byte": 8, "rune": 32,
+}
+
+// set is the deduplicated, sorted constants. Integers are kept as uint64 bit patterns,
+// signed ones sign extended so uint64(v) in the generated code gives the same value.
+type set struct {
+	typ    string
+	str    bool
+	signed bool
+	ints   []uint64
+	strs   []string
+}
+
+func newSet(typ string, args []string) (*set, error) {
+	s := &set{typ: typ}
+	if typ == "string" {
+		s.str = true
+		seen := make(map[string]bool)
+		for _, a := range args {
+			if !seen[a] {
+				seen[a] = true
+				s.strs = append(s.strs, a)
+			}
+		}
+		sort.Strings(s.strs)
+		return s, nil
+	}
+	bits, ok := intBits[typ]
+	if !ok {
+		return nil, fmt.Errorf("unsupported type %s", typ)
+	}
+	s.signed = !strings.HasPrefix(typ, "u") && typ != "byte"
+	seen := make(map[uint64]bool)
+	for _, a := range args {
+		var v uint64
+		if s.signed {
+			i, err := strconv.ParseInt(a, 0, bits)
+			if err != nil {
+				return nil, err
+			}
+			v = uint64(i)
+		} else {
+			u, err := strconv.ParseUint(a, 0, bits)
+			if err != nil {
+				return nil, err
+			}
+			v = u
+		}
+		if !seen[v] {
+			seen[v] = true
+			s.ints = append(s.ints, v)
+		}
+	}
+	sort.Slice(s.ints, func(i, j int) bool { return s.less(s.ints[i], s.ints[j]) })
+	return s, nil
+}
+
+func (s *set) less(a, b uint64) bool {
+	if s.signed {
+		return int64(a) < int64(b)
+	}
+	return a < b
+}
+
+func (s *set) len() int {
+	if s.str {
+		return len(s.strs)
+	}
+	return len(s.ints)
+}
+
+func (s *set) lit(v uint64) string {
+	if s.signed {
+		return strconv.FormatInt(int64(v), 10)
+	}
+	return strconv.FormatUint(v, 10)
+}
+
+// span is max-min, it does not overflow as uint64 for either signedness.
+func (s *set) span() uint64 {
+	return s.ints[len(s.ints)-1] - s.ints[0]
+}
+
+func (s *set) choose(m string) (string, error) {
+	switch m {
+	case "auto":
+		if s.str {
+			return "mph", nil
+		}
+		if span := s.span(); span < maxBitmapSpan && span/64 < uint64(len(s.ints)) {
+			return "bitmap", nil
+		}
+		return "search", nil
+	case "bitmap":
+		if s.str {
+			return "", fmt.Errorf("bitmap needs an integer type")
+		}
+		if s.span() >= maxBitmapSpan {
+			return "", fmt.Errorf("bitmap span %d exceeds %d", s.span(), maxBitmapSpan)
+		}
+		return m, nil
+	case "search":
+		return m, nil
+	case "mph":
+		if !s.str {
+			return "", fmt.Errorf("mph needs type string")
+		}
+		return m, nil
+	}
+	return "", fmt.Errorf("unknown method %s", m)
+}
+
+// generate returns the membership function and, unless inTest, the test file for it.
+// A non-empty group is written into the test file as a //bench:group directive.
+func generate(pkg, fn, m, group string, s *set, inTest bool) (code, test []byte, err error) {
+	if s.len() == 0 {
+		return nil, nil, fmt.Errorf("empty set")
+	}
+	if m, err = s.choose(m); err != nil {
+		return nil, nil, err
+	}
+
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "// Code generated by setgen -func=%s -type=%s; DO NOT EDIT.\n\n", fn, s.typ)
+	fmt.Fprintf(&buf, "package %s\n\n", pkg)
+	if inTest {
+		writeGroup(&buf, group)
+		buf.WriteString("import \"testing\"\n\n")
+	}
+	switch m {
+	case "bitmap":
+		genBitmap(&buf, fn, s)
+	case "search":
+		genSearch(&buf, fn, s)
+	case "mph":
+		if err := genMPH(&buf, fn, s); err != nil {
+			return nil, nil, err
+		}
+	}
+	if !inTest {
+		if code, err = format.Source(buf.Bytes()); err != nil {
+			return nil, nil, err
+		}
+		buf.Reset()
+		fmt.Fprintf(&buf, "// Code generated by setgen -func=%s -type=%s; DO NOT EDIT.\n\n", fn, s.typ)
+		fmt.Fprintf(&buf, "package %s\n\n", pkg)
+		writeGroup(&buf, group)
+		buf.WriteString("import \"testing\"\n\n")
+		genTest(&buf, fn, s)
+		test, err = format.Source(buf.Bytes())
+		return code, test, err
+	}
+	genTest(&buf, fn, s)
+	code, err = format.Source(buf.Bytes())
+	return code, nil, err
+}
+
+func writeGroup(buf *bytes.Buffer, group string) {
+	if group != "" {
+		fmt.Fprintf(buf, "//bench:group %s\n\n", group)
+	}
+}
+
+func genBitmap(buf *bytes.Buffer, fn string, s *set) {
+	min := s.ints[0]
+	sub := ""
+	if min != 0 {
+		sub = fmt.Sprintf(" - %#x", min)
+	}
+	doc := fmt.Sprintf("// %s reports whether v is one of the %d constants, bitmap over [%s, %s].\n",
+		fn, len(s.ints), s.lit(min), s.lit(s.ints[len(s.ints)-1]))
+	words := make([]uint64, s.span()/64+1)
+	for _, v := range s.ints {
+		u := v - min
+		words[u/64] |= 1 << (u % 64)
+	}
+	if len(words) == 1 {
+		buf.WriteString(doc)
+		fmt.Fprintf(buf, "func %s(v %s) bool {\n", fn, s.typ)
+		fmt.Fprintf(buf, "u := uint64(v)%s\n", sub)
+		fmt.Fprintf(buf, "return u < 64 && %#x>>u&1 != 0\n}\n\n", words[0])
+		return
+	}
+	fmt.Fprintf(buf, "var %sBits = [%d]uint64{", fn, len(words))
+	for i, w := range words {
+		if i%4 == 0 {
+			buf.WriteString("\n")
+		}
+		fmt.Fprintf(buf, "%#x, ", w)
+	}
+	buf.WriteString("\n}\n\n")
+	buf.WriteString(doc)
+	fmt.Fprintf(buf, "func %s(v %s) bool {\n", fn, s.typ)
+	fmt.Fprintf(buf, "u := uint64(v)%s\n", sub)
+	fmt.Fprintf(buf, "return u < %d && %sBits[u>>6]&(1<<(u&63)) != 0\n}\n\n", len(words)*64, fn)
+}
+
+func genSearch(buf *bytes.Buffer, fn string, s *set) {
+	fmt.Fprintf(buf, "var %sTable = [%d]%s{", fn, len(s.ints), s.typ)
+	for i, v := range s.ints {
+		if i%8 == 0 {
+			buf.WriteString("\n")
+		}
+		fmt.Fprintf(buf, "%s, ", s.lit(v))
+	}
+	buf.WriteString("\n}\n\n")
+	fmt.Fprintf(buf, "// %s reports whether v is one of the %d constants, binary search over a sorted table.\n", fn, len(s.ints))
+	fmt.Fprintf(buf, "func %s(v %s) bool {\n", fn, s.typ)
+	fmt.Fprintf(buf, "if v < %s || v > %s {\nreturn false\n}\n", s.lit(s.ints[0]), s.lit(s.ints[len(s.ints)-1]))
+	fmt.Fprintf(buf, `lo, hi := 0, len(%[1]sTable)
+	for lo < hi {
+		m := int(uint(lo+hi) >> 1)
+		if %[1]sTable[m] < v {
+			lo = m + 1
+		} else {
+			hi = m
+		}
+	}
+	return lo < len(%[1]sTable) && %[1]sTable[lo] == v
+}
+
+`, fn)
+}
+
+const (
+	fnvOffset = 2166136261
+	fnvPrime  = 16777619
+	maxSeed   = 1 << 24
+)
+
+// mphHash is seeded FNV-1a, it must match the function emitted by genMPH.
+func mphHash(s string, seed uint32) uint32 {
+	h := fnvOffset ^ seed
+	for i := 0; i < len(s); i++ {
+		h ^= uint32(s[i])
+		h *= fnvPrime
+	}
+	return h
+}
+
+// reduce maps h to [0, n) by its high bits, the low bits of FNV barely depend on the seed.
+func reduce(h, n uint32) uint32 {
+	return uint32(uint64(h) * uint64(n) >> 32)
+}
+
+// buildMPH finds a minimal perfect hash by hash and displace: keys go to len(keys) buckets by
+// mphHash(s, 0), then, biggest bucket first, each bucket gets a seed that sends all of its keys
+// to free slots. Hashes map to [0, n) by reduce. A bucket with a single key stores -slot-1 instead of a seed.
+func buildMPH(keys []string) (seeds []int32, slots []string, err error) {
+	n := uint32(len(keys))
+	buckets := make([][]string, n)
+	for _, k := range keys {
+		b := reduce(mphHash(k, 0), n)
+		buckets[b] = append(buckets[b], k)
+	}
+	order := make([]int, n)
+	for i := range order {
+		order[i] = i
+	}
+	sort.SliceStable(order, func(i, j int) bool { return len(buckets[order[i]]) > len(buckets[order[j]]) })
+
+	seeds = make([]int32, n)
+	slots = make([]string, n)
+	used := make([]bool, n)
+	pos := make([]uint32, 0, n)
+	oi := 0
+	for ; oi < len(order) && len(buckets[order[oi]]) > 1; oi++ {
+		b := order[oi]
+		seed := uint32(1)
+	next:
+		for ; seed < maxSeed; seed++ {
+			pos = pos[:0]
+			for _, k := range buckets[b] {
+				p := reduce(mphHash(k, seed), n)
+				if used[p] {
+					continue next
+				}
+				for _, q := range pos {
+					if q == p {
+						continue next
+					}
+				}
+				pos = append(pos, p)
+			}
+			break
+		}
+		if seed == maxSeed {
+			return nil, nil, fmt.Errorf("no perfect hash seed found for bucket %d", b)
+		}
+		seeds[b] = int32(seed)
+		for i, k := range buckets[b] {
+			used[pos[i]] = true
+			slots[pos[i]] = k
+		}
+	}
+	free := uint32(0)
+	for ; oi < len(order) && len(buckets[order[oi]]) == 1; oi++ {
+		for used[free] {
+			free++
+		}
+		used[free] = true
+		slots[free] = buckets[order[oi]][0]
+		seeds[order[oi]] = -int32(free) - 1
+	}
+	return seeds, slots, nil
+}
+
+func genMPH(buf *bytes.Buffer, fn string, s *set) error {
+	seeds, slots, err := buildMPH(s.strs)
+	if err != nil {
+		return err
+	}
+	n := len(slots)
+	fmt.Fprintf(buf, "var %sSeeds = [%d]int32{", fn, n)
+	for i, v := range seeds {
+		if i%8 == 0 {
+			buf.WriteString("\n")
+		}
+		fmt.Fprintf(buf, "%d, ", v)
+	}
+	buf.WriteString("\n}\n\n")
+	fmt.Fprintf(buf, "var %sKeys = [%d]string{\n", fn, n)
+	for _, k := range slots {
+		fmt.Fprintf(buf, "%q,\n", k)
+	}
+	buf.WriteString("}\n\n")
+	fmt.Fprintf(buf, `func %[1]sHash(s string, seed uint32) uint32 {
+	h := uint32(%[2]d) ^ seed
+	for i := 0; i < len(s); i++ {
+		h ^= uint32(s[i])
+		h *= %[3]d
+	}
+	return h
+}
+
+// %[1]s reports whether s is one of the %[4]d constants, minimal perfect hash and one string compare.
+func %[1]s(s string) bool {
+	d := %[1]sSeeds[uint64(%[1]sHash(s, 0))*%[4]d>>32]
+	var i uint64
+	if d < 0 {
+		i = uint64(-d-1)
+	} else {
+		i = uint64(%[1]sHash(s, uint32(d)))*%[4]d>>32
+	}
+	return %[1]sKeys[i] == s
+}
+
+`, fn, fnvOffset, fnvPrime, n)
+	return nil
+}
+
+// misses returns values outside the set, as many as there are members when possible.
+func (s *set) misses() []string {
+	var out []string
+	if s.str {
+		seen := make(map[string]bool)
+		for _, k := range s.strs {
+			seen[k] = true
+		}
+		add := func(k string) {
+			if !seen[k] && len(out) < len(s.strs) {
+				seen[k] = true
+				out = append(out, strconv.Quote(k))
+			}
+		}
+		add("")
+		for _, k := range s.strs {
+			add(k + "_")
+			if len(k) > 0 {
+				add(k[:len(k)-1] + string(k[len(k)-1]^1))
+			}
+		}
+		return out
+	}
+	min, max := s.ints[0], s.ints[len(s.ints)-1]
+	// 边界外的值, 注意不要越过类型的范围
+	if s.less(min-1, min) && fitsType(s, min-1) {
+		out = append(out, s.lit(min-1))
+	}
+	if s.less(max, max+1) && fitsType(s, max+1) {
+		out = append(out, s.lit(max+1))
+	}
+	for i := 1; i < len(s.ints) && len(out) < len(s.ints); i++ {
+		if v := s.ints[i-1] + 1; v != s.ints[i] {
+			out = append(out, s.lit(v))
+		}
+	}
+	return out
+}
+
+func fitsType(s *set, v uint64) bool {
+	bits := intBits[s.typ]
+	if bits == 64 {
+		return true
+	}
+	if s.signed {
+		i := int64(v)
+		return i >= -1<<(bits-1) && i < 1<<(bits-1)
+	}
+	return v < 1<<bits
+}
+
+func genTest(buf *bytes.Buffer, fn string, s *set) {
+	title := exportName(fn)
+	fmt.Fprintf(buf, "var %sMap = map[%s]bool{\n", fn, s.typ)
+	var members []string
+	if s.str {
+		for _, k := range s.strs {
+			members = append(members, strconv.Quote(k))
+		}
+	} else {
+		for _, v := range s.ints {
+			members = append(members, s.lit(v))
+		}
+	}
+	for _, k := range members {
+		fmt.Fprintf(buf, "%s: true,\n", k)
+	}
+	buf.WriteString("}\n\n")
+
+	// 一半命中一半不命中
+	fmt.Fprintf(buf, "var %sInput = []%s{\n", fn, s.typ)
+	for _, k := range append(members, s.misses()...) {
+		fmt.Fprintf(buf, "%s,\n", k)
+	}
+	buf.WriteString("}\n\n")
+	fmt.Fprintf(buf, "var %sHits int\n\n", fn)
+
+	fmt.Fprintf(buf, `func Test%[1]s(t *testing.T) {
+	for _, v := range %[2]sInput {
+		if %[2]s(v) != %[2]sMap[v] {
+			t.Fatalf("%[2]s(%%v) = %%v", v, %[2]s(v))
+		}
+	}
+}
+
+func Benchmark%[1]s(b *testing.B) {
+	in := %[2]sInput
+	n := 0
+	for i := 0; i < b.N; i++ {
+		if %[2]s(in[i%%len(in)]) {
+			n++
+		}
+	}
+	%[2]sHits = n
+}
+
+func Benchmark%[1]sMap(b *testing.B) {
+	in := %[2]sInput
+	n := 0
+	for i := 0; i < b.N; i++ {
+		if %[2]sMap[in[i%%len(in)]] {
+			n++
+		}
+	}
+	%[2]sHits = n
+}
+`, title, fn)
+}
+
+func exportName(s string) string {
+	r := []rune(s)
+	r[0] = unicode.ToUpper(r[0])
+	return string(r)
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"fmt"
+	"math/rand"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestChoose(t *testing.T) {
+	s, err := newSet("int", []string{"16", "1", "2", "3", "3"})
+	assert.Nil(t, err)
+	assert.Equal(t, []uint64{1, 2, 3, 16}, s.ints)
+	m, _ := s.choose("auto")
+	assert.Equal(t, "bitmap", m)
+
+	s, _ = newSet("int", []string{"1", "10", "100", "1000", "10000", "100000"})
+	m, _ = s.choose("auto")
+	assert.Equal(t, "search", m)
+	_, err = s.choose("bitmap")
+	assert.NotNil(t, err)
+
+	s, _ = newSet("string", []string{"abc", "efg"})
+	m, _ = s.choose("auto")
+	assert.Equal(t, "mph", m)
+	_, err = s.choose("bitmap")
+	assert.NotNil(t, err)
+
+	_, err = newSet("int8", []string{"128"})
+	assert.NotNil(t, err)
+	_, err = newSet("float64", []string{"1"})
+	assert.NotNil(t, err)
+}
+
+func TestGenerate(t *testing.T) {
+	s, _ := newSet("int", []string{"-3", "1", "5", "7", "9"})
+	code, test, err := generate("build_in", "isOdd", "auto", "", s, false)
+	assert.Nil(t, err)
+	out := string(code)
+	assert.True(t, strings.HasPrefix(out, "// Code generated by setgen -func=isOdd -type=int; DO NOT EDIT."))
+	assert.Contains(t, out, "u := uint64(v) - 0xfffffffffffffffd")
+	assert.Contains(t, out, "return u < 64 &&")
+	assert.Contains(t, string(test), "func BenchmarkIsOddMap(b *testing.B) {")
+	assert.Contains(t, string(test), "-4,\n")
+
+	s, _ = newSet("uint16", []string{"1", "10", "100", "1000", "10000"})
+	code, test, err = generate("build_in", "isPow10", "auto", "switch_vs_map", s, true)
+	assert.Nil(t, err)
+	assert.Nil(t, test)
+	assert.Contains(t, string(code), "package build_in\n\n//bench:group switch_vs_map\n\nimport \"testing\"")
+	assert.Contains(t, string(code), "var isPow10Table = [5]uint16{")
+	assert.Contains(t, string(code), "func TestIsPow10(t *testing.T) {")
+
+	s, _ = newSet("string", []string{"abc", "efg", "hij", "klm"})
+	code, _, err = generate("build_in", "isTag", "auto", "", s, true)
+	assert.Nil(t, err)
+	assert.Contains(t, string(code), "var isTagKeys = [4]string{")
+
+	s, _ = newSet("string", nil)
+	_, _, err = generate("build_in", "isNone", "auto", "", s, true)
+	assert.NotNil(t, err)
+}
+
+func TestBuildMPH(t *testing.T) {
+	r := rand.New(rand.NewSource(1))
+	for _, n := range []int{1, 2, 7, 100, 5000} {
+		seen := make(map[string]bool)
+		var keys []string
+		for len(keys) < n {
+			k := fmt.Sprintf("key_%x", r.Int63n(1<<40))
+			if !seen[k] {
+				seen[k] = true
+				keys = append(keys, k)
+			}
+		}
+		seeds, slots, err := buildMPH(keys)
+		assert.Nil(t, err)
+		for _, k := range keys {
+			d := seeds[reduce(mphHash(k, 0), uint32(n))]
+			var i uint32
+			if d < 0 {
+				i = uint32(-d - 1)
+			} else {
+				i = reduce(mphHash(k, uint32(d)), uint32(n))
+			}
+			assert.Equal(t, k, slots[i])
+		}
+	}
+}
+
+func TestMisses(t *testing.T) {
+	s, _ := newSet("uint8", []string{"0", "1", "255"})
+	assert.Equal(t, []string{"2"}, s.misses())
+	s, _ = newSet("int8", []string{"-128", "127"})
+	assert.Equal(t, []string{"-127"}, s.misses())
+}
+
+func TestOutputName(t *testing.T) {
+	assert.Equal(t, "isexceptedvalueset_set_test.go", outputName("isExceptedValueSet", "switch_vs_map_test.go"))
+	assert.Equal(t, "isword_set.go", outputName("isWord", "words.go"))
+}
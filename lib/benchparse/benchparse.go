@@ -0,0 +1,94 @@
+package benchparse
+
+import (
+	"bufio"
+	"io"
+	"strconv"
+	"strings"
+)
+
+/*
+	解析 go test -bench 的输出, cmd/benchstore 和 cmd/benchreport 共用
+
+	results, header, err := benchparse.Parse(out)
+*/
+
+// Result is one line of benchmark output, -count=N gives N Results with the same key.
+type Result struct {
+	Pkg        string             `json:"pkg"`
+	Name       string             `json:"name"` // without the -GOMAXPROCS suffix
+	Procs      int                `json:"procs"`
+	Iterations int64              `json:"iterations"`
+	Metrics    map[string]float64 `json:"metrics"` // unit -> value, e.g. ns/op, B/op, allocs/op, p99-us
+}
+
+// Key names a benchmark with its -GOMAXPROCS suffix like go test prints it, so runs with -cpu=1,4
+// give separate keys and are never compared against each other.
+func (r *Result) Key() string {
+	if r.Procs > 1 {
+		return r.Pkg + "." + r.Name + "-" + strconv.Itoa(r.Procs)
+	}
+	return r.Pkg + "." + r.Name
+}
+
+// Header is the configuration go test prints before the benchmarks.
+type Header struct {
+	GOOS, GOARCH, CPU string
+}
+
+// Parse reads go test -bench output, other lines (PASS, ok, test logs) are ignored.
+func Parse(r io.Reader) ([]*Result, Header, error) {
+	var (
+		results []*Result
+		h       Header
+		pkg     string
+	)
+	sc := bufio.NewScanner(r)
+	sc.Buffer(make([]byte, 64<<10), 1<<20)
+	for sc.Scan() {
+		line := sc.Text()
+		switch {
+		case strings.HasPrefix(line, "goos: "):
+			h.GOOS = strings.TrimPrefix(line, "goos: ")
+		case strings.HasPrefix(line, "goarch: "):
+			h.GOARCH = strings.TrimPrefix(line, "goarch: ")
+		case strings.HasPrefix(line, "cpu: "):
+			h.CPU = strings.TrimPrefix(line, "cpu: ")
+		case strings.HasPrefix(line, "pkg: "):
+			pkg = strings.TrimPrefix(line, "pkg: ")
+		case strings.HasPrefix(line, "Benchmark"):
+			if res := ParseLine(line); res != nil {
+				res.Pkg = pkg
+				results = append(results, res)
+			}
+		}
+	}
+	return results, h, sc.Err()
+}
+
+// ParseLine parses "BenchmarkName-8  1000000  1384 ns/op  32 B/op  1 allocs/op", nil if it is not a result.
+func ParseLine(line string) *Result {
+	f := strings.Fields(line)
+	// 名字, 次数, 然后是成对的 值 单位
+	if len(f) < 4 || len(f)%2 != 0 {
+		return nil
+	}
+	n, err := strconv.ParseInt(f[1], 10, 64)
+	if err != nil {
+		return nil
+	}
+	res := &Result{Name: f[0], Procs: 1, Iterations: n, Metrics: make(map[string]float64)}
+	if i := strings.LastIndexByte(f[0], '-'); i > 0 {
+		if p, err := strconv.Atoi(f[0][i+1:]); err == nil {
+			res.Name, res.Procs = f[0][:i], p
+		}
+	}
+	for i := 2; i < len(f); i += 2 {
+		v, err := strconv.ParseFloat(f[i], 64)
+		if err != nil {
+			return nil
+		}
+		res.Metrics[f[i+1]] = v
+	}
+	return res
+}
@@ -0,0 +1,42 @@
+package benchparse
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const benchOutput = `goos: linux
+goarch: amd64
+pkg: github.com/buptbill220/go_performance/lib/keybuilder
+cpu: Intel(R) Xeon(R) CPU @ 2.20GHz
+BenchmarkFmtSprintf-4         	 5000000	       300 ns/op	      64 B/op	       4 allocs/op
+BenchmarkKeyBuilder-4         	30000000	        40.5 ns/op	       0 B/op	       0 allocs/op
+BenchmarkProtoH2C/c=8-4       	  100000	     12000 ns/op	        55.0 p99-us	 1.000 conns
+--- BENCH: BenchmarkLog-4
+    log_test.go:10: hello
+PASS
+ok  	github.com/buptbill220/go_performance/lib/keybuilder	3.2s
+`
+
+func TestParse(t *testing.T) {
+	results, h, err := Parse(strings.NewReader(benchOutput))
+	assert.Nil(t, err)
+	assert.Equal(t, "amd64", h.GOARCH)
+	assert.Equal(t, "Intel(R) Xeon(R) CPU @ 2.20GHz", h.CPU)
+	assert.Equal(t, 3, len(results))
+
+	r := results[1]
+	assert.Equal(t, "github.com/buptbill220/go_performance/lib/keybuilder.BenchmarkKeyBuilder-4", r.Key())
+	assert.Equal(t, 4, r.Procs)
+	assert.Equal(t, int64(30000000), r.Iterations)
+	assert.Equal(t, map[string]float64{"ns/op": 40.5, "B/op": 0, "allocs/op": 0}, r.Metrics)
+
+	r = results[2]
+	assert.Equal(t, "BenchmarkProtoH2C/c=8", r.Name)
+	assert.Equal(t, 55.0, r.Metrics["p99-us"])
+
+	assert.Nil(t, ParseLine("BenchmarkBad-4 notanumber 1 ns/op"))
+	assert.Nil(t, ParseLine("Benchmark results:"))
+}
@@ -0,0 +1,43 @@
+package gcdiag
+
+import (
+	"path/filepath"
+	"strings"
+)
+
+/*
+	读编译器 -m / -S / check_bce 输出时的公共处理, cmd/asmdiff 和 cmd/perfcheck 共用
+
+	file := gcdiag.Resolve(files, dir, "./p.go")
+*/
+
+// Resolve maps a path from a compiler message to one of files, the absolute paths of the compiled
+// files. The go command replays the messages of a cached build with paths relative to the directory
+// the first build ran in, so when dir does not give one of files the path is matched by suffix instead.
+// A path matching no file or more than one is joined to dir.
+func Resolve(files []string, dir, name string) string {
+	if filepath.IsAbs(name) {
+		return name
+	}
+	abs := filepath.Join(dir, name)
+	rest := filepath.Clean(name)
+	for strings.HasPrefix(rest, ".."+string(filepath.Separator)) {
+		rest = rest[3:]
+	}
+	match := ""
+	for _, f := range files {
+		if f == abs {
+			return abs
+		}
+		if strings.HasSuffix(f, string(filepath.Separator)+rest) && f != match {
+			if match != "" {
+				return abs
+			}
+			match = f
+		}
+	}
+	if match == "" {
+		return abs
+	}
+	return match
+}
@@ -0,0 +1,23 @@
+package gcdiag
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestResolve(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "p")
+	file := filepath.Join(dir, "p.go")
+	files := []string{file, file} // 汇编里同一个文件会出现多次
+	assert.Equal(t, file, Resolve(files, dir, "./p.go"))
+	assert.Equal(t, "/abs/x.go", Resolve(files, dir, "/abs/x.go"))
+	// 缓存回放, 相对的是第一次编译时的目录
+	assert.Equal(t, file, Resolve(files, "/elsewhere", "p/p.go"))
+	assert.Equal(t, file, Resolve(files, "/elsewhere", "../../p/p.go"))
+	assert.Equal(t, "/elsewhere/other.go", Resolve(files, "/elsewhere", "other.go"))
+	// 后缀对上多个文件时不猜
+	other := filepath.Join(t.TempDir(), "p", "p.go")
+	assert.Equal(t, "/elsewhere/p/p.go", Resolve([]string{file, other}, "/elsewhere", "p/p.go"))
+}
@@ -3,15 +3,19 @@ package main
 import (
 	"net/http"
 	//	"io/ioutil"
+	"flag"
 	"fmt"
-	"io"
 	"io/ioutil"
 	"net"
 	"time"
+
+	"github.com/buptbill220/go_performance/lib/http_long_connction/server"
 )
 
 const URL = "http://127.0.0.1:4102"
 
+var useH2C = flag.Bool("h2c", false, "use a single multiplexed h2c connection, server must run with -h2c")
+
 func doPost(client *http.Client, url, topic string) (string, error) {
 	uri := url + "/put?topic=" + topic
 
@@ -50,6 +54,7 @@ func doPost2(client *http.Client, url, topic string) (string, error) {
 }
 
 func main() {
+	flag.Parse()
 
 	client := &http.Client{
 		Transport: &http.Transport{
@@ -61,6 +66,10 @@ func main() {
 			MaxIdleConnsPerHost: 2, // 单机可以保持10个长链接, 默认为2个
 		},
 	}
+	if *useH2C {
+		// h2c下所有请求复用同一条连接, 不再受MaxIdleConnsPerHost影响
+		client.Transport = server.NewH2CTransport()
+	}
 	// 循环中没有Connection的创建, 说明进行了复用, test-case1
 
 	for {
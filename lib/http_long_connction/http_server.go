@@ -1,20 +1,60 @@
 package main
 
 import (
+	"flag"
 	"fmt"
-	"io"
 	"net/http"
+
+	"github.com/buptbill220/go_performance/lib/http_long_connction/server"
 )
 
-func HandlePut(w http.ResponseWriter, req *http.Request) {
-	fmt.Printf("Here: %v\n", req.RequestURI)
-	io.WriteString(w, "something-else")
-}
+/*
+	go run http_server.go [-h2c] -size=4096 -latency=uniform:1ms-10ms -close-rate=0.1 -abort-rate=0.01
+*/
+
+var (
+	addr      = flag.String("addr", "127.0.0.1:4102", "listen address")
+	size      = flag.Int("size", len("something-else"), "response body size")
+	latency   = flag.String("latency", "", "latency distribution: fixed:5ms, uniform:1ms-10ms, exp:5ms")
+	chunked   = flag.Bool("chunked", false, "use chunked transfer encoding instead of Content-Length")
+	chunkSize = flag.Int("chunk-size", 4096, "bytes per chunk in chunked mode")
+	closeRate = flag.Float64("close-rate", 0, "probability of replying with Connection: close")
+	abortRate = flag.Float64("abort-rate", 0, "probability of closing the connection mid-response")
+	useH2C    = flag.Bool("h2c", false, "also serve cleartext HTTP/2")
+)
 
 func main() {
-	http.HandleFunc("/put", HandlePut)
+	flag.Parse()
 
-	err := http.ListenAndServe("127.0.0.1:4102", nil)
+	lat, err := server.ParseLatency(*latency)
+	if err != nil {
+		panic(err)
+	}
+	h := server.New(server.Config{
+		Size:      *size,
+		Chunked:   *chunked,
+		ChunkSize: *chunkSize,
+		Latency:   lat,
+		CloseRate: *closeRate,
+		AbortRate: *abortRate,
+	})
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/put", func(w http.ResponseWriter, req *http.Request) {
+		fmt.Printf("Here: %v\n", req.RequestURI)
+		h.ServeHTTP(w, req)
+	})
+
+	var handler http.Handler = mux
+	if *useH2C {
+		handler = server.H2C(mux)
+	}
+	srv := &http.Server{
+		Addr:      *addr,
+		Handler:   handler,
+		ConnState: h.ConnState,
+	}
+	err = srv.ListenAndServe()
 	if err != nil {
 		panic(err)
 	}
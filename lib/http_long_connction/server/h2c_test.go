@@ -0,0 +1,132 @@
+package server
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+/*
+	go test -v -run=^$ -bench=Proto -benchmem
+
+	http1.1 的连接池 vs 单条h2c多路复用连接, 并发逐步增大
+*/
+
+var concurrency = []int{1, 8, 64, 256}
+
+func startH2CServer(cfg Config) (*httptest.Server, *Handler) {
+	h := New(cfg)
+	srv := httptest.NewUnstartedServer(H2C(h))
+	srv.Config.ConnState = h.ConnState
+	srv.Start()
+	return srv, h
+}
+
+func TestH2C(t *testing.T) {
+	srv, h := startH2CServer(Config{Size: 1024})
+	defer srv.Close()
+	client := &http.Client{Transport: NewH2CTransport()}
+	// 先建好连接, 否则并发的首个请求会各自拨号
+	_, err := get(client, srv.URL)
+	assert.Nil(t, err)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 32; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			resp, err := client.Get(srv.URL + "/put?topic=mq_ad1")
+			if !assert.Nil(t, err) {
+				return
+			}
+			b, err := ioutil.ReadAll(resp.Body)
+			resp.Body.Close()
+			assert.Nil(t, err)
+			assert.Equal(t, 1024, len(b))
+			assert.Equal(t, 2, resp.ProtoMajor)
+		}()
+	}
+	wg.Wait()
+	assert.Equal(t, int64(1), h.Stats().Conns)
+}
+
+func TestH2CAbort(t *testing.T) {
+	srv, _ := startH2CServer(Config{Size: 64 << 10, AbortRate: 1})
+	defer srv.Close()
+	client := &http.Client{Transport: NewH2CTransport()}
+
+	_, err := get(client, srv.URL)
+	assert.NotNil(t, err)
+}
+
+// runConcurrent 用c个goroutine跑满b.N个请求, 上报p50/p99延迟和建链数
+func runConcurrent(b *testing.B, client *http.Client, url string, h *Handler, c int) {
+	var next int64
+	lats := make([][]time.Duration, c)
+	var wg sync.WaitGroup
+
+	if _, err := get(client, url); err != nil {
+		b.Fatal(err)
+	}
+	b.ResetTimer()
+	for w := 0; w < c; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			for atomic.AddInt64(&next, 1) <= int64(b.N) {
+				start := time.Now()
+				if _, err := get(client, url); err != nil {
+					b.Error(err)
+					return
+				}
+				lats[w] = append(lats[w], time.Since(start))
+			}
+		}(w)
+	}
+	wg.Wait()
+	b.StopTimer()
+
+	var all []time.Duration
+	for _, l := range lats {
+		all = append(all, l...)
+	}
+	if len(all) == 0 {
+		return
+	}
+	sort.Slice(all, func(i, j int) bool { return all[i] < all[j] })
+	b.ReportMetric(float64(all[len(all)/2].Microseconds()), "p50-us")
+	b.ReportMetric(float64(all[len(all)*99/100].Microseconds()), "p99-us")
+	b.ReportMetric(float64(h.Stats().Conns), "conns")
+}
+
+func BenchmarkProtoHTTP1Pool(b *testing.B) {
+	for _, c := range concurrency {
+		b.Run("c="+strconv.Itoa(c), func(b *testing.B) {
+			srv, h := startServer(Config{Size: 1024})
+			defer srv.Close()
+			tr := &http.Transport{MaxIdleConnsPerHost: c}
+			defer tr.CloseIdleConnections()
+			runConcurrent(b, &http.Client{Transport: tr}, srv.URL, h, c)
+		})
+	}
+}
+
+func BenchmarkProtoH2C(b *testing.B) {
+	for _, c := range concurrency {
+		b.Run("c="+strconv.Itoa(c), func(b *testing.B) {
+			srv, h := startH2CServer(Config{Size: 1024})
+			defer srv.Close()
+			tr := NewH2CTransport()
+			defer tr.CloseIdleConnections()
+			runConcurrent(b, &http.Client{Transport: tr}, srv.URL, h, c)
+		})
+	}
+}
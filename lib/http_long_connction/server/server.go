@@ -0,0 +1,196 @@
+package server
+
+import (
+	"bytes"
+	"fmt"
+	"math/rand"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// 用于长链接实验的可配置server: 控制返回大小/延迟分布/chunked/Connection: close/中途断链
+
+type LatencyFunc func(r *rand.Rand) time.Duration
+
+func FixedLatency(d time.Duration) LatencyFunc {
+	return func(*rand.Rand) time.Duration {
+		return d
+	}
+}
+
+func UniformLatency(min, max time.Duration) LatencyFunc {
+	return func(r *rand.Rand) time.Duration {
+		if max <= min {
+			return min
+		}
+		return min + time.Duration(r.Int63n(int64(max-min)))
+	}
+}
+
+func ExpLatency(mean time.Duration) LatencyFunc {
+	return func(r *rand.Rand) time.Duration {
+		return time.Duration(r.ExpFloat64() * float64(mean))
+	}
+}
+
+// ParseLatency parses "fixed:5ms", "uniform:1ms-10ms" or "exp:5ms"; empty means no latency.
+func ParseLatency(s string) (LatencyFunc, error) {
+	if s == "" {
+		return nil, nil
+	}
+	kind, arg := s, ""
+	if idx := strings.IndexByte(s, ':'); idx >= 0 {
+		kind, arg = s[:idx], s[idx+1:]
+	}
+	switch kind {
+	case "fixed", "exp":
+		d, err := time.ParseDuration(arg)
+		if err != nil {
+			return nil, err
+		}
+		if kind == "fixed" {
+			return FixedLatency(d), nil
+		}
+		return ExpLatency(d), nil
+	case "uniform":
+		parts := strings.SplitN(arg, "-", 2)
+		if len(parts) != 2 {
+			return nil, fmt.Errorf("server: bad uniform latency %q", s)
+		}
+		min, err := time.ParseDuration(parts[0])
+		if err != nil {
+			return nil, err
+		}
+		max, err := time.ParseDuration(parts[1])
+		if err != nil {
+			return nil, err
+		}
+		return UniformLatency(min, max), nil
+	}
+	return nil, fmt.Errorf("server: unknown latency %q", s)
+}
+
+type Config struct {
+	Size      int         // body size in bytes
+	Chunked   bool        // Transfer-Encoding: chunked instead of Content-Length
+	ChunkSize int         // write size per flush in chunked mode
+	Latency   LatencyFunc // delay before writing the response
+	CloseRate float64     // probability of replying with Connection: close
+	AbortRate float64     // probability of dropping the connection mid-response
+	Seed      int64
+}
+
+type Stats struct {
+	Requests int64
+	Conns    int64
+	Closed   int64
+	Aborted  int64
+}
+
+type Handler struct {
+	cfg  Config
+	body []byte
+
+	mu  sync.Mutex
+	rnd *rand.Rand
+
+	requests int64
+	conns    int64
+	closed   int64
+	aborted  int64
+}
+
+func New(cfg Config) *Handler {
+	if cfg.ChunkSize <= 0 {
+		cfg.ChunkSize = 4096
+	}
+	if cfg.Seed == 0 {
+		cfg.Seed = time.Now().UnixNano()
+	}
+	return &Handler{
+		cfg:  cfg,
+		body: bytes.Repeat([]byte{'x'}, cfg.Size),
+		rnd:  rand.New(rand.NewSource(cfg.Seed)),
+	}
+}
+
+func (h *Handler) draw() (latency time.Duration, close, abort bool) {
+	h.mu.Lock()
+	if h.cfg.Latency != nil {
+		latency = h.cfg.Latency(h.rnd)
+	}
+	close = h.cfg.CloseRate > 0 && h.rnd.Float64() < h.cfg.CloseRate
+	abort = h.cfg.AbortRate > 0 && h.rnd.Float64() < h.cfg.AbortRate
+	h.mu.Unlock()
+	return
+}
+
+func (h *Handler) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	atomic.AddInt64(&h.requests, 1)
+	latency, close, abort := h.draw()
+	if latency > 0 {
+		time.Sleep(latency)
+	}
+
+	if close {
+		atomic.AddInt64(&h.closed, 1)
+		w.Header().Set("Connection", "close")
+	}
+	w.Header().Set("Content-Type", "text/plain")
+	if !h.cfg.Chunked {
+		w.Header().Set("Content-Length", strconv.Itoa(len(h.body)))
+	}
+	w.WriteHeader(http.StatusOK)
+
+	body := h.body
+	if abort {
+		body = body[:len(body)/2]
+	}
+	if h.cfg.Chunked {
+		for len(body) > 0 {
+			n := h.cfg.ChunkSize
+			if n > len(body) {
+				n = len(body)
+			}
+			w.Write(body[:n])
+			body = body[n:]
+			flush(w)
+		}
+	} else {
+		w.Write(body)
+	}
+
+	if abort {
+		// 先把已写的部分发出去, 再中断链接, 客户端会读到 unexpected EOF
+		atomic.AddInt64(&h.aborted, 1)
+		flush(w)
+		panic(http.ErrAbortHandler)
+	}
+}
+
+// ConnState can be set as http.Server.ConnState to count new connections.
+func (h *Handler) ConnState(c net.Conn, state http.ConnState) {
+	if state == http.StateNew {
+		atomic.AddInt64(&h.conns, 1)
+	}
+}
+
+func (h *Handler) Stats() Stats {
+	return Stats{
+		Requests: atomic.LoadInt64(&h.requests),
+		Conns:    atomic.LoadInt64(&h.conns),
+		Closed:   atomic.LoadInt64(&h.closed),
+		Aborted:  atomic.LoadInt64(&h.aborted),
+	}
+}
+
+func flush(w http.ResponseWriter) {
+	if f, ok := w.(http.Flusher); ok {
+		f.Flush()
+	}
+}
@@ -0,0 +1,132 @@
+package server
+
+import (
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const rounds = 10
+
+func startServer(cfg Config) (*httptest.Server, *Handler) {
+	h := New(cfg)
+	srv := httptest.NewUnstartedServer(h)
+	srv.Config.ConnState = h.ConnState
+	srv.Start()
+	return srv, h
+}
+
+func newClient() *http.Client {
+	return &http.Client{
+		Transport: &http.Transport{MaxIdleConnsPerHost: 2},
+	}
+}
+
+func get(client *http.Client, url string) (int, error) {
+	resp, err := client.Get(url + "/put?topic=mq_ad1")
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	b, err := ioutil.ReadAll(resp.Body)
+	return len(b), err
+}
+
+func TestParseLatency(t *testing.T) {
+	f, err := ParseLatency("fixed:5ms")
+	assert.Nil(t, err)
+	assert.Equal(t, 5*time.Millisecond, f(nil))
+
+	_, err = ParseLatency("uniform:1ms-10ms")
+	assert.Nil(t, err)
+	_, err = ParseLatency("exp:2ms")
+	assert.Nil(t, err)
+
+	f, err = ParseLatency("")
+	assert.Nil(t, err)
+	assert.Nil(t, f)
+
+	_, err = ParseLatency("uniform:1ms")
+	assert.NotNil(t, err)
+	_, err = ParseLatency("normal:1ms")
+	assert.NotNil(t, err)
+}
+
+// 读完body, 链接会被复用
+func TestReuseWhenBodyRead(t *testing.T) {
+	srv, h := startServer(Config{Size: 1024})
+	defer srv.Close()
+	client := newClient()
+
+	for i := 0; i < rounds; i++ {
+		n, err := get(client, srv.URL)
+		assert.Nil(t, err)
+		assert.Equal(t, 1024, n)
+	}
+	assert.Equal(t, int64(1), h.Stats().Conns)
+}
+
+func TestChunked(t *testing.T) {
+	srv, h := startServer(Config{Size: 10000, Chunked: true, ChunkSize: 1000})
+	defer srv.Close()
+	client := newClient()
+
+	resp, err := client.Get(srv.URL)
+	assert.Nil(t, err)
+	b, err := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	assert.Nil(t, err)
+	assert.Equal(t, 10000, len(b))
+	assert.Equal(t, int64(-1), resp.ContentLength)
+	assert.Equal(t, []string{"chunked"}, resp.TransferEncoding)
+
+	_, err = get(client, srv.URL)
+	assert.Nil(t, err)
+	assert.Equal(t, int64(1), h.Stats().Conns)
+}
+
+func TestConnectionClose(t *testing.T) {
+	srv, h := startServer(Config{Size: 128, CloseRate: 1})
+	defer srv.Close()
+	client := newClient()
+
+	for i := 0; i < rounds; i++ {
+		_, err := get(client, srv.URL)
+		assert.Nil(t, err)
+	}
+	st := h.Stats()
+	assert.Equal(t, int64(rounds), st.Closed)
+	assert.Equal(t, int64(rounds), st.Conns)
+}
+
+func TestAbortMidResponse(t *testing.T) {
+	for _, chunked := range []bool{false, true} {
+		srv, h := startServer(Config{Size: 64 << 10, Chunked: chunked, AbortRate: 1})
+		client := newClient()
+
+		_, err := get(client, srv.URL)
+		assert.Equal(t, io.ErrUnexpectedEOF, err)
+
+		// 被中断的链接不会进入idle池
+		get(client, srv.URL)
+		st := h.Stats()
+		assert.Equal(t, int64(2), st.Aborted)
+		assert.Equal(t, int64(2), st.Conns)
+		srv.Close()
+	}
+}
+
+func TestLatency(t *testing.T) {
+	srv, _ := startServer(Config{Size: 16, Latency: FixedLatency(20 * time.Millisecond)})
+	defer srv.Close()
+
+	start := time.Now()
+	_, err := get(newClient(), srv.URL)
+	assert.Nil(t, err)
+	assert.True(t, time.Since(start) >= 20*time.Millisecond)
+}
@@ -0,0 +1,29 @@
+// Code generated by jsongen -type=Data; DO NOT EDIT.
+
+package lib
+
+import "github.com/buptbill220/go_performance/lib/jsonenc"
+
+// AppendJSON appends the JSON encoding of p to dst, same as encoding/json.
+func (p *Data) AppendJSON(dst []byte) []byte {
+	if p == nil {
+		return append(dst, "null"...)
+	}
+	dst = append(dst, '{')
+	dst = append(dst, "\"req_id\":"...)
+	dst = jsonenc.AppendString(dst, string(p.ReqId))
+	dst = append(dst, ",\"item_ids\":"...)
+	if p.ItemIds == nil {
+		dst = append(dst, "null"...)
+	} else {
+		dst = append(dst, '[')
+		for i0 := range p.ItemIds {
+			if i0 > 0 {
+				dst = append(dst, ',')
+			}
+			dst = jsonenc.AppendInt(dst, int64(p.ItemIds[i0]))
+		}
+		dst = append(dst, ']')
+	}
+	return append(dst, '}')
+}
@@ -10,9 +10,11 @@ import (
 	in current dir
 
 	cmd:
-	go test -v -bench=. json_format_test.go  -benchmem
+	go test -v -bench=. json_format_test.go json_format_json_test.go -benchmem
 */
 
+//go:generate go run ../cmd/jsongen -type=Data json_format_test.go
+
 type Data struct {
 	ReqId   string  `json:"req_id"`
 	ItemIds []int64 `json:"item_ids"`
@@ -47,6 +49,20 @@ func TestStructFormat(t *testing.T) {
 	assert.Equal(t, nil, err)
 }
 
+func appendFormat(buf []byte) []byte {
+	dat := &Data{
+		ReqId:   reqId,
+		ItemIds: itemIds,
+	}
+	return dat.AppendJSON(buf[:0])
+}
+
+func TestAppendFormat(t *testing.T) {
+	expected, err := json.Marshal(&Data{ReqId: reqId, ItemIds: itemIds})
+	assert.Equal(t, nil, err)
+	assert.Equal(t, string(expected), string(appendFormat(nil)))
+}
+
 func TestMapFormat(t *testing.T) {
 	err := mapFormat()
 	assert.Equal(t, nil, err)
@@ -63,3 +79,10 @@ func BenchmarkMapFormat(b *testing.B) {
 		mapFormat()
 	}
 }
+
+func BenchmarkAppendFormat(b *testing.B) {
+	buf := make([]byte, 0, 128)
+	for i := 0; i <= b.N; i++ {
+		buf = appendFormat(buf)
+	}
+}
@@ -0,0 +1,118 @@
+package jsonenc_test
+
+import (
+	"encoding/json"
+	"math"
+	"testing"
+
+	"github.com/buptbill220/go_performance/lib/jsonenc"
+	"github.com/stretchr/testify/assert"
+)
+
+func boolPtr(v bool) *bool {
+	return &v
+}
+
+func strPtr(v string) *string {
+	return &v
+}
+
+var feed = &Feed{
+	ReqId:   "20170818142437010008060105749EBF",
+	ItemIds: []int64{1, 2, 3, 4, 5, 6},
+	Items: []*Item{
+		{Id: 1, Title: "<a href=\"x\">&amp;</a>", Score: 0.000001, Tags: []string{"a", "\u2028b"}},
+		nil,
+		{Id: -9, Title: "bad\xffutf8\x01\b\f\n\r\t\\", Score: 1e21, Ratio: 3.14, Payload: []byte("hello"), PtrStr: boolPtr(true)},
+	},
+	Main:   Item{Title: "中文", Tags: []string{}, Payload: []byte{}, Score: Score(math.MaxFloat64), Ratio: 1e-7, AsStr: 42},
+	Matrix: [][]int32{{1, 2}, nil, {}},
+	Name:   strPtr("name"),
+	Text:   "quoted \"<text>\"",
+}
+
+func TestAppendJSONEqualLib(t *testing.T) {
+	values := []interface {
+		AppendJSON([]byte) []byte
+	}{
+		feed,
+		&Feed{},
+		&Item{Dash: 1, Bad: 2, Hidden: "x"},
+		&OmitAll{},
+		&OmitAll{A: 1},
+		&OmitAll{B: "b", D: []byte{1}},
+		&OmitAll{C: true, E: "e"},
+		&OmitAll{F: []Octet("named byte")},
+		&Empty{},
+		(*Item)(nil),
+	}
+	for _, v := range values {
+		expected, err := json.Marshal(v)
+		assert.Nil(t, err)
+		assert.Equal(t, string(expected), string(v.AppendJSON(nil)))
+	}
+}
+
+// encoding/json 对 NaN/Inf 报错, AppendJSON 写 null, 输出还是合法的json
+func TestAppendJSONNaN(t *testing.T) {
+	item := &Item{Score: Score(math.NaN()), Ratio: float32(math.Inf(1))}
+	_, err := json.Marshal(item)
+	assert.NotNil(t, err)
+	out := item.AppendJSON(nil)
+	assert.Contains(t, string(out), `"score":null,"ratio":null`)
+	assert.True(t, json.Valid(out))
+}
+
+func TestAppendFloat(t *testing.T) {
+	for _, f := range []float64{0, -0.0, 1, -1.5, 1e-6, 1e-7, 123456789, 1e20, 1e21, 1.5e300, 5e-324, math.MaxFloat64} {
+		expected, _ := json.Marshal(f)
+		assert.Equal(t, string(expected), string(jsonenc.AppendFloat(nil, f, 64)))
+
+		if math.IsInf(float64(float32(f)), 0) {
+			continue
+		}
+		expected, _ = json.Marshal(float32(f))
+		assert.Equal(t, string(expected), string(jsonenc.AppendFloat(nil, float64(float32(f)), 32)))
+	}
+	for _, f := range []float64{math.NaN(), math.Inf(1), math.Inf(-1)} {
+		assert.Equal(t, "null", string(jsonenc.AppendFloat(nil, f, 64)))
+	}
+}
+
+func FuzzAppendString(f *testing.F) {
+	f.Add("<a href=\"x\">&amp;</a>")
+	f.Add("bad\xffutf8\x01\u2028\u2029")
+	f.Fuzz(func(t *testing.T, s string) {
+		expected, _ := json.Marshal(s)
+		if actual := jsonenc.AppendString(nil, s); string(actual) != string(expected) {
+			t.Fatalf("%q: lib=%s gen=%s", s, expected, actual)
+		}
+	})
+}
+
+func FuzzAppendFloat(f *testing.F) {
+	f.Add(1.5)
+	f.Add(1e21)
+	f.Fuzz(func(t *testing.T, v float64) {
+		if math.IsNaN(v) || math.IsInf(v, 0) {
+			return
+		}
+		expected, _ := json.Marshal(v)
+		if actual := jsonenc.AppendFloat(nil, v, 64); string(actual) != string(expected) {
+			t.Fatalf("%v: lib=%s gen=%s", v, expected, actual)
+		}
+	})
+}
+
+func BenchmarkMarshalFeed(b *testing.B) {
+	for i := 0; i < b.N; i++ {
+		json.Marshal(feed)
+	}
+}
+
+func BenchmarkAppendJSONFeed(b *testing.B) {
+	buf := make([]byte, 0, 1024)
+	for i := 0; i < b.N; i++ {
+		buf = feed.AppendJSON(buf[:0])
+	}
+}
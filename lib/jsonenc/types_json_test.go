@@ -0,0 +1,178 @@
+// Code generated by jsongen -type=Item,Feed,OmitAll,Empty; DO NOT EDIT.
+
+package jsonenc_test
+
+import "github.com/buptbill220/go_performance/lib/jsonenc"
+
+// AppendJSON appends the JSON encoding of p to dst, same as encoding/json.
+func (p *Item) AppendJSON(dst []byte) []byte {
+	if p == nil {
+		return append(dst, "null"...)
+	}
+	dst = append(dst, '{')
+	dst = append(dst, "\"id\":"...)
+	dst = jsonenc.AppendInt(dst, int64(p.Id))
+	dst = append(dst, ",\"title\":"...)
+	dst = jsonenc.AppendString(dst, string(p.Title))
+	dst = append(dst, ",\"score\":"...)
+	dst = jsonenc.AppendFloat(dst, float64(p.Score), 64)
+	if p.Ratio != 0 {
+		dst = append(dst, ",\"ratio\":"...)
+		dst = jsonenc.AppendFloat(dst, float64(p.Ratio), 32)
+	}
+	dst = append(dst, ",\"Tags\":"...)
+	if p.Tags == nil {
+		dst = append(dst, "null"...)
+	} else {
+		dst = append(dst, '[')
+		for i0 := range p.Tags {
+			if i0 > 0 {
+				dst = append(dst, ',')
+			}
+			dst = jsonenc.AppendString(dst, string(p.Tags[i0]))
+		}
+		dst = append(dst, ']')
+	}
+	dst = append(dst, ",\"payload\":"...)
+	dst = jsonenc.AppendBytes(dst, []byte(p.Payload))
+	dst = append(dst, ",\"-\":"...)
+	dst = jsonenc.AppendInt(dst, int64(p.Dash))
+	dst = append(dst, ",\"Bad\":"...)
+	dst = jsonenc.AppendUint(dst, uint64(p.Bad))
+	dst = append(dst, ",\"as_str\":"...)
+	dst = append(dst, '"')
+	dst = jsonenc.AppendInt(dst, int64(p.AsStr))
+	dst = append(dst, '"')
+	dst = append(dst, ",\"PtrStr\":"...)
+	if p.PtrStr == nil {
+		dst = append(dst, "null"...)
+	} else {
+		dst = append(dst, '"')
+		dst = jsonenc.AppendBool(dst, bool(*p.PtrStr))
+		dst = append(dst, '"')
+	}
+	return append(dst, '}')
+}
+
+// AppendJSON appends the JSON encoding of p to dst, same as encoding/json.
+func (p *Feed) AppendJSON(dst []byte) []byte {
+	if p == nil {
+		return append(dst, "null"...)
+	}
+	dst = append(dst, '{')
+	dst = append(dst, "\"req_id\":"...)
+	dst = jsonenc.AppendString(dst, string(p.ReqId))
+	dst = append(dst, ",\"item_ids\":"...)
+	if p.ItemIds == nil {
+		dst = append(dst, "null"...)
+	} else {
+		dst = append(dst, '[')
+		for i0 := range p.ItemIds {
+			if i0 > 0 {
+				dst = append(dst, ',')
+			}
+			dst = jsonenc.AppendInt(dst, int64(p.ItemIds[i0]))
+		}
+		dst = append(dst, ']')
+	}
+	if len(p.Items) != 0 {
+		dst = append(dst, ",\"items\":"...)
+		dst = append(dst, '[')
+		for i0 := range p.Items {
+			if i0 > 0 {
+				dst = append(dst, ',')
+			}
+			dst = p.Items[i0].AppendJSON(dst)
+		}
+		dst = append(dst, ']')
+	}
+	dst = append(dst, ",\"main\":"...)
+	dst = p.Main.AppendJSON(dst)
+	dst = append(dst, ",\"next\":"...)
+	dst = p.Next.AppendJSON(dst)
+	if len(p.Matrix) != 0 {
+		dst = append(dst, ",\"matrix\":"...)
+		dst = append(dst, '[')
+		for i0 := range p.Matrix {
+			if i0 > 0 {
+				dst = append(dst, ',')
+			}
+			if p.Matrix[i0] == nil {
+				dst = append(dst, "null"...)
+			} else {
+				dst = append(dst, '[')
+				for i1 := range p.Matrix[i0] {
+					if i1 > 0 {
+						dst = append(dst, ',')
+					}
+					dst = jsonenc.AppendInt(dst, int64(p.Matrix[i0][i1]))
+				}
+				dst = append(dst, ']')
+			}
+		}
+		dst = append(dst, ']')
+	}
+	if p.Name != nil {
+		dst = append(dst, ",\"name\":"...)
+		dst = jsonenc.AppendString(dst, string(*p.Name))
+	}
+	dst = append(dst, ",\"text\":"...)
+	dst = jsonenc.AppendString(dst, string(jsonenc.AppendString(nil, string(p.Text))))
+	return append(dst, '}')
+}
+
+// AppendJSON appends the JSON encoding of p to dst, same as encoding/json.
+func (p *OmitAll) AppendJSON(dst []byte) []byte {
+	if p == nil {
+		return append(dst, "null"...)
+	}
+	dst = append(dst, '{')
+	start := len(dst)
+	if p.A != 0 {
+		dst = append(dst, "\"a\":"...)
+		dst = jsonenc.AppendInt(dst, int64(p.A))
+	}
+	if p.B != "" {
+		if len(dst) > start {
+			dst = append(dst, ',')
+		}
+		dst = append(dst, "\"b\":"...)
+		dst = jsonenc.AppendString(dst, string(p.B))
+	}
+	if p.C {
+		if len(dst) > start {
+			dst = append(dst, ',')
+		}
+		dst = append(dst, "\"c\":"...)
+		dst = jsonenc.AppendBool(dst, bool(p.C))
+	}
+	if len(p.D) != 0 {
+		if len(dst) > start {
+			dst = append(dst, ',')
+		}
+		dst = append(dst, "\"d\":"...)
+		dst = jsonenc.AppendBytes(dst, []byte(p.D))
+	}
+	if len(p.F) != 0 {
+		if len(dst) > start {
+			dst = append(dst, ',')
+		}
+		dst = append(dst, "\"f\":"...)
+		dst = jsonenc.AppendBytes(dst, []byte(string(p.F)))
+	}
+	if len(dst) > start {
+		dst = append(dst, ',')
+	}
+	dst = append(dst, "\"e\":"...)
+	dst = jsonenc.AppendString(dst, string(p.E))
+	return append(dst, '}')
+}
+
+// AppendJSON appends the JSON encoding of p to dst, same as encoding/json.
+func (p *Empty) AppendJSON(dst []byte) []byte {
+	if p == nil {
+		return append(dst, "null"...)
+	}
+	dst = append(dst, '{')
+	return append(dst, '}')
+}
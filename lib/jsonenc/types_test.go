@@ -0,0 +1,44 @@
+package jsonenc_test
+
+//go:generate go run ../../cmd/jsongen -type=Item,Feed,OmitAll,Empty types_test.go
+
+type Score float64
+
+type Octet uint8
+
+type Item struct {
+	Id      int64   `json:"id"`
+	Title   string  `json:"title"`
+	Score   Score   `json:"score"`
+	Ratio   float32 `json:"ratio,omitempty"`
+	Tags    []string
+	Payload []byte `json:"payload"`
+	Hidden  string `json:"-"`
+	Dash    int    `json:"-,"`
+	Bad     uint16 `json:"\\bad"`
+	AsStr   int64  `json:"as_str,string"`
+	PtrStr  *bool  `json:",string"`
+	private int
+}
+
+type Feed struct {
+	ReqId   string    `json:"req_id"`
+	ItemIds []int64   `json:"item_ids"`
+	Items   []*Item   `json:"items,omitempty"`
+	Main    Item      `json:"main"`
+	Next    *Item     `json:"next"`
+	Matrix  [][]int32 `json:"matrix,omitempty"`
+	Name    *string   `json:"name,omitempty"`
+	Text    string    `json:"text,string"`
+}
+
+type OmitAll struct {
+	A int     `json:"a,omitempty"`
+	B string  `json:"b,omitempty"`
+	C bool    `json:"c,omitempty"`
+	D []uint8 `json:"d,omitempty"`
+	F []Octet `json:"f,omitempty"`
+	E string  `json:"e"`
+}
+
+type Empty struct{}
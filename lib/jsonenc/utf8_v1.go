@@ -0,0 +1,6 @@
+//go:build !goexperiment.jsonv2
+
+package jsonenc
+
+// 老的 encoding/json 把非法utf8写成转义形式
+const invalidUTF8 = `\ufffd`
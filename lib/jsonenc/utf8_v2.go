@@ -0,0 +1,6 @@
+//go:build goexperiment.jsonv2
+
+package jsonenc
+
+// 基于 json/v2 的 encoding/json 直接写入 U+FFFD 的utf8编码
+const invalidUTF8 = "\ufffd"
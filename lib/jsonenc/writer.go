@@ -0,0 +1,199 @@
+package jsonenc
+
+import (
+	"io"
+	"math"
+	"sync"
+)
+
+/*
+	流式写json, 大列表(比如上万个item id)不用先整个拼好再写出去:
+
+	w := jsonenc.NewWriter(rsp)
+	w.BeginObject()
+	w.Key("req_id")
+	w.String(reqId)
+	w.Key("item_ids")
+	w.Int64Array(itemIds)
+	w.EndObject()
+	err := w.Close()
+
+	buffer从pool里拿, 超过flushSize就写到底层io.Writer, Close时归还
+	逗号由Writer自己维护, 调用方只保证 Key/值 成对出现
+*/
+
+const (
+	bufferSize = 8 << 10
+	flushSize  = 4 << 10
+)
+
+var bufferPool = sync.Pool{
+	New: func() interface{} {
+		b := make([]byte, 0, bufferSize)
+		return &b
+	},
+}
+
+type Writer struct {
+	w     io.Writer
+	buf   *[]byte
+	comma bool
+	err   error
+}
+
+func NewWriter(w io.Writer) *Writer {
+	return &Writer{
+		w:   w,
+		buf: bufferPool.Get().(*[]byte),
+	}
+}
+
+// Reset reuses the Writer for w, the pending output is dropped.
+func (p *Writer) Reset(w io.Writer) {
+	if p.buf == nil {
+		p.buf = bufferPool.Get().(*[]byte)
+	}
+	*p.buf = (*p.buf)[:0]
+	p.w = w
+	p.comma = false
+	p.err = nil
+}
+
+func (p *Writer) value() []byte {
+	b := *p.buf
+	if p.comma {
+		b = append(b, ',')
+	}
+	p.comma = true
+	return b
+}
+
+func (p *Writer) done(b []byte) {
+	*p.buf = b
+	if len(b) >= flushSize {
+		p.Flush()
+	}
+}
+
+func (p *Writer) BeginObject() {
+	b := p.value()
+	p.comma = false
+	p.done(append(b, '{'))
+}
+
+func (p *Writer) EndObject() {
+	p.comma = true
+	p.done(append(*p.buf, '}'))
+}
+
+func (p *Writer) BeginArray() {
+	b := p.value()
+	p.comma = false
+	p.done(append(b, '['))
+}
+
+func (p *Writer) EndArray() {
+	p.comma = true
+	p.done(append(*p.buf, ']'))
+}
+
+func (p *Writer) Key(k string) {
+	b := AppendString(p.value(), k)
+	p.comma = false
+	p.done(append(b, ':'))
+}
+
+func (p *Writer) String(v string) {
+	p.done(AppendString(p.value(), v))
+}
+
+func (p *Writer) Int64(v int64) {
+	p.done(AppendInt(p.value(), v))
+}
+
+func (p *Writer) Uint64(v uint64) {
+	p.done(AppendUint(p.value(), v))
+}
+
+// Float64 fails the Writer for NaN and Inf like encoding/json, nothing more is written and Close returns the error.
+func (p *Writer) Float64(v float64) {
+	if (math.IsInf(v, 0) || math.IsNaN(v)) && p.err == nil {
+		p.err = unsupported(v, 64)
+	}
+	p.done(AppendFloat(p.value(), v, 64))
+}
+
+func (p *Writer) Bool(v bool) {
+	p.done(AppendBool(p.value(), v))
+}
+
+func (p *Writer) Null() {
+	p.done(append(p.value(), "null"...))
+}
+
+// Raw writes an already encoded JSON value.
+func (p *Writer) Raw(v []byte) {
+	p.done(append(p.value(), v...))
+}
+
+// Int64Array writes v as an array, nil as null like encoding/json.
+func (p *Writer) Int64Array(v []int64) {
+	if v == nil {
+		p.Null()
+		return
+	}
+	b := append(p.value(), '[')
+	for i, n := range v {
+		if i > 0 {
+			b = append(b, ',')
+		}
+		b = AppendInt(b, n)
+		if len(b) >= flushSize {
+			p.done(b)
+			b = *p.buf
+		}
+	}
+	p.done(append(b, ']'))
+}
+
+func (p *Writer) StringArray(v []string) {
+	if v == nil {
+		p.Null()
+		return
+	}
+	b := append(p.value(), '[')
+	for i, s := range v {
+		if i > 0 {
+			b = append(b, ',')
+		}
+		b = AppendString(b, s)
+		if len(b) >= flushSize {
+			p.done(b)
+			b = *p.buf
+		}
+	}
+	p.done(append(b, ']'))
+}
+
+// Flush writes the buffered output, the first write error is kept and returned by later calls.
+func (p *Writer) Flush() error {
+	if p.err != nil {
+		*p.buf = (*p.buf)[:0]
+		return p.err
+	}
+	if len(*p.buf) > 0 {
+		_, p.err = p.w.Write(*p.buf)
+		*p.buf = (*p.buf)[:0]
+	}
+	return p.err
+}
+
+// Close flushes and gives the buffer back to the pool, the Writer can not be used after Close except Reset.
+func (p *Writer) Close() error {
+	err := p.Flush()
+	if cap(*p.buf) <= 4*bufferSize {
+		bufferPool.Put(p.buf)
+	}
+	p.buf = nil
+	return err
+}
@@ -0,0 +1,142 @@
+package jsonenc_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"math"
+	"testing"
+
+	"github.com/buptbill220/go_performance/lib/jsonenc"
+	"github.com/stretchr/testify/assert"
+)
+
+type bigFeed struct {
+	ReqId   string   `json:"req_id"`
+	ItemIds []int64  `json:"item_ids"`
+	Extra   []string `json:"extra"`
+	Score   float64  `json:"score"`
+	Ok      bool     `json:"ok"`
+	Next    *int     `json:"next"`
+	Nested  struct {
+		Count uint64  `json:"count"`
+		Empty []int64 `json:"empty"`
+	} `json:"nested"`
+}
+
+var big bigFeed
+
+func init() {
+	big.ReqId = "20170818142437010008060105749EBF"
+	for i := 0; i < 10000; i++ {
+		big.ItemIds = append(big.ItemIds, 6455077430972383757+int64(i))
+	}
+	big.Extra = []string{"<a>", "b"}
+	big.Score = 0.5
+	big.Ok = true
+	big.Nested.Count = 3
+	big.Nested.Empty = []int64{}
+}
+
+func writeBig(w *jsonenc.Writer) error {
+	w.BeginObject()
+	w.Key("req_id")
+	w.String(big.ReqId)
+	w.Key("item_ids")
+	w.Int64Array(big.ItemIds)
+	w.Key("extra")
+	w.StringArray(big.Extra)
+	w.Key("score")
+	w.Float64(big.Score)
+	w.Key("ok")
+	w.Bool(big.Ok)
+	w.Key("next")
+	w.Null()
+	w.Key("nested")
+	w.BeginObject()
+	w.Key("count")
+	w.Uint64(big.Nested.Count)
+	w.Key("empty")
+	w.BeginArray()
+	w.EndArray()
+	w.EndObject()
+	w.EndObject()
+	return w.Close()
+}
+
+func TestWriterEqualLib(t *testing.T) {
+	expected, err := json.Marshal(&big)
+	assert.Nil(t, err)
+
+	var buf bytes.Buffer
+	assert.Nil(t, writeBig(jsonenc.NewWriter(&buf)))
+	assert.Equal(t, string(expected), buf.String())
+}
+
+func TestWriterArray(t *testing.T) {
+	var buf bytes.Buffer
+	w := jsonenc.NewWriter(&buf)
+	w.BeginArray()
+	w.Int64(1)
+	w.BeginObject()
+	w.EndObject()
+	w.Int64Array(nil)
+	w.Raw([]byte(`{"a":1}`))
+	w.EndArray()
+	assert.Nil(t, w.Close())
+	assert.Equal(t, `[1,{},null,{"a":1}]`, buf.String())
+}
+
+type errWriter struct {
+	n int
+}
+
+func (p *errWriter) Write(b []byte) (int, error) {
+	p.n++
+	return 0, errors.New("broken pipe")
+}
+
+func TestWriterError(t *testing.T) {
+	ew := &errWriter{}
+	err := writeBig(jsonenc.NewWriter(ew))
+	assert.NotNil(t, err)
+	assert.Equal(t, 1, ew.n)
+}
+
+func TestWriterNaN(t *testing.T) {
+	var buf bytes.Buffer
+	w := jsonenc.NewWriter(&buf)
+	w.BeginArray()
+	w.Float64(1)
+	w.Float64(math.NaN())
+	w.Float64(2)
+	w.EndArray()
+	_, expected := json.Marshal(math.NaN())
+	err := w.Close()
+	if assert.NotNil(t, err) {
+		assert.Equal(t, expected.Error(), err.Error())
+	}
+	assert.Equal(t, "", buf.String())
+}
+
+func BenchmarkMarshalBigFeed(b *testing.B) {
+	for i := 0; i < b.N; i++ {
+		dat, _ := json.Marshal(&big)
+		ioutil.Discard.Write(dat)
+	}
+}
+
+func BenchmarkWriterBigFeed(b *testing.B) {
+	for i := 0; i < b.N; i++ {
+		writeBig(jsonenc.NewWriter(ioutil.Discard))
+	}
+}
+
+func BenchmarkWriterBigFeedReuse(b *testing.B) {
+	w := jsonenc.NewWriter(ioutil.Discard)
+	for i := 0; i < b.N; i++ {
+		w.Reset(ioutil.Discard)
+		writeBig(w)
+	}
+}
@@ -0,0 +1,127 @@
+package keybuilder
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+var (
+	uid     int64 = 34639849336
+	version int64 = 631
+	slot    int64 = 3
+)
+
+func TestBuilder(t *testing.T) {
+	b := New(':', 32)
+	b.Str("after_rank").Int(uid).Int(version).Uint(7).Bool(true)
+	assert.Equal(t, "after_rank:34639849336:631:7:1", b.String())
+	assert.Equal(t, b.String(), b.UnsafeString())
+
+	b.Reset().Raw("ad_").Int(-5).Str("x").Bytes([]byte("y"))
+	assert.Equal(t, "ad_-5:x:y", b.String())
+
+	b = New(0, 0)
+	b.Str("a").Int(1).Str("b")
+	assert.Equal(t, "a1b", string(b.Key()))
+	assert.Equal(t, "", b.Reset().UnsafeString())
+}
+
+func TestAppendInt(t *testing.T) {
+	for _, v := range []int64{0, 1, 9, 10, 999, 1023, 1024, -1, math.MaxInt64, math.MinInt64} {
+		assert.Equal(t, strconv.FormatInt(v, 10), string(AppendInt(nil, v)))
+		assert.Equal(t, strconv.FormatInt(v, 10), FormatInt(v))
+	}
+	assert.Equal(t, "18446744073709551615", string(AppendUint(nil, math.MaxUint64)))
+	assert.Equal(t, "1023", string(AppendUint(nil, 1023)))
+}
+
+func TestNoAlloc(t *testing.T) {
+	b := New(':', 64)
+	m := map[string]int{"after_rank:34639849336:631:3": 1}
+	allocs := testing.AllocsPerRun(100, func() {
+		b.Reset().Str("after_rank").Int(uid).Int(version).Int(slot)
+		if m[b.UnsafeString()] != 1 {
+			t.Fatal("lookup failed")
+		}
+	})
+	assert.Equal(t, float64(0), allocs)
+
+	allocs = testing.AllocsPerRun(100, func() {
+		kb := Get(':')
+		kb.Str("after_rank").Int(uid).Int(version).Int(slot)
+		Put(kb)
+	})
+	assert.Equal(t, float64(0), allocs)
+}
+
+func fmtKey() string {
+	return fmt.Sprintf("after_rank:%d:%d:%d", uid, version, slot)
+}
+
+func stringsBuilderKey() string {
+	var sb strings.Builder
+	sb.Grow(64)
+	sb.WriteString("after_rank:")
+	sb.WriteString(strconv.FormatInt(uid, 10))
+	sb.WriteByte(':')
+	sb.WriteString(strconv.FormatInt(version, 10))
+	sb.WriteByte(':')
+	sb.WriteString(strconv.FormatInt(slot, 10))
+	return sb.String()
+}
+
+func strconvAddKey() string {
+	return "after_rank:" + strconv.FormatInt(uid, 10) + ":" + strconv.FormatInt(version, 10) + ":" + strconv.FormatInt(slot, 10)
+}
+
+func BenchmarkFmtSprintf(b *testing.B) {
+	for i := 0; i < b.N; i++ {
+		fmtKey()
+	}
+}
+
+func BenchmarkStringsBuilder(b *testing.B) {
+	for i := 0; i < b.N; i++ {
+		stringsBuilderKey()
+	}
+}
+
+func BenchmarkStrconvAdd(b *testing.B) {
+	for i := 0; i < b.N; i++ {
+		strconvAddKey()
+	}
+}
+
+func BenchmarkKeyBuilder(b *testing.B) {
+	kb := New(':', 64)
+	for i := 0; i < b.N; i++ {
+		kb.Reset().Str("after_rank").Int(uid).Int(version).Int(slot)
+	}
+}
+
+func BenchmarkKeyBuilderPool(b *testing.B) {
+	for i := 0; i < b.N; i++ {
+		kb := Get(':')
+		kb.Str("after_rank").Int(uid).Int(version).Int(slot)
+		Put(kb)
+	}
+}
+
+func BenchmarkSmallIntTable(b *testing.B) {
+	buf := make([]byte, 0, 8)
+	for i := 0; i < b.N; i++ {
+		buf = AppendInt(buf[:0], version)
+	}
+}
+
+func BenchmarkSmallIntStrconv(b *testing.B) {
+	buf := make([]byte, 0, 8)
+	for i := 0; i < b.N; i++ {
+		buf = strconv.AppendInt(buf[:0], version, 10)
+	}
+}
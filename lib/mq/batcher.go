@@ -0,0 +1,91 @@
+package mq
+
+import (
+	"sync"
+	"time"
+)
+
+// Batcher collects bodies for one topic and sends them with MultiPublish
+// when MaxBatch bodies are pending or MaxDelay passed since the first one.
+type Batcher struct {
+	p        Producer
+	topic    string
+	maxBatch int
+	maxDelay time.Duration
+	onError  func(err error, bodies [][]byte)
+
+	mu      sync.Mutex
+	pending [][]byte
+	timer   *time.Timer
+	stopped bool
+}
+
+// NewBatcher returns a Batcher, onError gets the bodies of a failed background flush and may be nil.
+func NewBatcher(p Producer, topic string, maxBatch int, maxDelay time.Duration, onError func(err error, bodies [][]byte)) *Batcher {
+	if maxBatch <= 0 {
+		maxBatch = 1
+	}
+	return &Batcher{p: p, topic: topic, maxBatch: maxBatch, maxDelay: maxDelay, onError: onError}
+}
+
+// Add queues body, it publishes in the caller when the batch is full.
+func (b *Batcher) Add(body []byte) error {
+	b.mu.Lock()
+	if b.stopped {
+		b.mu.Unlock()
+		return ErrStopped
+	}
+	b.pending = append(b.pending, body)
+	if len(b.pending) < b.maxBatch {
+		if len(b.pending) == 1 && b.maxDelay > 0 {
+			b.timer = time.AfterFunc(b.maxDelay, b.flushTimer)
+		}
+		b.mu.Unlock()
+		return nil
+	}
+	bodies := b.take()
+	b.mu.Unlock()
+	return b.p.MultiPublish(b.topic, bodies)
+}
+
+// Flush publishes what is pending now.
+func (b *Batcher) Flush() error {
+	b.mu.Lock()
+	bodies := b.take()
+	b.mu.Unlock()
+	if len(bodies) == 0 {
+		return nil
+	}
+	return b.p.MultiPublish(b.topic, bodies)
+}
+
+// Stop flushes the pending bodies, Add returns ErrStopped afterwards.
+func (b *Batcher) Stop() error {
+	b.mu.Lock()
+	b.stopped = true
+	b.mu.Unlock()
+	return b.Flush()
+}
+
+func (b *Batcher) flushTimer() {
+	b.mu.Lock()
+	bodies := b.take()
+	b.mu.Unlock()
+	if len(bodies) == 0 {
+		return
+	}
+	if err := b.p.MultiPublish(b.topic, bodies); err != nil && b.onError != nil {
+		b.onError(err, bodies)
+	}
+}
+
+// take must be called with b.mu held.
+func (b *Batcher) take() [][]byte {
+	if b.timer != nil {
+		b.timer.Stop()
+		b.timer = nil
+	}
+	bodies := b.pending
+	b.pending = nil
+	return bodies
+}
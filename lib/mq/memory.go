@@ -0,0 +1,401 @@
+package mq
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/buptbill220/go_performance/simple_impl"
+)
+
+var ErrStarted = errors.New("mq: consumer already started")
+
+var (
+	_ Producer   = (*Memory)(nil)
+	_ Subscriber = (*Memory)(nil)
+	_ Consumer   = (*MemoryConsumer)(nil)
+)
+
+// Memory is an in-process broker for tests, every channel keeps its messages in a simple_impl.Queue.
+// Like nsq every channel of a topic gets a copy and messages published before the first
+// channel exists wait in the topic. There is no message timeout and nothing is persisted.
+type Memory struct {
+	// ConsumerOptions are used by Subscribe and SubscribeBatch, set it before subscribing
+	ConsumerOptions MemoryOptions
+
+	mu      sync.Mutex
+	topics  map[string]*memTopic
+	seq     uint64
+	stopped bool
+}
+
+type memTopic struct {
+	backlog  *simple_impl.Queue
+	channels map[string]*memChannel
+}
+
+type memChannel struct {
+	queue *simple_impl.Queue
+	// ready 有一个缓冲, Push 之后通知一个等着的worker, 它取完发现还有再接着通知
+	ready chan struct{}
+}
+
+type memMessage struct {
+	id        string
+	body      []byte
+	timestamp time.Time
+	attempts  uint16
+}
+
+func NewMemory() *Memory {
+	return &Memory{topics: make(map[string]*memTopic)}
+}
+
+func (b *Memory) Publish(topic string, body []byte) error {
+	return b.MultiPublish(topic, [][]byte{body})
+}
+
+func (b *Memory) MultiPublish(topic string, bodies [][]byte) error {
+	if len(bodies) == 0 {
+		return ErrEmptyBody
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.stopped {
+		return ErrStopped
+	}
+	t := b.topic(topic)
+	now := time.Now()
+	for _, body := range bodies {
+		b.seq++
+		id := fmt.Sprintf("%016x", b.seq)
+		if len(t.channels) == 0 {
+			t.backlog.Push(&memMessage{id: id, body: body, timestamp: now})
+			continue
+		}
+		for _, ch := range t.channels {
+			ch.push(&memMessage{id: id, body: body, timestamp: now})
+		}
+	}
+	return nil
+}
+
+// DeferredPublish publishes body after delay unless b was stopped by then.
+func (b *Memory) DeferredPublish(topic string, delay time.Duration, body []byte) error {
+	b.mu.Lock()
+	stopped := b.stopped
+	b.mu.Unlock()
+	if stopped {
+		return ErrStopped
+	}
+	time.AfterFunc(delay, func() { b.Publish(topic, body) })
+	return nil
+}
+
+// Stop makes publishing return ErrStopped, consumers keep getting what is queued.
+func (b *Memory) Stop() {
+	b.mu.Lock()
+	b.stopped = true
+	b.mu.Unlock()
+}
+
+// Depth is the number of messages waiting in a channel, or in the topic when channel is "".
+func (b *Memory) Depth(topic, channel string) int64 {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	t, ok := b.topics[topic]
+	if !ok {
+		return 0
+	}
+	if channel == "" {
+		return t.backlog.Len()
+	}
+	if ch, ok := t.channels[channel]; ok {
+		return ch.queue.Len()
+	}
+	return 0
+}
+
+// topic must be called with b.mu held.
+func (b *Memory) topic(name string) *memTopic {
+	t, ok := b.topics[name]
+	if !ok {
+		t = &memTopic{backlog: simple_impl.NewQueue(), channels: make(map[string]*memChannel)}
+		b.topics[name] = t
+	}
+	return t
+}
+
+// channel creates the channel on first use, the first channel of a topic takes its backlog.
+func (b *Memory) channel(topic, name string) *memChannel {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	t := b.topic(topic)
+	ch, ok := t.channels[name]
+	if ok {
+		return ch
+	}
+	ch = &memChannel{queue: simple_impl.NewQueue(), ready: make(chan struct{}, 1)}
+	if len(t.channels) == 0 {
+		for v := t.backlog.Pop(); v != nil; v = t.backlog.Pop() {
+			ch.queue.Push(v)
+		}
+		ch.signal()
+	}
+	t.channels[name] = ch
+	return ch
+}
+
+func (ch *memChannel) push(m *memMessage) {
+	ch.queue.Push(m)
+	ch.signal()
+}
+
+func (ch *memChannel) signal() {
+	select {
+	case ch.ready <- struct{}{}:
+	default:
+	}
+}
+
+type MemoryOptions struct {
+	Concurrency int // handler goroutines, 0 is 1
+	// MaxAttempts is the number of deliveries before a failing message is dropped, 0 retries forever
+	MaxAttempts  uint16
+	RequeueDelay time.Duration // Nack delay after a handler error
+	// BatchSize and BatchTimeout are used by NewBatchConsumer only
+	BatchSize    int           // 0 is 100
+	BatchTimeout time.Duration // 0 is 100ms, a batch is handled when it is full or its first message waited this long
+}
+
+type MemoryStats struct {
+	Handled  uint64
+	Failed   uint64
+	Requeued uint64
+	Dropped  uint64
+}
+
+func (s MemoryStats) String() string {
+	return fmt.Sprintf("handled=%d failed=%d requeued=%d dropped=%d", s.Handled, s.Failed, s.Requeued, s.Dropped)
+}
+
+// MemoryConsumer calls its handler for the messages of one channel of a Memory broker.
+type MemoryConsumer struct {
+	b       *Memory
+	topic   string
+	channel string
+	opts    MemoryOptions
+	h       Handler
+	bh      BatchHandler
+
+	ch       *memChannel
+	stop     chan struct{}
+	stopOnce sync.Once
+	wg       sync.WaitGroup
+
+	handled  uint64
+	failed   uint64
+	requeued uint64
+	dropped  uint64
+}
+
+// NewConsumer returns a consumer of topic/channel, the channel is created by Start.
+func (b *Memory) NewConsumer(topic, channel string, opts MemoryOptions, h Handler) *MemoryConsumer {
+	if opts.Concurrency <= 0 {
+		opts.Concurrency = 1
+	}
+	return &MemoryConsumer{b: b, topic: topic, channel: channel, opts: opts, h: h, stop: make(chan struct{})}
+}
+
+// NewBatchConsumer returns a consumer calling h with up to opts.BatchSize messages at a time.
+func (b *Memory) NewBatchConsumer(topic, channel string, opts MemoryOptions, h BatchHandler) *MemoryConsumer {
+	if opts.BatchSize <= 0 {
+		opts.BatchSize = 100
+	}
+	if opts.BatchTimeout <= 0 {
+		opts.BatchTimeout = 100 * time.Millisecond
+	}
+	c := b.NewConsumer(topic, channel, opts, nil)
+	c.bh = h
+	return c
+}
+
+func (b *Memory) Subscribe(topic, channel string, h Handler) (Consumer, error) {
+	return b.NewConsumer(topic, channel, b.ConsumerOptions, h), nil
+}
+
+func (b *Memory) SubscribeBatch(topic, channel string, h BatchHandler) (Consumer, error) {
+	return b.NewBatchConsumer(topic, channel, b.ConsumerOptions, h), nil
+}
+
+func (c *MemoryConsumer) Start() error {
+	if c.ch != nil {
+		return ErrStarted
+	}
+	c.ch = c.b.channel(c.topic, c.channel)
+	c.wg.Add(c.opts.Concurrency)
+	for i := 0; i < c.opts.Concurrency; i++ {
+		go func() {
+			defer c.wg.Done()
+			if c.bh != nil {
+				for ms := c.nextBatch(); ms != nil; ms = c.nextBatch() {
+					c.handleBatch(ms)
+				}
+				return
+			}
+			for m := c.next(); m != nil; m = c.next() {
+				c.handle(m)
+			}
+		}()
+	}
+	return nil
+}
+
+// Stop waits for the handlers running now, queued messages stay in the channel.
+func (c *MemoryConsumer) Stop() error {
+	c.stopOnce.Do(func() { close(c.stop) })
+	c.wg.Wait()
+	return nil
+}
+
+// next blocks until a message is queued, nil means c was stopped.
+func (c *MemoryConsumer) next() *memMessage {
+	for {
+		select {
+		case <-c.stop:
+			// 可能刚拿走了通知, 还给同一个channel的其他consumer
+			if c.ch.queue.Len() > 0 {
+				c.ch.signal()
+			}
+			return nil
+		default:
+		}
+		if v := c.ch.queue.Pop(); v != nil {
+			if c.ch.queue.Len() > 0 {
+				c.ch.signal()
+			}
+			return v.(*memMessage)
+		}
+		select {
+		case <-c.stop:
+		case <-c.ch.ready:
+		}
+	}
+}
+
+// nextBatch blocks for the first message, then takes more until the batch is full, BatchTimeout passed
+// since the first one or c was stopped. nil means c was stopped.
+func (c *MemoryConsumer) nextBatch() []*memMessage {
+	m := c.next()
+	if m == nil {
+		return nil
+	}
+	ms := []*memMessage{m}
+	timer := time.NewTimer(c.opts.BatchTimeout)
+	defer timer.Stop()
+loop:
+	for len(ms) < c.opts.BatchSize {
+		if v := c.ch.queue.Pop(); v != nil {
+			ms = append(ms, v.(*memMessage))
+			continue
+		}
+		select {
+		case <-c.stop:
+			break loop
+		case <-timer.C:
+			break loop
+		case <-c.ch.ready:
+		}
+	}
+	// 停了也把攒到的这批处理完, 剩下的通知给别的worker
+	if c.ch.queue.Len() > 0 {
+		c.ch.signal()
+	}
+	return ms
+}
+
+func (c *MemoryConsumer) handle(m *memMessage) {
+	d := c.deliver(m)
+	c.settle(d, Call(func() error { return c.h(d) }))
+}
+
+func (c *MemoryConsumer) handleBatch(ms []*memMessage) {
+	ds := make([]Message, len(ms))
+	for i, m := range ms {
+		ds[i] = c.deliver(m)
+	}
+	for i, err := range CallBatch(len(ds), func() []error { return c.bh(ds) }) {
+		c.settle(ds[i].(*delivery), err)
+	}
+}
+
+func (c *MemoryConsumer) deliver(m *memMessage) *delivery {
+	m.attempts++
+	return &delivery{m: m, ch: c.ch, attempts: m.attempts}
+}
+
+// settle counts the result of d and acks or requeues it unless the handler already did.
+func (c *MemoryConsumer) settle(d *delivery, err error) {
+	if err == nil {
+		atomic.AddUint64(&c.handled, 1)
+	} else {
+		atomic.AddUint64(&c.failed, 1)
+	}
+	if d.Responded() {
+		return
+	}
+	switch {
+	case err == nil:
+		d.Ack()
+	case c.opts.MaxAttempts > 0 && d.attempts >= c.opts.MaxAttempts:
+		atomic.AddUint64(&c.dropped, 1)
+		d.Ack()
+	default:
+		atomic.AddUint64(&c.requeued, 1)
+		d.Nack(c.opts.RequeueDelay)
+	}
+}
+
+func (c *MemoryConsumer) Stats() MemoryStats {
+	return MemoryStats{
+		Handled:  atomic.LoadUint64(&c.handled),
+		Failed:   atomic.LoadUint64(&c.failed),
+		Requeued: atomic.LoadUint64(&c.requeued),
+		Dropped:  atomic.LoadUint64(&c.dropped),
+	}
+}
+
+// delivery is one attempt of a memMessage, after Nack another worker may already own m.
+type delivery struct {
+	m         *memMessage
+	ch        *memChannel
+	attempts  uint16
+	responded int32
+}
+
+func (d *delivery) ID() string           { return d.m.id }
+func (d *delivery) Body() []byte         { return d.m.body }
+func (d *delivery) Attempts() uint16     { return d.attempts }
+func (d *delivery) Timestamp() time.Time { return d.m.timestamp }
+func (d *delivery) Touch()               {}
+
+func (d *delivery) Responded() bool {
+	return atomic.LoadInt32(&d.responded) == 1
+}
+
+func (d *delivery) Ack() {
+	atomic.CompareAndSwapInt32(&d.responded, 0, 1)
+}
+
+func (d *delivery) Nack(delay time.Duration) {
+	if !atomic.CompareAndSwapInt32(&d.responded, 0, 1) {
+		return
+	}
+	if delay <= 0 {
+		d.ch.push(d.m)
+		return
+	}
+	time.AfterFunc(delay, func() { d.ch.push(d.m) })
+}
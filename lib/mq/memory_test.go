@@ -0,0 +1,259 @@
+package mq
+
+import (
+	"errors"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func waitFor(t *testing.T, what string, cond func() bool) {
+	deadline := time.Now().Add(5 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+type bodies struct {
+	mu sync.Mutex
+	m  map[string]int
+}
+
+func (b *bodies) add(m Message) {
+	b.mu.Lock()
+	if b.m == nil {
+		b.m = make(map[string]int)
+	}
+	b.m[string(m.Body())]++
+	b.mu.Unlock()
+}
+
+func (b *bodies) len() int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return len(b.m)
+}
+
+func start(t *testing.T, b *Memory, channel string, opts MemoryOptions, h Handler) *MemoryConsumer {
+	c := b.NewConsumer("t", channel, opts, h)
+	assert.NoError(t, c.Start())
+	t.Cleanup(func() { c.Stop() })
+	return c
+}
+
+func TestMemoryPublishConsume(t *testing.T) {
+	b := NewMemory()
+	// 没有channel时留在topic里, 第一个channel全拿走
+	assert.NoError(t, b.Publish("t", []byte("a")))
+	assert.NoError(t, b.MultiPublish("t", [][]byte{[]byte("b"), []byte("c")}))
+	assert.Equal(t, ErrEmptyBody, b.MultiPublish("t", nil))
+	assert.Equal(t, int64(3), b.Depth("t", ""))
+
+	var got bodies
+	c := start(t, b, "ch", MemoryOptions{Concurrency: 4}, func(m Message) error {
+		got.add(m)
+		return nil
+	})
+	waitFor(t, "3 messages", func() bool { return c.Stats().Handled == 3 })
+	assert.Equal(t, 3, got.len())
+	assert.Equal(t, int64(0), b.Depth("t", ""))
+	assert.Equal(t, int64(0), b.Depth("t", "ch"))
+	assert.Equal(t, ErrStarted, c.Start())
+
+	for i := 0; i < 100; i++ {
+		b.Publish("t", []byte(strconv.Itoa(i)))
+	}
+	waitFor(t, "100 more", func() bool { return c.Stats().Handled == 103 })
+	assert.Equal(t, 103, got.len())
+}
+
+func TestMemoryTwoChannels(t *testing.T) {
+	b := NewMemory()
+	var a, o bodies
+	start(t, b, "ch", MemoryOptions{}, func(m Message) error { a.add(m); return nil })
+	start(t, b, "other", MemoryOptions{}, func(m Message) error { o.add(m); return nil })
+	b.MultiPublish("t", [][]byte{[]byte("1"), []byte("2"), []byte("3")})
+	waitFor(t, "both channels", func() bool { return a.len() == 3 && o.len() == 3 })
+}
+
+func TestMemoryNack(t *testing.T) {
+	b := NewMemory()
+	var mu sync.Mutex
+	attempts := make(map[string]uint16)
+	c := start(t, b, "ch", MemoryOptions{RequeueDelay: 10 * time.Millisecond}, func(m Message) error {
+		mu.Lock()
+		defer mu.Unlock()
+		attempts[string(m.Body())] = m.Attempts()
+		switch {
+		case string(m.Body()) == "manual" && m.Attempts() == 1:
+			m.Nack(0)
+			return nil
+		case m.Attempts() < 3 && string(m.Body()) != "manual":
+			return errors.New("again")
+		}
+		return nil
+	})
+	begin := time.Now()
+	b.Publish("t", []byte("a"))
+	b.Publish("t", []byte("manual"))
+	waitFor(t, "handled", func() bool { return c.Stats().Handled == 3 })
+	assert.True(t, time.Since(begin) >= 20*time.Millisecond)
+	mu.Lock()
+	assert.Equal(t, map[string]uint16{"a": 3, "manual": 2}, attempts)
+	mu.Unlock()
+	st := c.Stats()
+	assert.Equal(t, uint64(2), st.Failed)
+	// handler 自己 Nack 的不算在 Requeued 里
+	assert.Equal(t, uint64(2), st.Requeued)
+}
+
+func TestMemoryMaxAttempts(t *testing.T) {
+	b := NewMemory()
+	c := start(t, b, "ch", MemoryOptions{MaxAttempts: 3}, func(m Message) error {
+		if m.Attempts() == 1 {
+			panic("boom")
+		}
+		return errors.New("fail")
+	})
+	b.Publish("t", []byte("a"))
+	waitFor(t, "dropped", func() bool { return c.Stats().Dropped == 1 })
+	st := c.Stats()
+	assert.Equal(t, uint64(3), st.Failed)
+	assert.Equal(t, uint64(2), st.Requeued)
+	assert.Equal(t, "handled=0 failed=3 requeued=2 dropped=1", st.String())
+	assert.Equal(t, int64(0), b.Depth("t", "ch"))
+}
+
+func TestMemoryDeferredAndStop(t *testing.T) {
+	b := NewMemory()
+	var got bodies
+	c := start(t, b, "ch", MemoryOptions{}, func(m Message) error { got.add(m); return nil })
+	begin := time.Now()
+	assert.NoError(t, b.DeferredPublish("t", 30*time.Millisecond, []byte("d")))
+	waitFor(t, "deferred", func() bool { return got.len() == 1 })
+	assert.True(t, time.Since(begin) >= 30*time.Millisecond)
+
+	b.Stop()
+	assert.Equal(t, ErrStopped, b.Publish("t", []byte("x")))
+	assert.Equal(t, ErrStopped, b.DeferredPublish("t", time.Millisecond, []byte("x")))
+	assert.NoError(t, c.Stop())
+	assert.NoError(t, c.Stop())
+}
+
+func TestMemoryStopDrains(t *testing.T) {
+	b := NewMemory()
+	running := make(chan struct{}, 2)
+	c := b.NewConsumer("t", "ch", MemoryOptions{Concurrency: 2}, func(m Message) error {
+		running <- struct{}{}
+		time.Sleep(50 * time.Millisecond)
+		return nil
+	})
+	assert.NoError(t, c.Start())
+	for i := 0; i < 5; i++ {
+		b.Publish("t", []byte(strconv.Itoa(i)))
+	}
+	<-running
+	<-running
+	// 在处理的两条做完, 剩下的留在channel里给下一个consumer
+	assert.NoError(t, c.Stop())
+	assert.Equal(t, uint64(2), c.Stats().Handled)
+	assert.Equal(t, int64(3), b.Depth("t", "ch"))
+
+	var got bodies
+	start(t, b, "ch", MemoryOptions{}, func(m Message) error { got.add(m); return nil })
+	waitFor(t, "rest", func() bool { return got.len() == 3 })
+}
+
+func TestMemoryBatch(t *testing.T) {
+	b := NewMemory()
+	for i := 0; i < 10; i++ {
+		b.Publish("t", []byte(strconv.Itoa(i)))
+	}
+	var mu sync.Mutex
+	var sizes []int
+	var got bodies
+	c := b.NewBatchConsumer("t", "ch", MemoryOptions{BatchSize: 4, BatchTimeout: 20 * time.Millisecond}, func(ms []Message) []error {
+		mu.Lock()
+		sizes = append(sizes, len(ms))
+		mu.Unlock()
+		if string(ms[0].Body()) == "8" && ms[0].Attempts() == 1 {
+			// 少返回结果整批失败
+			return []error{nil}
+		}
+		errs := make([]error, len(ms))
+		for i, m := range ms {
+			// 第二批第一条失败一次
+			if string(m.Body()) == "4" && m.Attempts() == 1 {
+				errs[i] = errors.New("again")
+				continue
+			}
+			got.add(m)
+		}
+		return errs
+	})
+	assert.NoError(t, c.Start())
+	defer c.Stop()
+	waitFor(t, "10 messages", func() bool { return got.len() == 10 })
+	mu.Lock()
+	// 4 重排到队尾, 和 8 9 凑一批整批失败, 再来一次
+	assert.Equal(t, []int{4, 4, 3, 3}, sizes)
+	mu.Unlock()
+	st := c.Stats()
+	assert.Equal(t, uint64(4), st.Failed)
+	assert.Equal(t, uint64(4), st.Requeued)
+	assert.Equal(t, int64(0), b.Depth("t", "ch"))
+
+	// 不满一批等 BatchTimeout
+	begin := time.Now()
+	b.Publish("t", []byte("x"))
+	waitFor(t, "x", func() bool { return got.len() == 11 })
+	assert.True(t, time.Since(begin) >= 20*time.Millisecond)
+}
+
+func TestMemorySubscribe(t *testing.T) {
+	b := NewMemory()
+	b.ConsumerOptions = MemoryOptions{Concurrency: 2, BatchSize: 3}
+	var s Subscriber = b
+	var got bodies
+	c, err := s.Subscribe("t", "ch", func(m Message) error { got.add(m); return nil })
+	assert.NoError(t, err)
+	assert.NoError(t, c.Start())
+	defer c.Stop()
+	bc, err := s.SubscribeBatch("t", "batch", func(ms []Message) []error {
+		assert.True(t, len(ms) <= 3)
+		for _, m := range ms {
+			got.add(m)
+		}
+		return nil
+	})
+	assert.NoError(t, err)
+	assert.NoError(t, bc.Start())
+	defer bc.Stop()
+	for i := 0; i < 5; i++ {
+		b.Publish("t", []byte(strconv.Itoa(i)))
+	}
+	waitFor(t, "both channels", func() bool {
+		return c.(*MemoryConsumer).Stats().Handled == 5 && bc.(*MemoryConsumer).Stats().Handled == 5
+	})
+	assert.Equal(t, 5, got.len())
+}
+
+func TestBatcherMemory(t *testing.T) {
+	b := NewMemory()
+	bt := NewBatcher(b, "t", 3, time.Hour, nil)
+	assert.NoError(t, bt.Add([]byte("1")))
+	assert.NoError(t, bt.Add([]byte("2")))
+	assert.Equal(t, int64(0), b.Depth("t", ""))
+	assert.NoError(t, bt.Add([]byte("3")))
+	assert.Equal(t, int64(3), b.Depth("t", ""))
+	assert.NoError(t, bt.Add([]byte("4")))
+	assert.NoError(t, bt.Stop())
+	assert.Equal(t, int64(4), b.Depth("t", ""))
+	assert.Equal(t, ErrStopped, bt.Add([]byte("5")))
+}
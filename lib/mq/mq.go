@@ -0,0 +1,116 @@
+package mq
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+/*
+	消息队列的抽象, 业务代码只依赖这里的接口, 换broker时只换构造的那一行
+
+	nsq:    lib/mq/nsqmq, 发送用 nsqpub, 消费用 nsqsub (重试退避, 死信, 攒批)
+	内存:   mq.NewMemory(), 基于 simple_impl.Queue, 单测里代替nsqd
+
+	var p mq.Producer = mq.NewMemory()    // 或 nsqmq.NewProducer(nsqpub.Options{...})
+	p.Publish("topic", []byte("x"))
+	p.DeferredPublish("topic", time.Second, []byte("x"))
+
+	handler := func(m mq.Message) error {
+		// 返回 nil 算成功, 返回 error 由后端按自己的策略重投
+		// 也可以自己 m.Ack() 或 m.Nack(delay) 决定什么时候重投, 之后返回值只计数
+		return nil
+	}
+	var s mq.Subscriber = mq.NewMemory()    // 或 nsqmq.NewSubscriber(nsqsub.Options{...})
+	c, err := s.Subscribe("topic", "ch", handler)
+	c.Start()
+	c.Stop()
+
+	并发, 重试, 攒批这些后端自己的参数在构造 Subscriber 时给, Subscribe 只管topic和channel
+*/
+
+var (
+	ErrStopped   = errors.New("mq: producer stopped")
+	ErrEmptyBody = errors.New("mq: empty message list")
+)
+
+// Message is a delivered message, it is acked or nacked exactly once;
+// calls after the first response are ignored.
+type Message interface {
+	ID() string
+	Body() []byte
+	// Attempts counts deliveries, 1 on the first one
+	Attempts() uint16
+	Timestamp() time.Time
+
+	Ack()
+	// Nack redelivers the message after delay
+	Nack(delay time.Duration)
+	// Touch extends the time the broker waits for a response, a no-op where there is no such timeout
+	Touch()
+	// Responded reports whether Ack or Nack was called
+	Responded() bool
+}
+
+// Handler returns nil to ack m and an error to let the backend retry it,
+// the result only counts in stats when the handler responded itself.
+type Handler func(m Message) error
+
+// BatchHandler returns one error per message, a nil slice means all succeeded.
+type BatchHandler func(ms []Message) []error
+
+type Producer interface {
+	Publish(topic string, body []byte) error
+	MultiPublish(topic string, bodies [][]byte) error
+	DeferredPublish(topic string, delay time.Duration, body []byte) error
+	Stop()
+}
+
+type Consumer interface {
+	Start() error
+	// Stop stops taking messages and returns after the ones in flight were handled
+	Stop() error
+}
+
+// Subscriber creates the consumers of a backend, they are not started.
+type Subscriber interface {
+	Subscribe(topic, channel string, h Handler) (Consumer, error)
+	// SubscribeBatch calls h with up to the backend's batch size messages at a time
+	SubscribeBatch(topic, channel string, h BatchHandler) (Consumer, error)
+}
+
+// Call runs a handler, a panic is returned as an error. Shared by the backends.
+func Call(h func() error) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("mq: handler panic: %v", r)
+		}
+	}()
+	return h()
+}
+
+// CallBatch runs a batch handler for n messages and returns exactly n results,
+// a panic or a wrong number of results fails the whole batch.
+func CallBatch(n int, h func() []error) (errs []error) {
+	defer func() {
+		if r := recover(); r != nil {
+			errs = fill(n, fmt.Errorf("mq: batch handler panic: %v", r))
+		}
+	}()
+	errs = h()
+	if errs == nil {
+		return make([]error, n)
+	}
+	if len(errs) != n {
+		return fill(n, fmt.Errorf("mq: batch handler returned %d results for %d messages", len(errs), n))
+	}
+	return errs
+}
+
+func fill(n int, err error) []error {
+	errs := make([]error, n)
+	for i := range errs {
+		errs[i] = err
+	}
+	return errs
+}
@@ -0,0 +1,25 @@
+package mq
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCall(t *testing.T) {
+	assert.NoError(t, Call(func() error { return nil }))
+	assert.EqualError(t, Call(func() error { panic("boom") }), "mq: handler panic: boom")
+
+	assert.Equal(t, []error{nil, nil}, CallBatch(2, func() []error { return nil }))
+	fail := errors.New("fail")
+	assert.Equal(t, []error{nil, fail}, CallBatch(2, func() []error { return []error{nil, fail} }))
+	errs := CallBatch(2, func() []error { return []error{fail} })
+	if assert.Equal(t, 2, len(errs)) {
+		assert.EqualError(t, errs[1], "mq: batch handler returned 1 results for 2 messages")
+	}
+	errs = CallBatch(2, func() []error { panic("boom") })
+	if assert.Equal(t, 2, len(errs)) {
+		assert.EqualError(t, errs[0], "mq: batch handler panic: boom")
+	}
+}
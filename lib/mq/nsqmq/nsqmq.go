@@ -0,0 +1,109 @@
+package nsqmq
+
+import (
+	"time"
+
+	"github.com/buptbill220/go_performance/lib/mq"
+	"github.com/buptbill220/go_performance/lib/nsqpub"
+	"github.com/buptbill220/go_performance/lib/nsqsub"
+	"github.com/nsqio/go-nsq"
+)
+
+/*
+	mq 的nsq后端, 发送是 nsqpub (多节点切换), 消费是 nsqsub (重试退避, 死信, 排空, 攒批)
+
+	p, err := nsqmq.NewProducer(nsqpub.Options{Addrs: []string{"127.0.0.1:4150"}})
+	c, err := nsqmq.NewConsumer(nsqsub.Options{
+		Topic:   "topic",
+		Channel: "ch",
+		Lookupd: []string{"127.0.0.1:4161"},
+	}, func(m mq.Message) error { ... })
+	c.Start()
+
+	只认 mq 接口的地方用 Subscriber, nsqsub.Options 里除了 Topic 和 Channel 都是公共的
+	var s mq.Subscriber = nsqmq.NewSubscriber(nsqsub.Options{Lookupd: []string{"127.0.0.1:4161"}})
+	c, err := s.SubscribeBatch("topic", "ch", batchHandler)
+
+	Nack 是 REQ 不带go-nsq的退避, handler 返回 error 时按 nsqsub 的 BackoffBase 重排
+*/
+
+var (
+	_ mq.Producer   = (*nsqpub.Publisher)(nil)
+	_ mq.Consumer   = (*nsqsub.Consumer)(nil)
+	_ mq.Subscriber = (*Subscriber)(nil)
+)
+
+func NewProducer(opts nsqpub.Options) (*nsqpub.Publisher, error) {
+	return nsqpub.New(opts)
+}
+
+// NewConsumer returns a nsqsub.Consumer calling h, its Stats stay available.
+func NewConsumer(opts nsqsub.Options, h mq.Handler) (*nsqsub.Consumer, error) {
+	return nsqsub.New(opts, func(m *nsq.Message) error {
+		return h(Wrap(m))
+	})
+}
+
+// NewBatchConsumer is NewConsumer for nsqsub.NewBatch.
+func NewBatchConsumer(opts nsqsub.Options, h mq.BatchHandler) (*nsqsub.Consumer, error) {
+	return nsqsub.NewBatch(opts, nsqsub.BatchHandlerFunc(func(ms []*nsq.Message) []error {
+		wrapped := make([]mq.Message, len(ms))
+		for i, m := range ms {
+			wrapped[i] = Wrap(m)
+		}
+		return h(wrapped)
+	}))
+}
+
+// Subscriber creates nsqsub consumers from one set of options, the consumers are *nsqsub.Consumer.
+type Subscriber struct {
+	opts nsqsub.Options
+}
+
+// NewSubscriber ignores opts.Topic and opts.Channel, every Subscribe sets its own.
+func NewSubscriber(opts nsqsub.Options) *Subscriber {
+	return &Subscriber{opts: opts}
+}
+
+func (s *Subscriber) Subscribe(topic, channel string, h mq.Handler) (mq.Consumer, error) {
+	c, err := NewConsumer(s.options(topic, channel), h)
+	if err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+func (s *Subscriber) SubscribeBatch(topic, channel string, h mq.BatchHandler) (mq.Consumer, error) {
+	c, err := NewBatchConsumer(s.options(topic, channel), h)
+	if err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+func (s *Subscriber) options(topic, channel string) nsqsub.Options {
+	o := s.opts
+	o.Topic, o.Channel = topic, channel
+	return o
+}
+
+// Wrap returns m as a mq.Message, m must have auto response disabled for Ack and Nack to stick.
+func Wrap(m *nsq.Message) mq.Message {
+	return message{m}
+}
+
+type message struct {
+	m *nsq.Message
+}
+
+func (m message) ID() string           { return string(m.m.ID[:]) }
+func (m message) Body() []byte         { return m.m.Body }
+func (m message) Attempts() uint16     { return m.m.Attempts }
+func (m message) Timestamp() time.Time { return time.Unix(0, m.m.Timestamp) }
+func (m message) Ack()                 { m.m.Finish() }
+func (m message) Touch()               { m.m.Touch() }
+func (m message) Responded() bool      { return m.m.HasResponded() }
+
+func (m message) Nack(delay time.Duration) {
+	m.m.RequeueWithoutBackoff(delay)
+}
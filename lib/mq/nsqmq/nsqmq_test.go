@@ -0,0 +1,162 @@
+package nsqmq
+
+import (
+	"errors"
+	"io/ioutil"
+	"log"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/buptbill220/go_performance/lib/mq"
+	"github.com/buptbill220/go_performance/lib/nsqfake"
+	"github.com/buptbill220/go_performance/lib/nsqpub"
+	"github.com/buptbill220/go_performance/lib/nsqsub"
+	"github.com/stretchr/testify/assert"
+)
+
+func startNSQD(t *testing.T) *nsqfake.NSQD {
+	n, err := nsqfake.NewNSQD("127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(n.Close)
+	return n
+}
+
+func testOptions(n *nsqfake.NSQD) nsqsub.Options {
+	return nsqsub.Options{
+		Topic:        "t",
+		Channel:      "ch",
+		NSQD:         []string{n.Addr()},
+		Concurrency:  2,
+		BackoffBase:  time.Millisecond,
+		BackoffMax:   4 * time.Millisecond,
+		DrainTimeout: time.Second,
+		Logger:       log.New(ioutil.Discard, "", 0),
+	}
+}
+
+func waitFor(t *testing.T, what string, cond func() bool) {
+	deadline := time.Now().Add(5 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestProducerConsumer(t *testing.T) {
+	n := startNSQD(t)
+	var p mq.Producer
+	p, err := NewProducer(nsqpub.Options{Addrs: []string{n.Addr()}, Logger: log.New(ioutil.Discard, "", 0)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer p.Stop()
+	assert.NoError(t, p.Publish("t", []byte("a")))
+	assert.NoError(t, p.MultiPublish("t", [][]byte{[]byte("b"), []byte("c")}))
+	assert.NoError(t, p.DeferredPublish("t", 10*time.Millisecond, []byte("d")))
+
+	var mu sync.Mutex
+	got := make(map[string]uint16)
+	c, err := NewConsumer(testOptions(n), func(m mq.Message) error {
+		mu.Lock()
+		defer mu.Unlock()
+		got[string(m.Body())] = m.Attempts()
+		assert.Equal(t, 16, len(m.ID()))
+		assert.False(t, m.Timestamp().IsZero())
+		switch {
+		case string(m.Body()) == "a" && m.Attempts() == 1:
+			// handler 自己 REQ, 返回值只计数
+			m.Nack(0)
+			assert.True(t, m.Responded())
+		case string(m.Body()) == "b" && m.Attempts() == 1:
+			return errors.New("again")
+		case string(m.Body()) == "c":
+			m.Touch()
+			m.Ack()
+			return errors.New("ignored")
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var _ mq.Consumer = c
+	assert.NoError(t, c.Start())
+	waitFor(t, "finish", func() bool { return n.Channel("t", "ch").Finished == 4 })
+	assert.NoError(t, c.Stop())
+
+	mu.Lock()
+	assert.Equal(t, map[string]uint16{"a": 2, "b": 2, "c": 1, "d": 1}, got)
+	mu.Unlock()
+	assert.Equal(t, 2, n.Channel("t", "ch").Requeued)
+	st := c.Stats()
+	assert.Equal(t, uint64(4), st.Handled)
+	assert.Equal(t, uint64(2), st.Failed)
+	assert.Equal(t, uint64(1), st.Requeued)
+}
+
+func TestBatchConsumer(t *testing.T) {
+	n := startNSQD(t)
+	o := testOptions(n)
+	o.Concurrency = 1
+	o.BatchSize = 4
+	o.BatchTimeout = 100 * time.Millisecond
+	var mu sync.Mutex
+	var sizes []int
+	c, err := NewBatchConsumer(o, func(ms []mq.Message) []error {
+		mu.Lock()
+		sizes = append(sizes, len(ms))
+		mu.Unlock()
+		errs := make([]error, len(ms))
+		if ms[0].Attempts() == 1 {
+			errs[0] = errors.New("first one again")
+		}
+		return errs
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	n.Publish("t", []byte("1"), []byte("2"), []byte("3"), []byte("4"))
+	assert.NoError(t, c.Start())
+	waitFor(t, "finish", func() bool { return n.Channel("t", "ch").Finished == 4 })
+	assert.NoError(t, c.Stop())
+	mu.Lock()
+	defer mu.Unlock()
+	assert.Equal(t, 4, sizes[0])
+	assert.Equal(t, 1, n.Channel("t", "ch").Requeued)
+}
+
+func TestSubscriber(t *testing.T) {
+	n := startNSQD(t)
+	o := testOptions(n)
+	o.Topic, o.Channel = "ignored", "ignored"
+	var s mq.Subscriber = NewSubscriber(o)
+	c, err := s.Subscribe("t", "ch", func(m mq.Message) error { return nil })
+	if err != nil {
+		t.Fatal(err)
+	}
+	bc, err := s.SubscribeBatch("t2", "ch", func(ms []mq.Message) []error { return nil })
+	if err != nil {
+		t.Fatal(err)
+	}
+	n.Publish("t", []byte("1"), []byte("2"))
+	n.Publish("t2", []byte("1"), []byte("2"))
+	assert.NoError(t, c.Start())
+	assert.NoError(t, bc.Start())
+	waitFor(t, "finish", func() bool {
+		return n.Channel("t", "ch").Finished == 2 && n.Channel("t2", "ch").Finished == 2
+	})
+	assert.NoError(t, c.Stop())
+	assert.NoError(t, bc.Stop())
+	assert.Equal(t, uint64(2), c.(*nsqsub.Consumer).Stats().Handled)
+
+	o.MaxInFlight = 1
+	_, err = NewSubscriber(o).Subscribe("t", "ch", func(m mq.Message) error { return nil })
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(), nsqsub.ErrConcurrency.Error())
+	}
+}
@@ -0,0 +1,238 @@
+package nsqbench
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math/rand"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/buptbill220/go_performance/lib/schedprobe"
+)
+
+/*
+	端到端压测nsq: 生产者在消息体里带上发送时间和序号, 消费者统计吞吐, 端到端延迟, 重复和丢失
+
+	enc := nsqbench.NewEncoder(256)
+	p.Publish(topic, enc.Next())
+
+	tr := nsqbench.NewTracker()
+	func(m *nsq.Message) error { tr.Record(m.Body, time.Now()); return nil }
+	fmt.Println(tr.Report())
+
+	消息体: "nsqb" | producer id | seq | 发送时间(ns) | 填充到 size, 都是大端
+	每个 Encoder 有随机的 producer id, 序号从0开始, 按 producer 分别算重复和丢失;
+	最大序号之后丢的消息看不出来, 知道发了多少条时用 Report.Unique 对比;
+	发失败的消息要 Undo 把序号还回去, 不然消费端会算成丢失
+	序号比这个producer已见的最大序号大 MaxSeqGap 以上的消息算 Invalid, 免得一个坏序号让bitmap占满内存
+	生产者和消费者在不同机器上时延迟包含两边的时钟差
+*/
+
+const (
+	magic = "nsqb"
+	// HeaderSize is the smallest body, a larger size is padded
+	HeaderSize = len(magic) + 24
+)
+
+// MaxSeqGap bounds how far past the highest sequence number seen a message may be, the bitmap of a
+// producer grows to the highest sequence number.
+const MaxSeqGap = 1 << 24
+
+var (
+	ErrInvalid = errors.New("nsqbench: not a benchmark message")
+	ErrSeqGap  = errors.New("nsqbench: sequence number too far past the highest seen")
+)
+
+type Header struct {
+	Producer uint64
+	Seq      uint64
+	Sent     time.Time
+}
+
+type Encoder struct {
+	id   uint64
+	seq  uint64
+	size int
+}
+
+// NewEncoder returns an Encoder whose bodies are size bytes, at least HeaderSize.
+func NewEncoder(size int) *Encoder {
+	if size < HeaderSize {
+		size = HeaderSize
+	}
+	return &Encoder{id: rand.New(rand.NewSource(time.Now().UnixNano())).Uint64(), size: size}
+}
+
+func (e *Encoder) ID() uint64 {
+	return e.id
+}
+
+// Sent is the number of bodies returned by Next.
+func (e *Encoder) Sent() uint64 {
+	return atomic.LoadUint64(&e.seq)
+}
+
+// Next returns a body with the next sequence number stamped with the current time.
+func (e *Encoder) Next() []byte {
+	seq := atomic.AddUint64(&e.seq, 1) - 1
+	return Encode(Header{Producer: e.id, Seq: seq, Sent: time.Now()}, e.size)
+}
+
+// Undo takes back the sequence number of the last Next whose body failed to publish, so the next
+// body reuses it and the consumer does not count a gap. Only when Next is called from one goroutine.
+func (e *Encoder) Undo() {
+	atomic.AddUint64(&e.seq, ^uint64(0))
+}
+
+func Encode(h Header, size int) []byte {
+	if size < HeaderSize {
+		size = HeaderSize
+	}
+	b := make([]byte, size)
+	copy(b, magic)
+	binary.BigEndian.PutUint64(b[4:], h.Producer)
+	binary.BigEndian.PutUint64(b[12:], h.Seq)
+	binary.BigEndian.PutUint64(b[20:], uint64(h.Sent.UnixNano()))
+	return b
+}
+
+func Decode(b []byte) (Header, error) {
+	if len(b) < HeaderSize || string(b[:4]) != magic {
+		return Header{}, ErrInvalid
+	}
+	return Header{
+		Producer: binary.BigEndian.Uint64(b[4:]),
+		Seq:      binary.BigEndian.Uint64(b[12:]),
+		Sent:     time.Unix(0, int64(binary.BigEndian.Uint64(b[20:]))),
+	}, nil
+}
+
+// stream tracks the sequence numbers seen from one producer.
+type stream struct {
+	seen   []uint64 // bitmap
+	unique uint64
+	maxSeq uint64
+}
+
+// add returns false for a duplicate.
+func (s *stream) add(seq uint64) bool {
+	w := int(seq / 64)
+	if w >= len(s.seen) {
+		n := 2 * len(s.seen)
+		if n <= w {
+			n = w + 1
+		}
+		seen := make([]uint64, n)
+		copy(seen, s.seen)
+		s.seen = seen
+	}
+	bit := uint64(1) << (seq % 64)
+	if s.seen[w]&bit != 0 {
+		return false
+	}
+	s.seen[w] |= bit
+	s.unique++
+	if seq > s.maxSeq {
+		s.maxSeq = seq
+	}
+	return true
+}
+
+// Tracker is safe for concurrent use.
+type Tracker struct {
+	mu          sync.Mutex
+	streams     map[uint64]*stream
+	latency     schedprobe.Histogram
+	received    uint64
+	unique      uint64
+	duplicates  uint64
+	invalid     uint64
+	first, last time.Time
+}
+
+func NewTracker() *Tracker {
+	return &Tracker{streams: make(map[uint64]*stream)}
+}
+
+// Record counts a received body, now is when it was received.
+func (t *Tracker) Record(body []byte, now time.Time) (Header, error) {
+	h, err := Decode(body)
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if err != nil {
+		t.invalid++
+		return h, err
+	}
+	s := t.streams[h.Producer]
+	var maxSeq uint64
+	if s != nil {
+		maxSeq = s.maxSeq
+	}
+	if h.Seq > maxSeq+MaxSeqGap {
+		t.invalid++
+		return h, ErrSeqGap
+	}
+	t.received++
+	if t.first.IsZero() {
+		t.first = now
+	}
+	t.last = now
+	if s == nil {
+		s = &stream{}
+		t.streams[h.Producer] = s
+	}
+	if !s.add(h.Seq) {
+		t.duplicates++
+		return h, nil
+	}
+	t.unique++
+	t.latency.Record(now.Sub(h.Sent))
+	return h, nil
+}
+
+// Unique is the number of distinct messages received, cheaper than Report.
+func (t *Tracker) Unique() uint64 {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.unique
+}
+
+type Report struct {
+	Producers  int
+	Received   uint64 // including duplicates
+	Unique     uint64
+	Duplicates uint64
+	Lost       uint64 // gaps below the highest sequence number of each producer
+	Invalid    uint64
+	Elapsed    time.Duration // first to last received
+	Throughput float64       // unique messages per second over Elapsed
+	Latency    schedprobe.Summary
+}
+
+func (r Report) String() string {
+	return fmt.Sprintf("received=%d unique=%d dup=%d lost=%d invalid=%d producers=%d elapsed=%v throughput=%.0f/s\nlatency: %v",
+		r.Received, r.Unique, r.Duplicates, r.Lost, r.Invalid, r.Producers, r.Elapsed.Round(time.Millisecond), r.Throughput, r.Latency)
+}
+
+func (t *Tracker) Report() Report {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	r := Report{
+		Producers:  len(t.streams),
+		Received:   t.received,
+		Unique:     t.unique,
+		Duplicates: t.duplicates,
+		Invalid:    t.invalid,
+		Elapsed:    t.last.Sub(t.first),
+		Latency:    schedprobe.Summarize(&t.latency),
+	}
+	for _, s := range t.streams {
+		r.Lost += s.maxSeq + 1 - s.unique
+	}
+	if r.Elapsed > 0 {
+		r.Throughput = float64(r.Unique) / r.Elapsed.Seconds()
+	}
+	return r
+}
@@ -0,0 +1,107 @@
+package nsqbench
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestEncodeDecode(t *testing.T) {
+	sent := time.Unix(100, 12345)
+	b := Encode(Header{Producer: 7, Seq: 42, Sent: sent}, 100)
+	assert.Equal(t, 100, len(b))
+	h, err := Decode(b)
+	assert.NoError(t, err)
+	assert.Equal(t, uint64(7), h.Producer)
+	assert.Equal(t, uint64(42), h.Seq)
+	assert.True(t, sent.Equal(h.Sent))
+
+	assert.Equal(t, HeaderSize, len(Encode(h, 1)))
+	_, err = Decode([]byte("0-p"))
+	assert.Equal(t, ErrInvalid, err)
+}
+
+func TestEncoder(t *testing.T) {
+	e := NewEncoder(0)
+	for i := 0; i < 3; i++ {
+		h, err := Decode(e.Next())
+		assert.NoError(t, err)
+		assert.Equal(t, uint64(i), h.Seq)
+		assert.Equal(t, e.ID(), h.Producer)
+	}
+	assert.Equal(t, uint64(3), e.Sent())
+	e.Next()
+	e.Undo()
+	h, _ := Decode(e.Next())
+	assert.Equal(t, uint64(3), h.Seq)
+	assert.NotEqual(t, e.ID(), NewEncoder(0).ID())
+}
+
+func TestTracker(t *testing.T) {
+	tr := NewTracker()
+	start := time.Unix(1000, 0)
+	rec := func(producer, seq uint64, latency, at time.Duration) {
+		b := Encode(Header{Producer: producer, Seq: seq, Sent: start.Add(at - latency)}, 0)
+		_, err := tr.Record(b, start.Add(at))
+		assert.NoError(t, err)
+	}
+	// producer 1: 0 1 3 3 5, 丢了 2 和 4
+	rec(1, 0, time.Millisecond, 0)
+	rec(1, 1, time.Millisecond, 100*time.Millisecond)
+	rec(1, 3, 2*time.Millisecond, 200*time.Millisecond)
+	rec(1, 3, 2*time.Millisecond, 300*time.Millisecond)
+	rec(1, 5, 3*time.Millisecond, 400*time.Millisecond)
+	// producer 2: 0 1
+	rec(2, 1, time.Millisecond, 450*time.Millisecond)
+	rec(2, 0, time.Millisecond, 500*time.Millisecond)
+	tr.Record([]byte("junk"), start)
+	// 坏序号不分配bitmap, 也不算新的producer
+	_, err := tr.Record(Encode(Header{Producer: 1, Seq: 5 + MaxSeqGap + 1}, 0), start)
+	assert.Equal(t, ErrSeqGap, err)
+	_, err = tr.Record(Encode(Header{Producer: 3, Seq: 1 << 62}, 0), start)
+	assert.Equal(t, ErrSeqGap, err)
+	rec(1, 5+MaxSeqGap, time.Millisecond, 500*time.Millisecond)
+
+	r := tr.Report()
+	assert.Equal(t, 2, r.Producers)
+	assert.Equal(t, uint64(8), r.Received)
+	assert.Equal(t, uint64(7), r.Unique)
+	assert.Equal(t, uint64(1), r.Duplicates)
+	assert.Equal(t, uint64(2+MaxSeqGap-1), r.Lost)
+	assert.Equal(t, uint64(3), r.Invalid)
+	assert.Equal(t, 500*time.Millisecond, r.Elapsed)
+	assert.InDelta(t, 14, r.Throughput, 0.01)
+	assert.Equal(t, uint64(7), r.Latency.Count)
+	assert.InDelta(t, float64(3*time.Millisecond), float64(r.Latency.Max), float64(50*time.Microsecond))
+}
+
+func TestStreamGrow(t *testing.T) {
+	var s stream
+	assert.True(t, s.add(1000))
+	assert.True(t, s.add(3))
+	assert.False(t, s.add(1000))
+	assert.True(t, s.add(100000))
+	assert.Equal(t, uint64(3), s.unique)
+	assert.Equal(t, uint64(100000), s.maxSeq)
+}
+
+func TestTrackerConcurrent(t *testing.T) {
+	tr := NewTracker()
+	e := NewEncoder(64)
+	var wg sync.WaitGroup
+	for g := 0; g < 4; g++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < 1000; i++ {
+				tr.Record(e.Next(), time.Now())
+			}
+		}()
+	}
+	wg.Wait()
+	r := tr.Report()
+	assert.Equal(t, uint64(4000), r.Unique)
+	assert.Equal(t, uint64(0), r.Lost+r.Duplicates)
+}
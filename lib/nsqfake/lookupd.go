@@ -0,0 +1,121 @@
+package nsqfake
+
+import (
+	"encoding/json"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+)
+
+// Lookupd is a fake nsqlookupd HTTP endpoint, /lookup?topic= lists the
+// added NSQDs that have the topic, like nsqd registering its topics.
+type Lookupd struct {
+	ln  net.Listener
+	srv *http.Server
+
+	mu      sync.Mutex
+	nsqds   []*NSQD
+	lookups int
+}
+
+type peerInfo struct {
+	RemoteAddress    string `json:"remote_address"`
+	Hostname         string `json:"hostname"`
+	BroadcastAddress string `json:"broadcast_address"`
+	TCPPort          int    `json:"tcp_port"`
+	HTTPPort         int    `json:"http_port"`
+	Version          string `json:"version"`
+}
+
+type lookupResp struct {
+	Channels  []string    `json:"channels"`
+	Producers []*peerInfo `json:"producers"`
+}
+
+// NewLookupd listens on addr for HTTP, "127.0.0.1:0" picks a free port.
+func NewLookupd(addr string, nsqds ...*NSQD) (*Lookupd, error) {
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	l := &Lookupd{ln: ln, nsqds: nsqds}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/ping", func(w http.ResponseWriter, r *http.Request) {
+		w.Write(okResp)
+	})
+	mux.HandleFunc("/lookup", l.lookup)
+	l.srv = &http.Server{Handler: mux}
+	go l.srv.Serve(ln)
+	return l, nil
+}
+
+// Addr is the HTTP address to give to ConnectToNSQLookupd.
+func (l *Lookupd) Addr() string {
+	return l.ln.Addr().String()
+}
+
+func (l *Lookupd) Add(n *NSQD) {
+	l.mu.Lock()
+	l.nsqds = append(l.nsqds, n)
+	l.mu.Unlock()
+}
+
+// Lookups returns the number of /lookup requests served.
+func (l *Lookupd) Lookups() int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.lookups
+}
+
+func (l *Lookupd) Close() {
+	l.srv.Close()
+}
+
+func (l *Lookupd) lookup(w http.ResponseWriter, r *http.Request) {
+	l.mu.Lock()
+	l.lookups++
+	nsqds := append([]*NSQD(nil), l.nsqds...)
+	l.mu.Unlock()
+
+	topic := r.URL.Query().Get("topic")
+	if topic == "" {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"message": "MISSING_ARG_TOPIC"})
+		return
+	}
+	resp := lookupResp{Channels: []string{}, Producers: []*peerInfo{}}
+	seen := make(map[string]bool)
+	for _, n := range nsqds {
+		channels, ok := n.hasTopic(topic)
+		if !ok {
+			continue
+		}
+		host, port, _ := net.SplitHostPort(n.Addr())
+		tcpPort, _ := strconv.Atoi(port)
+		resp.Producers = append(resp.Producers, &peerInfo{
+			RemoteAddress:    n.Addr(),
+			Hostname:         host,
+			BroadcastAddress: host,
+			TCPPort:          tcpPort,
+			Version:          "fake",
+		})
+		for _, ch := range channels {
+			if !seen[ch] {
+				seen[ch] = true
+				resp.Channels = append(resp.Channels, ch)
+			}
+		}
+	}
+	if len(resp.Producers) == 0 {
+		writeJSON(w, http.StatusNotFound, map[string]string{"message": "TOPIC_NOT_FOUND"})
+		return
+	}
+	writeJSON(w, http.StatusOK, resp)
+}
+
+func writeJSON(w http.ResponseWriter, code int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.Header().Set("X-NSQ-Content-Type", "nsq; version=1.0")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(v)
+}
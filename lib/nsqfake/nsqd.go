@@ -0,0 +1,755 @@
+package nsqfake
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"regexp"
+	"strconv"
+	"sync"
+	"time"
+)
+
+/*
+	进程内的假nsqd, 说的是nsqd的TCP协议(V2), 测试生产者和消费者不用起真的nsqd和nsqlookupd
+
+	n, _ := nsqfake.NewNSQD("127.0.0.1:0")
+	defer n.Close()
+	p, _ := nsq.NewProducer(n.Addr(), nsq.NewConfig())
+	p.Publish("topic", []byte("x"))
+	n.Messages("topic")
+
+	c, _ := nsq.NewConsumer("topic", "ch", nsq.NewConfig())
+	c.ConnectToNSQD(n.Addr())
+	n.Channel("topic", "ch")    // Depth, InFlight, Finished, Requeued, TimedOut
+
+	支持 IDENTIFY, SUB, PUB, MPUB, DPUB, RDY, FIN, REQ, TOUCH, NOP, CLS
+	和nsqd一样每个channel拿到topic的一份消息, 没有channel时消息留在topic里; 没有TLS, 压缩和认证
+	SetLatency/SetFailPub 用来模拟慢节点和发布失败, Close 后用同一个地址 NewNSQD 模拟节点恢复
+	nsqlookupd 用 NewLookupd(addr, nsqds...), /lookup 只返回有这个topic的nsqd
+*/
+
+const (
+	frameTypeResponse = 0
+	frameTypeError    = 1
+	frameTypeMessage  = 2
+
+	msgIDLength = 16
+	// defaultMsgTimeout is used when IDENTIFY does not set msg_timeout
+	defaultMsgTimeout = 60 * time.Second
+)
+
+var (
+	magicV2       = []byte("  V2")
+	okResp        = []byte("OK")
+	heartbeatResp = []byte("_heartbeat_")
+)
+
+var validName = regexp.MustCompile(`^[\.a-zA-Z0-9_-]+(#ephemeral)?$`)
+
+func validTopic(name string) bool {
+	return len(name) > 0 && len(name) <= 64 && validName.MatchString(name)
+}
+
+// Message is a published message as nsqd received it.
+type Message struct {
+	Topic string
+	Body  []byte
+	Defer time.Duration // DPUB delay
+	At    time.Time
+}
+
+// ChannelStats is a snapshot of a channel.
+type ChannelStats struct {
+	Depth    int // queued, not in flight
+	InFlight int
+	Clients  int
+	Finished int
+	Requeued int
+	TimedOut int
+}
+
+type message struct {
+	id       [msgIDLength]byte
+	body     []byte
+	ts       int64
+	attempts uint16
+}
+
+type inFlight struct {
+	msg    *message
+	client *conn
+	timer  *time.Timer
+}
+
+type channel struct {
+	queue    []*message
+	inFlight map[[msgIDLength]byte]*inFlight
+	clients  []*conn
+	next     int
+	stats    ChannelStats
+}
+
+type topic struct {
+	channels map[string]*channel
+	backlog  []*message // published before any channel existed
+}
+
+type NSQD struct {
+	ln net.Listener
+
+	mu       sync.Mutex
+	messages map[string][]*Message
+	topics   map[string]*topic
+	conns    map[*conn]bool
+	latency  time.Duration
+	failPub  bool
+	commands []string
+	msgSeq   uint64
+	closed   bool
+	wg       sync.WaitGroup
+}
+
+// NewNSQD listens on addr, "127.0.0.1:0" picks a free port.
+func NewNSQD(addr string) (*NSQD, error) {
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	n := &NSQD{
+		ln:       ln,
+		messages: make(map[string][]*Message),
+		topics:   make(map[string]*topic),
+		conns:    make(map[*conn]bool),
+	}
+	n.wg.Add(1)
+	go n.accept()
+	return n, nil
+}
+
+func (n *NSQD) Addr() string {
+	return n.ln.Addr().String()
+}
+
+// SetLatency delays every response, for slow-node tests.
+func (n *NSQD) SetLatency(d time.Duration) {
+	n.mu.Lock()
+	n.latency = d
+	n.mu.Unlock()
+}
+
+// SetFailPub makes PUB, MPUB and DPUB answer E_PUB_FAILED and drop the message.
+func (n *NSQD) SetFailPub(fail bool) {
+	n.mu.Lock()
+	n.failPub = fail
+	n.mu.Unlock()
+}
+
+// Messages returns what was published to topic, in order.
+func (n *NSQD) Messages(topic string) []*Message {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	return append([]*Message(nil), n.messages[topic]...)
+}
+
+// Commands returns the command lines received, without their bodies.
+func (n *NSQD) Commands() []string {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	return append([]string(nil), n.commands...)
+}
+
+// Publish puts bodies into topic as if a producer sent them.
+func (n *NSQD) Publish(topic string, bodies ...[]byte) {
+	n.mu.Lock()
+	ds := n.put(topic, bodies, 0)
+	n.mu.Unlock()
+	deliver(ds)
+}
+
+// Topics returns the topics that were published to or subscribed.
+func (n *NSQD) Topics() []string {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	var names []string
+	for name := range n.topics {
+		names = append(names, name)
+	}
+	return names
+}
+
+func (n *NSQD) hasTopic(name string) ([]string, bool) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	t := n.topics[name]
+	if t == nil || n.closed {
+		return nil, false
+	}
+	var channels []string
+	for ch := range t.channels {
+		channels = append(channels, ch)
+	}
+	return channels, true
+}
+
+// Channel returns the stats of a channel, the zero value if it does not exist.
+func (n *NSQD) Channel(topicName, channelName string) ChannelStats {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	t := n.topics[topicName]
+	if t == nil || t.channels[channelName] == nil {
+		return ChannelStats{}
+	}
+	ch := t.channels[channelName]
+	st := ch.stats
+	st.Depth = len(ch.queue)
+	st.InFlight = len(ch.inFlight)
+	st.Clients = len(ch.clients)
+	return st
+}
+
+// Close stops listening and drops every connection, like nsqd going down.
+func (n *NSQD) Close() {
+	n.mu.Lock()
+	if n.closed {
+		n.mu.Unlock()
+		return
+	}
+	n.closed = true
+	n.ln.Close()
+	for c := range n.conns {
+		c.Close()
+	}
+	for _, t := range n.topics {
+		for _, ch := range t.channels {
+			for _, f := range ch.inFlight {
+				f.timer.Stop()
+			}
+		}
+	}
+	n.mu.Unlock()
+	n.wg.Wait()
+}
+
+func (n *NSQD) accept() {
+	defer n.wg.Done()
+	for {
+		nc, err := n.ln.Accept()
+		if err != nil {
+			return
+		}
+		c := &conn{Conn: nc, r: bufio.NewReader(nc), done: make(chan struct{}), msgTimeout: defaultMsgTimeout}
+		n.mu.Lock()
+		if n.closed {
+			n.mu.Unlock()
+			nc.Close()
+			return
+		}
+		n.conns[c] = true
+		n.mu.Unlock()
+		n.wg.Add(1)
+		go func() {
+			defer n.wg.Done()
+			n.serve(c)
+			close(c.done)
+			c.Close()
+			n.mu.Lock()
+			delete(n.conns, c)
+			ds := n.unsubscribe(c)
+			n.mu.Unlock()
+			deliver(ds)
+		}()
+	}
+}
+
+type conn struct {
+	net.Conn
+	r    *bufio.Reader
+	wm   sync.Mutex
+	done chan struct{}
+
+	// guarded by NSQD.mu
+	msgTimeout time.Duration
+	sub        *channel
+	rdy        int64
+	inFlight   int64
+	closing    bool
+}
+
+func (c *conn) writeFrame(typ int32, data []byte) error {
+	c.wm.Lock()
+	defer c.wm.Unlock()
+	buf := make([]byte, 8+len(data))
+	binary.BigEndian.PutUint32(buf, uint32(4+len(data)))
+	binary.BigEndian.PutUint32(buf[4:], uint32(typ))
+	copy(buf[8:], data)
+	_, err := c.Write(buf)
+	return err
+}
+
+func (c *conn) readBody() ([]byte, error) {
+	var size int32
+	if err := binary.Read(c.r, binary.BigEndian, &size); err != nil {
+		return nil, err
+	}
+	if size < 0 || size > 64<<20 {
+		return nil, fmt.Errorf("E_BAD_BODY invalid body size %d", size)
+	}
+	body := make([]byte, size)
+	_, err := io.ReadFull(c.r, body)
+	return body, err
+}
+
+func (c *conn) heartbeat(interval time.Duration) {
+	t := time.NewTicker(interval)
+	defer t.Stop()
+	for {
+		select {
+		case <-c.done:
+			return
+		case <-t.C:
+			if c.writeFrame(frameTypeResponse, heartbeatResp) != nil {
+				return
+			}
+		}
+	}
+}
+
+// protocolError is sent to the client as an error frame, fatal ones close the connection.
+type protocolError struct {
+	msg   string
+	fatal bool
+}
+
+func (e *protocolError) Error() string { return e.msg }
+
+func fatalError(format string, args ...interface{}) error {
+	return &protocolError{fmt.Sprintf(format, args...), true}
+}
+
+func (n *NSQD) serve(c *conn) {
+	magic := make([]byte, 4)
+	if _, err := io.ReadFull(c.r, magic); err != nil || !bytes.Equal(magic, magicV2) {
+		return
+	}
+	for {
+		line, err := c.r.ReadString('\n')
+		if err != nil {
+			return
+		}
+		line = line[:len(line)-1]
+		if len(line) > 0 && line[len(line)-1] == '\r' {
+			line = line[:len(line)-1]
+		}
+		params := bytes.Split([]byte(line), []byte(" "))
+		n.mu.Lock()
+		n.commands = append(n.commands, line)
+		latency := n.latency
+		n.mu.Unlock()
+
+		resp, err := n.exec(c, params)
+		if latency > 0 {
+			time.Sleep(latency)
+		}
+		if err != nil {
+			pe, ok := err.(*protocolError)
+			if !ok {
+				return
+			}
+			if c.writeFrame(frameTypeError, []byte(pe.msg)) != nil || pe.fatal {
+				return
+			}
+			continue
+		}
+		if resp != nil {
+			if c.writeFrame(frameTypeResponse, resp) != nil {
+				return
+			}
+		}
+	}
+}
+
+// exec runs one command, a nil response means nothing is sent back.
+func (n *NSQD) exec(c *conn, params [][]byte) ([]byte, error) {
+	switch string(params[0]) {
+	case "IDENTIFY":
+		return n.identify(c)
+	case "NOP":
+		return nil, nil
+	case "PUB", "MPUB", "DPUB":
+		return n.publish(c, params)
+	case "SUB":
+		return n.sub(c, params)
+	case "RDY":
+		return nil, n.rdy(c, params)
+	case "FIN", "REQ", "TOUCH":
+		return nil, n.finish(c, params)
+	case "CLS":
+		// 和nsqd一样不再投递, 等客户端处理完在途的消息后自己断开
+		n.mu.Lock()
+		c.closing = true
+		n.mu.Unlock()
+		return []byte("CLOSE_WAIT"), nil
+	}
+	return nil, fatalError("E_INVALID invalid command %s", params[0])
+}
+
+func (n *NSQD) identify(c *conn) ([]byte, error) {
+	body, err := c.readBody()
+	if err != nil {
+		return nil, fatalError("E_BAD_BODY IDENTIFY failed to read body")
+	}
+	var id struct {
+		HeartbeatInterval int64 `json:"heartbeat_interval"`
+		MsgTimeout        int64 `json:"msg_timeout"`
+	}
+	if err := json.Unmarshal(body, &id); err != nil {
+		return nil, fatalError("E_BAD_BODY IDENTIFY failed to decode JSON body")
+	}
+	if id.MsgTimeout > 0 {
+		n.mu.Lock()
+		c.msgTimeout = time.Duration(id.MsgTimeout) * time.Millisecond
+		n.mu.Unlock()
+	}
+	if id.HeartbeatInterval > 0 {
+		go c.heartbeat(time.Duration(id.HeartbeatInterval) * time.Millisecond)
+	}
+	return okResp, nil
+}
+
+func (n *NSQD) publish(c *conn, params [][]byte) ([]byte, error) {
+	cmd := string(params[0])
+	if len(params) < 2 {
+		return nil, fatalError("E_INVALID %s insufficient number of parameters", cmd)
+	}
+	topic := string(params[1])
+	var delay time.Duration
+	if cmd == "DPUB" {
+		if len(params) < 3 {
+			return nil, fatalError("E_INVALID DPUB insufficient number of parameters")
+		}
+		ms, err := strconv.ParseInt(string(params[2]), 10, 64)
+		if err != nil || ms < 0 {
+			return nil, fatalError("E_INVALID DPUB could not parse timeout")
+		}
+		delay = time.Duration(ms) * time.Millisecond
+	}
+	body, err := c.readBody()
+	if err != nil {
+		return nil, fatalError("E_BAD_BODY %s failed to read body", cmd)
+	}
+	if !validTopic(topic) {
+		return nil, fatalError("E_BAD_TOPIC %s topic name %q is not valid", cmd, topic)
+	}
+	bodies := [][]byte{body}
+	if cmd == "MPUB" {
+		if bodies, err = splitMPUB(body); err != nil {
+			return nil, fatalError("E_BAD_BODY MPUB %v", err)
+		}
+	}
+
+	n.mu.Lock()
+	if n.failPub {
+		n.mu.Unlock()
+		return nil, &protocolError{fmt.Sprintf("E_%s_FAILED %s failed", cmd, cmd), false}
+	}
+	ds := n.put(topic, bodies, delay)
+	n.mu.Unlock()
+	deliver(ds)
+	return okResp, nil
+}
+
+// splitMPUB parses [num][size][data][size][data]...
+func splitMPUB(body []byte) ([][]byte, error) {
+	if len(body) < 4 {
+		return nil, fmt.Errorf("invalid body size %d", len(body))
+	}
+	num := binary.BigEndian.Uint32(body)
+	body = body[4:]
+	if num == 0 {
+		return nil, fmt.Errorf("invalid message count 0")
+	}
+	bodies := make([][]byte, 0, num)
+	for i := uint32(0); i < num; i++ {
+		if len(body) < 4 {
+			return nil, fmt.Errorf("short message %d", i)
+		}
+		size := binary.BigEndian.Uint32(body)
+		body = body[4:]
+		if uint64(size) > uint64(len(body)) {
+			return nil, fmt.Errorf("short message %d", i)
+		}
+		bodies = append(bodies, body[:size])
+		body = body[size:]
+	}
+	return bodies, nil
+}
+
+func (n *NSQD) sub(c *conn, params [][]byte) ([]byte, error) {
+	if len(params) < 3 {
+		return nil, fatalError("E_INVALID SUB insufficient number of parameters")
+	}
+	topicName, channelName := string(params[1]), string(params[2])
+	if !validTopic(topicName) {
+		return nil, fatalError("E_BAD_TOPIC SUB topic name %q is not valid", topicName)
+	}
+	if !validTopic(channelName) {
+		return nil, fatalError("E_BAD_CHANNEL SUB channel name %q is not valid", channelName)
+	}
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if c.sub != nil {
+		return nil, fatalError("E_INVALID cannot SUB in current state")
+	}
+	ch := n.channel(topicName, channelName)
+	ch.clients = append(ch.clients, c)
+	c.sub = ch
+	return okResp, nil
+}
+
+func (n *NSQD) rdy(c *conn, params [][]byte) error {
+	count := int64(1)
+	if len(params) > 1 {
+		var err error
+		if count, err = strconv.ParseInt(string(params[1]), 10, 64); err != nil || count < 0 || count > 2500 {
+			return fatalError("E_INVALID RDY count %s out of range 0-2500", params[1])
+		}
+	}
+	n.mu.Lock()
+	if c.sub == nil {
+		n.mu.Unlock()
+		return fatalError("E_INVALID cannot RDY in current state")
+	}
+	c.rdy = count
+	ds := n.pump(c.sub)
+	n.mu.Unlock()
+	deliver(ds)
+	return nil
+}
+
+// finish handles FIN id, REQ id timeout_ms and TOUCH id.
+func (n *NSQD) finish(c *conn, params [][]byte) error {
+	cmd := string(params[0])
+	if len(params) < 2 || (cmd == "REQ" && len(params) < 3) {
+		return fatalError("E_INVALID %s insufficient number of parameters", cmd)
+	}
+	var id [msgIDLength]byte
+	if len(params[1]) != msgIDLength {
+		return fatalError("E_INVALID %s invalid message ID", cmd)
+	}
+	copy(id[:], params[1])
+	var requeue time.Duration
+	if cmd == "REQ" {
+		ms, err := strconv.ParseInt(string(params[2]), 10, 64)
+		if err != nil || ms < 0 {
+			return fatalError("E_INVALID REQ could not parse timeout %s", params[2])
+		}
+		requeue = time.Duration(ms) * time.Millisecond
+	}
+
+	n.mu.Lock()
+	if c.sub == nil {
+		n.mu.Unlock()
+		return fatalError("E_INVALID cannot %s in current state", cmd)
+	}
+	ch := c.sub
+	f := ch.inFlight[id]
+	if f == nil || f.client != c {
+		n.mu.Unlock()
+		return &protocolError{fmt.Sprintf("E_%s_FAILED %s %s failed", cmd, cmd, id[:]), false}
+	}
+	var ds []delivery
+	switch cmd {
+	case "TOUCH":
+		f.timer.Reset(c.msgTimeout)
+	case "FIN":
+		n.removeInFlight(ch, f)
+		ch.stats.Finished++
+		ds = n.pump(ch)
+	case "REQ":
+		n.removeInFlight(ch, f)
+		ch.stats.Requeued++
+		if requeue > 0 {
+			time.AfterFunc(requeue, func() {
+				n.mu.Lock()
+				ch.queue = append(ch.queue, f.msg)
+				ds := n.pump(ch)
+				n.mu.Unlock()
+				deliver(ds)
+			})
+		} else {
+			ch.queue = append(ch.queue, f.msg)
+		}
+		ds = n.pump(ch)
+	}
+	n.mu.Unlock()
+	deliver(ds)
+	return nil
+}
+
+// channel returns the channel, creating it and its topic. n.mu must be held.
+func (n *NSQD) channel(topicName, channelName string) *channel {
+	t := n.topic(topicName)
+	ch := t.channels[channelName]
+	if ch == nil {
+		ch = &channel{inFlight: make(map[[msgIDLength]byte]*inFlight)}
+		t.channels[channelName] = ch
+		// 第一个channel拿走topic里积压的消息
+		if len(t.channels) == 1 {
+			ch.queue, t.backlog = t.backlog, nil
+		}
+	}
+	return ch
+}
+
+func (n *NSQD) topic(name string) *topic {
+	t := n.topics[name]
+	if t == nil {
+		t = &topic{channels: make(map[string]*channel)}
+		n.topics[name] = t
+	}
+	return t
+}
+
+// put records and queues bodies, delayed ones are queued by a timer. n.mu must be held.
+func (n *NSQD) put(topicName string, bodies [][]byte, delay time.Duration) []delivery {
+	now := time.Now()
+	t := n.topic(topicName)
+	msgs := make([]*message, len(bodies))
+	for i, b := range bodies {
+		n.messages[topicName] = append(n.messages[topicName], &Message{Topic: topicName, Body: b, Defer: delay, At: now})
+		n.msgSeq++
+		m := &message{body: b, ts: now.UnixNano()}
+		copy(m.id[:], fmt.Sprintf("%016x", n.msgSeq))
+		msgs[i] = m
+	}
+	if delay > 0 {
+		time.AfterFunc(delay, func() {
+			n.mu.Lock()
+			ds := n.queue(t, msgs)
+			n.mu.Unlock()
+			deliver(ds)
+		})
+		return nil
+	}
+	return n.queue(t, msgs)
+}
+
+// queue gives every channel of t its own copy of msgs. n.mu must be held.
+func (n *NSQD) queue(t *topic, msgs []*message) []delivery {
+	if len(t.channels) == 0 {
+		t.backlog = append(t.backlog, msgs...)
+		return nil
+	}
+	var ds []delivery
+	for _, ch := range t.channels {
+		for _, m := range msgs {
+			cp := *m
+			ch.queue = append(ch.queue, &cp)
+		}
+		ds = append(ds, n.pump(ch)...)
+	}
+	return ds
+}
+
+type delivery struct {
+	c    *conn
+	data []byte
+}
+
+// deliver writes message frames, it must be called without n.mu.
+func deliver(ds []delivery) {
+	for _, d := range ds {
+		d.c.writeFrame(frameTypeMessage, d.data)
+	}
+}
+
+// pump moves queued messages to clients with RDY left, round robin. n.mu must be held.
+func (n *NSQD) pump(ch *channel) []delivery {
+	var ds []delivery
+	for len(ch.queue) > 0 {
+		c := ch.ready()
+		if c == nil {
+			break
+		}
+		m := ch.queue[0]
+		ch.queue = ch.queue[1:]
+		m.attempts++
+		f := &inFlight{msg: m, client: c}
+		f.timer = time.AfterFunc(c.msgTimeout, func() { n.timeout(ch, f) })
+		ch.inFlight[m.id] = f
+		c.inFlight++
+
+		data := make([]byte, 10+msgIDLength+len(m.body))
+		binary.BigEndian.PutUint64(data, uint64(m.ts))
+		binary.BigEndian.PutUint16(data[8:], m.attempts)
+		copy(data[10:], m.id[:])
+		copy(data[10+msgIDLength:], m.body)
+		ds = append(ds, delivery{c, data})
+	}
+	return ds
+}
+
+func (ch *channel) ready() *conn {
+	for i := range ch.clients {
+		c := ch.clients[(ch.next+i)%len(ch.clients)]
+		if !c.closing && c.inFlight < c.rdy {
+			ch.next = (ch.next + i + 1) % len(ch.clients)
+			return c
+		}
+	}
+	return nil
+}
+
+func (n *NSQD) removeInFlight(ch *channel, f *inFlight) {
+	f.timer.Stop()
+	delete(ch.inFlight, f.msg.id)
+	f.client.inFlight--
+}
+
+// timeout requeues a message the client held longer than its msg_timeout.
+func (n *NSQD) timeout(ch *channel, f *inFlight) {
+	n.mu.Lock()
+	if ch.inFlight[f.msg.id] != f || n.closed {
+		n.mu.Unlock()
+		return
+	}
+	n.removeInFlight(ch, f)
+	ch.stats.TimedOut++
+	ch.queue = append(ch.queue, f.msg)
+	ds := n.pump(ch)
+	n.mu.Unlock()
+	deliver(ds)
+}
+
+// unsubscribe requeues the messages in flight to a closed connection. n.mu must be held.
+func (n *NSQD) unsubscribe(c *conn) []delivery {
+	ch := c.sub
+	if ch == nil {
+		return nil
+	}
+	c.sub = nil
+	for i, x := range ch.clients {
+		if x == c {
+			ch.clients = append(ch.clients[:i], ch.clients[i+1:]...)
+			break
+		}
+	}
+	if ch.next >= len(ch.clients) {
+		ch.next = 0
+	}
+	for _, f := range ch.inFlight {
+		if f.client == c {
+			n.removeInFlight(ch, f)
+			ch.queue = append(ch.queue, f.msg)
+		}
+	}
+	if n.closed {
+		return nil
+	}
+	return n.pump(ch)
+}
@@ -0,0 +1,245 @@
+package nsqfake
+
+import (
+	"errors"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/nsqio/go-nsq"
+	"github.com/stretchr/testify/assert"
+)
+
+func newNSQD(t *testing.T) *NSQD {
+	n, err := NewNSQD("127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(n.Close)
+	return n
+}
+
+func newConsumer(t *testing.T, topic string, h nsq.HandlerFunc, setup func(*nsq.Config)) *nsq.Consumer {
+	cfg := nsq.NewConfig()
+	cfg.LookupdPollInterval = 50 * time.Millisecond
+	cfg.DefaultRequeueDelay = 0
+	if setup != nil {
+		setup(cfg)
+	}
+	c, err := nsq.NewConsumer(topic, "ch", cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.SetLogger(log.New(ioutil.Discard, "", 0), nsq.LogLevelError)
+	c.AddHandler(h)
+	t.Cleanup(c.Stop)
+	return c
+}
+
+func waitFor(t *testing.T, what string, cond func() bool) {
+	deadline := time.Now().Add(5 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+type bodies struct {
+	mu sync.Mutex
+	m  map[string]int
+}
+
+func (b *bodies) add(m *nsq.Message) {
+	b.mu.Lock()
+	if b.m == nil {
+		b.m = make(map[string]int)
+	}
+	b.m[string(m.Body)]++
+	b.mu.Unlock()
+}
+
+func (b *bodies) len() int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return len(b.m)
+}
+
+func TestPublishConsume(t *testing.T) {
+	n := newNSQD(t)
+	p, err := nsq.NewProducer(n.Addr(), nsq.NewConfig())
+	if err != nil {
+		t.Fatal(err)
+	}
+	p.SetLogger(log.New(ioutil.Discard, "", 0), nsq.LogLevelError)
+	defer p.Stop()
+	// 没有channel时消息留在topic里
+	assert.NoError(t, p.Publish("t", []byte("a")))
+	assert.NoError(t, p.MultiPublish("t", [][]byte{[]byte("b"), []byte("c")}))
+	assert.NoError(t, p.DeferredPublish("t", 20*time.Millisecond, []byte("d")))
+
+	var got bodies
+	c := newConsumer(t, "t", func(m *nsq.Message) error {
+		got.add(m)
+		return nil
+	}, nil)
+	assert.NoError(t, c.ConnectToNSQD(n.Addr()))
+	waitFor(t, "4 messages", func() bool { return n.Channel("t", "ch").Finished == 4 })
+	assert.Equal(t, 4, got.len())
+	st := n.Channel("t", "ch")
+	assert.Equal(t, 0, st.Depth)
+	assert.Equal(t, 0, st.InFlight)
+	assert.Equal(t, 1, st.Clients)
+	assert.Equal(t, 4, len(n.Messages("t")))
+}
+
+func TestRequeue(t *testing.T) {
+	n := newNSQD(t)
+	var mu sync.Mutex
+	attempts := make(map[string]uint16)
+	c := newConsumer(t, "t", func(m *nsq.Message) error {
+		mu.Lock()
+		defer mu.Unlock()
+		attempts[string(m.Body)] = m.Attempts
+		if m.Attempts < 3 {
+			return errors.New("again")
+		}
+		return nil
+	}, func(cfg *nsq.Config) {
+		cfg.MaxBackoffDuration = 0
+	})
+	assert.NoError(t, c.ConnectToNSQD(n.Addr()))
+	n.Publish("t", []byte("a"), []byte("b"))
+	waitFor(t, "finish", func() bool { return n.Channel("t", "ch").Finished == 2 })
+	mu.Lock()
+	assert.Equal(t, map[string]uint16{"a": 3, "b": 3}, attempts)
+	mu.Unlock()
+	assert.Equal(t, 4, n.Channel("t", "ch").Requeued)
+}
+
+func TestTimeoutAndTouch(t *testing.T) {
+	n := newNSQD(t)
+	var mu sync.Mutex
+	calls := 0
+	c := newConsumer(t, "t", func(m *nsq.Message) error {
+		mu.Lock()
+		calls++
+		first := calls == 1
+		mu.Unlock()
+		if first {
+			// 第一次超时不处理, 第二次靠TOUCH撑过msg_timeout
+			m.DisableAutoResponse()
+			return nil
+		}
+		for i := 0; i < 4; i++ {
+			time.Sleep(40 * time.Millisecond)
+			m.Touch()
+		}
+		return nil
+	}, func(cfg *nsq.Config) {
+		cfg.MsgTimeout = 100 * time.Millisecond
+	})
+	assert.NoError(t, c.ConnectToNSQD(n.Addr()))
+	n.Publish("t", []byte("a"))
+	waitFor(t, "finish", func() bool { return n.Channel("t", "ch").Finished == 1 })
+	assert.Equal(t, 1, n.Channel("t", "ch").TimedOut)
+	assert.Contains(t, n.Commands(), "TOUCH "+"0000000000000001")
+}
+
+func TestDisconnectRequeues(t *testing.T) {
+	n := newNSQD(t)
+	block := make(chan struct{})
+	c := newConsumer(t, "t", func(m *nsq.Message) error {
+		<-block
+		return nil
+	}, nil)
+	assert.NoError(t, c.ConnectToNSQD(n.Addr()))
+	n.Publish("t", []byte("a"))
+	waitFor(t, "in flight", func() bool { return n.Channel("t", "ch").InFlight == 1 })
+	assert.NoError(t, c.DisconnectFromNSQD(n.Addr()))
+	close(block)
+	waitFor(t, "requeue", func() bool {
+		st := n.Channel("t", "ch")
+		return st.Clients == 0 && (st.Depth == 1 || st.Finished == 1)
+	})
+}
+
+func TestTwoChannels(t *testing.T) {
+	n := newNSQD(t)
+	var a, b bodies
+	ca := newConsumer(t, "t", func(m *nsq.Message) error { a.add(m); return nil }, nil)
+	cfg := nsq.NewConfig()
+	cb, _ := nsq.NewConsumer("t", "other", cfg)
+	cb.SetLogger(log.New(ioutil.Discard, "", 0), nsq.LogLevelError)
+	cb.AddHandler(nsq.HandlerFunc(func(m *nsq.Message) error { b.add(m); return nil }))
+	defer cb.Stop()
+	assert.NoError(t, ca.ConnectToNSQD(n.Addr()))
+	assert.NoError(t, cb.ConnectToNSQD(n.Addr()))
+	// SUB 不等回复, 两个channel都建好再发, 否则后建的channel拿不到
+	waitFor(t, "subscribe", func() bool {
+		return n.Channel("t", "ch").Clients == 1 && n.Channel("t", "other").Clients == 1
+	})
+	n.Publish("t", []byte("1"), []byte("2"), []byte("3"))
+	waitFor(t, "both channels", func() bool { return a.len() == 3 && b.len() == 3 })
+}
+
+func TestLookupd(t *testing.T) {
+	n1, n2 := newNSQD(t), newNSQD(t)
+	l, err := NewLookupd("127.0.0.1:0", n1, n2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+
+	resp, err := http.Get("http://" + l.Addr() + "/lookup?topic=t")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+
+	n1.Publish("t", []byte("1"))
+	n2.Publish("t", []byte("2"))
+	var got bodies
+	// MaxInFlight 小于连接数时 go-nsq 轮流给连接RDY, 要等好几秒
+	c := newConsumer(t, "t", func(m *nsq.Message) error { got.add(m); return nil }, func(cfg *nsq.Config) {
+		cfg.MaxInFlight = 10
+	})
+	assert.NoError(t, c.ConnectToNSQLookupd(l.Addr()))
+	waitFor(t, "messages from both nsqd", func() bool { return got.len() == 2 })
+	assert.Equal(t, 2, c.Stats().Connections)
+
+	// 新的nsqd在下一次轮询时被发现
+	n3 := newNSQD(t)
+	l.Add(n3)
+	n3.Publish("t", []byte("3"))
+	// 已有的两个连接占满了 MaxInFlight, 新连接要等 go-nsq 5s 后重试RDY才收得到消息, 这里只看发现和订阅
+	waitFor(t, "third nsqd", func() bool { return n3.Channel("t", "ch").Clients == 1 })
+	assert.Equal(t, 3, c.Stats().Connections)
+	assert.True(t, l.Lookups() >= 2)
+}
+
+func TestBadCommands(t *testing.T) {
+	n := newNSQD(t)
+	p, _ := nsq.NewProducer(n.Addr(), nsq.NewConfig())
+	p.SetLogger(log.New(ioutil.Discard, "", 0), nsq.LogLevelError)
+	defer p.Stop()
+	err := p.Publish("bad!topic", []byte("x"))
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(), "E_BAD_TOPIC")
+	}
+	// E_BAD_TOPIC 会断开连接, 换一个producer
+	p2, _ := nsq.NewProducer(n.Addr(), nsq.NewConfig())
+	p2.SetLogger(log.New(ioutil.Discard, "", 0), nsq.LogLevelError)
+	defer p2.Stop()
+	n.SetFailPub(true)
+	err = p2.Publish("t", []byte("x"))
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(), "E_PUB_FAILED")
+	}
+	assert.Empty(t, n.Messages("t"))
+}
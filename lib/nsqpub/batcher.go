@@ -0,0 +1,12 @@
+package nsqpub
+
+import (
+	"time"
+
+	"github.com/buptbill220/go_performance/lib/mq"
+)
+
+// NewBatcher returns a mq.Batcher publishing through p.
+func (p *Publisher) NewBatcher(topic string, maxBatch int, maxDelay time.Duration, onError func(err error, bodies [][]byte)) *mq.Batcher {
+	return mq.NewBatcher(p, topic, maxBatch, maxDelay, onError)
+}
@@ -0,0 +1,376 @@
+package nsqpub
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/buptbill220/go_performance/lib/mq"
+	"github.com/nsqio/go-nsq"
+)
+
+/*
+	往多个nsqd发消息, 一个节点挂了自动切到别的节点, 都失败时退避后重试
+
+	p, err := nsqpub.New(nsqpub.Options{
+		Addrs:    []string{"127.0.0.1:4150", "127.0.0.1:4250"},
+		Strategy: nsqpub.LeastLatency,
+	})
+	defer p.Stop()
+	p.Publish("topic", []byte("x"))
+	p.MultiPublish("topic", bodies)
+	p.DeferredPublish("topic", time.Second, []byte("x"))
+
+	失败的节点在 MinBackoff 起翻倍(最多 MaxBackoff)的时间里不会被选, 除非所有节点都在冷却
+	E_BAD_TOPIC, E_BAD_BODY 之类换节点也不会成功的错误直接返回, 不重试
+	攒批发送用 NewBatcher
+*/
+
+type Strategy int
+
+const (
+	RoundRobin Strategy = iota
+	LeastLatency
+)
+
+func (s Strategy) String() string {
+	switch s {
+	case RoundRobin:
+		return "rr"
+	case LeastLatency:
+		return "latency"
+	}
+	return fmt.Sprintf("Strategy(%d)", int(s))
+}
+
+// ParseStrategy accepts the names printed by String.
+func ParseStrategy(s string) (Strategy, error) {
+	switch s {
+	case "rr", "roundrobin":
+		return RoundRobin, nil
+	case "latency", "leastlatency":
+		return LeastLatency, nil
+	}
+	return 0, fmt.Errorf("nsqpub: unknown strategy %q", s)
+}
+
+var (
+	ErrNoAddrs   = errors.New("nsqpub: no nsqd address")
+	ErrStopped   = mq.ErrStopped // shared with mq.Batcher
+	ErrBadTopic  = errors.New("nsqpub: invalid topic name")
+	ErrEmptyBody = errors.New("nsqpub: empty message list")
+)
+
+type Options struct {
+	Addrs    []string
+	Strategy Strategy
+	Config   *nsq.Config // nil is nsq.NewConfig()
+
+	// MaxAttempts is the number of publish attempts over all nodes, 0 is 2*len(Addrs)
+	MaxAttempts int
+	// MinBackoff, MaxBackoff bound the wait between rounds and the cool-down of a failed node
+	MinBackoff time.Duration // 0 is 50ms
+	MaxBackoff time.Duration // 0 is 5s
+
+	Logger   *log.Logger  // nil logs to stderr
+	LogLevel nsq.LogLevel // the zero value, LogLevelDebug, is raised to LogLevelWarning
+}
+
+func (o *Options) setDefaults() {
+	if o.Config == nil {
+		o.Config = nsq.NewConfig()
+	}
+	if o.MaxAttempts <= 0 {
+		o.MaxAttempts = 2 * len(o.Addrs)
+	}
+	if o.MinBackoff <= 0 {
+		o.MinBackoff = 50 * time.Millisecond
+	}
+	if o.MaxBackoff < o.MinBackoff {
+		o.MaxBackoff = 5 * time.Second
+		if o.MaxBackoff < o.MinBackoff {
+			o.MaxBackoff = o.MinBackoff
+		}
+	}
+	if o.Logger == nil {
+		o.Logger = log.New(os.Stderr, "", log.LstdFlags)
+	}
+	if o.LogLevel == nsq.LogLevelDebug {
+		o.LogLevel = nsq.LogLevelWarning
+	}
+}
+
+// latencyWeight is the weight of the newest sample in the latency EWMA.
+const latencyWeight = 0.2
+
+type node struct {
+	addr     string
+	producer *nsq.Producer
+
+	mu        sync.Mutex
+	latency   time.Duration
+	failures  int // consecutive
+	downUntil time.Time
+	stopped   bool // no producer is created after Stop
+
+	published uint64
+	errors    uint64
+}
+
+func (n *node) success(d time.Duration) {
+	atomic.AddUint64(&n.published, 1)
+	n.mu.Lock()
+	if n.latency == 0 {
+		n.latency = d
+	} else {
+		n.latency = time.Duration(latencyWeight*float64(d) + (1-latencyWeight)*float64(n.latency))
+	}
+	n.failures = 0
+	n.downUntil = time.Time{}
+	n.mu.Unlock()
+}
+
+func (n *node) get() *nsq.Producer {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	return n.producer
+}
+
+// fail puts the node into cool-down. After a connection error the producer is
+// replaced, reconnecting a go-nsq v1.1.0 Producer races with its old router goroutine.
+func (n *node) fail(o *Options, np *nsq.Producer, err error) {
+	atomic.AddUint64(&n.errors, 1)
+	n.mu.Lock()
+	n.failures++
+	n.downUntil = time.Now().Add(backoff(o, n.failures))
+	if _, ok := err.(nsq.ErrProtocol); ok || n.producer != np || n.stopped {
+		n.mu.Unlock()
+		return
+	}
+	fresh, nerr := newProducer(n.addr, o)
+	if nerr != nil {
+		n.mu.Unlock()
+		return
+	}
+	n.producer = fresh
+	n.mu.Unlock()
+	np.Stop()
+}
+
+func (n *node) stop() {
+	n.mu.Lock()
+	n.stopped = true
+	np := n.producer
+	n.mu.Unlock()
+	np.Stop()
+}
+
+func newProducer(addr string, o *Options) (*nsq.Producer, error) {
+	np, err := nsq.NewProducer(addr, o.Config)
+	if err != nil {
+		return nil, err
+	}
+	np.SetLogger(o.Logger, o.LogLevel)
+	return np, nil
+}
+
+func backoff(o *Options, attempt int) time.Duration {
+	d := o.MinBackoff
+	for i := 1; i < attempt && d < o.MaxBackoff; i++ {
+		d *= 2
+	}
+	if d > o.MaxBackoff {
+		d = o.MaxBackoff
+	}
+	return d
+}
+
+type Publisher struct {
+	opts  Options
+	nodes []*node
+	next  uint32
+
+	// 发送和退避时不拿锁, Stop 关 quit 叫醒退避中的发送, 每次尝试前再看一次 stopped
+	mu      sync.Mutex
+	stopped bool
+	quit    chan struct{}
+}
+
+func New(opts Options) (*Publisher, error) {
+	if len(opts.Addrs) == 0 {
+		return nil, ErrNoAddrs
+	}
+	opts.setDefaults()
+	p := &Publisher{opts: opts, quit: make(chan struct{})}
+	for _, addr := range opts.Addrs {
+		np, err := newProducer(addr, &p.opts)
+		if err != nil {
+			p.Stop()
+			return nil, fmt.Errorf("nsqpub: %s: %v", addr, err)
+		}
+		p.nodes = append(p.nodes, &node{addr: addr, producer: np})
+	}
+	return p, nil
+}
+
+func (p *Publisher) Publish(topic string, body []byte) error {
+	return p.do(topic, func(np *nsq.Producer) error {
+		return np.Publish(topic, body)
+	})
+}
+
+// MultiPublish sends all bodies to one node in a single MPUB.
+func (p *Publisher) MultiPublish(topic string, bodies [][]byte) error {
+	if len(bodies) == 0 {
+		return ErrEmptyBody
+	}
+	return p.do(topic, func(np *nsq.Producer) error {
+		return np.MultiPublish(topic, bodies)
+	})
+}
+
+func (p *Publisher) DeferredPublish(topic string, delay time.Duration, body []byte) error {
+	return p.do(topic, func(np *nsq.Producer) error {
+		return np.DeferredPublish(topic, delay, body)
+	})
+}
+
+// do tries fn on one node after another, every node is tried once per round.
+func (p *Publisher) do(topic string, fn func(*nsq.Producer) error) error {
+	if !nsq.IsValidTopicName(topic) {
+		return fmt.Errorf("%v %q", ErrBadTopic, topic)
+	}
+	var lastErr error
+	tried := make(map[*node]bool, len(p.nodes))
+	round := 0
+	for attempt := 0; attempt < p.opts.MaxAttempts; attempt++ {
+		if p.isStopped() {
+			return ErrStopped
+		}
+		n := p.pick(tried)
+		if n == nil {
+			round++
+			t := time.NewTimer(backoff(&p.opts, round))
+			select {
+			case <-t.C:
+			case <-p.quit:
+				t.Stop()
+				return ErrStopped
+			}
+			tried = make(map[*node]bool, len(p.nodes))
+			n = p.pick(tried)
+		}
+		tried[n] = true
+		np := n.get()
+		start := time.Now()
+		err := fn(np)
+		if err == nil {
+			n.success(time.Since(start))
+			return nil
+		}
+		lastErr = fmt.Errorf("nsqpub: %s: %v", n.addr, err)
+		if !retryable(err) {
+			return lastErr
+		}
+		n.fail(&p.opts, np, err)
+	}
+	return lastErr
+}
+
+// pick returns an untried node, preferring nodes that are not cooling down.
+// It returns nil when every node was tried.
+func (p *Publisher) pick(tried map[*node]bool) *node {
+	now := time.Now()
+	var best, fallback *node
+	var bestLatency time.Duration
+	var fallbackUntil time.Time
+	start := int(atomic.AddUint32(&p.next, 1) - 1)
+	for i := range p.nodes {
+		n := p.nodes[(start+i)%len(p.nodes)]
+		if tried[n] {
+			continue
+		}
+		n.mu.Lock()
+		latency, downUntil := n.latency, n.downUntil
+		n.mu.Unlock()
+		if now.Before(downUntil) {
+			if fallback == nil || downUntil.Before(fallbackUntil) {
+				fallback, fallbackUntil = n, downUntil
+			}
+			continue
+		}
+		if p.opts.Strategy == RoundRobin {
+			return n
+		}
+		// 没测过的节点先用, 好有延迟数据
+		if best == nil || latency < bestLatency {
+			best, bestLatency = n, latency
+		}
+	}
+	if best != nil {
+		return best
+	}
+	return fallback
+}
+
+// retryable reports whether another node might accept the message.
+func retryable(err error) bool {
+	if pe, ok := err.(nsq.ErrProtocol); ok {
+		return strings.HasPrefix(pe.Reason, "E_PUB_FAILED") ||
+			strings.HasPrefix(pe.Reason, "E_MPUB_FAILED") ||
+			strings.HasPrefix(pe.Reason, "E_DPUB_FAILED")
+	}
+	return true
+}
+
+func (p *Publisher) isStopped() bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.stopped
+}
+
+// Stop stops every producer, publishing after Stop returns ErrStopped. A publish waiting
+// between rounds returns ErrStopped at once, one in flight fails with the producer's error.
+func (p *Publisher) Stop() {
+	p.mu.Lock()
+	if p.stopped {
+		p.mu.Unlock()
+		return
+	}
+	p.stopped = true
+	close(p.quit)
+	p.mu.Unlock()
+	for _, n := range p.nodes {
+		n.stop()
+	}
+}
+
+type NodeStats struct {
+	Addr      string
+	Published uint64
+	Errors    uint64
+	Latency   time.Duration // EWMA of successful publishes
+	Down      bool          // cooling down after a failure
+}
+
+func (p *Publisher) Stats() []NodeStats {
+	now := time.Now()
+	stats := make([]NodeStats, len(p.nodes))
+	for i, n := range p.nodes {
+		n.mu.Lock()
+		stats[i] = NodeStats{
+			Addr:      n.addr,
+			Published: atomic.LoadUint64(&n.published),
+			Errors:    atomic.LoadUint64(&n.errors),
+			Latency:   n.latency,
+			Down:      now.Before(n.downUntil),
+		}
+		n.mu.Unlock()
+	}
+	return stats
+}
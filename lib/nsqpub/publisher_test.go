@@ -0,0 +1,227 @@
+package nsqpub
+
+import (
+	"errors"
+	"io/ioutil"
+	"log"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/buptbill220/go_performance/lib/nsqfake"
+	"github.com/nsqio/go-nsq"
+	"github.com/stretchr/testify/assert"
+)
+
+func startNSQD(t *testing.T, n int) []*nsqfake.NSQD {
+	var ds []*nsqfake.NSQD
+	for i := 0; i < n; i++ {
+		d, err := nsqfake.NewNSQD("127.0.0.1:0")
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(d.Close)
+		ds = append(ds, d)
+	}
+	return ds
+}
+
+func newPublisher(t *testing.T, s Strategy, ds ...*nsqfake.NSQD) *Publisher {
+	return newPublisherBackoff(t, s, time.Millisecond, 10*time.Millisecond, ds...)
+}
+
+func newPublisherBackoff(t *testing.T, s Strategy, min, max time.Duration, ds ...*nsqfake.NSQD) *Publisher {
+	var addrs []string
+	for _, d := range ds {
+		addrs = append(addrs, d.Addr())
+	}
+	p, err := New(Options{
+		Addrs:      addrs,
+		Strategy:   s,
+		MinBackoff: min,
+		MaxBackoff: max,
+		Logger:     log.New(ioutil.Discard, "", 0),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(p.Stop)
+	return p
+}
+
+func publishN(t *testing.T, p *Publisher, n int) {
+	for i := 0; i < n; i++ {
+		if err := p.Publish("test", []byte(strconv.Itoa(i))); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestRoundRobin(t *testing.T) {
+	ds := startNSQD(t, 2)
+	p := newPublisher(t, RoundRobin, ds...)
+	publishN(t, p, 10)
+	assert.Equal(t, 5, len(ds[0].Messages("test")))
+	assert.Equal(t, 5, len(ds[1].Messages("test")))
+}
+
+func TestFailover(t *testing.T) {
+	ds := startNSQD(t, 2)
+	p := newPublisher(t, RoundRobin, ds...)
+	publishN(t, p, 2)
+	ds[0].Close()
+	publishN(t, p, 10)
+	assert.Equal(t, 1, len(ds[0].Messages("test")))
+	assert.Equal(t, 11, len(ds[1].Messages("test")))
+
+	st := p.Stats()
+	assert.True(t, st[0].Errors > 0)
+	assert.Equal(t, uint64(11), st[1].Published)
+}
+
+func TestRecover(t *testing.T) {
+	ds := startNSQD(t, 2)
+	// 冷却固定200ms, 发完马上看一定还在冷却
+	p := newPublisherBackoff(t, RoundRobin, 200*time.Millisecond, 200*time.Millisecond, ds...)
+	addr := ds[0].Addr()
+	ds[0].Close()
+	publishN(t, p, 4)
+	st := p.Stats()[0]
+	assert.True(t, st.Down)
+	assert.Equal(t, uint64(1), st.Errors, "not picked again while cooling down")
+
+	d, err := nsqfake.NewNSQD(addr)
+	if err != nil {
+		t.Skip("port reused: ", err)
+	}
+	defer d.Close()
+	for p.Stats()[0].Down {
+		time.Sleep(5 * time.Millisecond)
+	}
+	publishN(t, p, 4)
+	assert.True(t, len(d.Messages("test")) > 0)
+	assert.False(t, p.Stats()[0].Down)
+}
+
+func TestLeastLatency(t *testing.T) {
+	ds := startNSQD(t, 2)
+	ds[0].SetLatency(20 * time.Millisecond)
+	p := newPublisher(t, LeastLatency, ds...)
+	publishN(t, p, 20)
+	// 两个节点各测一次后都走快的
+	assert.Equal(t, 1, len(ds[0].Messages("test")))
+	assert.Equal(t, 19, len(ds[1].Messages("test")))
+	st := p.Stats()
+	assert.True(t, st[0].Latency > st[1].Latency)
+}
+
+func TestPubFailed(t *testing.T) {
+	ds := startNSQD(t, 2)
+	ds[0].SetFailPub(true)
+	p := newPublisher(t, RoundRobin, ds...)
+	publishN(t, p, 4)
+	assert.Equal(t, 0, len(ds[0].Messages("test")))
+	assert.Equal(t, 4, len(ds[1].Messages("test")))
+
+	ds[1].SetFailPub(true)
+	err := p.Publish("test", []byte("x"))
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "E_PUB_FAILED")
+}
+
+func TestNotRetryable(t *testing.T) {
+	ds := startNSQD(t, 2)
+	p := newPublisher(t, RoundRobin, ds...)
+	err := p.Publish("bad topic", []byte("x"))
+	assert.Contains(t, err.Error(), ErrBadTopic.Error())
+	assert.Empty(t, ds[0].Commands())
+
+	assert.False(t, retryable(nsq.ErrProtocol{Reason: "E_BAD_BODY empty"}))
+	assert.True(t, retryable(nsq.ErrProtocol{Reason: "E_MPUB_FAILED"}))
+	assert.True(t, retryable(errors.New("EOF")))
+}
+
+func TestMultiAndDeferredPublish(t *testing.T) {
+	ds := startNSQD(t, 1)
+	p := newPublisher(t, RoundRobin, ds...)
+	assert.Equal(t, ErrEmptyBody, p.MultiPublish("test", nil))
+	assert.NoError(t, p.MultiPublish("test", [][]byte{[]byte("a"), []byte("b"), []byte("c")}))
+	assert.NoError(t, p.DeferredPublish("test", 1500*time.Millisecond, []byte("d")))
+
+	ms := ds[0].Messages("test")
+	if assert.Equal(t, 4, len(ms)) {
+		assert.Equal(t, "a", string(ms[0].Body))
+		assert.Equal(t, "c", string(ms[2].Body))
+		assert.Equal(t, 1500*time.Millisecond, ms[3].Defer)
+	}
+}
+
+func TestStop(t *testing.T) {
+	ds := startNSQD(t, 1)
+	p := newPublisher(t, RoundRobin, ds...)
+	p.Stop()
+	assert.Equal(t, ErrStopped, p.Publish("test", []byte("x")))
+}
+
+func TestStopDuringBackoff(t *testing.T) {
+	ds := startNSQD(t, 1)
+	p := newPublisherBackoff(t, RoundRobin, time.Minute, time.Minute, ds...)
+	ds[0].Close()
+	done := make(chan error, 1)
+	go func() { done <- p.Publish("test", []byte("x")) }()
+	for p.Stats()[0].Errors == 0 {
+		time.Sleep(time.Millisecond)
+	}
+	// Publish 在两轮之间等1分钟, Stop 不用等它
+	start := time.Now()
+	p.Stop()
+	assert.Equal(t, ErrStopped, <-done)
+	assert.True(t, time.Since(start) < time.Second)
+}
+
+func TestBatcher(t *testing.T) {
+	ds := startNSQD(t, 1)
+	p := newPublisher(t, RoundRobin, ds...)
+
+	b := p.NewBatcher("test", 3, time.Hour, nil)
+	for i := 0; i < 7; i++ {
+		assert.NoError(t, b.Add([]byte(strconv.Itoa(i))))
+	}
+	assert.Equal(t, 6, len(ds[0].Messages("test")))
+	assert.NoError(t, b.Stop())
+	assert.Equal(t, 7, len(ds[0].Messages("test")))
+	assert.Equal(t, ErrStopped, b.Add([]byte("x")))
+
+	mpub := 0
+	for _, c := range ds[0].Commands() {
+		if c == "MPUB test" {
+			mpub++
+		}
+	}
+	assert.Equal(t, 3, mpub)
+}
+
+func TestBatcherDelay(t *testing.T) {
+	ds := startNSQD(t, 1)
+	p := newPublisher(t, RoundRobin, ds...)
+
+	var mu sync.Mutex
+	var failed [][]byte
+	b := p.NewBatcher("test", 100, 10*time.Millisecond, func(err error, bodies [][]byte) {
+		mu.Lock()
+		failed = append(failed, bodies...)
+		mu.Unlock()
+	})
+	b.Add([]byte("a"))
+	b.Add([]byte("b"))
+	time.Sleep(100 * time.Millisecond)
+	assert.Equal(t, 2, len(ds[0].Messages("test")))
+
+	ds[0].SetFailPub(true)
+	b.Add([]byte("c"))
+	time.Sleep(100 * time.Millisecond)
+	mu.Lock()
+	assert.Equal(t, 1, len(failed))
+	mu.Unlock()
+}
@@ -0,0 +1,171 @@
+package nsqsub
+
+import (
+	"sync/atomic"
+	"time"
+
+	"github.com/buptbill220/go_performance/lib/mq"
+	"github.com/nsqio/go-nsq"
+)
+
+// defaultMsgTimeout is nsqd's --msg-timeout default, used when Config.MsgTimeout is not set.
+const defaultMsgTimeout = 60 * time.Second
+
+// BatchHandler returns one error per message, a nil slice means all succeeded.
+// The slice of messages is not reused after HandleBatch returns.
+type BatchHandler interface {
+	HandleBatch(ms []*nsq.Message) []error
+}
+
+type BatchHandlerFunc func(ms []*nsq.Message) []error
+
+func (f BatchHandlerFunc) HandleBatch(ms []*nsq.Message) []error {
+	return f(ms)
+}
+
+// NewBatch returns a Consumer that calls h with up to BatchSize messages,
+// Concurrency batches are handled at the same time.
+func NewBatch(opts Options, h BatchHandler) (*Consumer, error) {
+	opts.setDefaults(true)
+	if err := opts.validate(); err != nil {
+		return nil, err
+	}
+	c, err := newConsumer(opts)
+	if err != nil {
+		return nil, err
+	}
+	c.batch = h
+	c.in = make(chan *nsq.Message, opts.MaxInFlight)
+	c.stopping = make(chan struct{})
+	c.quit = make(chan struct{})
+	c.queued = make(map[*nsq.Message]time.Time, opts.MaxInFlight)
+	// go-nsq 的handler只负责转给攒批的goroutine
+	c.consumer.AddHandler(nsq.HandlerFunc(func(m *nsq.Message) error {
+		atomic.AddInt64(&c.inFlight, 1)
+		m.DisableAutoResponse()
+		c.queuedMu.Lock()
+		c.queued[m] = time.Now()
+		c.queuedMu.Unlock()
+		c.in <- m
+		return nil
+	}))
+	// 一个goroutine攒批, Concurrency 个处理; 都在忙时消息在 in 里排着, 下一批很快就满.
+	// 排着的消息nsqd照样算超时, 另起一个goroutine定时 TOUCH
+	full := make(chan []*nsq.Message)
+	c.wg.Add(2 + opts.Concurrency)
+	go c.collect(full)
+	go c.touchQueued()
+	for i := 0; i < opts.Concurrency; i++ {
+		go func() {
+			defer c.wg.Done()
+			for batch := range full {
+				c.runBatch(batch)
+			}
+		}()
+	}
+	return c, nil
+}
+
+func (c *Consumer) collect(full chan<- []*nsq.Message) {
+	defer c.wg.Done()
+	defer close(full)
+	size := c.opts.BatchSize
+	for {
+		var m *nsq.Message
+		select {
+		case m = <-c.in:
+		case <-c.quit:
+			// Stop 等到没有在途的消息才关 quit
+			return
+		}
+		batch := make([]*nsq.Message, 1, size)
+		batch[0] = m
+		timer := time.NewTimer(c.opts.BatchTimeout)
+	fill:
+		for len(batch) < size {
+			select {
+			case m := <-c.in:
+				batch = append(batch, m)
+			case <-timer.C:
+				break fill
+			case <-c.stopping:
+				batch = c.drainIn(batch)
+				break fill
+			}
+		}
+		timer.Stop()
+		full <- batch
+	}
+}
+
+// drainIn adds what is already queued to batch without waiting.
+func (c *Consumer) drainIn(batch []*nsq.Message) []*nsq.Message {
+	for len(batch) < c.opts.BatchSize {
+		select {
+		case m := <-c.in:
+			batch = append(batch, m)
+		default:
+			return batch
+		}
+	}
+	return batch
+}
+
+// touchQueued touches the messages not handed to HandleBatch yet every MsgTimeout/2,
+// so none of them waits longer than MsgTimeout/2 untouched.
+func (c *Consumer) touchQueued() {
+	defer c.wg.Done()
+	every := c.opts.Config.MsgTimeout / 2
+	if every <= 0 {
+		every = defaultMsgTimeout / 2
+	}
+	tick := time.NewTicker(every)
+	defer tick.Stop()
+	var ms []*nsq.Message
+	for {
+		select {
+		case <-tick.C:
+		case <-c.quit:
+			return
+		}
+		ms = ms[:0]
+		now := time.Now()
+		c.queuedMu.Lock()
+		for m := range c.queued {
+			ms = append(ms, m)
+			c.queued[m] = now
+		}
+		c.queuedMu.Unlock()
+		touch(ms)
+	}
+}
+
+// touch 要等连接的写goroutine, 不能拿着 queuedMu 调; 已经 FIN 的 Touch 什么都不做
+func touch(ms []*nsq.Message) {
+	for _, m := range ms {
+		m.Touch()
+	}
+}
+
+func (c *Consumer) runBatch(ms []*nsq.Message) {
+	// 排队超过 BatchTimeout 的交给 HandleBatch 前再 TOUCH, HandleBatch 至少有 MsgTimeout-BatchTimeout
+	var stale []*nsq.Message
+	now := time.Now()
+	c.queuedMu.Lock()
+	for _, m := range ms {
+		if now.Sub(c.queued[m]) > c.opts.BatchTimeout {
+			stale = append(stale, m)
+		}
+		delete(c.queued, m)
+	}
+	c.queuedMu.Unlock()
+	touch(stale)
+	start := time.Now()
+	errs := mq.CallBatch(len(ms), func() []error { return c.batch.HandleBatch(ms) })
+	c.record(time.Since(start))
+	atomic.AddUint64(&c.batches, 1)
+	for i, m := range ms {
+		c.settle(m, errs[i])
+		atomic.AddInt64(&c.inFlight, -1)
+	}
+}
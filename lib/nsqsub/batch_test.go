@@ -0,0 +1,238 @@
+package nsqsub
+
+import (
+	"errors"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/nsqio/go-nsq"
+	"github.com/stretchr/testify/assert"
+)
+
+type batchRecorder struct {
+	mu    sync.Mutex
+	sizes []int
+}
+
+func (r *batchRecorder) add(n int) {
+	r.mu.Lock()
+	r.sizes = append(r.sizes, n)
+	r.mu.Unlock()
+}
+
+func (r *batchRecorder) get() []int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]int(nil), r.sizes...)
+}
+
+func startBatch(t *testing.T, o Options, h BatchHandlerFunc) *Consumer {
+	c, err := NewBatch(o, h)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Start(); err != nil {
+		t.Fatal(err)
+	}
+	return c
+}
+
+func publishN(n interface{ Publish(string, ...[]byte) }, count int) {
+	var bodies [][]byte
+	for i := 0; i < count; i++ {
+		bodies = append(bodies, []byte(strconv.Itoa(i)))
+	}
+	n.Publish("t", bodies...)
+}
+
+func TestBatchValidate(t *testing.T) {
+	n := startNSQD(t)
+	o := testOptions(n)
+	o.Concurrency = 2
+	o.BatchSize = 5
+	o.MaxInFlight = 8
+	_, err := NewBatch(o, nil)
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(), ErrBatchSize.Error())
+	}
+
+	o.MaxInFlight = 0
+	o.Config = nsq.NewConfig()
+	o.Config.MsgTimeout = time.Second
+	o.BatchTimeout = time.Second
+	_, err = NewBatch(o, nil)
+	assert.Error(t, err)
+
+	o.BatchTimeout = 0
+	c, err := NewBatch(o, nil)
+	if assert.NoError(t, err) {
+		assert.Equal(t, 10, c.opts.MaxInFlight)
+		assert.Equal(t, 100*time.Millisecond, c.opts.BatchTimeout)
+		c.Stop()
+	}
+}
+
+func TestBatchSize(t *testing.T) {
+	n := startNSQD(t)
+	o := testOptions(n)
+	o.Concurrency = 1
+	o.BatchSize = 5
+	o.BatchTimeout = 10 * time.Second
+	var r batchRecorder
+	c := startBatch(t, o, func(ms []*nsq.Message) []error {
+		r.add(len(ms))
+		return nil
+	})
+	publishN(n, 10)
+	waitFor(t, "handled", func() bool { return c.Stats().Handled == 10 })
+	assert.Equal(t, []int{5, 5}, r.get())
+	assert.NoError(t, c.Stop())
+	assert.Equal(t, uint64(2), c.Stats().Batches)
+	assert.Equal(t, 10, n.Channel("t", "ch").Finished)
+}
+
+func TestBatchTimeout(t *testing.T) {
+	n := startNSQD(t)
+	o := testOptions(n)
+	o.BatchSize = 100
+	o.BatchTimeout = 20 * time.Millisecond
+	var r batchRecorder
+	c := startBatch(t, o, func(ms []*nsq.Message) []error {
+		r.add(len(ms))
+		return nil
+	})
+	publishN(n, 3)
+	waitFor(t, "handled", func() bool { return c.Stats().Handled == 3 })
+	assert.Equal(t, []int{3}, r.get())
+	assert.NoError(t, c.Stop())
+}
+
+func TestBatchPerMessageResult(t *testing.T) {
+	n := startNSQD(t)
+	o := testOptions(n)
+	o.Concurrency = 1
+	o.BatchSize = 4
+	o.BatchTimeout = 20 * time.Millisecond
+	o.MaxAttempts = 2
+	dl := &recorder{}
+	o.DeadLetter = dl
+	c := startBatch(t, o, func(ms []*nsq.Message) []error {
+		errs := make([]error, len(ms))
+		for i, m := range ms {
+			v, _ := strconv.Atoi(string(m.Body))
+			// 奇数第一次失败, 3 一直失败
+			if v == 3 || (v%2 == 1 && m.Attempts == 1) {
+				errs[i] = errors.New("no")
+			}
+		}
+		return errs
+	})
+	publishN(n, 4)
+	waitFor(t, "settled", func() bool {
+		st := c.Stats()
+		return st.Handled == 3 && st.DeadLettered == 1
+	})
+	assert.NoError(t, c.Stop())
+	st := c.Stats()
+	assert.Equal(t, uint64(3), st.Failed) // 1, 3, 3
+	assert.Equal(t, uint64(2), st.Requeued)
+	assert.Equal(t, []string{"3"}, dl.bodies)
+	assert.Equal(t, 4, n.Channel("t", "ch").Finished)
+}
+
+func TestBatchBadResults(t *testing.T) {
+	n := startNSQD(t)
+	o := testOptions(n)
+	o.Concurrency = 1
+	o.MaxAttempts = 1
+	o.BatchSize = 2
+	o.BatchTimeout = time.Second
+	calls := 0
+	c := startBatch(t, o, func(ms []*nsq.Message) []error {
+		calls++
+		if calls == 1 {
+			panic("boom")
+		}
+		return []error{nil}
+	})
+	publishN(n, 4)
+	waitFor(t, "dropped", func() bool { return c.Stats().Dropped == 4 })
+	assert.NoError(t, c.Stop())
+	assert.Equal(t, uint64(4), c.Stats().Failed)
+}
+
+func TestBatchMaxInFlight(t *testing.T) {
+	n := startNSQD(t)
+	o := testOptions(n)
+	o.Concurrency = 2
+	o.BatchSize = 5
+	o.BatchTimeout = 5 * time.Millisecond
+	var mu sync.Mutex
+	maxInFlight, maxBatch := 0, 0
+	c := startBatch(t, o, func(ms []*nsq.Message) []error {
+		mu.Lock()
+		if x := n.Channel("t", "ch").InFlight; x > maxInFlight {
+			maxInFlight = x
+		}
+		if len(ms) > maxBatch {
+			maxBatch = len(ms)
+		}
+		mu.Unlock()
+		time.Sleep(2 * time.Millisecond)
+		return nil
+	})
+	publishN(n, 100)
+	waitFor(t, "handled", func() bool { return c.Stats().Handled == 100 })
+	assert.NoError(t, c.Stop())
+	mu.Lock()
+	defer mu.Unlock()
+	assert.True(t, maxInFlight <= 10, "in flight %d", maxInFlight)
+	assert.Equal(t, 5, maxBatch)
+}
+
+func TestBatchStopFlushes(t *testing.T) {
+	n := startNSQD(t)
+	o := testOptions(n)
+	o.BatchSize = 100
+	o.BatchTimeout = 10 * time.Second
+	var r batchRecorder
+	c := startBatch(t, o, func(ms []*nsq.Message) []error {
+		r.add(len(ms))
+		return nil
+	})
+	publishN(n, 3)
+	waitFor(t, "in flight", func() bool { return c.Stats().InFlight == 3 })
+	start := time.Now()
+	// 不等 BatchTimeout, 马上处理攒了一半的batch
+	assert.NoError(t, c.Stop())
+	assert.True(t, time.Since(start) < time.Second)
+	assert.Equal(t, []int{3}, r.get())
+	assert.Equal(t, 3, n.Channel("t", "ch").Finished)
+}
+
+func TestBatchTouchQueued(t *testing.T) {
+	n := startNSQD(t)
+	o := testOptions(n)
+	o.Concurrency = 1
+	o.BatchSize = 2
+	o.MaxInFlight = 6
+	o.BatchTimeout = 5 * time.Millisecond
+	o.Config = nsq.NewConfig()
+	o.Config.MsgTimeout = 200 * time.Millisecond
+	var r batchRecorder
+	c := startBatch(t, o, func(ms []*nsq.Message) []error {
+		r.add(len(ms))
+		time.Sleep(150 * time.Millisecond)
+		return nil
+	})
+	// 3批一共450ms, 最后一批排队的时间超过 MsgTimeout, 没有 TOUCH 就会被重投
+	publishN(n, 6)
+	waitFor(t, "handled", func() bool { return c.Stats().Handled == 6 })
+	assert.NoError(t, c.Stop())
+	assert.Equal(t, []int{2, 2, 2}, r.get())
+	ch := n.Channel("t", "ch")
+	assert.Equal(t, 0, ch.TimedOut)
+	assert.Equal(t, 6, ch.Finished)
+}
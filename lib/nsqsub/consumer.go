@@ -0,0 +1,371 @@
+package nsqsub
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/buptbill220/go_performance/lib/mq"
+	"github.com/buptbill220/go_performance/lib/schedprobe"
+	"github.com/nsqio/go-nsq"
+)
+
+/*
+	nsq消费的框架: 并发数和 MaxInFlight 要配得上, 退出时先把在途的消息处理完, 失败按指数退避重排,
+	超过次数发到死信topic
+
+	c, err := nsqsub.New(nsqsub.Options{
+		Topic:       "topic",
+		Channel:     "ch",
+		Lookupd:     []string{"127.0.0.1:4161"},
+		Concurrency: 25,
+		MaxInFlight: 25,
+		DeadLetter:  publisher,    // *nsqpub.Publisher 或 *nsq.Producer
+	}, nsqsub.HandlerFunc(func(m *nsq.Message) error { ... }))
+	c.Start()
+	...
+	c.Stop()    // CLS, 最多等 DrainTimeout
+	c.Stats()   // 成功, 失败, 重排, 死信数和处理延迟
+
+	写库之类喜欢攒批的用 NewBatch, 攒够 BatchSize 条或者第一条等了 BatchTimeout 就调一次 HandleBatch,
+	按返回的每条的 error 分别 FIN 或重排. 还在攒批或者排队等 HandleBatch 的消息每 MsgTimeout/2
+	(没设 Config.MsgTimeout 时按nsqd默认的60s算) TOUCH 一次, 不会被nsqd超时重投;
+	HandleBatch 至少有 MsgTimeout-BatchTimeout, 比这久的话要自己调 m.Touch()
+
+	handler 返回 error 或 panic 都算失败, 第 n 次失败后 BackoffBase*2^(n-1) (不超过 BackoffMax) 后再投;
+	第 MaxAttempts 次还失败就发到 DeadLetterTopic, 没配 DeadLetter 时丢掉并打日志
+*/
+
+var (
+	ErrConcurrency  = errors.New("nsqsub: MaxInFlight is less than Concurrency, handlers would sit idle")
+	ErrNoAddrs      = errors.New("nsqsub: no nsqlookupd or nsqd address")
+	ErrDrainTimeout = errors.New("nsqsub: messages still in flight after DrainTimeout")
+	ErrBatchSize    = errors.New("nsqsub: MaxInFlight is less than Concurrency*BatchSize, batches would never fill")
+)
+
+type HandlerFunc func(m *nsq.Message) error
+
+// Publisher is where dead letters go, *nsq.Producer and *nsqpub.Publisher implement it.
+type Publisher interface {
+	Publish(topic string, body []byte) error
+}
+
+type Options struct {
+	Topic   string
+	Channel string
+	Lookupd []string // nsqlookupd HTTP addresses
+	NSQD    []string // nsqd TCP addresses, connected directly
+	Config  *nsq.Config
+
+	// Concurrency is the number of handler goroutines (concurrent HandleBatch calls for NewBatch), 0 is 1
+	Concurrency int
+	// MaxInFlight over all nsqd, 0 is Concurrency (Concurrency*BatchSize for NewBatch), less is an error
+	MaxInFlight int
+
+	// BatchSize and BatchTimeout are used by NewBatch only
+	BatchSize    int           // 0 is 100
+	BatchTimeout time.Duration // 0 is 100ms, must be less than Config.MsgTimeout when it is set
+
+	// DrainTimeout bounds how long Stop waits for messages in flight, 0 is 30s
+	DrainTimeout time.Duration
+
+	// MaxAttempts is the number of deliveries before a message is dead-lettered, 0 is 5
+	MaxAttempts uint16
+	BackoffBase time.Duration // 0 is 1s
+	BackoffMax  time.Duration // 0 is 10m, must not exceed nsqd --max-req-timeout (1h by default)
+
+	DeadLetter      Publisher
+	DeadLetterTopic string // "" is Topic + "_dead"
+
+	Logger   *log.Logger  // nil logs to stderr
+	LogLevel nsq.LogLevel // the zero value, LogLevelDebug, is raised to LogLevelInfo
+}
+
+func (o *Options) setDefaults(batch bool) {
+	if o.Config == nil {
+		o.Config = nsq.NewConfig()
+	}
+	if o.Concurrency <= 0 {
+		o.Concurrency = 1
+	}
+	if batch {
+		if o.BatchSize <= 0 {
+			o.BatchSize = 100
+		}
+		if o.BatchTimeout <= 0 {
+			o.BatchTimeout = 100 * time.Millisecond
+		}
+	} else {
+		o.BatchSize = 1
+	}
+	if o.MaxInFlight == 0 {
+		o.MaxInFlight = o.Concurrency * o.BatchSize
+	}
+	if o.DrainTimeout <= 0 {
+		o.DrainTimeout = 30 * time.Second
+	}
+	if o.MaxAttempts == 0 {
+		o.MaxAttempts = 5
+	}
+	if o.BackoffBase <= 0 {
+		o.BackoffBase = time.Second
+	}
+	if o.BackoffMax <= 0 {
+		o.BackoffMax = 10 * time.Minute
+	}
+	if o.DeadLetterTopic == "" {
+		o.DeadLetterTopic = o.Topic + "_dead"
+	}
+	if o.Logger == nil {
+		o.Logger = log.New(os.Stderr, "", log.LstdFlags)
+	}
+	if o.LogLevel == nsq.LogLevelDebug {
+		o.LogLevel = nsq.LogLevelInfo
+	}
+}
+
+func (o *Options) validate() error {
+	if len(o.Lookupd) == 0 && len(o.NSQD) == 0 {
+		return ErrNoAddrs
+	}
+	if o.MaxInFlight < o.Concurrency {
+		return fmt.Errorf("%v (%d < %d)", ErrConcurrency, o.MaxInFlight, o.Concurrency)
+	}
+	if o.MaxInFlight < o.Concurrency*o.BatchSize {
+		return fmt.Errorf("%v (%d < %d*%d)", ErrBatchSize, o.MaxInFlight, o.Concurrency, o.BatchSize)
+	}
+	if o.BatchSize > 1 && o.Config.MsgTimeout > 0 && o.BatchTimeout >= o.Config.MsgTimeout {
+		return fmt.Errorf("nsqsub: BatchTimeout %v is not less than MsgTimeout %v", o.BatchTimeout, o.Config.MsgTimeout)
+	}
+	if o.DeadLetter != nil && !nsq.IsValidTopicName(o.DeadLetterTopic) {
+		return fmt.Errorf("nsqsub: invalid dead letter topic %q", o.DeadLetterTopic)
+	}
+	return nil
+}
+
+type Consumer struct {
+	opts     Options
+	handler  HandlerFunc
+	batch    BatchHandler
+	consumer *nsq.Consumer
+
+	// batch mode, in is never closed: go-nsq may still call the handler after its forced exit
+	in       chan *nsq.Message
+	stopping chan struct{}
+	stopOnce sync.Once
+	quit     chan struct{}
+	quitOnce sync.Once
+	wg       sync.WaitGroup
+	// batch mode, messages not handed to HandleBatch yet, see touchQueued
+	queuedMu sync.Mutex
+	queued   map[*nsq.Message]time.Time // last TOUCH or arrival
+
+	batches  uint64
+	handled  uint64
+	failed   uint64
+	requeued uint64
+	dead     uint64
+	dropped  uint64
+	inFlight int64
+
+	mu      sync.Mutex
+	latency schedprobe.Histogram
+}
+
+func New(opts Options, h HandlerFunc) (*Consumer, error) {
+	opts.setDefaults(false)
+	if err := opts.validate(); err != nil {
+		return nil, err
+	}
+	c, err := newConsumer(opts)
+	if err != nil {
+		return nil, err
+	}
+	c.handler = h
+	c.consumer.AddConcurrentHandlers(nsq.HandlerFunc(c.handle), opts.Concurrency)
+	return c, nil
+}
+
+func newConsumer(opts Options) (*Consumer, error) {
+	cfg := *opts.Config
+	cfg.MaxInFlight = opts.MaxInFlight
+	// 次数由 handle 自己判断, go-nsq 超过 MaxAttempts 会不调 handler 直接 FIN
+	cfg.MaxAttempts = 0
+	nc, err := nsq.NewConsumer(opts.Topic, opts.Channel, &cfg)
+	if err != nil {
+		return nil, err
+	}
+	nc.SetLogger(opts.Logger, opts.LogLevel)
+	return &Consumer{opts: opts, consumer: nc}, nil
+}
+
+// Start connects to the nsqd and nsqlookupd addresses.
+func (c *Consumer) Start() error {
+	if len(c.opts.NSQD) > 0 {
+		if err := c.consumer.ConnectToNSQDs(c.opts.NSQD); err != nil {
+			return err
+		}
+	}
+	if len(c.opts.Lookupd) > 0 {
+		if err := c.consumer.ConnectToNSQLookupds(c.opts.Lookupd); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// drainPoll is how often Stop checks the in flight count after go-nsq stopped.
+const drainPoll = 5 * time.Millisecond
+
+// Stop stops taking messages and waits up to DrainTimeout for the ones in flight.
+func (c *Consumer) Stop() error {
+	timeout := time.NewTimer(c.opts.DrainTimeout)
+	defer timeout.Stop()
+	if c.stopping != nil {
+		// 攒了一半的batch不等 BatchTimeout 了
+		c.stopOnce.Do(func() { close(c.stopping) })
+	}
+	c.consumer.Stop()
+	select {
+	case <-c.consumer.StopChan:
+	case <-timeout.C:
+		return c.errDrain()
+	}
+
+	// go-nsq 的 Stop 最多等30s, 之后不管还在跑的handler直接关 StopChan, 所以还要等自己的计数
+	tick := time.NewTicker(drainPoll)
+	defer tick.Stop()
+	for atomic.LoadInt64(&c.inFlight) > 0 {
+		select {
+		case <-tick.C:
+		case <-timeout.C:
+			return c.errDrain()
+		}
+	}
+	if c.quit != nil {
+		c.quitOnce.Do(func() { close(c.quit) })
+		done := make(chan struct{})
+		go func() {
+			c.wg.Wait()
+			close(done)
+		}()
+		select {
+		case <-done:
+		case <-timeout.C:
+			return c.errDrain()
+		}
+	}
+	return nil
+}
+
+func (c *Consumer) errDrain() error {
+	return fmt.Errorf("%v (%d in flight)", ErrDrainTimeout, atomic.LoadInt64(&c.inFlight))
+}
+
+// Backoff is the requeue delay after the n-th failed attempt.
+func (c *Consumer) Backoff(attempt uint16) time.Duration {
+	d := c.opts.BackoffBase
+	for i := uint16(1); i < attempt && d < c.opts.BackoffMax; i++ {
+		d *= 2
+	}
+	if d > c.opts.BackoffMax {
+		d = c.opts.BackoffMax
+	}
+	return d
+}
+
+func (c *Consumer) handle(m *nsq.Message) error {
+	atomic.AddInt64(&c.inFlight, 1)
+	defer atomic.AddInt64(&c.inFlight, -1)
+	m.DisableAutoResponse()
+
+	start := time.Now()
+	err := mq.Call(func() error { return c.handler(m) })
+	c.record(time.Since(start))
+	c.settle(m, err)
+	return nil
+}
+
+func (c *Consumer) record(d time.Duration) {
+	c.mu.Lock()
+	c.latency.Record(d)
+	c.mu.Unlock()
+}
+
+// settle finishes, requeues or dead-letters m depending on the handler result,
+// unless the handler already responded.
+func (c *Consumer) settle(m *nsq.Message, err error) {
+	if err == nil {
+		atomic.AddUint64(&c.handled, 1)
+	} else {
+		atomic.AddUint64(&c.failed, 1)
+	}
+	if m.HasResponded() {
+		// handler 自己 FIN 或 REQ 了, 结果只计数
+		return
+	}
+	if err == nil {
+		m.Finish()
+		return
+	}
+	if m.Attempts < c.opts.MaxAttempts {
+		atomic.AddUint64(&c.requeued, 1)
+		// RequeueWithoutBackoff 只推迟这一条, Requeue 会让整个consumer进入go-nsq的退避
+		m.RequeueWithoutBackoff(c.Backoff(m.Attempts))
+		return
+	}
+	if c.opts.DeadLetter == nil {
+		atomic.AddUint64(&c.dropped, 1)
+		c.opts.Logger.Printf("nsqsub: dropping message %s after %d attempts: %v", m.ID[:], m.Attempts, err)
+		m.Finish()
+		return
+	}
+	if perr := c.opts.DeadLetter.Publish(c.opts.DeadLetterTopic, m.Body); perr != nil {
+		// 死信发不出去就继续重排, 不丢消息
+		c.opts.Logger.Printf("nsqsub: dead letter %s to %s: %v", m.ID[:], c.opts.DeadLetterTopic, perr)
+		atomic.AddUint64(&c.requeued, 1)
+		m.RequeueWithoutBackoff(c.opts.BackoffMax)
+		return
+	}
+	atomic.AddUint64(&c.dead, 1)
+	m.Finish()
+}
+
+// Stats counts messages, in batch mode Latency is per HandleBatch call.
+type Stats struct {
+	Handled      uint64 // handler returned nil
+	Failed       uint64 // handler returned an error or panicked
+	Requeued     uint64
+	DeadLettered uint64
+	Dropped      uint64 // failed MaxAttempts times without a DeadLetter publisher
+	InFlight     int64  // received and not yet finished or requeued
+	Batches      uint64
+	Latency      schedprobe.Summary
+	Connections  int
+}
+
+func (s Stats) String() string {
+	return fmt.Sprintf("handled=%d failed=%d requeued=%d dead=%d dropped=%d in_flight=%d batches=%d conns=%d latency: %v",
+		s.Handled, s.Failed, s.Requeued, s.DeadLettered, s.Dropped, s.InFlight, s.Batches, s.Connections, s.Latency)
+}
+
+func (c *Consumer) Stats() Stats {
+	c.mu.Lock()
+	latency := schedprobe.Summarize(&c.latency)
+	c.mu.Unlock()
+	return Stats{
+		Handled:      atomic.LoadUint64(&c.handled),
+		Failed:       atomic.LoadUint64(&c.failed),
+		Requeued:     atomic.LoadUint64(&c.requeued),
+		DeadLettered: atomic.LoadUint64(&c.dead),
+		Dropped:      atomic.LoadUint64(&c.dropped),
+		InFlight:     atomic.LoadInt64(&c.inFlight),
+		Batches:      atomic.LoadUint64(&c.batches),
+		Latency:      latency,
+		Connections:  c.consumer.Stats().Connections,
+	}
+}
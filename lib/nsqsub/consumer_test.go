@@ -0,0 +1,243 @@
+package nsqsub
+
+import (
+	"errors"
+	"io/ioutil"
+	"log"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/buptbill220/go_performance/lib/nsqfake"
+	"github.com/nsqio/go-nsq"
+	"github.com/stretchr/testify/assert"
+)
+
+func startNSQD(t *testing.T) *nsqfake.NSQD {
+	n, err := nsqfake.NewNSQD("127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(n.Close)
+	return n
+}
+
+func testOptions(n *nsqfake.NSQD) Options {
+	return Options{
+		Topic:        "t",
+		Channel:      "ch",
+		NSQD:         []string{n.Addr()},
+		Concurrency:  4,
+		BackoffBase:  time.Millisecond,
+		BackoffMax:   4 * time.Millisecond,
+		DrainTimeout: time.Second,
+		Logger:       log.New(ioutil.Discard, "", 0),
+	}
+}
+
+func start(t *testing.T, o Options, h HandlerFunc) *Consumer {
+	c, err := New(o, h)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Start(); err != nil {
+		t.Fatal(err)
+	}
+	return c
+}
+
+func waitFor(t *testing.T, what string, cond func() bool) {
+	deadline := time.Now().Add(5 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestValidate(t *testing.T) {
+	n := startNSQD(t)
+	o := testOptions(n)
+	o.MaxInFlight = 2
+	_, err := New(o, nil)
+	assert.Contains(t, err.Error(), ErrConcurrency.Error())
+
+	o = testOptions(n)
+	o.NSQD = nil
+	_, err = New(o, nil)
+	assert.Equal(t, ErrNoAddrs, err)
+
+	o = testOptions(n)
+	o.DeadLetter = &recorder{}
+	o.DeadLetterTopic = "bad topic"
+	_, err = New(o, nil)
+	assert.Error(t, err)
+}
+
+func TestBackoff(t *testing.T) {
+	c := &Consumer{opts: Options{BackoffBase: time.Second, BackoffMax: 5 * time.Second}}
+	assert.Equal(t, time.Second, c.Backoff(1))
+	assert.Equal(t, 2*time.Second, c.Backoff(2))
+	assert.Equal(t, 4*time.Second, c.Backoff(3))
+	assert.Equal(t, 5*time.Second, c.Backoff(4))
+	assert.Equal(t, 5*time.Second, c.Backoff(100))
+}
+
+func TestHandle(t *testing.T) {
+	n := startNSQD(t)
+	c := start(t, testOptions(n), func(m *nsq.Message) error {
+		time.Sleep(time.Millisecond)
+		return nil
+	})
+	n.Publish("t", []byte("a"), []byte("b"), []byte("c"))
+	waitFor(t, "handled", func() bool { return c.Stats().Handled == 3 })
+	assert.NoError(t, c.Stop())
+	st := c.Stats()
+	assert.Equal(t, uint64(3), st.Latency.Count)
+	assert.True(t, st.Latency.P50 >= time.Millisecond)
+	assert.Equal(t, 3, n.Channel("t", "ch").Finished)
+}
+
+type recorder struct {
+	mu     sync.Mutex
+	fail   bool
+	topics []string
+	bodies []string
+}
+
+func (r *recorder) Publish(topic string, body []byte) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.fail {
+		return errors.New("down")
+	}
+	r.topics = append(r.topics, topic)
+	r.bodies = append(r.bodies, string(body))
+	return nil
+}
+
+func TestRetryAndDeadLetter(t *testing.T) {
+	n := startNSQD(t)
+	dl := &recorder{}
+	o := testOptions(n)
+	o.MaxAttempts = 3
+	o.DeadLetter = dl
+	var calls int32
+	c := start(t, o, func(m *nsq.Message) error {
+		atomic.AddInt32(&calls, 1)
+		if string(m.Body) == "bad" {
+			return errors.New("bad message")
+		}
+		if m.Attempts == 1 {
+			panic("first try")
+		}
+		return nil
+	})
+	n.Publish("t", []byte("bad"), []byte("good"))
+	waitFor(t, "dead letter", func() bool {
+		st := c.Stats()
+		return st.DeadLettered == 1 && st.Handled == 1
+	})
+	assert.NoError(t, c.Stop())
+
+	st := c.Stats()
+	assert.Equal(t, uint64(4), st.Failed) // bad x3, good x1 (panic)
+	assert.Equal(t, uint64(3), st.Requeued)
+	assert.Equal(t, int32(5), atomic.LoadInt32(&calls))
+	assert.Equal(t, []string{"t_dead"}, dl.topics)
+	assert.Equal(t, []string{"bad"}, dl.bodies)
+	assert.Equal(t, 3, n.Channel("t", "ch").Requeued)
+}
+
+func TestDeadLetterDown(t *testing.T) {
+	n := startNSQD(t)
+	dl := &recorder{fail: true}
+	o := testOptions(n)
+	o.MaxAttempts = 1
+	o.DeadLetter = dl
+	c := start(t, o, func(m *nsq.Message) error { return errors.New("no") })
+	n.Publish("t", []byte("x"))
+	// 死信发不出去就一直重排
+	waitFor(t, "requeue", func() bool { return c.Stats().Requeued >= 2 })
+	dl.mu.Lock()
+	dl.fail = false
+	dl.mu.Unlock()
+	waitFor(t, "dead letter", func() bool { return c.Stats().DeadLettered == 1 })
+	assert.NoError(t, c.Stop())
+}
+
+func TestDrop(t *testing.T) {
+	n := startNSQD(t)
+	o := testOptions(n)
+	o.MaxAttempts = 2
+	c := start(t, o, func(m *nsq.Message) error { return errors.New("no") })
+	n.Publish("t", []byte("x"))
+	waitFor(t, "drop", func() bool { return c.Stats().Dropped == 1 })
+	assert.NoError(t, c.Stop())
+	assert.Equal(t, 1, n.Channel("t", "ch").Finished)
+}
+
+func TestDrain(t *testing.T) {
+	n := startNSQD(t)
+	release := make(chan struct{})
+	c := start(t, testOptions(n), func(m *nsq.Message) error {
+		<-release
+		return nil
+	})
+	n.Publish("t", []byte("a"), []byte("b"))
+	waitFor(t, "in flight", func() bool { return c.Stats().InFlight == 2 })
+	time.AfterFunc(50*time.Millisecond, func() { close(release) })
+	// Stop 等在途的两条处理完才返回
+	assert.NoError(t, c.Stop())
+	assert.Equal(t, uint64(2), c.Stats().Handled)
+	assert.Equal(t, 2, n.Channel("t", "ch").Finished)
+}
+
+func TestDrainTimeout(t *testing.T) {
+	n := startNSQD(t)
+	release := make(chan struct{})
+	defer close(release)
+	o := testOptions(n)
+	o.DrainTimeout = 50 * time.Millisecond
+	c := start(t, o, func(m *nsq.Message) error {
+		<-release
+		return nil
+	})
+	n.Publish("t", []byte("a"))
+	waitFor(t, "in flight", func() bool { return c.Stats().InFlight == 1 })
+	err := c.Stop()
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(), ErrDrainTimeout.Error())
+	}
+}
+
+// go-nsq 的 Stop 30s 后不等handler就关 StopChan, 这里用没连接的consumer(StopChan马上关)
+// 加一条假的在途消息模拟这种情况, Stop 要按自己的计数等到 DrainTimeout
+func TestDrainPastStopChan(t *testing.T) {
+	n := startNSQD(t)
+	o := testOptions(n)
+	o.DrainTimeout = 50 * time.Millisecond
+	c, err := New(o, func(m *nsq.Message) error { return nil })
+	if err != nil {
+		t.Fatal(err)
+	}
+	atomic.AddInt64(&c.inFlight, 1)
+	err = c.Stop()
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(), "(1 in flight)")
+	}
+
+	o.DrainTimeout = 5 * time.Second
+	c, err = NewBatch(o, BatchHandlerFunc(func(ms []*nsq.Message) []error { return nil }))
+	if err != nil {
+		t.Fatal(err)
+	}
+	atomic.AddInt64(&c.inFlight, 1)
+	time.AfterFunc(30*time.Millisecond, func() { atomic.AddInt64(&c.inFlight, -1) })
+	start := time.Now()
+	assert.NoError(t, c.Stop())
+	assert.True(t, time.Since(start) >= 30*time.Millisecond)
+	assert.NoError(t, c.Stop())
+}
@@ -0,0 +1,94 @@
+//go:build linux
+
+package perfevent
+
+import (
+	"fmt"
+	"syscall"
+	"unsafe"
+)
+
+// attr is struct perf_event_attr up to PERF_ATTR_SIZE_VER5.
+type attr struct {
+	Type             uint32
+	Size             uint32
+	Config           uint64
+	SamplePeriod     uint64
+	SampleType       uint64
+	ReadFormat       uint64
+	Bits             uint64
+	WakeupEvents     uint32
+	BpType           uint32
+	Config1          uint64
+	Config2          uint64
+	BranchSampleType uint64
+	SampleRegsUser   uint64
+	SampleStackUser  uint32
+	ClockID          int32
+	SampleRegsIntr   uint64
+	AuxWatermark     uint32
+	SampleMaxStack   uint16
+	_                uint16
+}
+
+const (
+	bitDisabled      = 1 << 0
+	bitExcludeKernel = 1 << 5
+	bitExcludeHV     = 1 << 6
+
+	formatTotalTimeEnabled = 1 << 0
+	formatTotalTimeRunning = 1 << 1
+
+	flagFDCloexec = 1 << 3
+
+	iocEnable  = 0x2400
+	iocDisable = 0x2401
+	iocReset   = 0x2403
+)
+
+type counter struct {
+	ev Event
+	fd int
+}
+
+func open(ev Event) (*counter, error) {
+	a := attr{
+		Type:       ev.typ,
+		Config:     ev.config,
+		ReadFormat: formatTotalTimeEnabled | formatTotalTimeRunning,
+		// 只统计用户态, perf_event_paranoid=2 时普通用户也能打开
+		Bits: bitDisabled | bitExcludeKernel | bitExcludeHV,
+	}
+	a.Size = uint32(unsafe.Sizeof(a))
+	// pid=0, cpu=-1: 当前线程, 不管在哪个cpu上
+	fd, _, errno := syscall.Syscall6(syscall.SYS_PERF_EVENT_OPEN, uintptr(unsafe.Pointer(&a)), 0, ^uintptr(0), ^uintptr(0), flagFDCloexec, 0)
+	if errno != 0 {
+		return nil, fmt.Errorf("perf_event_open %s: %v", ev.Name, errno)
+	}
+	return &counter{ev: ev, fd: int(fd)}, nil
+}
+
+func (c *counter) ioctl(req uintptr) {
+	syscall.Syscall(syscall.SYS_IOCTL, uintptr(c.fd), req, 0)
+}
+
+func (c *counter) enable()  { c.ioctl(iocEnable) }
+func (c *counter) disable() { c.ioctl(iocDisable) }
+func (c *counter) reset()   { c.ioctl(iocReset) }
+
+func (c *counter) read() (value, enabled, running uint64, err error) {
+	// read_format 是 value, time_enabled, time_running, 本机字节序
+	var vals [3]uint64
+	n, err := syscall.Read(c.fd, (*[24]byte)(unsafe.Pointer(&vals))[:])
+	if err != nil {
+		return 0, 0, 0, err
+	}
+	if n != 24 {
+		return 0, 0, 0, fmt.Errorf("perf event %s: short read %d", c.ev.Name, n)
+	}
+	return vals[0], vals[1], vals[2], nil
+}
+
+func (c *counter) close() {
+	syscall.Close(c.fd)
+}
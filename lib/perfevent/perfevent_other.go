@@ -0,0 +1,25 @@
+//go:build !linux
+
+package perfevent
+
+import "errors"
+
+var errUnsupported = errors.New("perf events are only supported on linux")
+
+type counter struct {
+	ev Event
+}
+
+func open(ev Event) (*counter, error) {
+	return nil, errUnsupported
+}
+
+func (c *counter) enable()  {}
+func (c *counter) disable() {}
+func (c *counter) reset()   {}
+
+func (c *counter) read() (value, enabled, running uint64, err error) {
+	return 0, 0, 0, errUnsupported
+}
+
+func (c *counter) close() {}
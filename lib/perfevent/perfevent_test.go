@@ -0,0 +1,100 @@
+package perfevent
+
+import (
+	"flag"
+	"runtime"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGroup(t *testing.T) {
+	runtime.LockOSThread()
+	defer runtime.UnlockOSThread()
+	g, err := Open(DefaultEvents...)
+	if err != nil {
+		t.Skipf("perf events unavailable: %v", err)
+	}
+	defer g.Close()
+	g.Start()
+	sum := 0
+	for i := 0; i < 1000000; i++ {
+		sum += i
+	}
+	g.Stop()
+	values := g.Read()
+	for _, ev := range g.Events() {
+		if v, ok := values[ev.Name]; ok {
+			assert.True(t, v >= 0, ev.Name)
+		}
+	}
+	if ins, ok := values[Instructions.Name]; ok {
+		assert.True(t, ins > 1000000, "%v instructions", ins)
+	}
+	assert.True(t, sum > 0)
+}
+
+func TestStartDisabled(t *testing.T) {
+	res := testing.Benchmark(func(b *testing.B) {
+		c := Start(b)
+		assert.Nil(t, c)
+		c.Stop()
+	})
+	assert.Equal(t, 0, len(res.Extra))
+}
+
+func TestStartEnabled(t *testing.T) {
+	flag.Set("perfevent", "true")
+	defer flag.Set("perfevent", "false")
+	defer setBenchtime("10ms")()
+
+	res := testing.Benchmark(func(b *testing.B) {
+		c := Start(b)
+		sum := 0
+		for i := 0; i < b.N; i++ {
+			sum += i
+		}
+		c.Stop()
+	})
+	// 没有计数器时benchmark照常跑, 只是没有额外的指标
+	assert.True(t, res.N > 0)
+	if _, err := Open(Instructions); err == nil {
+		assert.True(t, res.Extra["instructions/op"] > 0, "%v", res.Extra)
+	}
+}
+
+func TestReport(t *testing.T) {
+	defer setBenchtime("100x")()
+	res := testing.Benchmark(func(b *testing.B) {
+		b.N = 10
+		report(b, map[string]float64{"cycles": 200, "instructions": 300, "LLC-misses": 5})
+	})
+	assert.Equal(t, 20.0, res.Extra["cycles/op"])
+	assert.Equal(t, 0.5, res.Extra["LLC-misses/op"])
+	assert.Equal(t, 1.5, res.Extra["IPC"])
+}
+
+func setBenchtime(d string) (restore func()) {
+	old := flag.Lookup("test.benchtime").Value.String()
+	flag.Set("test.benchtime", d)
+	return func() { flag.Set("test.benchtime", old) }
+}
+
+// 虚拟机里通常没有硬件计数器, 用软件事件 task-clock 验证 open/read 这条路
+func TestSoftwareEvent(t *testing.T) {
+	runtime.LockOSThread()
+	defer runtime.UnlockOSThread()
+	g, err := Open(Event{Name: "task-clock", typ: 1, config: 1})
+	if err != nil {
+		t.Skipf("perf events unavailable: %v", err)
+	}
+	defer g.Close()
+	g.Start()
+	sum := 0
+	for i := 0; i < 10000000; i++ {
+		sum += i
+	}
+	g.Stop()
+	assert.True(t, g.Read()["task-clock"] > 0, "%v", g.Read())
+	assert.True(t, sum > 0)
+}
@@ -0,0 +1,317 @@
+package query
+
+import (
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+	"unsafe"
+)
+
+/*
+	按 `query:"name"` tag 把query绑定到struct上, 缺省值用 `default:"xx"`:
+
+	type FeedParams struct {
+		DeviceId  int64   `query:"device_id"`
+		Latitude  float64 `query:"latitude"`
+		AbVersion []int64 `query:"ab_version"`
+		Count     int     `query:"count" default:"20"`
+	}
+
+	字段的offset和setter在NewBinder时算好, Bind时不再走反射(和 op/model.go 里按offset取值一样)
+	列表类型按 ',' 分隔, 先解码再分隔, 所以 160531%2C164185 也能正确拆开
+	空值按没有传处理
+*/
+
+const maxBindFields = 64
+
+type setter func(p unsafe.Pointer, s string) error
+
+type fieldPlan struct {
+	name   string
+	offset uintptr
+	set    setter
+	def    string
+	hasDef bool
+}
+
+type Binder struct {
+	typ    reflect.Type
+	fields []fieldPlan
+	index  map[string]int
+}
+
+// NewBinder builds the field plan for the struct type of v, v can be a struct or a pointer to struct.
+func NewBinder(v interface{}) (*Binder, error) {
+	typ := reflect.TypeOf(v)
+	if typ != nil && typ.Kind() == reflect.Ptr {
+		typ = typ.Elem()
+	}
+	if typ == nil || typ.Kind() != reflect.Struct {
+		return nil, fmt.Errorf("query: bind target must be a struct, got %v", typ)
+	}
+
+	// seen 是一个uint64位图, 先数字段数, 超了就不用算plan
+	n := 0
+	for i := 0; i < typ.NumField(); i++ {
+		if name := typ.Field(i).Tag.Get("query"); name != "" && name != "-" {
+			n++
+		}
+	}
+	if n > maxBindFields {
+		return nil, fmt.Errorf("query: too many fields, max %d", maxBindFields)
+	}
+
+	b := &Binder{
+		typ:   typ,
+		index: make(map[string]int),
+	}
+	scratch := reflect.New(typ)
+	for i := 0; i < typ.NumField(); i++ {
+		f := typ.Field(i)
+		name := f.Tag.Get("query")
+		if name == "" || name == "-" {
+			continue
+		}
+		if f.PkgPath != "" {
+			return nil, fmt.Errorf("query: field %s is unexported", f.Name)
+		}
+		if _, ok := b.index[name]; ok {
+			return nil, fmt.Errorf("query: duplicate key %q", name)
+		}
+		set := newSetter(f.Type)
+		if set == nil {
+			return nil, fmt.Errorf("query: unsupported type %v of field %s", f.Type, f.Name)
+		}
+		plan := fieldPlan{
+			name:   name,
+			offset: f.Offset,
+			set:    set,
+		}
+		plan.def, plan.hasDef = f.Tag.Lookup("default")
+		if plan.hasDef {
+			p := unsafe.Pointer(uintptr(scratch.UnsafePointer()) + f.Offset)
+			if err := set(p, plan.def); err != nil {
+				return nil, fmt.Errorf("query: bad default of field %s: %v", f.Name, err)
+			}
+		}
+		b.index[name] = len(b.fields)
+		b.fields = append(b.fields, plan)
+	}
+	return b, nil
+}
+
+// MustNewBinder is like NewBinder but panics on error, for package level binders.
+func MustNewBinder(v interface{}) *Binder {
+	b, err := NewBinder(v)
+	if err != nil {
+		panic(err)
+	}
+	return b
+}
+
+// Bind fills dst, which must be a pointer to the struct type of the Binder.
+// Fields absent from the query get their default, or are left untouched.
+// A bad value does not stop the other fields from being bound: its field is handled
+// as absent and the first *BindError is returned after all fields were set.
+func (b *Binder) Bind(query string, dst interface{}) error {
+	v := reflect.ValueOf(dst)
+	if v.Kind() != reflect.Ptr || v.Type().Elem() != b.typ || v.IsNil() {
+		return fmt.Errorf("query: bind target must be *%v, got %T", b.typ, dst)
+	}
+	base := v.UnsafePointer()
+
+	var seen uint64
+	var first error
+	var buf [128]byte
+	it := NewIter(query)
+	for it.Next() {
+		key := it.Key()
+		var idx int
+		var ok bool
+		if key.NeedUnescape() {
+			k, err := key.AppendUnescape(buf[:0])
+			if err != nil {
+				continue
+			}
+			idx, ok = b.index[string(k)]
+		} else {
+			idx, ok = b.index[string(key)]
+		}
+		if !ok || seen&(1<<uint(idx)) != 0 || it.Value() == "" {
+			continue
+		}
+
+		f := &b.fields[idx]
+		raw := it.Value()
+		s := string(raw)
+		if raw.NeedUnescape() {
+			// 解码后的值可能会被string/列表字段持有, 这里不能复用buf
+			var err error
+			if s, err = raw.Unescape(); err != nil {
+				if first == nil {
+					first = &BindError{Key: f.name, Value: string(raw), Err: err}
+				}
+				continue
+			}
+		}
+		// setter 解析失败时不写字段, 后面同名的key和缺省值还有机会
+		if err := f.set(unsafe.Pointer(uintptr(base)+f.offset), s); err != nil {
+			if first == nil {
+				first = &BindError{Key: f.name, Value: s, Err: err}
+			}
+			continue
+		}
+		seen |= 1 << uint(idx)
+	}
+
+	for i := range b.fields {
+		f := &b.fields[i]
+		if !f.hasDef || seen&(1<<uint(i)) != 0 {
+			continue
+		}
+		f.set(unsafe.Pointer(uintptr(base)+f.offset), f.def)
+	}
+	return first
+}
+
+type BindError struct {
+	Key   string
+	Value string
+	Err   error
+}
+
+func (e *BindError) Error() string {
+	return fmt.Sprintf("query: bind %s=%q: %v", e.Key, e.Value, e.Err)
+}
+
+func newSetter(t reflect.Type) setter {
+	if t.Kind() == reflect.Slice {
+		return newListSetter(t.Elem())
+	}
+	switch t.Kind() {
+	case reflect.String:
+		return func(p unsafe.Pointer, s string) error {
+			*(*string)(p) = s
+			return nil
+		}
+	case reflect.Bool:
+		return func(p unsafe.Pointer, s string) error {
+			v, err := strconv.ParseBool(s)
+			if err != nil {
+				return err
+			}
+			*(*bool)(p) = v
+			return nil
+		}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		bits, size := t.Bits(), t.Size()
+		return func(p unsafe.Pointer, s string) error {
+			v, err := strconv.ParseInt(s, 10, bits)
+			if err != nil {
+				return err
+			}
+			storeInt(p, size, uint64(v))
+			return nil
+		}
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		bits, size := t.Bits(), t.Size()
+		return func(p unsafe.Pointer, s string) error {
+			v, err := strconv.ParseUint(s, 10, bits)
+			if err != nil {
+				return err
+			}
+			storeInt(p, size, v)
+			return nil
+		}
+	case reflect.Float32:
+		return func(p unsafe.Pointer, s string) error {
+			v, err := strconv.ParseFloat(s, 32)
+			if err != nil {
+				return err
+			}
+			*(*float32)(p) = float32(v)
+			return nil
+		}
+	case reflect.Float64:
+		return func(p unsafe.Pointer, s string) error {
+			v, err := strconv.ParseFloat(s, 64)
+			if err != nil {
+				return err
+			}
+			*(*float64)(p) = v
+			return nil
+		}
+	}
+	return nil
+}
+
+func storeInt(p unsafe.Pointer, size uintptr, v uint64) {
+	switch size {
+	case 1:
+		*(*uint8)(p) = uint8(v)
+	case 2:
+		*(*uint16)(p) = uint16(v)
+	case 4:
+		*(*uint32)(p) = uint32(v)
+	default:
+		*(*uint64)(p) = v
+	}
+}
+
+// newListSetter 按 ',' 拆分后逐个用元素的setter写入, 元素只支持标量类型
+// 底层按kind选定具体的slice类型, 命名类型(type X int64)和对应的基础类型内存布局一致
+func newListSetter(elem reflect.Type) setter {
+	set := newSetter(elem)
+	if set == nil || elem.Kind() == reflect.Slice {
+		return nil
+	}
+	switch elem.Kind() {
+	case reflect.String:
+		return listSetter[string](set)
+	case reflect.Bool:
+		return listSetter[bool](set)
+	case reflect.Int:
+		return listSetter[int](set)
+	case reflect.Int8:
+		return listSetter[int8](set)
+	case reflect.Int16:
+		return listSetter[int16](set)
+	case reflect.Int32:
+		return listSetter[int32](set)
+	case reflect.Int64:
+		return listSetter[int64](set)
+	case reflect.Uint:
+		return listSetter[uint](set)
+	case reflect.Uint8:
+		return listSetter[uint8](set)
+	case reflect.Uint16:
+		return listSetter[uint16](set)
+	case reflect.Uint32:
+		return listSetter[uint32](set)
+	case reflect.Uint64:
+		return listSetter[uint64](set)
+	case reflect.Float32:
+		return listSetter[float32](set)
+	case reflect.Float64:
+		return listSetter[float64](set)
+	}
+	return nil
+}
+
+func listSetter[T any](set setter) setter {
+	return func(p unsafe.Pointer, s string) error {
+		list := make([]T, strings.Count(s, ",")+1)
+		for i := range list {
+			item := s
+			if idx := strings.IndexByte(s, ','); idx >= 0 {
+				item, s = s[:idx], s[idx+1:]
+			}
+			if err := set(unsafe.Pointer(&list[i]), item); err != nil {
+				return err
+			}
+		}
+		*(*[]T)(p) = list
+		return nil
+	}
+}
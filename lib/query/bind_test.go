@@ -0,0 +1,187 @@
+package query
+
+import (
+	"net/url"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type feedParams struct {
+	DeviceId    int64    `query:"device_id"`
+	Iid         int64    `query:"iid"`
+	VersionCode int      `query:"version_code"`
+	Latitude    float64  `query:"latitude"`
+	DeviceType  string   `query:"device_type"`
+	AbVersion   []int64  `query:"ab_version"`
+	AbClient    []string `query:"ab_client"`
+	Count       int      `query:"count" default:"20"`
+	Debug       bool     `query:"debug" default:"false"`
+	Flags       uint8    `query:"flags"`
+	Other       string
+}
+
+var feedBinder = MustNewBinder(feedParams{})
+
+func manualBind(query string, p *feedParams) error {
+	values, err := url.ParseQuery(query)
+	if err != nil {
+		return err
+	}
+	if p.DeviceId, err = strconv.ParseInt(values.Get("device_id"), 10, 64); err != nil {
+		return err
+	}
+	if p.Iid, err = strconv.ParseInt(values.Get("iid"), 10, 64); err != nil {
+		return err
+	}
+	if p.VersionCode, err = strconv.Atoi(values.Get("version_code")); err != nil {
+		return err
+	}
+	if p.Latitude, err = strconv.ParseFloat(values.Get("latitude"), 64); err != nil {
+		return err
+	}
+	p.DeviceType = values.Get("device_type")
+	p.AbVersion = p.AbVersion[:0]
+	for _, s := range strings.Split(values.Get("ab_version"), ",") {
+		v, err := strconv.ParseInt(s, 10, 64)
+		if err != nil {
+			return err
+		}
+		p.AbVersion = append(p.AbVersion, v)
+	}
+	p.AbClient = strings.Split(values.Get("ab_client"), ",")
+	p.Count = 20
+	if s := values.Get("count"); s != "" {
+		if p.Count, err = strconv.Atoi(s); err != nil {
+			return err
+		}
+	}
+	p.Debug = false
+	if s := values.Get("debug"); s != "" {
+		if p.Debug, err = strconv.ParseBool(s); err != nil {
+			return err
+		}
+	}
+	flags, err := strconv.ParseUint(values.Get("flags"), 10, 8)
+	p.Flags = uint8(flags)
+	return err
+}
+
+func TestBind(t *testing.T) {
+	var p feedParams
+	err := feedBinder.Bind(source+"&flags=64", &p)
+	assert.Nil(t, err)
+	assert.Equal(t, int64(34639849336), p.DeviceId)
+	assert.Equal(t, int64(13484115781), p.Iid)
+	assert.Equal(t, 631, p.VersionCode)
+	assert.Equal(t, 30.838400681832415, p.Latitude)
+	assert.Equal(t, "OPPO R9 Plusm A", p.DeviceType)
+	assert.Equal(t, []int64{160531, 164185, 164424}, p.AbVersion)
+	assert.Equal(t, []string{"a1", "c4", "e1", "f2", "g2", "f7"}, p.AbClient)
+	assert.Equal(t, 20, p.Count)
+	assert.Equal(t, false, p.Debug)
+	assert.Equal(t, uint8(64), p.Flags)
+
+	var manual feedParams
+	assert.Nil(t, manualBind(source+"&flags=64", &manual))
+	assert.Equal(t, manual, p)
+
+	p = feedParams{}
+	err = feedBinder.Bind("count=5&count=6&debug=1&iid=&version_code=1", &p)
+	assert.Nil(t, err)
+	assert.Equal(t, 5, p.Count)
+	assert.Equal(t, true, p.Debug)
+	assert.Equal(t, int64(0), p.Iid)
+}
+
+func TestBindError(t *testing.T) {
+	var p feedParams
+	err := feedBinder.Bind("version_code=abc", &p)
+	assert.NotNil(t, err)
+	assert.Equal(t, "version_code", err.(*BindError).Key)
+
+	err = feedBinder.Bind("flags=256", &p)
+	assert.NotNil(t, err)
+
+	err = feedBinder.Bind("ab_version=1%2Cx", &p)
+	assert.NotNil(t, err)
+
+	err = feedBinder.Bind("iid=1", p)
+	assert.NotNil(t, err)
+
+	_, err = NewBinder(struct {
+		A map[string]int `query:"a"`
+	}{})
+	assert.NotNil(t, err)
+
+	_, err = NewBinder(struct {
+		A int `query:"a" default:"x"`
+	}{})
+	assert.NotNil(t, err)
+
+	_, err = NewBinder(struct {
+		A int `query:"a"`
+		B int `query:"a"`
+	}{})
+	assert.NotNil(t, err)
+}
+
+func TestBindPartial(t *testing.T) {
+	// 坏值不写字段, 其他字段照常绑定, 缺省值照常设置, 返回第一个错误
+	p := feedParams{Latitude: 1.5, Debug: true, Count: 7}
+	err := feedBinder.Bind("latitude=x&debug=maybe&iid=2&version_code=y&flags=3", &p)
+	if assert.NotNil(t, err) {
+		assert.Equal(t, "latitude", err.(*BindError).Key)
+	}
+	assert.Equal(t, 1.5, p.Latitude)
+	assert.Equal(t, false, p.Debug) // debug 的缺省值
+	assert.Equal(t, int64(2), p.Iid)
+	assert.Equal(t, 0, p.VersionCode)
+	assert.Equal(t, uint8(3), p.Flags)
+	assert.Equal(t, 20, p.Count)
+
+	// 同名的key后面的值是好的就用后面的
+	p = feedParams{}
+	assert.NotNil(t, feedBinder.Bind("count=x&count=3", &p))
+	assert.Equal(t, 3, p.Count)
+}
+
+func TestTooManyFields(t *testing.T) {
+	fields := make([]reflect.StructField, maxBindFields+1)
+	for i := range fields {
+		fields[i] = reflect.StructField{
+			Name: "F" + strconv.Itoa(i),
+			Type: reflect.TypeOf(0),
+			Tag:  reflect.StructTag(`query:"f` + strconv.Itoa(i) + `" default:"x"`),
+		}
+	}
+	// 字段数先检查, 不会去解析坏的缺省值
+	_, err := NewBinder(reflect.New(reflect.StructOf(fields)).Interface())
+	if assert.NotNil(t, err) {
+		assert.Contains(t, err.Error(), "too many fields")
+	}
+}
+
+// 两边都要走完整的成功路径, source 没有 flags, manualBind 会报错
+var benchSource = source + "&flags=64"
+
+func BenchmarkBind(b *testing.B) {
+	var p feedParams
+	for i := 0; i < b.N; i++ {
+		if err := feedBinder.Bind(benchSource, &p); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkManualBind(b *testing.B) {
+	var p feedParams
+	for i := 0; i < b.N; i++ {
+		if err := manualBind(benchSource, &p); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
@@ -0,0 +1,232 @@
+package query
+
+import (
+	"errors"
+	"net/url"
+	"strings"
+)
+
+/*
+	零分配的query解析, 对应 lib/parse_uri_test.go 里的实验:
+	- Iter 按顺序遍历 key/value, 不构造map
+	- Value 保留原始(未解码)的字符串, 用到时才做 %XX / '+' 解码
+	- KeySet 一次遍历把固定的几个key取出来
+*/
+
+var ErrSemicolon = errors.New("query: invalid semicolon separator in query")
+
+// Value is a raw query component, still percent-encoded.
+type Value string
+
+// NeedUnescape reports whether v contains '%' or '+'.
+//
+//perf:nobce
+func (v Value) NeedUnescape() bool {
+	for i := 0; i < len(v); i++ {
+		if v[i] == '%' || v[i] == '+' {
+			return true
+		}
+	}
+	return false
+}
+
+// Unescape decodes v like url.QueryUnescape, it does not allocate when nothing needs decoding.
+func (v Value) Unescape() (string, error) {
+	if !v.NeedUnescape() {
+		return string(v), nil
+	}
+	b, err := v.AppendUnescape(make([]byte, 0, len(v)))
+	if err != nil {
+		return "", err
+	}
+	return string(b), nil
+}
+
+// AppendUnescape appends the decoded v to dst.
+func (v Value) AppendUnescape(dst []byte) ([]byte, error) {
+	for i := 0; i < len(v); i++ {
+		switch c := v[i]; c {
+		case '%':
+			if i+2 >= len(v) || !ishex(v[i+1]) || !ishex(v[i+2]) {
+				return dst, escapeError(v[i:])
+			}
+			dst = append(dst, unhex(v[i+1])<<4|unhex(v[i+2]))
+			i += 2
+		case '+':
+			dst = append(dst, ' ')
+		default:
+			dst = append(dst, c)
+		}
+	}
+	return dst, nil
+}
+
+// Equal reports whether the decoded v equals s, without allocating.
+func (v Value) Equal(s string) bool {
+	j := 0
+	for i := 0; i < len(v); i++ {
+		c := v[i]
+		switch c {
+		case '%':
+			if i+2 >= len(v) || !ishex(v[i+1]) || !ishex(v[i+2]) {
+				return false
+			}
+			c = unhex(v[i+1])<<4 | unhex(v[i+2])
+			i += 2
+		case '+':
+			c = ' '
+		}
+		if j >= len(s) || s[j] != c {
+			return false
+		}
+		j++
+	}
+	return j == len(s)
+}
+
+// Valid reports whether all escapes in v are well formed.
+func (v Value) Valid() bool {
+	for i := 0; i < len(v); i++ {
+		if v[i] == '%' {
+			if i+2 >= len(v) || !ishex(v[i+1]) || !ishex(v[i+2]) {
+				return false
+			}
+			i += 2
+		}
+	}
+	return true
+}
+
+// Iter walks the pairs of a query string in order.
+// Pairs are split like url.ParseQuery: empty pairs are skipped and pairs containing ';' are skipped with ErrSemicolon.
+// Escapes are not validated here, see Value.Unescape.
+type Iter struct {
+	s     string
+	key   Value
+	value Value
+	err   error
+}
+
+func NewIter(query string) Iter {
+	return Iter{s: query}
+}
+
+func (it *Iter) Next() bool {
+	for it.s != "" {
+		var pair string
+		if idx := strings.IndexByte(it.s, '&'); idx >= 0 {
+			pair, it.s = it.s[:idx], it.s[idx+1:]
+		} else {
+			pair, it.s = it.s, ""
+		}
+		if strings.IndexByte(pair, ';') >= 0 {
+			if it.err == nil {
+				it.err = ErrSemicolon
+			}
+			continue
+		}
+		if pair == "" {
+			continue
+		}
+		if idx := strings.IndexByte(pair, '='); idx >= 0 {
+			it.key, it.value = Value(pair[:idx]), Value(pair[idx+1:])
+		} else {
+			it.key, it.value = Value(pair), ""
+		}
+		return true
+	}
+	return false
+}
+
+func (it *Iter) Key() Value {
+	return it.key
+}
+
+func (it *Iter) Value() Value {
+	return it.value
+}
+
+// Err returns the first malformed pair error seen so far.
+func (it *Iter) Err() error {
+	return it.err
+}
+
+const maxKeySetSize = 64
+
+// KeySet looks up a fixed set of at most 64 keys in one pass.
+type KeySet struct {
+	keys []string
+}
+
+func NewKeySet(keys ...string) *KeySet {
+	if len(keys) > maxKeySetSize {
+		panic("query: too many keys in KeySet")
+	}
+	return &KeySet{keys: keys}
+}
+
+func (k *KeySet) Len() int {
+	return len(k.keys)
+}
+
+// Lookup stores the raw value of the first occurrence of keys[i] into dst[i], like url.Values.Get.
+// dst must have at least Len() elements, missing keys are left empty; it returns how many keys were found.
+func (k *KeySet) Lookup(query string, dst []Value) int {
+	dst = dst[:len(k.keys)]
+	for i := range dst {
+		dst[i] = ""
+	}
+	var seen uint64
+	found := 0
+	it := NewIter(query)
+	for found < len(k.keys) && it.Next() {
+		key := it.Key()
+		if !key.Valid() || !it.Value().Valid() {
+			continue
+		}
+		needUnescape := key.NeedUnescape()
+		for i, name := range k.keys {
+			if seen&(1<<uint(i)) != 0 {
+				continue
+			}
+			if (!needUnescape && string(key) == name) || (needUnescape && key.Equal(name)) {
+				seen |= 1 << uint(i)
+				dst[i] = it.Value()
+				found++
+				break
+			}
+		}
+	}
+	return found
+}
+
+func escapeError(v Value) error {
+	if len(v) > 3 {
+		v = v[:3]
+	}
+	return url.EscapeError(v)
+}
+
+func ishex(c byte) bool {
+	switch {
+	case '0' <= c && c <= '9':
+		return true
+	case 'a' <= c && c <= 'f':
+		return true
+	case 'A' <= c && c <= 'F':
+		return true
+	}
+	return false
+}
+
+func unhex(c byte) byte {
+	switch {
+	case '0' <= c && c <= '9':
+		return c - '0'
+	case 'a' <= c && c <= 'f':
+		return c - 'a' + 10
+	case 'A' <= c && c <= 'F':
+		return c - 'A' + 10
+	}
+	return 0
+}
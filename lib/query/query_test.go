@@ -0,0 +1,170 @@
+package query
+
+import (
+	"net/url"
+	"reflect"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+var source = "ac=wifi&app_name=news_article&item_id=6455077430972383757&device_type=OPPO+R9+Plusm+A&version_code=631&latitude=30.838400681832415&ab_version=160531%2C164185%2C164424&iid=13484115781&ab_client=a1%2Cc4%2Ce1%2Cf2%2Cg2%2Cf7&device_id=34639849336&resolution=1080%2A1920&sig=a=b&dpi=480"
+
+type deviceInfo struct {
+	DeviceId    Value
+	Iid         Value
+	VersionCode Value
+}
+
+var deviceKeys = NewKeySet("device_id", "iid", "version_code")
+
+func lookupDevice(query string, d *deviceInfo) int {
+	var vals [3]Value
+	n := deviceKeys.Lookup(query, vals[:])
+	d.DeviceId, d.Iid, d.VersionCode = vals[0], vals[1], vals[2]
+	return n
+}
+
+// parseByIter 用Iter构造出和url.ParseQuery一样的结果
+func parseByIter(query string) (url.Values, error) {
+	m := make(url.Values)
+	it := NewIter(query)
+	var err error
+	for it.Next() {
+		key, err1 := it.Key().Unescape()
+		if err1 != nil {
+			if err == nil {
+				err = err1
+			}
+			continue
+		}
+		value, err1 := it.Value().Unescape()
+		if err1 != nil {
+			if err == nil {
+				err = err1
+			}
+			continue
+		}
+		m[key] = append(m[key], value)
+	}
+	if it.Err() != nil {
+		err = it.Err()
+	}
+	return m, err
+}
+
+func TestIter(t *testing.T) {
+	it := NewIter("a=1&&b&c=x%2Cy+z&d=e=f")
+	var keys, values []string
+	for it.Next() {
+		keys = append(keys, string(it.Key()))
+		v, err := it.Value().Unescape()
+		assert.Nil(t, err)
+		values = append(values, v)
+	}
+	assert.Nil(t, it.Err())
+	assert.Equal(t, []string{"a", "b", "c", "d"}, keys)
+	assert.Equal(t, []string{"1", "", "x,y z", "e=f"}, values)
+
+	it = NewIter("a=1;b=2&c=3")
+	assert.True(t, it.Next())
+	assert.Equal(t, Value("c"), it.Key())
+	assert.Equal(t, ErrSemicolon, it.Err())
+}
+
+func TestUnescape(t *testing.T) {
+	s, err := Value("160531%2C164185").Unescape()
+	assert.Nil(t, err)
+	assert.Equal(t, "160531,164185", s)
+
+	_, err = Value("a%2").Unescape()
+	assert.Equal(t, url.EscapeError("%2"), err)
+	_, err = Value("%zz1").Unescape()
+	assert.Equal(t, url.EscapeError("%zz"), err)
+
+	assert.True(t, Value("device%5Fid").Equal("device_id"))
+	assert.False(t, Value("device%5Fid").Equal("device_i"))
+	assert.False(t, Value("device%5").Equal("device"))
+}
+
+func TestParseEqualLib(t *testing.T) {
+	expected, err := url.ParseQuery(source)
+	assert.Nil(t, err)
+	actual, err := parseByIter(source)
+	assert.Nil(t, err)
+	assert.Equal(t, expected, actual)
+}
+
+func TestLookup(t *testing.T) {
+	var d deviceInfo
+	assert.Equal(t, 3, lookupDevice(source, &d))
+	assert.Equal(t, Value("34639849336"), d.DeviceId)
+	assert.Equal(t, Value("13484115781"), d.Iid)
+	assert.Equal(t, Value("631"), d.VersionCode)
+
+	// 第一次出现的为准, 和url.Values.Get一致; 编码过的key也能匹配
+	assert.Equal(t, 2, lookupDevice("iid=1&iid=2&device%5Fid=3&version_code=%zz", &d))
+	assert.Equal(t, Value("3"), d.DeviceId)
+	assert.Equal(t, Value("1"), d.Iid)
+	assert.Equal(t, Value(""), d.VersionCode)
+
+	allocs := testing.AllocsPerRun(100, func() {
+		lookupDevice(source, &d)
+	})
+	assert.Equal(t, float64(0), allocs)
+}
+
+func FuzzParseEqualLib(f *testing.F) {
+	f.Add(source)
+	f.Add("a=1&&b&c=x%2Cy+z&d=e=f")
+	f.Add("a=1;b=2&c=%zz&%41=%42")
+	f.Add("=&==&a%=b")
+	f.Fuzz(func(t *testing.T, query string) {
+		expected, err1 := url.ParseQuery(query)
+		actual, err2 := parseByIter(query)
+		if (err1 == nil) != (err2 == nil) {
+			t.Fatalf("%q: err lib=%v iter=%v", query, err1, err2)
+		}
+		if len(expected) == 0 && len(actual) == 0 {
+			return
+		}
+		if !reflect.DeepEqual(expected, actual) {
+			t.Fatalf("%q: lib=%v iter=%v", query, expected, actual)
+		}
+
+		var vals [1]Value
+		ks := NewKeySet("a")
+		if ks.Lookup(query, vals[:]) == 1 {
+			v, err := vals[0].Unescape()
+			if err != nil || v != expected.Get("a") {
+				t.Fatalf("%q: lookup=%q lib=%q", query, v, expected.Get("a"))
+			}
+		} else if _, ok := expected["a"]; ok {
+			t.Fatalf("%q: lookup missed key a", query)
+		}
+	})
+}
+
+func BenchmarkLibParseQuery(b *testing.B) {
+	for i := 0; i < b.N; i++ {
+		values, _ := url.ParseQuery(source)
+		_ = values.Get("device_id")
+	}
+}
+
+func BenchmarkIter(b *testing.B) {
+	buf := make([]byte, 0, 256)
+	for i := 0; i < b.N; i++ {
+		it := NewIter(source)
+		for it.Next() {
+			buf, _ = it.Value().AppendUnescape(buf[:0])
+		}
+	}
+}
+
+func BenchmarkLookup(b *testing.B) {
+	var d deviceInfo
+	for i := 0; i < b.N; i++ {
+		lookupDevice(source, &d)
+	}
+}
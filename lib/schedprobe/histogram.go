@@ -0,0 +1,125 @@
+package schedprobe
+
+import (
+	"math"
+	"math/bits"
+	"time"
+)
+
+// 每个2的幂区间分 subBuckets 个线性桶, 相对误差不超过 1/subBuckets, 和 HdrHistogram 2位有效数字差不多
+const (
+	subBits    = 7
+	subBuckets = 1 << subBits
+	// 超过 2^maxBits ns(约73分钟)的值按最大值记
+	maxBits    = 42
+	numBuckets = (maxBits - subBits + 1) * subBuckets
+)
+
+// Histogram records durations with bounded relative error and fixed memory, it is not safe for concurrent use.
+type Histogram struct {
+	counts [numBuckets]uint64
+	count  uint64
+	sum    float64
+	min    int64
+	max    int64
+}
+
+func bucket(v int64) int {
+	if v < subBuckets {
+		return int(v)
+	}
+	if v >= 1<<maxBits {
+		v = 1<<maxBits - 1
+	}
+	e := bits.Len64(uint64(v)) - 1 - subBits
+	return (e+1)*subBuckets + int(v>>uint(e)) - subBuckets
+}
+
+// bucketMax is the largest value that falls into bucket i.
+func bucketMax(i int) int64 {
+	if i < subBuckets {
+		return int64(i)
+	}
+	e := uint(i/subBuckets - 1)
+	sub := int64(i%subBuckets + subBuckets)
+	return (sub+1)<<e - 1
+}
+
+func (h *Histogram) Record(d time.Duration) {
+	v := int64(d)
+	if v < 0 {
+		v = 0
+	}
+	h.counts[bucket(v)]++
+	if h.count == 0 || v < h.min {
+		h.min = v
+	}
+	if v > h.max {
+		h.max = v
+	}
+	h.count++
+	h.sum += float64(v)
+}
+
+func (h *Histogram) Count() uint64 {
+	return h.count
+}
+
+func (h *Histogram) Min() time.Duration {
+	return time.Duration(h.min)
+}
+
+func (h *Histogram) Max() time.Duration {
+	return time.Duration(h.max)
+}
+
+func (h *Histogram) Mean() time.Duration {
+	if h.count == 0 {
+		return 0
+	}
+	return time.Duration(h.sum / float64(h.count))
+}
+
+// Quantile returns the value below which q (0~1) of the recorded values fall, rounded up to the bucket.
+func (h *Histogram) Quantile(q float64) time.Duration {
+	if h.count == 0 {
+		return 0
+	}
+	rank := uint64(math.Ceil(q * float64(h.count)))
+	if rank == 0 {
+		rank = 1
+	}
+	var seen uint64
+	for i, c := range h.counts {
+		seen += c
+		if seen >= rank {
+			v := bucketMax(i)
+			if v > h.max {
+				v = h.max
+			}
+			return time.Duration(v)
+		}
+	}
+	return time.Duration(h.max)
+}
+
+func (h *Histogram) Merge(o *Histogram) {
+	if o.count == 0 {
+		return
+	}
+	for i, c := range o.counts {
+		h.counts[i] += c
+	}
+	if h.count == 0 || o.min < h.min {
+		h.min = o.min
+	}
+	if o.max > h.max {
+		h.max = o.max
+	}
+	h.count += o.count
+	h.sum += o.sum
+}
+
+func (h *Histogram) Reset() {
+	*h = Histogram{}
+}
@@ -0,0 +1,182 @@
+package schedprobe
+
+import (
+	"math"
+	"runtime/metrics"
+	"sync"
+	"time"
+)
+
+// MetricsSample is a reading of the runtime/metrics the scheduler analysis needs.
+// Counters and histograms are cumulative since the process started, diff two samples for a window.
+type MetricsSample struct {
+	Time       time.Time
+	GCCycles   uint64
+	GCPause    time.Duration // total stop-the-world time of GC, estimated from the histogram
+	Goroutines uint64
+	Runnable   uint64 // 0 when the runtime does not export /sched/goroutines/runnable
+	// SchedLatencies is /sched/latencies:seconds, how long goroutines sat runnable before running
+	SchedLatencies *metrics.Float64Histogram
+}
+
+const (
+	metricGCCycles    = "/gc/cycles/total:gc-cycles"
+	metricGCPauses    = "/sched/pauses/total/gc:seconds"
+	metricGCPausesOld = "/gc/pauses:seconds"
+	metricGoroutines  = "/sched/goroutines:goroutines"
+	metricRunnable    = "/sched/goroutines/runnable:goroutines"
+	metricLatencies   = "/sched/latencies:seconds"
+)
+
+func metricNames() []string {
+	supported := make(map[string]bool)
+	for _, d := range metrics.All() {
+		supported[d.Name] = true
+	}
+	var names []string
+	for _, n := range []string{metricGCCycles, metricGCPauses, metricGoroutines, metricRunnable, metricLatencies} {
+		if n == metricGCPauses && !supported[n] {
+			n = metricGCPausesOld
+		}
+		if supported[n] {
+			names = append(names, n)
+		}
+	}
+	return names
+}
+
+// ReadMetrics takes one sample now.
+func ReadMetrics() MetricsSample {
+	names := metricNames()
+	samples := make([]metrics.Sample, len(names))
+	for i, n := range names {
+		samples[i].Name = n
+	}
+	return readSamples(samples)
+}
+
+func readSamples(samples []metrics.Sample) MetricsSample {
+	metrics.Read(samples)
+	s := MetricsSample{Time: time.Now()}
+	for _, m := range samples {
+		switch m.Name {
+		case metricGCCycles:
+			s.GCCycles = m.Value.Uint64()
+		case metricGCPauses, metricGCPausesOld:
+			s.GCPause = histogramSum(m.Value.Float64Histogram())
+		case metricGoroutines:
+			s.Goroutines = m.Value.Uint64()
+		case metricRunnable:
+			s.Runnable = m.Value.Uint64()
+		case metricLatencies:
+			// Read 会复用histogram的内存, 要拷贝一份
+			h := m.Value.Float64Histogram()
+			s.SchedLatencies = &metrics.Float64Histogram{
+				Counts:  append([]uint64(nil), h.Counts...),
+				Buckets: h.Buckets,
+			}
+		}
+	}
+	return s
+}
+
+// histogramSum estimates the total of a histogram in seconds using bucket midpoints.
+func histogramSum(h *metrics.Float64Histogram) time.Duration {
+	var sum float64
+	for i, c := range h.Counts {
+		if c == 0 {
+			continue
+		}
+		lo, hi := h.Buckets[i], h.Buckets[i+1]
+		if math.IsInf(lo, -1) {
+			lo = hi
+		}
+		if math.IsInf(hi, 1) {
+			hi = lo
+		}
+		sum += float64(c) * (lo + hi) / 2
+	}
+	return time.Duration(sum * float64(time.Second))
+}
+
+// LatencyQuantile returns the q quantile of scheduling latency between two samples of /sched/latencies,
+// prev may be nil for everything since the process started.
+func LatencyQuantile(prev, cur *metrics.Float64Histogram, q float64) time.Duration {
+	if cur == nil {
+		return 0
+	}
+	counts := make([]uint64, len(cur.Counts))
+	var total uint64
+	for i, c := range cur.Counts {
+		if prev != nil && i < len(prev.Counts) {
+			c -= prev.Counts[i]
+		}
+		counts[i] = c
+		total += c
+	}
+	if total == 0 {
+		return 0
+	}
+	rank := uint64(math.Ceil(q * float64(total)))
+	if rank == 0 {
+		rank = 1
+	}
+	var seen uint64
+	for i, c := range counts {
+		seen += c
+		if seen >= rank {
+			hi := cur.Buckets[i+1]
+			if math.IsInf(hi, 1) {
+				hi = cur.Buckets[i]
+			}
+			return time.Duration(hi * float64(time.Second))
+		}
+	}
+	return 0
+}
+
+// MetricsRecorder samples runtime/metrics in the background. The sampling goroutine is scheduled like
+// any other, under heavy load the samples get sparse, see SchedTrace for a view from sysmon.
+type MetricsRecorder struct {
+	stop    chan struct{}
+	done    chan struct{}
+	mu      sync.Mutex
+	samples []MetricsSample
+}
+
+func RecordMetrics(interval time.Duration) *MetricsRecorder {
+	r := &MetricsRecorder{stop: make(chan struct{}), done: make(chan struct{})}
+	names := metricNames()
+	buf := make([]metrics.Sample, len(names))
+	for i, n := range names {
+		buf[i].Name = n
+	}
+	r.samples = append(r.samples, readSamples(buf))
+	go func() {
+		defer close(r.done)
+		t := time.NewTicker(interval)
+		defer t.Stop()
+		for {
+			select {
+			case <-r.stop:
+				return
+			case <-t.C:
+				s := readSamples(buf)
+				r.mu.Lock()
+				r.samples = append(r.samples, s)
+				r.mu.Unlock()
+			}
+		}
+	}()
+	return r
+}
+
+// Stop takes a last sample and returns all of them in time order.
+func (r *MetricsRecorder) Stop() []MetricsSample {
+	close(r.stop)
+	<-r.done
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.samples = append(r.samples, ReadMetrics())
+	return r.samples
+}
@@ -0,0 +1,176 @@
+package schedprobe
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+/*
+	在服务里后台测goroutine的调度延迟, CPU打满或者GC的时候能看到被唤醒的goroutine要等多久才真正跑起来
+
+	p := schedprobe.Start(schedprobe.Options{Interval: 10 * time.Millisecond})
+	defer p.Stop()
+	...
+	s := p.Stats()
+	log.Printf("wakeup p50=%v p99=%v max=%v", s.Wakeup.P50, s.Wakeup.P99, s.Wakeup.Max)
+
+	每个 Interval 测两个值, 都用 time.Since(单调时钟), 不会像 time.Now().Nanosecond() 一样每秒回绕:
+		Wakeup: 往一个阻塞在chan上的goroutine发当前时间, 到它被调度起来收到的延迟
+		Timer:  time.Sleep(Interval) 实际多睡了多久
+	直方图固定内存(约37KB), 相对误差<1%
+*/
+
+type Options struct {
+	// Interval between two probes, default 10ms
+	Interval time.Duration
+}
+
+// Summary is a snapshot of one histogram.
+type Summary struct {
+	Count uint64
+	Mean  time.Duration
+	P50   time.Duration
+	P90   time.Duration
+	P99   time.Duration
+	P999  time.Duration
+	Max   time.Duration
+}
+
+// Summarize returns the count, mean, percentiles and max of h.
+func Summarize(h *Histogram) Summary {
+	return Summary{
+		Count: h.Count(),
+		Mean:  h.Mean(),
+		P50:   h.Quantile(0.5),
+		P90:   h.Quantile(0.9),
+		P99:   h.Quantile(0.99),
+		P999:  h.Quantile(0.999),
+		Max:   h.Max(),
+	}
+}
+
+func (s Summary) String() string {
+	return fmt.Sprintf("n=%d mean=%v p50=%v p90=%v p99=%v p99.9=%v max=%v", s.Count, s.Mean, s.P50, s.P90, s.P99, s.P999, s.Max)
+}
+
+type Stats struct {
+	Since  time.Time
+	Wakeup Summary
+	Timer  Summary
+	// Stalled is how late the probe in flight already is, when the scheduler is so busy
+	// that no probe finishes in a window the histograms stay empty but Stalled grows
+	Stalled time.Duration
+}
+
+type Probe struct {
+	interval time.Duration
+
+	mu         sync.Mutex
+	since      time.Time
+	sleepStart time.Time
+	wakeup     Histogram
+	timer      Histogram
+
+	handoff chan time.Time
+	stop    chan struct{}
+	done    sync.WaitGroup
+}
+
+// Start starts probing in the background until Stop.
+func Start(opt Options) *Probe {
+	if opt.Interval <= 0 {
+		opt.Interval = 10 * time.Millisecond
+	}
+	p := &Probe{
+		interval: opt.Interval,
+		since:    time.Now(),
+		handoff:  make(chan time.Time),
+		stop:     make(chan struct{}),
+	}
+	p.done.Add(2)
+	go p.receive()
+	go p.run()
+	return p
+}
+
+func (p *Probe) run() {
+	defer p.done.Done()
+	defer close(p.handoff)
+	for {
+		start := time.Now()
+		p.mu.Lock()
+		p.sleepStart = start
+		p.mu.Unlock()
+		time.Sleep(p.interval)
+		late := time.Since(start) - p.interval
+		p.mu.Lock()
+		p.timer.Record(late)
+		p.sleepStart = time.Time{}
+		p.mu.Unlock()
+
+		select {
+		case <-p.stop:
+			return
+		default:
+		}
+
+		// receive 已经阻塞在chan上, 这次发送会把它唤醒
+		p.handoff <- time.Now()
+	}
+}
+
+func (p *Probe) receive() {
+	defer p.done.Done()
+	for sent := range p.handoff {
+		d := time.Since(sent)
+		p.mu.Lock()
+		p.wakeup.Record(d)
+		p.mu.Unlock()
+	}
+}
+
+// Stop stops the probe goroutines, Stats keeps working.
+func (p *Probe) Stop() {
+	close(p.stop)
+	p.done.Wait()
+}
+
+// Stats returns the latencies recorded since Start or the last Reset.
+func (p *Probe) Stats() Stats {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.statsLocked()
+}
+
+// Reset clears the histograms, for reporting one window at a time.
+func (p *Probe) Reset() {
+	p.mu.Lock()
+	p.resetLocked()
+	p.mu.Unlock()
+}
+
+// StatsAndReset returns Stats and starts a new window.
+func (p *Probe) StatsAndReset() Stats {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	s := p.statsLocked()
+	p.resetLocked()
+	return s
+}
+
+func (p *Probe) statsLocked() Stats {
+	s := Stats{Since: p.since, Wakeup: Summarize(&p.wakeup), Timer: Summarize(&p.timer)}
+	if !p.sleepStart.IsZero() {
+		if late := time.Since(p.sleepStart) - p.interval; late > 0 {
+			s.Stalled = late
+		}
+	}
+	return s
+}
+
+func (p *Probe) resetLocked() {
+	p.wakeup.Reset()
+	p.timer.Reset()
+	p.since = time.Now()
+}
@@ -0,0 +1,125 @@
+package schedprobe
+
+import (
+	"math/rand"
+	"runtime"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBucket(t *testing.T) {
+	for _, v := range []int64{0, 1, 127, 128, 129, 255, 256, 1000, 1e6, 123456789, 1<<maxBits - 1} {
+		i := bucket(v)
+		assert.True(t, bucketMax(i) >= v, "%d", v)
+		if i > 0 {
+			assert.True(t, bucketMax(i-1) < v, "%d", v)
+		}
+		// 相对误差
+		assert.True(t, float64(bucketMax(i)-v) <= float64(v)/subBuckets, "%d", v)
+	}
+	assert.Equal(t, numBuckets-1, bucket(1<<maxBits))
+	assert.Equal(t, numBuckets-1, bucket(1<<62))
+}
+
+func TestHistogram(t *testing.T) {
+	var h Histogram
+	assert.Equal(t, time.Duration(0), h.Quantile(0.5))
+
+	var vals []int64
+	for i := 0; i < 10000; i++ {
+		v := rand.Int63n(int64(50 * time.Millisecond))
+		vals = append(vals, v)
+		h.Record(time.Duration(v))
+	}
+	sort.Slice(vals, func(i, j int) bool { return vals[i] < vals[j] })
+	assert.Equal(t, uint64(10000), h.Count())
+	assert.Equal(t, time.Duration(vals[0]), h.Min())
+	assert.Equal(t, time.Duration(vals[len(vals)-1]), h.Max())
+	assert.Equal(t, h.Max(), h.Quantile(1))
+	for _, q := range []float64{0.5, 0.9, 0.99, 0.999} {
+		exact := float64(vals[int(q*float64(len(vals)))-1])
+		got := float64(h.Quantile(q))
+		assert.InDelta(t, exact, got, exact/subBuckets+1, "q=%v", q)
+	}
+
+	var o Histogram
+	o.Record(-time.Second)
+	o.Record(time.Hour * 100)
+	h.Merge(&o)
+	assert.Equal(t, uint64(10002), h.Count())
+	assert.Equal(t, time.Duration(0), h.Min())
+	assert.Equal(t, 100*time.Hour, h.Max())
+
+	h.Reset()
+	assert.Equal(t, uint64(0), h.Count())
+}
+
+func TestProbe(t *testing.T) {
+	p := Start(Options{Interval: time.Millisecond})
+	time.Sleep(50 * time.Millisecond)
+	s := p.Stats()
+	assert.True(t, s.Wakeup.Count > 5, "%v", s.Wakeup)
+	assert.True(t, s.Timer.Count >= s.Wakeup.Count)
+	assert.True(t, s.Wakeup.P50 <= s.Wakeup.P99 && s.Wakeup.P99 <= s.Wakeup.Max)
+	assert.Contains(t, s.Wakeup.String(), "p99=")
+
+	s = p.StatsAndReset()
+	assert.True(t, s.Wakeup.Count > 0)
+	p.Stop()
+	s2 := p.Stats()
+	assert.True(t, s2.Since.After(s.Since))
+	assert.True(t, s2.Timer.Count < s.Timer.Count+5)
+	assert.Equal(t, time.Duration(0), s2.Stalled)
+}
+
+func TestStalled(t *testing.T) {
+	p := &Probe{interval: time.Millisecond, sleepStart: time.Now().Add(-time.Second)}
+	s := p.Stats()
+	assert.True(t, s.Stalled > 900*time.Millisecond, "%v", s.Stalled)
+	assert.Equal(t, uint64(0), s.Timer.Count)
+}
+
+func TestParseSchedTrace(t *testing.T) {
+	st, ok := ParseSchedTrace("SCHED 1004ms: gomaxprocs=4 idleprocs=1 threads=9 spinningthreads=0 needspinning=0 idlethreads=3 runqueue=12 [ 3 0 5 1 ] schedticks=[ 40 1 2 3 ]")
+	assert.True(t, ok)
+	assert.Equal(t, 1004*time.Millisecond, st.Uptime)
+	assert.Equal(t, 4, st.GoMaxProcs)
+	assert.Equal(t, 1, st.IdleProcs)
+	assert.Equal(t, 9, st.Threads)
+	assert.Equal(t, 3, st.IdleThreads)
+	assert.Equal(t, []int{3, 0, 5, 1}, st.LocalRunQueues)
+	assert.Equal(t, 21, st.Runnable())
+
+	st, ok = ParseSchedTrace("SCHED 0ms: gomaxprocs=1 idleprocs=0 threads=3 spinningthreads=0 idlethreads=1 runqueue=0 [0]")
+	assert.True(t, ok)
+	assert.Equal(t, []int{0}, st.LocalRunQueues)
+
+	// schedtrace+scheddetail 的 P/M/G 行不要
+	for _, line := range []string{"  P0: status=1 schedtick=4", "SCHED xms:", "panic: oops", "SCHED 1ms: [1 x]"} {
+		_, ok = ParseSchedTrace(line)
+		assert.False(t, ok, line)
+	}
+}
+
+func TestMetrics(t *testing.T) {
+	r := RecordMetrics(time.Millisecond)
+	var keep [][]byte
+	for i := 0; i < 200; i++ {
+		keep = append(keep, make([]byte, 64<<10))
+	}
+	runtime.GC()
+	time.Sleep(10 * time.Millisecond)
+	samples := r.Stop()
+	assert.True(t, len(samples) >= 3, "%d", len(samples))
+	first, last := samples[0], samples[len(samples)-1]
+	assert.True(t, last.GCCycles > first.GCCycles)
+	assert.True(t, last.GCPause >= first.GCPause)
+	assert.True(t, last.Goroutines > 0)
+	assert.NotNil(t, last.SchedLatencies)
+	assert.True(t, LatencyQuantile(nil, last.SchedLatencies, 0.5) > 0)
+	assert.Equal(t, time.Duration(0), LatencyQuantile(last.SchedLatencies, last.SchedLatencies, 0.5))
+	assert.True(t, len(keep) > 0)
+}
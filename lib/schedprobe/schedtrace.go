@@ -0,0 +1,89 @@
+package schedprobe
+
+import (
+	"strconv"
+	"strings"
+	"time"
+)
+
+// SchedTrace is one line of GODEBUG=schedtrace=X output, printed by sysmon so it keeps coming when
+// every P is busy:
+//
+//	SCHED 1004ms: gomaxprocs=4 idleprocs=0 threads=9 spinningthreads=0 needspinning=0 idlethreads=3 runqueue=12 [ 3 0 5 1 ] schedticks=[ ... ]
+type SchedTrace struct {
+	Uptime          time.Duration // since the process started
+	GoMaxProcs      int
+	IdleProcs       int
+	Threads         int
+	SpinningThreads int
+	IdleThreads     int
+	RunQueue        int   // global run queue
+	LocalRunQueues  []int // one per P
+}
+
+// Runnable is the goroutines waiting in the global and all local run queues.
+func (t *SchedTrace) Runnable() int {
+	n := t.RunQueue
+	for _, q := range t.LocalRunQueues {
+		n += q
+	}
+	return n
+}
+
+// ParseSchedTrace parses a summary schedtrace line, ok is false for other lines.
+func ParseSchedTrace(line string) (t SchedTrace, ok bool) {
+	if !strings.HasPrefix(line, "SCHED ") {
+		return t, false
+	}
+	rest := line[len("SCHED "):]
+	i := strings.Index(rest, "ms:")
+	if i < 0 {
+		return t, false
+	}
+	ms, err := strconv.ParseInt(rest[:i], 10, 64)
+	if err != nil {
+		return t, false
+	}
+	t.Uptime = time.Duration(ms) * time.Millisecond
+	rest = rest[i+len("ms:"):]
+	// 新版本后面还有 schedticks=[ ... ], 只取第一个[]
+	if j := strings.IndexByte(rest, '['); j >= 0 {
+		k := strings.IndexByte(rest[j:], ']')
+		if k < 0 {
+			return t, false
+		}
+		for _, f := range strings.Fields(rest[j+1 : j+k]) {
+			q, err := strconv.Atoi(f)
+			if err != nil {
+				return t, false
+			}
+			t.LocalRunQueues = append(t.LocalRunQueues, q)
+		}
+		rest = rest[:j]
+	}
+	for _, kv := range strings.Fields(rest) {
+		eq := strings.IndexByte(kv, '=')
+		if eq < 0 {
+			continue
+		}
+		v, err := strconv.Atoi(kv[eq+1:])
+		if err != nil {
+			continue
+		}
+		switch kv[:eq] {
+		case "gomaxprocs":
+			t.GoMaxProcs = v
+		case "idleprocs":
+			t.IdleProcs = v
+		case "threads":
+			t.Threads = v
+		case "spinningthreads":
+			t.SpinningThreads = v
+		case "idlethreads":
+			t.IdleThreads = v
+		case "runqueue":
+			t.RunQueue = v
+		}
+	}
+	return t, true
+}
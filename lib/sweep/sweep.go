@@ -0,0 +1,289 @@
+package sweep
+
+import (
+	"encoding/csv"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+/*
+	同一个benchmark跑一组参数(大小, 元素类型, goroutine数), 代替写死的 size = 50000, 好找交叉点
+
+	func BenchmarkSliceVsMap(b *testing.B) {
+		sweep.New().
+			Ints("size", 4, 16, 64, 256).
+			Values("type", "int", "string").
+			Values("impl", "slice", "map").
+			Run(b, func(b *testing.B, p sweep.Point) {
+				n := p.Int("size")
+				...
+			})
+	}
+
+	每个参数一层子benchmark: BenchmarkSliceVsMap/size=4/type=int/impl=slice, 可以用 -bench 过滤
+	go test -bench SliceVsMap ./build_in -sweep.csv=slice_vs_map.csv
+	会写出 benchmark,size,type,impl,n,ns/op 的CSV, 用 -count 时每行是最后一次的结果
+	go test 在包目录下跑, 相对路径的CSV写在包目录里; 一次测多个包时不要用绝对路径, 会互相覆盖
+*/
+
+var csvPath = flag.String("sweep.csv", "", "write sweep results to this CSV file")
+
+const goroutinesParam = "goroutines"
+
+type param struct {
+	name   string
+	values []interface{}
+}
+
+// Grid is the cartesian product of its parameters, the first one is the outermost.
+type Grid struct {
+	params []param
+}
+
+func New() *Grid {
+	return &Grid{}
+}
+
+func (g *Grid) Values(name string, values ...interface{}) *Grid {
+	g.params = append(g.params, param{name: name, values: values})
+	return g
+}
+
+func (g *Grid) Ints(name string, values ...int) *Grid {
+	vs := make([]interface{}, len(values))
+	for i, v := range values {
+		vs[i] = v
+	}
+	return g.Values(name, vs...)
+}
+
+// Goroutines adds the goroutine counts used by Point.RunParallel.
+func (g *Grid) Goroutines(counts ...int) *Grid {
+	return g.Ints(goroutinesParam, counts...)
+}
+
+// Points returns every combination of the parameters.
+func (g *Grid) Points() []Point {
+	points := []Point{{}}
+	for _, p := range g.params {
+		next := make([]Point, 0, len(points)*len(p.values))
+		for _, pt := range points {
+			for _, v := range p.values {
+				next = append(next, pt.with(p.name, v))
+			}
+		}
+		points = next
+	}
+	return points
+}
+
+// Run runs body as a sub-benchmark for every point, one level per parameter.
+func (g *Grid) Run(b *testing.B, body func(b *testing.B, p Point)) {
+	g.run(b, 0, Point{}, body)
+}
+
+func (g *Grid) run(b *testing.B, level int, pt Point, body func(b *testing.B, p Point)) {
+	if level == len(g.params) {
+		runPoint(b, pt, body)
+		return
+	}
+	p := g.params[level]
+	for _, v := range p.values {
+		next := pt.with(p.name, v)
+		b.Run(p.name+"="+format(v), func(b *testing.B) {
+			g.run(b, level+1, next, body)
+		})
+	}
+}
+
+func runPoint(b *testing.B, pt Point, body func(b *testing.B, p Point)) {
+	body(b, pt)
+	b.StopTimer()
+	bench := b.Name()
+	if i := strings.IndexByte(bench, '/'); i >= 0 {
+		bench = bench[:i]
+	}
+	record(bench, pt, b.N, b.Elapsed())
+}
+
+// Point is one combination of parameter values.
+type Point struct {
+	names  []string
+	values []interface{}
+}
+
+func (p Point) with(name string, v interface{}) Point {
+	return Point{
+		names:  append(append([]string(nil), p.names...), name),
+		values: append(append([]interface{}(nil), p.values...), v),
+	}
+}
+
+// Name is the sub-benchmark name of the point, e.g. size=16/type=int.
+func (p Point) Name() string {
+	parts := make([]string, len(p.names))
+	for i, n := range p.names {
+		parts[i] = n + "=" + format(p.values[i])
+	}
+	return strings.Join(parts, "/")
+}
+
+// Value returns the value of a parameter, it panics if the grid has no such parameter.
+func (p Point) Value(name string) interface{} {
+	for i, n := range p.names {
+		if n == name {
+			return p.values[i]
+		}
+	}
+	panic("sweep: no parameter " + name)
+}
+
+func (p Point) Int(name string) int {
+	return p.Value(name).(int)
+}
+
+func (p Point) String(name string) string {
+	return format(p.Value(name))
+}
+
+// Goroutines is the goroutines parameter, 1 if the grid does not have one.
+func (p Point) Goroutines() int {
+	for i, n := range p.names {
+		if n == goroutinesParam {
+			return p.values[i].(int)
+		}
+	}
+	return 1
+}
+
+// RunParallel splits b.N calls of body over Goroutines() goroutines.
+// Unlike b.RunParallel the goroutine count does not depend on GOMAXPROCS.
+func (p Point) RunParallel(b *testing.B, body func()) {
+	n := p.Goroutines()
+	var wg sync.WaitGroup
+	for i := 0; i < n; i++ {
+		calls := b.N / n
+		if i < b.N%n {
+			calls++
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < calls; j++ {
+				body()
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+func format(v interface{}) string {
+	switch x := v.(type) {
+	case string:
+		return x
+	case int:
+		return strconv.Itoa(x)
+	}
+	return fmt.Sprint(v)
+}
+
+// Row is the result of one point.
+type Row struct {
+	Benchmark string // top-level benchmark name
+	Point     Point
+	N         int
+	NsPerOp   float64
+}
+
+var (
+	mu   sync.Mutex
+	rows []*Row
+	byID = make(map[string]*Row)
+)
+
+// record keeps the last run of every point, testing calls the benchmark again with a larger b.N.
+func record(bench string, pt Point, n int, elapsed time.Duration) {
+	if n <= 0 {
+		return
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	id := bench + "/" + pt.Name()
+	r := byID[id]
+	if r == nil {
+		r = &Row{Benchmark: bench, Point: pt}
+		byID[id] = r
+		rows = append(rows, r)
+	}
+	r.N = n
+	r.NsPerOp = float64(elapsed.Nanoseconds()) / float64(n)
+	if *csvPath != "" {
+		if err := writeFile(*csvPath); err != nil {
+			fmt.Fprintf(os.Stderr, "sweep: %v\n", err)
+		}
+	}
+}
+
+// Rows returns the results recorded so far.
+func Rows() []*Row {
+	mu.Lock()
+	defer mu.Unlock()
+	return append([]*Row(nil), rows...)
+}
+
+func writeFile(path string) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	if err := writeCSV(f, rows); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// WriteCSV writes the recorded results, one column per parameter of any grid.
+func WriteCSV(w io.Writer) error {
+	mu.Lock()
+	defer mu.Unlock()
+	return writeCSV(w, rows)
+}
+
+func writeCSV(w io.Writer, rows []*Row) error {
+	var names []string
+	seen := make(map[string]bool)
+	for _, r := range rows {
+		for _, n := range r.Point.names {
+			if !seen[n] {
+				seen[n] = true
+				names = append(names, n)
+			}
+		}
+	}
+	cw := csv.NewWriter(w)
+	cw.Write(append(append([]string{"benchmark"}, names...), "n", "ns/op"))
+	for _, r := range rows {
+		rec := []string{r.Benchmark}
+		for _, n := range names {
+			v := ""
+			for i, pn := range r.Point.names {
+				if pn == n {
+					v = format(r.Point.values[i])
+				}
+			}
+			rec = append(rec, v)
+		}
+		rec = append(rec, strconv.Itoa(r.N), strconv.FormatFloat(r.NsPerOp, 'f', 2, 64))
+		cw.Write(rec)
+	}
+	cw.Flush()
+	return cw.Error()
+}
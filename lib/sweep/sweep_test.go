@@ -0,0 +1,76 @@
+package sweep
+
+import (
+	"bytes"
+	"flag"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPoints(t *testing.T) {
+	g := New().Ints("size", 1, 10).Values("type", "int", "string")
+	var names []string
+	for _, p := range g.Points() {
+		names = append(names, p.Name())
+	}
+	assert.Equal(t, []string{"size=1/type=int", "size=1/type=string", "size=10/type=int", "size=10/type=string"}, names)
+
+	p := g.Points()[3]
+	assert.Equal(t, 10, p.Int("size"))
+	assert.Equal(t, "string", p.String("type"))
+	assert.Equal(t, 1, p.Goroutines())
+	assert.Panics(t, func() { p.Value("procs") })
+	assert.Equal(t, 1, len(New().Points()))
+}
+
+func TestRunParallel(t *testing.T) {
+	for _, n := range []int{1, 3, 8} {
+		p := New().Goroutines(n).Points()[0]
+		var calls int64
+		b := &testing.B{N: 100}
+		p.RunParallel(b, func() { atomic.AddInt64(&calls, 1) })
+		assert.Equal(t, int64(100), calls)
+		assert.Equal(t, n, p.Goroutines())
+	}
+}
+
+func TestRecord(t *testing.T) {
+	old := flag.Lookup("test.benchtime").Value.String()
+	flag.Set("test.benchtime", "10ms")
+	defer flag.Set("test.benchtime", old)
+
+	g := New().Ints("size", 2, 4).Goroutines(1, 2)
+	for _, pt := range g.Points() {
+		testing.Benchmark(func(b *testing.B) {
+			runPoint(b, pt, func(b *testing.B, p Point) {
+				p.RunParallel(b, func() {})
+			})
+		})
+	}
+
+	rows := Rows()
+	assert.Equal(t, 4, len(rows))
+	assert.Equal(t, 4, rows[3].Point.Int("size"))
+	assert.True(t, rows[3].N > 1)
+
+	var buf bytes.Buffer
+	assert.Nil(t, WriteCSV(&buf))
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	assert.Equal(t, 5, len(lines))
+	assert.Equal(t, "benchmark,size,goroutines,n,ns/op", lines[0])
+	assert.True(t, strings.HasPrefix(lines[4], ",4,2,"), lines[4])
+}
+
+func TestWriteCSVUnion(t *testing.T) {
+	a := New().Ints("size", 8).Points()[0]
+	b := New().Values("type", "map").Points()[0]
+	var buf bytes.Buffer
+	assert.Nil(t, writeCSV(&buf, []*Row{
+		{Benchmark: "BenchmarkA", Point: a, N: 10, NsPerOp: 1.5},
+		{Benchmark: "BenchmarkB", Point: b, N: 20, NsPerOp: 3},
+	}))
+	assert.Equal(t, "benchmark,size,type,n,ns/op\nBenchmarkA,8,,10,1.50\nBenchmarkB,,map,20,3.00\n", buf.String())
+}
@@ -0,0 +1,226 @@
+package wire
+
+import (
+	"errors"
+	"fmt"
+	"math"
+)
+
+var (
+	ErrShortBuffer = errors.New("wire: short buffer")
+	ErrOverflow    = errors.New("wire: varint overflows 64 bits")
+)
+
+// ReadError tells where a read failed, it unwraps to ErrShortBuffer or ErrOverflow.
+// Need is the number of bytes the read wanted from Offset, -1 if unknown.
+type ReadError struct {
+	Offset int
+	Need   int
+	Err    error
+}
+
+func (e *ReadError) Error() string {
+	if e.Err == ErrShortBuffer && e.Need >= 0 {
+		return fmt.Sprintf("%v: need %d bytes at offset %d", e.Err, e.Need, e.Offset)
+	}
+	return fmt.Sprintf("%v at offset %d", e.Err, e.Offset)
+}
+
+func (e *ReadError) Unwrap() error {
+	return e.Err
+}
+
+// Reader reads from a []byte, a failed read does not move the offset.
+type Reader struct {
+	buf []byte
+	off int
+}
+
+func NewReader(buf []byte) *Reader {
+	return &Reader{buf: buf}
+}
+
+func (r *Reader) Reset(buf []byte) {
+	r.buf = buf
+	r.off = 0
+}
+
+func (r *Reader) Offset() int {
+	return r.off
+}
+
+func (r *Reader) Len() int {
+	return len(r.buf) - r.off
+}
+
+func (r *Reader) short(n int) error {
+	return &ReadError{Offset: r.off, Need: n, Err: ErrShortBuffer}
+}
+
+// next returns the next n bytes and moves the offset, for reads whose length is only known at run time.
+func (r *Reader) next(n int) ([]byte, error) {
+	if n < 0 || len(r.buf)-r.off < n {
+		return nil, r.short(n)
+	}
+	b := r.buf[r.off : r.off+n]
+	r.off += n
+	return b, nil
+}
+
+//perf:nobce
+func (r *Reader) Uint8() (uint8, error) {
+	if r.off < 0 || r.off > len(r.buf)-1 {
+		return 0, r.short(1)
+	}
+	v := r.buf[r.off]
+	r.off++
+	return v, nil
+}
+
+//perf:nobce
+func (r *Reader) Uint16BE() (uint16, error) {
+	if r.off < 0 || r.off > len(r.buf)-2 {
+		return 0, r.short(2)
+	}
+	b := r.buf[r.off:][:2:2]
+	r.off += 2
+	return uint16(b[1]) | uint16(b[0])<<8, nil
+}
+
+//perf:nobce
+func (r *Reader) Uint16LE() (uint16, error) {
+	if r.off < 0 || r.off > len(r.buf)-2 {
+		return 0, r.short(2)
+	}
+	b := r.buf[r.off:][:2:2]
+	r.off += 2
+	return uint16(b[0]) | uint16(b[1])<<8, nil
+}
+
+//perf:nobce
+func (r *Reader) Uint32BE() (uint32, error) {
+	if r.off < 0 || r.off > len(r.buf)-4 {
+		return 0, r.short(4)
+	}
+	b := r.buf[r.off:][:4:4]
+	r.off += 4
+	return uint32(b[3]) | uint32(b[2])<<8 | uint32(b[1])<<16 | uint32(b[0])<<24, nil
+}
+
+//perf:nobce
+func (r *Reader) Uint32LE() (uint32, error) {
+	if r.off < 0 || r.off > len(r.buf)-4 {
+		return 0, r.short(4)
+	}
+	b := r.buf[r.off:][:4:4]
+	r.off += 4
+	return uint32(b[0]) | uint32(b[1])<<8 | uint32(b[2])<<16 | uint32(b[3])<<24, nil
+}
+
+//perf:nobce
+func (r *Reader) Uint64BE() (uint64, error) {
+	if r.off < 0 || r.off > len(r.buf)-8 {
+		return 0, r.short(8)
+	}
+	b := r.buf[r.off:][:8:8]
+	r.off += 8
+	return uint64(b[7]) | uint64(b[6])<<8 | uint64(b[5])<<16 | uint64(b[4])<<24 |
+		uint64(b[3])<<32 | uint64(b[2])<<40 | uint64(b[1])<<48 | uint64(b[0])<<56, nil
+}
+
+//perf:nobce
+func (r *Reader) Uint64LE() (uint64, error) {
+	if r.off < 0 || r.off > len(r.buf)-8 {
+		return 0, r.short(8)
+	}
+	b := r.buf[r.off:][:8:8]
+	r.off += 8
+	return uint64(b[0]) | uint64(b[1])<<8 | uint64(b[2])<<16 | uint64(b[3])<<24 |
+		uint64(b[4])<<32 | uint64(b[5])<<40 | uint64(b[6])<<48 | uint64(b[7])<<56, nil
+}
+
+func (r *Reader) Int16BE() (int16, error) {
+	v, err := r.Uint16BE()
+	return int16(v), err
+}
+
+func (r *Reader) Int16LE() (int16, error) {
+	v, err := r.Uint16LE()
+	return int16(v), err
+}
+
+func (r *Reader) Int32BE() (int32, error) {
+	v, err := r.Uint32BE()
+	return int32(v), err
+}
+
+func (r *Reader) Int32LE() (int32, error) {
+	v, err := r.Uint32LE()
+	return int32(v), err
+}
+
+func (r *Reader) Int64BE() (int64, error) {
+	v, err := r.Uint64BE()
+	return int64(v), err
+}
+
+func (r *Reader) Int64LE() (int64, error) {
+	v, err := r.Uint64LE()
+	return int64(v), err
+}
+
+// Uvarint reads like binary.Uvarint.
+func (r *Reader) Uvarint() (uint64, error) {
+	var v uint64
+	var s uint
+	for i := r.off; i < len(r.buf); i++ {
+		c := r.buf[i]
+		n := i - r.off
+		if n == 9 && c > 1 {
+			return 0, &ReadError{Offset: r.off, Err: ErrOverflow}
+		}
+		if c < 0x80 {
+			r.off = i + 1
+			return v | uint64(c)<<s, nil
+		}
+		v |= uint64(c&0x7f) << s
+		s += 7
+	}
+	return 0, &ReadError{Offset: r.off, Need: -1, Err: ErrShortBuffer}
+}
+
+// Varint reads a zigzag encoded varint.
+func (r *Reader) Varint() (int64, error) {
+	v, err := r.Uvarint()
+	return UnZigZag(v), err
+}
+
+// LenBytes reads a length prefixed byte slice, the result shares memory with the Reader's buffer.
+func (r *Reader) LenBytes() ([]byte, error) {
+	off := r.off
+	n, err := r.Uvarint()
+	if err != nil {
+		return nil, err
+	}
+	if n > uint64(len(r.buf)-r.off) {
+		need := -1
+		if n <= math.MaxInt32 {
+			need = r.off - off + int(n)
+		}
+		r.off = off
+		return nil, &ReadError{Offset: off, Need: need, Err: ErrShortBuffer}
+	}
+	b := r.buf[r.off : r.off+int(n) : r.off+int(n)]
+	r.off += int(n)
+	return b, nil
+}
+
+func (r *Reader) String() (string, error) {
+	b, err := r.LenBytes()
+	return string(b), err
+}
+
+// Raw reads n bytes without length, the result shares memory with the Reader's buffer.
+func (r *Reader) Raw(n int) ([]byte, error) {
+	return r.next(n)
+}
@@ -0,0 +1,225 @@
+package wire
+
+import (
+	"encoding/binary"
+	"errors"
+	"math"
+	"math/rand"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+var num int64 = rand.Int63()
+
+func TestFixedEqualBinary(t *testing.T) {
+	w := NewWriter(nil)
+	w.Uint16BE(0x0102)
+	w.Uint16LE(0x0102)
+	w.Uint32BE(0x01020304)
+	w.Uint32LE(0x01020304)
+	w.Uint64BE(uint64(num))
+	w.Uint64LE(uint64(num))
+
+	expected := make([]byte, 0, 28)
+	expected = binary.BigEndian.AppendUint16(expected, 0x0102)
+	expected = binary.LittleEndian.AppendUint16(expected, 0x0102)
+	expected = binary.BigEndian.AppendUint32(expected, 0x01020304)
+	expected = binary.LittleEndian.AppendUint32(expected, 0x01020304)
+	expected = binary.BigEndian.AppendUint64(expected, uint64(num))
+	expected = binary.LittleEndian.AppendUint64(expected, uint64(num))
+	assert.Equal(t, expected, w.Bytes())
+}
+
+func TestVarintEqualBinary(t *testing.T) {
+	for _, v := range []int64{0, 1, -1, 63, -64, 64, 300, -300, math.MaxInt64, math.MinInt64, num} {
+		w := NewWriter(nil)
+		w.Varint(v)
+		assert.Equal(t, binary.AppendVarint(nil, v), w.Bytes())
+
+		w.Reset()
+		w.Uvarint(uint64(v))
+		assert.Equal(t, binary.AppendUvarint(nil, uint64(v)), w.Bytes())
+	}
+	assert.Equal(t, uint64(1), ZigZag(-1))
+	assert.Equal(t, int64(-1), UnZigZag(1))
+}
+
+func TestRoundTrip(t *testing.T) {
+	w := NewWriter(make([]byte, 0, 4))
+	w.Uint8(7)
+	w.Int16BE(-2)
+	w.Int16LE(-3)
+	w.Int32BE(-4)
+	w.Int32LE(-5)
+	w.Int64BE(num)
+	w.Int64LE(-num)
+	w.Varint(-300)
+	w.Uvarint(math.MaxUint64)
+	w.String("req_id")
+	w.LenBytes(nil)
+	w.Raw([]byte{1, 2})
+
+	r := NewReader(w.Bytes())
+	u8, _ := r.Uint8()
+	assert.Equal(t, uint8(7), u8)
+	i16, _ := r.Int16BE()
+	assert.Equal(t, int16(-2), i16)
+	i16, _ = r.Int16LE()
+	assert.Equal(t, int16(-3), i16)
+	i32, _ := r.Int32BE()
+	assert.Equal(t, int32(-4), i32)
+	i32, _ = r.Int32LE()
+	assert.Equal(t, int32(-5), i32)
+	i64, _ := r.Int64BE()
+	assert.Equal(t, num, i64)
+	i64, _ = r.Int64LE()
+	assert.Equal(t, -num, i64)
+	i64, _ = r.Varint()
+	assert.Equal(t, int64(-300), i64)
+	u64, _ := r.Uvarint()
+	assert.Equal(t, uint64(math.MaxUint64), u64)
+	s, _ := r.String()
+	assert.Equal(t, "req_id", s)
+	b, err := r.LenBytes()
+	assert.Nil(t, err)
+	assert.Equal(t, 0, len(b))
+	b, _ = r.Raw(2)
+	assert.Equal(t, []byte{1, 2}, b)
+	assert.Equal(t, 0, r.Len())
+
+	_, err = r.Uint8()
+	assert.True(t, errors.Is(err, ErrShortBuffer))
+}
+
+func TestShortBuffer(t *testing.T) {
+	r := NewReader([]byte{1, 2, 3})
+	_, err := r.Uint32BE()
+	assert.True(t, errors.Is(err, ErrShortBuffer))
+	assert.Equal(t, 4, err.(*ReadError).Need)
+	assert.Equal(t, 0, r.Offset())
+
+	// 长度前缀说有5个字节, 实际只有2个, offset不动
+	r = NewReader([]byte{5, 'a', 'b'})
+	_, err = r.String()
+	assert.True(t, errors.Is(err, ErrShortBuffer))
+	assert.Equal(t, 6, err.(*ReadError).Need)
+	assert.Equal(t, 0, r.Offset())
+
+	r = NewReader([]byte{0x80, 0x80})
+	_, err = r.Uvarint()
+	assert.True(t, errors.Is(err, ErrShortBuffer))
+
+	r = NewReader([]byte{0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0x02})
+	_, err = r.Uvarint()
+	assert.True(t, errors.Is(err, ErrOverflow))
+	assert.Equal(t, 0, r.Offset())
+}
+
+// 任意输入都不能panic, 且结果和encoding/binary一致
+func FuzzReader(f *testing.F) {
+	f.Add([]byte{5, 'a', 'b'})
+	f.Add([]byte{0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0x01, 0x00})
+	f.Add([]byte{1, 2, 3, 4, 5, 6, 7, 8, 9})
+	f.Fuzz(func(t *testing.T, data []byte) {
+		r := NewReader(data)
+		v, err := r.Uvarint()
+		ev, n := binary.Uvarint(data)
+		if (err == nil) != (n > 0) {
+			t.Fatalf("%x: err=%v binary n=%d", data, err, n)
+		}
+		if err == nil && (v != ev || r.Offset() != n) {
+			t.Fatalf("%x: %d@%d binary %d@%d", data, v, r.Offset(), ev, n)
+		}
+
+		r.Reset(data)
+		u64, err := r.Uint64BE()
+		if len(data) >= 8 {
+			if err != nil || u64 != binary.BigEndian.Uint64(data) {
+				t.Fatalf("%x: Uint64BE %d %v", data, u64, err)
+			}
+		} else if !errors.Is(err, ErrShortBuffer) {
+			t.Fatalf("%x: Uint64BE expected short buffer, got %v", data, err)
+		}
+
+		r.Reset(data)
+		for r.Len() > 0 {
+			before := r.Offset()
+			if _, err := r.LenBytes(); err != nil {
+				if r.Offset() != before {
+					t.Fatalf("%x: offset moved on error", data)
+				}
+				break
+			}
+		}
+	})
+}
+
+func FuzzRoundTrip(f *testing.F) {
+	f.Add(int64(0), uint64(0), "")
+	f.Add(num, uint64(math.MaxUint64), "req_id")
+	f.Fuzz(func(t *testing.T, i int64, u uint64, s string) {
+		w := NewWriter(nil)
+		w.Varint(i)
+		w.Uvarint(u)
+		w.String(s)
+		w.Int64BE(i)
+		w.Uint64LE(u)
+
+		r := NewReader(w.Bytes())
+		ri, err1 := r.Varint()
+		ru, err2 := r.Uvarint()
+		rs, err3 := r.String()
+		rbe, err4 := r.Int64BE()
+		rle, err5 := r.Uint64LE()
+		for _, err := range []error{err1, err2, err3, err4, err5} {
+			if err != nil {
+				t.Fatal(err)
+			}
+		}
+		if ri != i || ru != u || rs != s || rbe != i || rle != u || r.Len() != 0 {
+			t.Fatalf("round trip mismatch: %d %d %q", i, u, s)
+		}
+	})
+}
+
+func BenchmarkBinaryPutUint64(b *testing.B) {
+	buf := make([]byte, 8)
+	for i := 0; i < b.N; i++ {
+		binary.BigEndian.PutUint64(buf, uint64(num))
+	}
+}
+
+func BenchmarkWriterUint64BE(b *testing.B) {
+	w := NewWriter(make([]byte, 0, 8))
+	for i := 0; i < b.N; i++ {
+		w.Reset()
+		w.Uint64BE(uint64(num))
+	}
+}
+
+func BenchmarkWriterVarint(b *testing.B) {
+	w := NewWriter(make([]byte, 0, 16))
+	for i := 0; i < b.N; i++ {
+		w.Reset()
+		w.Varint(num)
+	}
+}
+
+func BenchmarkReaderUint64BE(b *testing.B) {
+	buf := binary.BigEndian.AppendUint64(nil, uint64(num))
+	r := NewReader(buf)
+	for i := 0; i < b.N; i++ {
+		r.Reset(buf)
+		r.Uint64BE()
+	}
+}
+
+func BenchmarkReaderVarint(b *testing.B) {
+	buf := binary.AppendVarint(nil, num)
+	r := NewReader(buf)
+	for i := 0; i < b.N; i++ {
+		r.Reset(buf)
+		r.Varint()
+	}
+}
@@ -0,0 +1,154 @@
+package wire
+
+/*
+	build_in/write_to_byte_test.go 和 ams/write_byte.go 的结论推广成一个包:
+	定长整数先拿到一个刚好长度的切片, 用 _ = b[7] 一次性做完边界检查, 后面的写入编译器不再检查
+	Reader 的定长读先判断 0 <= off <= len-N 再取 buf[off:][:N:N], 编译器能证明都不越界, 一次检查也不留(//perf:nobce)
+	写成 len-off < N 证明不了 off 非负, 切片那里还会多一次检查
+
+	w := wire.NewWriter(make([]byte, 0, 64))
+	w.Uint64BE(uint64(num))
+	w.Varint(-1)
+	w.String("req_id")
+	send(w.Bytes())
+*/
+
+type Writer struct {
+	buf []byte
+}
+
+// NewWriter appends to buf.
+func NewWriter(buf []byte) *Writer {
+	return &Writer{buf: buf}
+}
+
+func (w *Writer) Reset() {
+	w.buf = w.buf[:0]
+}
+
+func (w *Writer) Bytes() []byte {
+	return w.buf
+}
+
+func (w *Writer) Len() int {
+	return len(w.buf)
+}
+
+// grow extends buf by n bytes and returns them.
+func (w *Writer) grow(n int) []byte {
+	l := len(w.buf)
+	if cap(w.buf)-l < n {
+		nb := make([]byte, l, 2*cap(w.buf)+n)
+		copy(nb, w.buf)
+		w.buf = nb
+	}
+	w.buf = w.buf[:l+n]
+	return w.buf[l:]
+}
+
+func (w *Writer) Uint8(v uint8) {
+	w.buf = append(w.buf, v)
+}
+
+func (w *Writer) Uint16BE(v uint16) {
+	b := w.grow(2)
+	_ = b[1] // early bounds check to guarantee safety of writes below
+	b[0] = byte(v >> 8)
+	b[1] = byte(v)
+}
+
+func (w *Writer) Uint16LE(v uint16) {
+	b := w.grow(2)
+	_ = b[1]
+	b[0] = byte(v)
+	b[1] = byte(v >> 8)
+}
+
+func (w *Writer) Uint32BE(v uint32) {
+	b := w.grow(4)
+	_ = b[3]
+	b[0] = byte(v >> 24)
+	b[1] = byte(v >> 16)
+	b[2] = byte(v >> 8)
+	b[3] = byte(v)
+}
+
+func (w *Writer) Uint32LE(v uint32) {
+	b := w.grow(4)
+	_ = b[3]
+	b[0] = byte(v)
+	b[1] = byte(v >> 8)
+	b[2] = byte(v >> 16)
+	b[3] = byte(v >> 24)
+}
+
+func (w *Writer) Uint64BE(v uint64) {
+	b := w.grow(8)
+	_ = b[7]
+	b[0] = byte(v >> 56)
+	b[1] = byte(v >> 48)
+	b[2] = byte(v >> 40)
+	b[3] = byte(v >> 32)
+	b[4] = byte(v >> 24)
+	b[5] = byte(v >> 16)
+	b[6] = byte(v >> 8)
+	b[7] = byte(v)
+}
+
+func (w *Writer) Uint64LE(v uint64) {
+	b := w.grow(8)
+	_ = b[7]
+	b[0] = byte(v)
+	b[1] = byte(v >> 8)
+	b[2] = byte(v >> 16)
+	b[3] = byte(v >> 24)
+	b[4] = byte(v >> 32)
+	b[5] = byte(v >> 40)
+	b[6] = byte(v >> 48)
+	b[7] = byte(v >> 56)
+}
+
+func (w *Writer) Int16BE(v int16) { w.Uint16BE(uint16(v)) }
+func (w *Writer) Int16LE(v int16) { w.Uint16LE(uint16(v)) }
+func (w *Writer) Int32BE(v int32) { w.Uint32BE(uint32(v)) }
+func (w *Writer) Int32LE(v int32) { w.Uint32LE(uint32(v)) }
+func (w *Writer) Int64BE(v int64) { w.Uint64BE(uint64(v)) }
+func (w *Writer) Int64LE(v int64) { w.Uint64LE(uint64(v)) }
+
+// Uvarint writes v like binary.PutUvarint.
+func (w *Writer) Uvarint(v uint64) {
+	for v >= 0x80 {
+		w.buf = append(w.buf, byte(v)|0x80)
+		v >>= 7
+	}
+	w.buf = append(w.buf, byte(v))
+}
+
+// Varint writes v zigzag encoded, like binary.PutVarint.
+func (w *Writer) Varint(v int64) {
+	w.Uvarint(ZigZag(v))
+}
+
+// LenBytes writes a uvarint length followed by b.
+func (w *Writer) LenBytes(b []byte) {
+	w.Uvarint(uint64(len(b)))
+	w.buf = append(w.buf, b...)
+}
+
+func (w *Writer) String(s string) {
+	w.Uvarint(uint64(len(s)))
+	w.buf = append(w.buf, s...)
+}
+
+// Raw writes b without length.
+func (w *Writer) Raw(b []byte) {
+	w.buf = append(w.buf, b...)
+}
+
+func ZigZag(v int64) uint64 {
+	return uint64(v<<1) ^ uint64(v>>63)
+}
+
+func UnZigZag(v uint64) int64 {
+	return int64(v>>1) ^ -int64(v&1)
+}
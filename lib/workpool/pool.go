@@ -0,0 +1,247 @@
+package workpool
+
+import (
+	"context"
+	"errors"
+	"runtime"
+	"sync"
+	"sync/atomic"
+)
+
+/*
+	分优先级的worker pool: 延迟敏感的请求走 High, 烧CPU的后台任务走 Low
+	Go的调度没有优先级, 大量CPU任务和请求goroutine一起排队时请求会被拖慢(见 apps/schedule)
+
+	p := workpool.New(workpool.Options{})
+	defer p.Close()
+	err := p.Submit(workpool.High, func() { handle(req) })
+	err = p.Submit(workpool.Low, func() {
+		for _, chunk := range chunks {
+			compute(chunk)
+			p.Checkpoint() // 有High任务排队时让出CPU
+		}
+	})
+
+	- Low 的并发默认是 GOMAXPROCS-1, 总留一个P给High;
+	  GOMAXPROCS=1 (或者 LowWorkers >= GOMAXPROCS) 时留不出来, Low 占满了所有P, 这时 Checkpoint 每次都让出一次,
+	  发High任务的goroutine才能及时跑上, High 的延迟取决于 Low 任务调 Checkpoint 有多勤
+	- 每个lane的队列有上限, 满了 Submit 直接返回 ErrFull, 由调用方决定丢掉还是降级(准入控制)
+	- SubmitWait 会等队列有空位, ctx结束或者pool关闭
+*/
+
+type Priority int
+
+const (
+	High Priority = iota
+	Low
+	numLanes
+)
+
+func (p Priority) String() string {
+	switch p {
+	case High:
+		return "high"
+	case Low:
+		return "low"
+	}
+	return "unknown"
+}
+
+var (
+	ErrFull   = errors.New("workpool: queue is full")
+	ErrClosed = errors.New("workpool: pool is closed")
+)
+
+type Options struct {
+	// HighWorkers default GOMAXPROCS
+	HighWorkers int
+	// LowWorkers bounds the concurrency of background work, default GOMAXPROCS-1 and at least 1,
+	// so with GOMAXPROCS=1 no P is kept for High
+	LowWorkers int
+	// HighQueue and LowQueue are the queue capacities, default 1024 and 4*LowWorkers
+	HighQueue int
+	LowQueue  int
+}
+
+type LaneStats struct {
+	Workers   int
+	Queued    int
+	Running   int64
+	Submitted int64
+	Rejected  int64
+	Completed int64
+}
+
+type Stats struct {
+	High LaneStats
+	Low  LaneStats
+}
+
+type lane struct {
+	workers   int
+	tasks     chan func()
+	pending   int64 // queued or running
+	running   int64
+	submitted int64
+	rejected  int64
+	completed int64
+}
+
+func (l *lane) stats() LaneStats {
+	return LaneStats{
+		Workers:   l.workers,
+		Queued:    len(l.tasks),
+		Running:   atomic.LoadInt64(&l.running),
+		Submitted: atomic.LoadInt64(&l.submitted),
+		Rejected:  atomic.LoadInt64(&l.rejected),
+		Completed: atomic.LoadInt64(&l.completed),
+	}
+}
+
+type Pool struct {
+	lanes [numLanes]*lane
+
+	// Submit 拿读锁, Close 拿写锁, 关闭之后不会再往chan里写
+	// SubmitWait 不能拿着锁阻塞, 登记在 senders 里放锁等, Close 先关 quit 叫醒它们, 等它们都走了再关chan
+	mu      sync.RWMutex
+	closed  bool
+	quit    chan struct{}
+	senders sync.WaitGroup
+	wg      sync.WaitGroup
+
+	// lowTakesAll 是 Low 能占满所有P, Checkpoint 总要让出
+	lowTakesAll bool
+}
+
+func New(opt Options) *Pool {
+	procs := runtime.GOMAXPROCS(0)
+	if opt.HighWorkers <= 0 {
+		opt.HighWorkers = procs
+	}
+	if opt.LowWorkers <= 0 {
+		opt.LowWorkers = procs - 1
+		if opt.LowWorkers < 1 {
+			opt.LowWorkers = 1
+		}
+	}
+	if opt.HighQueue <= 0 {
+		opt.HighQueue = 1024
+	}
+	if opt.LowQueue <= 0 {
+		opt.LowQueue = 4 * opt.LowWorkers
+	}
+	p := &Pool{quit: make(chan struct{}), lowTakesAll: opt.LowWorkers >= procs}
+	p.lanes[High] = &lane{workers: opt.HighWorkers, tasks: make(chan func(), opt.HighQueue)}
+	p.lanes[Low] = &lane{workers: opt.LowWorkers, tasks: make(chan func(), opt.LowQueue)}
+	for _, l := range p.lanes {
+		for i := 0; i < l.workers; i++ {
+			p.wg.Add(1)
+			go p.work(l)
+		}
+	}
+	return p
+}
+
+func (p *Pool) work(l *lane) {
+	defer p.wg.Done()
+	for fn := range l.tasks {
+		atomic.AddInt64(&l.running, 1)
+		fn()
+		atomic.AddInt64(&l.running, -1)
+		atomic.AddInt64(&l.pending, -1)
+		atomic.AddInt64(&l.completed, 1)
+	}
+}
+
+func (p *Pool) lane(pri Priority) *lane {
+	if pri < 0 || pri >= numLanes {
+		pri = Low
+	}
+	return p.lanes[pri]
+}
+
+// Submit queues fn without blocking, ErrFull if the lane's queue is full.
+func (p *Pool) Submit(pri Priority, fn func()) error {
+	l := p.lane(pri)
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	if p.closed {
+		return ErrClosed
+	}
+	atomic.AddInt64(&l.pending, 1)
+	select {
+	case l.tasks <- fn:
+		atomic.AddInt64(&l.submitted, 1)
+		return nil
+	default:
+		atomic.AddInt64(&l.pending, -1)
+		atomic.AddInt64(&l.rejected, 1)
+		return ErrFull
+	}
+}
+
+// SubmitWait queues fn, waiting for room until ctx is done or the pool is closed.
+func (p *Pool) SubmitWait(ctx context.Context, pri Priority, fn func()) error {
+	l := p.lane(pri)
+	p.mu.RLock()
+	if p.closed {
+		p.mu.RUnlock()
+		return ErrClosed
+	}
+	p.senders.Add(1)
+	p.mu.RUnlock()
+	defer p.senders.Done()
+
+	atomic.AddInt64(&l.pending, 1)
+	select {
+	case l.tasks <- fn:
+		atomic.AddInt64(&l.submitted, 1)
+		return nil
+	case <-ctx.Done():
+		atomic.AddInt64(&l.pending, -1)
+		atomic.AddInt64(&l.rejected, 1)
+		return ctx.Err()
+	case <-p.quit:
+		atomic.AddInt64(&l.pending, -1)
+		atomic.AddInt64(&l.rejected, 1)
+		return ErrClosed
+	}
+}
+
+// Checkpoint is for long Low tasks to call between chunks of work, it yields the CPU while High tasks are
+// queued or running, so a High worker that is runnable on this P gets it before the next chunk.
+// When the Low workers can take every P it also yields once otherwise, the goroutine about to submit a
+// High task may be waiting for a P.
+func (p *Pool) Checkpoint() {
+	if p.lowTakesAll {
+		runtime.Gosched()
+	}
+	high := p.lanes[High]
+	for i := 0; i < maxYields && atomic.LoadInt64(&high.pending) > 0; i++ {
+		runtime.Gosched()
+	}
+}
+
+// maxYields keeps a Low task from being starved forever by a High queue that never drains.
+const maxYields = 100
+
+func (p *Pool) Stats() Stats {
+	return Stats{High: p.lanes[High].stats(), Low: p.lanes[Low].stats()}
+}
+
+// Close stops accepting tasks and waits for the queued ones to finish.
+func (p *Pool) Close() {
+	p.mu.Lock()
+	if p.closed {
+		p.mu.Unlock()
+		return
+	}
+	p.closed = true
+	close(p.quit)
+	p.mu.Unlock()
+	p.senders.Wait()
+	for _, l := range p.lanes {
+		close(l.tasks)
+	}
+	p.wg.Wait()
+}
@@ -0,0 +1,116 @@
+package workpool
+
+import (
+	"context"
+	"runtime"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSubmit(t *testing.T) {
+	p := New(Options{HighWorkers: 2, LowWorkers: 1})
+	var n int64
+	var wg sync.WaitGroup
+	for i := 0; i < 100; i++ {
+		wg.Add(2)
+		assert.Nil(t, p.Submit(High, func() { atomic.AddInt64(&n, 1); wg.Done() }))
+		assert.Nil(t, p.SubmitWait(context.Background(), Low, func() { atomic.AddInt64(&n, 1); wg.Done() }))
+	}
+	wg.Wait()
+	assert.Equal(t, int64(200), n)
+	s := p.Stats()
+	assert.Equal(t, 2, s.High.Workers)
+	assert.Equal(t, 1, s.Low.Workers)
+	assert.Equal(t, int64(100), s.High.Submitted)
+	p.Close()
+	assert.Equal(t, int64(100), p.Stats().Low.Completed)
+	assert.Equal(t, ErrClosed, p.Submit(High, func() {}))
+	p.Close()
+}
+
+func TestAdmission(t *testing.T) {
+	p := New(Options{LowWorkers: 1, LowQueue: 2})
+	defer p.Close()
+	block := make(chan struct{})
+	started := make(chan struct{})
+	assert.Nil(t, p.Submit(Low, func() { close(started); <-block }))
+	<-started
+	assert.Nil(t, p.Submit(Low, func() {}))
+	assert.Nil(t, p.Submit(Low, func() {}))
+	assert.Equal(t, ErrFull, p.Submit(Low, func() {}))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	assert.Equal(t, context.DeadlineExceeded, p.SubmitWait(ctx, Low, func() {}))
+	s := p.Stats().Low
+	assert.Equal(t, int64(2), s.Rejected)
+	assert.Equal(t, 2, s.Queued)
+	assert.Equal(t, int64(1), s.Running)
+
+	// High 不受 Low 堵塞影响
+	done := make(chan struct{})
+	assert.Nil(t, p.Submit(High, func() { close(done) }))
+	<-done
+	close(block)
+}
+
+func TestCloseWhileWaiting(t *testing.T) {
+	p := New(Options{HighWorkers: 1, HighQueue: 1})
+	block := make(chan struct{})
+	started := make(chan struct{})
+	assert.Nil(t, p.Submit(High, func() { close(started); <-block }))
+	<-started
+	assert.Nil(t, p.Submit(High, func() {}))
+
+	waitErr := make(chan error)
+	go func() { waitErr <- p.SubmitWait(context.Background(), High, func() {}) }()
+	// 让 SubmitWait 先阻塞在满的队列上
+	time.Sleep(10 * time.Millisecond)
+	closed := make(chan struct{})
+	go func() {
+		p.Close()
+		close(closed)
+	}()
+	select {
+	case err := <-waitErr:
+		assert.Equal(t, ErrClosed, err)
+	case <-time.After(5 * time.Second):
+		t.Fatal("SubmitWait blocks Close")
+	}
+	assert.Equal(t, ErrClosed, p.Submit(High, func() {}))
+	close(block)
+	<-closed
+	s := p.Stats().High
+	assert.Equal(t, int64(2), s.Completed)
+	assert.Equal(t, int64(1), s.Rejected)
+}
+
+func TestCheckpoint(t *testing.T) {
+	p := New(Options{HighWorkers: 1, LowWorkers: 1})
+	defer p.Close()
+	// 没有High任务时不让出
+	start := time.Now()
+	p.Checkpoint()
+	assert.True(t, time.Since(start) < 10*time.Millisecond)
+
+	block := make(chan struct{})
+	p.Submit(High, func() { <-block })
+	p.Checkpoint() // 最多让出 maxYields 次, 不会一直等
+	close(block)
+}
+
+func TestCheckpointOneP(t *testing.T) {
+	defer runtime.GOMAXPROCS(runtime.GOMAXPROCS(1))
+	p := New(Options{})
+	defer p.Close()
+	assert.Equal(t, 1, p.Stats().Low.Workers)
+	// 只有一个P, Low 占着它; 没有High任务也要让出, 等着P的goroutine(比如要发High任务的)才能跑
+	var ran int32
+	go atomic.StoreInt32(&ran, 1)
+	p.Checkpoint()
+	assert.Equal(t, int32(1), atomic.LoadInt32(&ran))
+}
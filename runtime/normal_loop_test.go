@@ -4,6 +4,9 @@ import (
 	"math/rand"
 	"testing"
 	"encoding/hex"
+
+	"github.com/buptbill220/go_performance/lib/perfevent"
+	"github.com/buptbill220/go_performance/lib/sweep"
 )
 
 type A struct {
@@ -63,40 +66,78 @@ func optLoop(l *[]A) {
 
 func BenchmarkRead1(b *testing.B) {
 	var sum = 0
+	c := perfevent.Start(b)
 	for i := 0; i < b.N; i++ {
 		for _, m := range l1 {
 			sum += m.F3
 		}
 	}
+	c.Stop()
 }
 
 func BenchmarkRead2(b *testing.B) {
 	var sum = 0
+	c := perfevent.Start(b)
 	for i := 0; i < b.N; i++ {
 		for _, m := range l2 {
 			sum += m.F3
 		}
 	}
+	c.Stop()
 }
 
 func BenchmarkRead3(b *testing.B) {
 	var sum = 0
+	c := perfevent.Start(b)
 	for i := 0; i < b.N; i++ {
 		for idx := range l2 {
 			sum += l2[idx].F3
 		}
 	}
+	c.Stop()
 }
 
 
 func BenchmarkLoop1(b *testing.B) {
+	c := perfevent.Start(b)
 	for i := 0; i < b.N; i++ {
 		normalLoop(l1)
 	}
+	c.Stop()
 }
 
 func BenchmarkLoop2(b *testing.B) {
+	c := perfevent.Start(b)
 	for i := 0; i < b.N; i++ {
 		optLoop(&l2)
 	}
-}
\ No newline at end of file
+	c.Stop()
+}
+
+// 指针slice和值slice的遍历, 数据超过cache之后差距变大, 加 -perfevent 看cache miss
+func BenchmarkLoopSweep(b *testing.B) {
+	sweep.New().
+		Ints("size", 10, 1000, 100000).
+		Values("elem", "pointer", "value").
+		Run(b, func(b *testing.B, p sweep.Point) {
+			n := p.Int("size")
+			ptrs := make([]*A, n)
+			values := make([]A, n)
+			for i := 0; i < n; i++ {
+				ptrs[i] = &A{F3: rand.Int()}
+				values[i] = *ptrs[i]
+			}
+			b.ResetTimer()
+			c := perfevent.Start(b)
+			defer c.Stop()
+			if p.String("elem") == "pointer" {
+				for i := 0; i < b.N; i++ {
+					normalLoop(ptrs)
+				}
+			} else {
+				for i := 0; i < b.N; i++ {
+					optLoop(&values)
+				}
+			}
+		})
+}
@@ -39,7 +39,7 @@ func (p *Queue) Push(item interface{}) {
 		p.tail.next = node
 		p.tail = node
 	}
-	p.len++
+	atomic.AddInt64(&p.len, 1)
 	p.lock.Unlock()
 }
 
@@ -55,7 +55,7 @@ func (p *Queue) Pop() interface{} {
 	if p.head == nil {
 		p.tail = nil
 	}
-	p.len--
+	atomic.AddInt64(&p.len, -1)
 	p.lock.Unlock()
 	return i.data
 }